@@ -0,0 +1,196 @@
+package xtemplate
+
+// This file implements writing an access log line for each request, separate
+// from the debug log.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// AccessLogConfig configures an access log with a line for each request in a
+// standard format, so it can be fed to log pipelines and analyzers that
+// don't understand the debug log.
+type AccessLogConfig struct {
+	// The format of each line, default `combined`:
+	//
+	//   - `common`: the Common Log Format used by Apache and nginx.
+	//   - `combined`: the Common Log Format with the referer and user agent.
+	//   - `json`: a JSON object with the configured fields.
+	Format string `json:"format,omitempty"`
+
+	// The path of a file to append lines to, or `stdout` or `stderr`. Default
+	// `stdout`.
+	Output string `json:"output,omitempty"`
+
+	// The fields of the `json` format. Default all of them: `time`, `remote`,
+	// `user`, `method`, `uri`, `proto`, `host`, `status`, `bytes`,
+	// `duration`, `referer`, `user_agent`, `request_id`, and `route`.
+	Fields []string `json:"fields,omitempty"`
+
+	// The fraction of requests to log, from 0 to 1, to reduce the volume of
+	// busy sites. Responses with a 5xx status are always logged. Default `1`.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+
+	// Write lines to this writer instead of Output.
+	Writer io.Writer `json:"-"`
+}
+
+// WithAccessLog creates an [xtemplate.Option] that enables the access log.
+func WithAccessLog(config AccessLogConfig) Option {
+	return func(c *Config) error {
+		c.AccessLog = &config
+		return nil
+	}
+}
+
+var accessLogFields = []string{"time", "remote", "user", "method", "uri", "proto", "host", "status", "bytes", "duration", "referer", "user_agent", "request_id", "route"}
+
+// accessLogger writes access log lines to a file that is shared by all the
+// instances of a server.
+type accessLogger struct {
+	config *AccessLogConfig
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newAccessLogger opens the access log, which is closed when ctx is cancelled.
+func newAccessLogger(ctx context.Context, config *AccessLogConfig) (*accessLogger, error) {
+	if config.Format == "" {
+		config.Format = "combined"
+	}
+	switch config.Format {
+	case "common", "combined":
+	case "json":
+		if len(config.Fields) == 0 {
+			config.Fields = accessLogFields
+		}
+		for _, f := range config.Fields {
+			if !slices.Contains(accessLogFields, f) {
+				return nil, fmt.Errorf("unknown access log field '%s'", f)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown access log format '%s'", config.Format)
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = 1
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, fmt.Errorf("access log sample rate must be between 0 and 1: %v", config.SampleRate)
+	}
+
+	l := &accessLogger{config: config, w: config.Writer}
+	if l.w != nil {
+		return l, nil
+	}
+	switch config.Output {
+	case "", "stdout":
+		l.w = os.Stdout
+	case "stderr":
+		l.w = os.Stderr
+	default:
+		file, err := os.OpenFile(config.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		l.w = file
+		go func() {
+			<-ctx.Done()
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			file.Close()
+		}()
+	}
+	return l, nil
+}
+
+// log writes a line for the request r served by the route pattern.
+func (l *accessLogger) log(r *http.Request, pattern string, start time.Time, metrics httpsnoop.Metrics) {
+	if metrics.Code < 500 && l.config.SampleRate < 1 && rand.Float64() >= l.config.SampleRate {
+		return
+	}
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	var user string
+	if r.URL.User != nil {
+		user = r.URL.User.Username()
+	} else if u, _, ok := r.BasicAuth(); ok {
+		user = u
+	}
+
+	var line []byte
+	if l.config.Format == "json" {
+		entry := make(map[string]any, len(l.config.Fields))
+		for _, f := range l.config.Fields {
+			switch f {
+			case "time":
+				entry[f] = start.Format(time.RFC3339Nano)
+			case "remote":
+				entry[f] = remote
+			case "user":
+				entry[f] = user
+			case "method":
+				entry[f] = r.Method
+			case "uri":
+				entry[f] = r.RequestURI
+			case "proto":
+				entry[f] = r.Proto
+			case "host":
+				entry[f] = r.Host
+			case "status":
+				entry[f] = metrics.Code
+			case "bytes":
+				entry[f] = metrics.Written
+			case "duration":
+				entry[f] = metrics.Duration.Seconds()
+			case "referer":
+				entry[f] = r.Referer()
+			case "user_agent":
+				entry[f] = r.UserAgent()
+			case "request_id":
+				entry[f] = GetRequestId(r.Context())
+			case "route":
+				entry[f] = pattern
+			}
+		}
+		line, _ = json.Marshal(entry)
+	} else {
+		bytes := "-"
+		if metrics.Written > 0 {
+			bytes = strconv.FormatInt(metrics.Written, 10)
+		}
+		line = fmt.Appendf(nil, "%s - %s [%s] %s %d %s", clfValue(remote), clfValue(user), start.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto), metrics.Code, bytes)
+		if l.config.Format == "combined" {
+			line = fmt.Appendf(line, " %s %s", strconv.Quote(clfValue(r.Referer())), strconv.Quote(clfValue(r.UserAgent())))
+		}
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+func clfValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
@@ -0,0 +1,109 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// syncBuffer is a buffer that the server and the test can use at once.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	start := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	metrics := httpsnoop.Metrics{Code: 200, Written: 5, Duration: 1500 * time.Millisecond}
+	for _, test := range []struct {
+		config AccessLogConfig
+		want   string
+	}{
+		{AccessLogConfig{Format: "common"}, `192.0.2.1 - alice [04/Mar/2024:10:00:00 +0000] "GET /a?b=1 HTTP/1.1" 200 5` + "\n"},
+		{AccessLogConfig{}, `192.0.2.1 - alice [04/Mar/2024:10:00:00 +0000] "GET /a?b=1 HTTP/1.1" 200 5 "-" "curl/8.0"` + "\n"},
+		{AccessLogConfig{Format: "json", Fields: []string{"status", "uri", "user", "route", "duration"}}, `{"duration":1.5,"route":"GET /a","status":200,"uri":"/a?b=1","user":"alice"}` + "\n"},
+	} {
+		var buf syncBuffer
+		test.config.Writer = &buf
+		l, err := newAccessLogger(context.Background(), &test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/a?b=1", nil)
+		r.SetBasicAuth("alice", "password")
+		r.Header.Set("User-Agent", "curl/8.0")
+		l.log(r, "GET /a", start, metrics)
+		if got := buf.String(); got != test.want {
+			t.Errorf("format %q logged:\n%s\nwant:\n%s", test.config.Format, got, test.want)
+		}
+	}
+}
+
+func TestAccessLogConfigValidation(t *testing.T) {
+	for _, config := range []AccessLogConfig{
+		{Format: "apache"},
+		{Format: "json", Fields: []string{"cookie"}},
+		{SampleRate: 2},
+	} {
+		config.Writer = &syncBuffer{}
+		if _, err := newAccessLogger(context.Background(), &config); err == nil {
+			t.Errorf("access log config %+v is valid, want an error", config)
+		}
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	var buf syncBuffer
+	l, err := newAccessLogger(context.Background(), &AccessLogConfig{Format: "common", SampleRate: 1e-9, Writer: &buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < 100; i++ {
+		l.log(r, "GET /", time.Now(), httpsnoop.Metrics{Code: 200})
+	}
+	if got := buf.String(); got != "" {
+		t.Fatalf("sampled out requests were logged: %s", got)
+	}
+	l.log(r, "GET /", time.Now(), httpsnoop.Metrics{Code: 503})
+	if got := buf.String(); !strings.Contains(got, `"GET / HTTP/1.1" 503`) {
+		t.Fatalf("server error wasn't logged: %q", got)
+	}
+}
+
+func TestAccessLogReload(t *testing.T) {
+	var before, after syncBuffer
+	server, ts := newTestServer(t, map[string]string{"index.html": "hello"}, nil, nil, WithAccessLog(AccessLogConfig{Format: "common", Writer: &before}))
+	if status := getStatus(t, ts.URL+"/"); status != http.StatusOK {
+		t.Fatalf("status %d, want 200", status)
+	}
+	if err := server.Reload(WithAccessLog(AccessLogConfig{Format: "json", Fields: []string{"status"}, Writer: &after})); err != nil {
+		t.Fatal(err)
+	}
+	getStatus(t, ts.URL+"/")
+	if got := strings.Count(before.String(), "\n"); got != 1 {
+		t.Fatalf("logged %d lines before the reload, want 1:\n%s", got, before.String())
+	}
+	if got := after.String(); got != `{"status":200}`+"\n" {
+		t.Fatalf("logged %q after the reload changed the access log", got)
+	}
+}
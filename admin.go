@@ -0,0 +1,257 @@
+package xtemplate
+
+// This file implements the admin API, which patches individual template files
+// into a running server without redeploying the templates directory and lets
+// operators control the server.
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing/fstest"
+	"time"
+)
+
+// AdminPath is the url path prefix of the admin API, which is served by
+// [Server.Handler] when [Config.AdminToken] is set.
+const AdminPath = "/_xtemplate/admin"
+
+// Patch replaces the file at path_ in the templates FS with content, or adds
+// it if it doesn't exist, and reloads. The patch is kept across reloads until
+// it's removed with [Server.Unpatch]. If the new instance fails to load, the
+// patch is discarded and the error is returned.
+func (x *Server) Patch(path_ string, content []byte) error {
+	name, err := patchPath(path_)
+	if err != nil {
+		return err
+	}
+	return x.updatePatches(func(patches fstest.MapFS) {
+		patches[name] = &fstest.MapFile{Data: content, ModTime: time.Now(), Mode: 0444}
+	})
+}
+
+// Unpatch removes the patch of the file at path_ and reloads.
+func (x *Server) Unpatch(path_ string) error {
+	name, err := patchPath(path_)
+	if err != nil {
+		return err
+	}
+	return x.updatePatches(func(patches fstest.MapFS) {
+		delete(patches, name)
+	})
+}
+
+// Patches returns the paths of all patched files.
+func (x *Server) Patches() []string {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	var paths []string
+	for name := range x.config.patches {
+		paths = append(paths, "/"+name)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func patchPath(path_ string) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+path_), "/")
+	if !fs.ValidPath(name) || name == "." {
+		return "", fmt.Errorf("invalid patch path '%s'", path_)
+	}
+	return name, nil
+}
+
+// updatePatches reloads with a modified copy of the patches, and keeps them if
+// the reload succeeds.
+func (x *Server) updatePatches(update func(fstest.MapFS)) error {
+	x.patchMutex.Lock()
+	defer x.patchMutex.Unlock()
+
+	x.mutex.Lock()
+	patches := maps.Clone(x.config.patches)
+	x.mutex.Unlock()
+	if patches == nil {
+		patches = fstest.MapFS{}
+	}
+	update(patches)
+
+	err := x.reload(nil, func(c *Config) error {
+		c.patches = patches
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	x.mutex.Lock()
+	x.config.patches = patches
+	x.mutex.Unlock()
+	return nil
+}
+
+// adminHandler serves the admin API. All requests must have the header
+// `Authorization: Bearer <admin_token>`.
+//
+//   - `GET /_xtemplate/admin/patches` lists patched files
+//   - `PUT /_xtemplate/admin/patches/{path...}` patches a file with the body
+//   - `DELETE /_xtemplate/admin/patches/{path...}` removes a patch
+//   - `POST /_xtemplate/admin/reload` reloads templates
+//   - `GET /_xtemplate/admin/stats` returns the current instance's stats
+//   - `GET /_xtemplate/admin/routes` lists the current instance's routes
+//   - `POST /_xtemplate/admin/drain` waits for requests in flight to finish
+//     while rejecting new ones, up to the `timeout` query param, default `30s`
+//   - `DELETE /_xtemplate/admin/drain` handles new requests again
+//   - `POST /_xtemplate/admin/stop` drains and stops the server
+func (x *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+AdminPath+"/reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := x.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		x.config.Logger.Info("reloaded by admin api")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET "+AdminPath+"/stats", func(w http.ResponseWriter, r *http.Request) {
+		instance := x.Instance()
+		if instance == nil {
+			http.Error(w, "server stopped", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			InstanceId int64
+			Started    time.Time
+			Draining   bool
+			InFlight   int64
+			Panics     int64
+			Stats      InstanceStats
+		}{instance.Id(), x.started, x.draining.Load(), x.inFlight.Load(), instance.Panics(), instance.Stats()})
+	})
+	mux.HandleFunc("GET "+AdminPath+"/routes", func(w http.ResponseWriter, r *http.Request) {
+		instance := x.Instance()
+		if instance == nil {
+			http.Error(w, "server stopped", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range instance.RoutePatterns() {
+			fmt.Fprintln(w, p)
+		}
+	})
+	mux.HandleFunc("POST "+AdminPath+"/drain", func(w http.ResponseWriter, r *http.Request) {
+		if err := x.adminDrain(r); err != nil {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE "+AdminPath+"/drain", func(w http.ResponseWriter, r *http.Request) {
+		x.Resume()
+		x.config.Logger.Info("resumed by admin api")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+AdminPath+"/stop", func(w http.ResponseWriter, r *http.Request) {
+		if err := x.adminDrain(r); err != nil {
+			x.config.Logger.Warn("stopping with requests in flight", slog.Any("error", err))
+		}
+		x.config.Logger.Info("stopped by admin api")
+		x.Stop()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET "+AdminPath+"/patches", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range x.Patches() {
+			fmt.Fprintln(w, p)
+		}
+	})
+	mux.HandleFunc("PUT "+AdminPath+"/patches/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 10<<20))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if err := x.Patch(r.PathValue("path"), content); err != nil {
+			x.config.Logger.Warn("failed to patch template", slog.String("path", r.PathValue("path")), slog.Any("error", err))
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		x.config.Logger.Info("patched template", slog.String("path", r.PathValue("path")), slog.Int("size", len(content)))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE "+AdminPath+"/patches/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		if err := x.Unpatch(r.PathValue("path")); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		x.config.Logger.Info("removed template patch", slog.String("path", r.PathValue("path")))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	token := []byte("Bearer " + x.config.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminDrain drains the server for up to the request's `timeout` query param.
+func (x *Server) adminDrain(r *http.Request) error {
+	timeout := 30 * time.Second
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		var err error
+		if timeout, err = time.ParseDuration(t); err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	x.config.Logger.Info("draining by admin api", slog.Int64("in_flight", x.inFlight.Load()))
+	return x.Drain(ctx)
+}
+
+// serveAdmin serves the admin API at the configured listen address until the
+// server's context is cancelled.
+func (x *Server) serveAdmin() {
+	log := x.config.Logger.WithGroup("admin")
+	var ln net.Listener
+	var err error
+	if path_, ok := strings.CutPrefix(x.config.AdminListen, "unix:"); ok {
+		// remove a stale socket, but never another kind of file
+		if stat, err := os.Stat(path_); err == nil && stat.Mode()&os.ModeSocket != 0 {
+			os.Remove(path_)
+		}
+		if ln, err = net.Listen("unix", path_); err == nil {
+			err = os.Chmod(path_, 0o600)
+		}
+	} else {
+		ln, err = net.Listen("tcp", x.config.AdminListen)
+	}
+	if err != nil {
+		log.Error("failed to listen for admin api", slog.Any("error", err))
+		return
+	}
+	srv := &http.Server{Handler: x.adminHandler(), ReadHeaderTimeout: time.Duration(x.config.ReadHeaderTimeout)}
+	go func() {
+		<-x.config.Ctx.Done()
+		srv.Close()
+	}()
+	log.Info("starting admin server", slog.String("listen", x.config.AdminListen))
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		log.Error("admin server stopped", slog.Any("error", err))
+	}
+}
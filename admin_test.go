@@ -0,0 +1,275 @@
+package xtemplate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testAdminToken = "secret"
+
+// newTestServer serves files as templates with the admin API enabled and the
+// options opts. The template func `wait` signals started and blocks until it
+// receives from release.
+func newTestServer(t *testing.T, files map[string]string, started chan<- struct{}, release <-chan struct{}, opts ...Option) (*Server, *httptest.Server) {
+	t.Helper()
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	config := New()
+	config.Ctx = ctx
+	config.AdminToken = testAdminToken
+	server, err := config.Server(append([]Option{
+		WithTemplateFS(fsys),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithFuncMaps(map[string]any{"wait": func() string {
+			started <- struct{}{}
+			<-release
+			return "done"
+		}}),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+func adminRequest(t *testing.T, ts *httptest.Server, method, path string) (*http.Response, string) {
+	t.Helper()
+	return adminRequestBody(t, ts, method, path, nil)
+}
+
+func adminRequestBody(t *testing.T, ts *httptest.Server, method, path string, body io.Reader) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+AdminPath+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return doRequest(t, req)
+}
+
+func doRequest(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func getStatus(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"index.html": "hello"}, nil, nil)
+	req, _ := http.NewRequest("GET", ts.URL+AdminPath+"/stats", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	if resp, _ := doRequest(t, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status %d with a wrong token, want 401", resp.StatusCode)
+	}
+}
+
+func TestAdminStatsAndRoutes(t *testing.T) {
+	server, ts := newTestServer(t, map[string]string{"index.html": "hello", "about.html": "about"}, nil, nil)
+
+	resp, body := adminRequest(t, ts, "GET", "/stats")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stats status %d: %s", resp.StatusCode, body)
+	}
+	var stats struct {
+		InstanceId int64
+		Draining   bool
+		Stats      InstanceStats
+	}
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.InstanceId != server.Instance().Id() || stats.Draining || stats.Stats.TemplateFiles != 2 {
+		t.Fatalf("unexpected stats: %s", body)
+	}
+
+	resp, body = adminRequest(t, ts, "GET", "/routes")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("routes status %d: %s", resp.StatusCode, body)
+	}
+	for _, pattern := range []string{"GET /", "GET /about"} {
+		if !strings.Contains(body, pattern+"\n") {
+			t.Fatalf("routes %q don't include %q", body, pattern)
+		}
+	}
+
+	id := server.Instance().Id()
+	if resp, body := adminRequest(t, ts, "POST", "/reload"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("reload status %d: %s", resp.StatusCode, body)
+	}
+	if server.Instance().Id() == id {
+		t.Fatalf("reload didn't replace the instance")
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server, ts := newTestServer(t, map[string]string{"index.html": "hello", "slow.html": "{{wait}}"}, started, release)
+
+	slow := make(chan int)
+	getSlow := func() {
+		resp, err := http.Get(ts.URL + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}
+	go getSlow()
+	<-started
+
+	drained := make(chan error)
+	go func() { drained <- server.Drain(context.Background()) }()
+	for !server.draining.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("status %d while draining, want 503 with Retry-After", resp.StatusCode)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("drain returned with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	if status := <-slow; status != http.StatusOK {
+		t.Fatalf("request in flight while draining got status %d, want 200", status)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+
+	if resp, body := adminRequest(t, ts, "DELETE", "/drain"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("resume status %d: %s", resp.StatusCode, body)
+	}
+	if status := getStatus(t, ts.URL+"/"); status != http.StatusOK {
+		t.Fatalf("status %d after resume, want 200", status)
+	}
+
+	// draining times out while requests are still in flight
+	go getSlow()
+	<-started
+	if resp, _ := adminRequest(t, ts, "POST", "/drain?timeout=50ms"); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("drain status %d with a request in flight, want 504", resp.StatusCode)
+	}
+	release <- struct{}{}
+	<-slow
+}
+
+func TestAdminStop(t *testing.T) {
+	server, ts := newTestServer(t, map[string]string{"index.html": "hello"}, nil, nil)
+
+	if resp, body := adminRequest(t, ts, "POST", "/stop?timeout=1s"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("stop status %d: %s", resp.StatusCode, body)
+	}
+	select {
+	case <-server.Stopped():
+	default:
+		t.Fatalf("server not stopped")
+	}
+	if status := getStatus(t, ts.URL+"/"); status != http.StatusServiceUnavailable {
+		t.Fatalf("status %d after stop, want 503", status)
+	}
+	if resp, _ := adminRequest(t, ts, "GET", "/stats"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("stats status %d after stop, want 503", resp.StatusCode)
+	}
+}
+
+func TestAdminPatches(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"index.html": "hello"}, nil, nil)
+	get := func(path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		resp, body := doRequest(t, req)
+		return resp.StatusCode, body
+	}
+	patch := func(path, content string) int {
+		t.Helper()
+		resp, _ := adminRequestBody(t, ts, "PUT", "/patches"+path, strings.NewReader(content))
+		return resp.StatusCode
+	}
+
+	if status := patch("/index.html", "patched"); status != http.StatusNoContent {
+		t.Fatalf("patch status %d", status)
+	}
+	if status := patch("/new.html", "new"); status != http.StatusNoContent {
+		t.Fatalf("patch status %d adding a file", status)
+	}
+	if status, body := get("/"); status != http.StatusOK || body != "patched" {
+		t.Fatalf("status %d body %q of a patched file", status, body)
+	}
+	if status, body := get("/new"); status != http.StatusOK || body != "new" {
+		t.Fatalf("status %d body %q of an added file", status, body)
+	}
+	if _, body := adminRequest(t, ts, "GET", "/patches"); body != "/index.html\n/new.html\n" {
+		t.Fatalf("patches %q", body)
+	}
+
+	// a patch that fails to load is discarded
+	if status := patch("/index.html", "{{"); status != http.StatusUnprocessableEntity {
+		t.Fatalf("patch status %d with a broken template, want 422", status)
+	}
+	if status, body := get("/"); status != http.StatusOK || body != "patched" {
+		t.Fatalf("status %d body %q after a failed patch", status, body)
+	}
+
+	// patches are kept across reloads
+	adminRequest(t, ts, "POST", "/reload")
+	if _, body := get("/"); body != "patched" {
+		t.Fatalf("body %q after a reload, want the patch", body)
+	}
+
+	for _, path := range []string{"/index.html", "/new.html"} {
+		if resp, body := adminRequest(t, ts, "DELETE", "/patches"+path); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("unpatch status %d: %s", resp.StatusCode, body)
+		}
+	}
+	if status, body := get("/"); status != http.StatusOK || body != "hello" {
+		t.Fatalf("status %d body %q after unpatching, want the original file", status, body)
+	}
+	// the index route handles paths that no longer have a file
+	if _, body := get("/new"); body != "hello" {
+		t.Fatalf("body %q of an unpatched added file, want the index", body)
+	}
+	if _, body := adminRequest(t, ts, "GET", "/patches"); body != "" {
+		t.Fatalf("patches %q after unpatching all", body)
+	}
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"os"
 	"time"
@@ -10,19 +11,36 @@ import (
 	"github.com/infogulch/xtemplate"
 
 	"github.com/alexflint/go-arg"
-	"github.com/infogulch/watch"
 )
 
 type Args struct {
 	xtemplate.Config
-	Watch          []string `json:"watch_dirs" arg:",separate"`
-	WatchTemplates bool     `json:"watch_templates"`
-	Listen         string   `json:"listen" arg:"-l"`
-	LogLevel       int      `json:"log_level" default:"-2"`
-	Configs        []string `json:"-" arg:"-c,--config,separate"`
-	ConfigFiles    []string `json:"-" arg:"-f,--config-file,separate"`
+	Watch          []string           `json:"watch_dirs" arg:",separate"`
+	WatchTemplates bool               `json:"watch_templates"`
+	WatchExclude   []string           `json:"watch_exclude" arg:"--watch-exclude,separate" help:"file and directory name patterns to ignore when watching"`
+	WatchDebounce  xtemplate.Duration `json:"watch_debounce" arg:"--watch-debounce" help:"how long to wait for changes to stop before reloading"`
+	Listen         string             `json:"listen" arg:"-l" help:"address to listen at, or a unix socket like unix:/run/xtemplate.sock"`
+	SocketMode     string             `json:"socket_mode" arg:"--socket-mode" help:"permissions of the unix socket in octal, like 0660"`
+	TLSCert        string             `json:"tls_cert" arg:"--tls-cert" help:"path to a PEM certificate file to serve HTTPS with"`
+	TLSKey         string             `json:"tls_key" arg:"--tls-key" help:"path to the PEM private key file of the certificate"`
+	ACMEHosts      []string           `json:"acme_hosts" arg:"--acme-host,separate" help:"hostnames to get certificates for from Let's Encrypt, which serves HTTPS"`
+	ACMECacheDir   string             `json:"acme_cache_dir" arg:"--acme-cache-dir" help:"directory to store ACME certificates and account keys in"`
+	ACMEEmail      string             `json:"acme_email" arg:"--acme-email" help:"contact email for the ACME account"`
+	H2C            bool               `json:"h2c" arg:"--h2c" help:"serve HTTP/2 without TLS, for proxies that connect with h2c"`
+	HTTP3          bool               `json:"http3" arg:"--http3" help:"also serve HTTP/3 over QUIC at the same port when serving HTTPS"`
+	RedirectListen string             `json:"redirect_listen" arg:"--redirect-listen" help:"address to redirect http requests to https and answer ACME challenges at, like :80"`
+	Plugins        []string           `json:"plugins" arg:"--plugin,separate" help:"paths to Go plugins that export extra template funcs as Funcs"`
+	FuncCommands   []FuncCommand      `json:"func_commands" arg:"-"`
+	LogLevel       int                `json:"log_level" default:"-2"`
+	Configs        []string           `json:"-" arg:"-c,--config,separate"`
+	ConfigFiles    []string           `json:"-" arg:"-f,--config-file,separate"`
+	Check          *Check             `json:"-" arg:"subcommand:check" help:"load templates and dot providers, run INIT templates in dry-run mode, and exit"`
 }
 
+// Check validates the configuration and templates without serving requests,
+// for use in CI pipelines. It exits with a non-zero status if loading fails.
+type Check struct{}
+
 var version = "development"
 
 func (Args) Version() string {
@@ -31,7 +49,13 @@ func (Args) Version() string {
 
 var defaultWatchTemplates = "true"
 var defaultListenAddress = "0.0.0.0:8080"
-var defaultArgs = Args{WatchTemplates: defaultWatchTemplates == "true", Listen: defaultListenAddress}
+var defaultArgs = Args{
+	WatchTemplates: defaultWatchTemplates == "true",
+	WatchExclude:   defaultWatchExclude,
+	WatchDebounce:  xtemplate.Duration(200 * time.Millisecond),
+	Listen:         defaultListenAddress,
+	ACMECacheDir:   "certs",
+}
 
 // Main can be called from your func main() if you want your program to act like
 // the default xtemplate cli, or use it as a reference for making your own.
@@ -93,19 +117,34 @@ func Main(overrides ...xtemplate.Option) {
 		log.Debug("loaded configuration", slog.Any("config", &config))
 	}
 
-	server, err := config.Server(overrides...)
+	if _, err := config.Options(withExtraFuncs(config.Plugins, config.FuncCommands, log)); err != nil {
+		log.Error("failed to load extra funcs", slog.Any("error", err))
+		os.Exit(2)
+	}
+
+	if _, err := config.Options(overrides...); err != nil {
+		log.Error("failed to apply config overrides", slog.Any("error", err))
+		os.Exit(2)
+	}
+
+	if config.Check != nil {
+		os.Exit(check(config.Config))
+	}
+
+	server, err := config.Server()
 	if err != nil {
 		log.Error("failed to load xtemplate", slog.Any("error", err))
 		os.Exit(2)
 	}
 
+	// templates loaded from a custom FS like an embed.FS can't be watched
 	if config.WatchTemplates && config.TemplatesFS == nil {
 		config.Watch = append(config.Watch, config.TemplatesDir)
+		config.Watch = append(config.Watch, config.TemplatesBaseDirs...)
 	}
 	if len(config.Watch) != 0 {
-		_, err := watch.Watch(config.Watch, 200*time.Millisecond, log.WithGroup("fswatch"), func() bool {
-			server.Reload()
-			return true
+		_, err := watchDirs(config.Watch, config.WatchExclude, time.Duration(config.WatchDebounce), log.WithGroup("fswatch"), func(changed []string) {
+			server.ReloadChanged(changed)
 		})
 		if err != nil {
 			log.Info("failed to watch directories", slog.Any("error", err), slog.Any("directories", config.Watch))
@@ -113,5 +152,28 @@ func Main(overrides ...xtemplate.Option) {
 		}
 	}
 
-	log.Info("server stopped", slog.Any("exit", server.Serve(config.Listen)))
+	log.Info("server stopped", slog.Any("exit", serve(&config, server, log)))
+}
+
+// check builds an instance to parse all templates, initialize dot providers,
+// and run INIT templates without committing them. Errors from loading
+// templates include the file path and line number.
+func check(config xtemplate.Config) int {
+	config.InitDryRun = true
+	_, stats, routes, err := config.Instance()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check failed: %v\n", err)
+		if stats != nil {
+			for _, name := range stats.Initializers {
+				fmt.Fprintf(os.Stderr, "initializer succeeded: %s\n", name)
+			}
+		}
+		return 1
+	}
+	for _, route := range routes {
+		fmt.Println(route.Pattern)
+	}
+	fmt.Printf("ok: %d template files, %d template definitions, %d initializers, %d static files, %d routes\n",
+		stats.TemplateFiles, stats.TemplateDefinitions, stats.TemplateInitializers, stats.StaticFiles, stats.Routes)
+	return 0
 }
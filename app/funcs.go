@@ -0,0 +1,229 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"os/exec"
+	"plugin"
+	"sync"
+	"time"
+
+	"github.com/infogulch/xtemplate"
+)
+
+// loadPlugin opens a Go plugin built with `go build -buildmode=plugin` and
+// returns the funcs it exports as a variable named `Funcs`:
+//
+//	var Funcs = template.FuncMap{"shout": strings.ToUpper}
+//
+// The plugin must be built with the same Go version and dependency versions
+// as the xtemplate binary.
+func loadPlugin(path string) (template.FuncMap, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plugin '%s': %w", path, err)
+	}
+	sym, err := p.Lookup("Funcs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Funcs in plugin '%s': %w", path, err)
+	}
+	switch funcs := sym.(type) {
+	case *template.FuncMap:
+		return *funcs, nil
+	case *map[string]any:
+		return *funcs, nil
+	case func() template.FuncMap:
+		return funcs(), nil
+	}
+	return nil, fmt.Errorf("plugin '%s' must export Funcs as a template.FuncMap, got %T", path, sym)
+}
+
+// FuncCommand configures a subprocess that implements template funcs. The
+// process is started on the first call to one of its funcs and is sent one
+// call at a time as a line of JSON on stdin:
+//
+//	{"func": "weather", "args": ["Berlin"]}
+//
+// It must reply with a line of JSON on stdout with the result or an error
+// message, which fails the template:
+//
+//	{"result": {"temp": 21}}
+//	{"error": "unknown city"}
+//
+// Lines the process writes to stderr are logged. If the process exits or
+// doesn't reply within the timeout, it is stopped and started again on the
+// next call.
+type FuncCommand struct {
+	// The command and its arguments, like `["python3", "funcs.py"]`.
+	Command []string `json:"command"`
+
+	// The names of the funcs the command implements.
+	Funcs []string `json:"funcs"`
+
+	// How long to wait for a reply to each call. Default `10s`.
+	Timeout xtemplate.Duration `json:"timeout,omitempty"`
+}
+
+type funcCommandRequest struct {
+	Func string `json:"func"`
+	Args []any  `json:"args"`
+}
+
+type funcCommandResponse struct {
+	Result any    `json:"result"`
+	Error  string `json:"error"`
+}
+
+// funcProcess is a running FuncCommand.
+type funcProcess struct {
+	config FuncCommand
+	log    *slog.Logger
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// FuncMap returns the command's funcs, which call the process.
+func (c FuncCommand) FuncMap(log *slog.Logger) (template.FuncMap, error) {
+	if len(c.Command) == 0 {
+		return nil, fmt.Errorf("func command is required")
+	}
+	if len(c.Funcs) == 0 {
+		return nil, fmt.Errorf("func command '%s' must list the funcs it implements", c.Command[0])
+	}
+	if c.Timeout == 0 {
+		c.Timeout = xtemplate.Duration(10 * time.Second)
+	}
+	p := &funcProcess{config: c, log: log.With(slog.String("func_command", c.Command[0]))}
+	funcs := template.FuncMap{}
+	for _, name := range c.Funcs {
+		name := name
+		funcs[name] = func(args ...any) (any, error) {
+			return p.call(name, args)
+		}
+	}
+	return funcs, nil
+}
+
+// start starts the process if it's not running. p.mu must be held.
+func (p *funcProcess) start() error {
+	if p.cmd != nil {
+		return nil
+	}
+	cmd := exec.Command(p.config.Command[0], p.config.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			p.log.Info("func command stderr", slog.String("line", scanner.Text()))
+		}
+	}()
+	p.log.Debug("started func command", slog.Int("pid", cmd.Process.Pid))
+	p.cmd, p.stdin, p.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// stop kills the process so the next call starts it again. p.mu must be held.
+func (p *funcProcess) stop() {
+	if p.cmd == nil {
+		return
+	}
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	go p.cmd.Wait()
+	p.cmd, p.stdin, p.stdout = nil, nil, nil
+}
+
+func (p *funcProcess) call(name string, args []any) (any, error) {
+	if args == nil {
+		args = []any{}
+	}
+	request, err := json.Marshal(funcCommandRequest{Func: name, Args: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode arguments of func '%s': %w", name, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.start(); err != nil {
+		return nil, fmt.Errorf("failed to start func command '%s': %w", p.config.Command[0], err)
+	}
+
+	type reply struct {
+		line []byte
+		err  error
+	}
+	done := make(chan reply, 1)
+	stdin, stdout := p.stdin, p.stdout
+	go func() {
+		if _, err := stdin.Write(append(request, '\n')); err != nil {
+			done <- reply{err: err}
+			return
+		}
+		line, err := stdout.ReadBytes('\n')
+		done <- reply{line, err}
+	}()
+
+	var r reply
+	select {
+	case r = <-done:
+	case <-time.After(time.Duration(p.config.Timeout)):
+		p.stop()
+		return nil, fmt.Errorf("func '%s' timed out after %s", name, time.Duration(p.config.Timeout))
+	}
+	if r.err != nil {
+		p.stop()
+		return nil, fmt.Errorf("failed to call func '%s': %w", name, r.err)
+	}
+	var response funcCommandResponse
+	if err := json.Unmarshal(r.line, &response); err != nil {
+		p.stop()
+		return nil, fmt.Errorf("failed to decode reply of func '%s': %w", name, err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("func '%s' failed: %s", name, response.Error)
+	}
+	return response.Result, nil
+}
+
+// withExtraFuncs loads the funcs from plugins and func commands.
+func withExtraFuncs(plugins []string, commands []FuncCommand, log *slog.Logger) xtemplate.Option {
+	return func(c *xtemplate.Config) error {
+		for _, path := range plugins {
+			funcs, err := loadPlugin(path)
+			if err != nil {
+				return err
+			}
+			log.Debug("loaded funcs from plugin", slog.String("path", path), slog.Int("count", len(funcs)))
+			c.FuncMaps = append(c.FuncMaps, funcs)
+		}
+		for _, command := range commands {
+			funcs, err := command.FuncMap(log)
+			if err != nil {
+				return err
+			}
+			c.FuncMaps = append(c.FuncMaps, funcs)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/infogulch/xtemplate"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serve serves requests to server at the listen address, over HTTPS if a
+// certificate or ACME hosts are configured, and also over HTTP/3 if enabled.
+func serve(config *Args, server *xtemplate.Server, log *slog.Logger) error {
+	tlsConfig, redirect, err := config.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if config.HTTP3 && tlsConfig == nil {
+		return fmt.Errorf("http3 requires a tls certificate or acme hosts")
+	}
+	ln, err := config.listen(log)
+	if err != nil {
+		return err
+	}
+	srv := server.HTTPServer()
+	srv.TLSConfig = tlsConfig
+	go func() {
+		// finish responding to requests after the server is stopped, for
+		// example by the admin api
+		<-server.Stopped()
+		srv.Shutdown(context.Background())
+	}()
+	if tlsConfig == nil {
+		if config.H2C {
+			srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{})
+		}
+		log.Info("starting server", slog.String("listen", ln.Addr().String()), slog.Bool("h2c", config.H2C))
+		return srv.Serve(ln)
+	}
+	if config.RedirectListen != "" {
+		go func() {
+			log.Info("starting https redirect server", slog.String("listen", config.RedirectListen))
+			err := (&http.Server{Addr: config.RedirectListen, Handler: redirect, ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout)}).ListenAndServe()
+			log.Error("https redirect server stopped", slog.Any("error", err))
+		}()
+	}
+	if config.HTTP3 {
+		addr, ok := ln.Addr().(*net.TCPAddr)
+		if !ok {
+			ln.Close()
+			return fmt.Errorf("http3 requires a tcp listen address")
+		}
+		h3 := &http3.Server{Addr: addr.String(), Handler: srv.Handler, TLSConfig: tlsConfig, IdleTimeout: srv.IdleTimeout, MaxHeaderBytes: srv.MaxHeaderBytes}
+		// tell clients connected over tcp that they can switch to http3
+		handler := srv.Handler
+		srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h3.SetQUICHeaders(w.Header())
+			handler.ServeHTTP(w, r)
+		})
+		go func() {
+			log.Info("starting http3 server", slog.String("listen", addr.String()))
+			err := h3.ListenAndServe()
+			log.Error("http3 server stopped", slog.Any("error", err))
+		}()
+	}
+	log.Info("starting https server", slog.String("listen", ln.Addr().String()))
+	return srv.ServeTLS(ln, "", "")
+}
+
+// listen returns the socket passed by systemd socket activation if there is
+// one, otherwise it listens at the listen address, which is a unix socket if
+// it starts with `unix:`.
+func (config *Args) listen(log *slog.Logger) (net.Listener, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		if err == nil {
+			log.Info("using socket passed by systemd", slog.String("address", ln.Addr().String()))
+		}
+		return ln, err
+	}
+	path, ok := strings.CutPrefix(config.Listen, "unix:")
+	if !ok {
+		return net.Listen("tcp", config.Listen)
+	}
+	// remove the socket left behind by a previous process that wasn't stopped
+	// cleanly, but never another kind of file
+	if stat, err := os.Stat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale unix socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if config.SocketMode != "" {
+		mode, err := strconv.ParseUint(config.SocketMode, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid socket mode '%s': %w", config.SocketMode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set unix socket mode: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// systemdListener returns the first socket passed by systemd socket activation,
+// or nil if the process wasn't activated by a socket. See sd_listen_fds(3).
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	// don't pass the sockets on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// passed sockets start at fd 3, after stdin, stdout, and stderr
+	file := os.NewFile(3, "LISTEN_FD_3")
+	defer file.Close()
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use socket passed by systemd: %w", err)
+	}
+	return ln, nil
+}
+
+// tlsConfig returns the TLS config to serve HTTPS with and the handler that
+// redirects http requests to https, or nil if HTTPS isn't configured. With
+// ACME, the handler also answers http-01 challenges.
+func (config *Args) tlsConfig() (*tls.Config, http.Handler, error) {
+	switch {
+	case len(config.ACMEHosts) > 0:
+		if config.TLSCert != "" || config.TLSKey != "" {
+			return nil, nil, fmt.Errorf("acme hosts can't be used with a tls certificate")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.ACMEHosts...),
+			Cache:      autocert.DirCache(config.ACMECacheDir),
+			Email:      config.ACMEEmail,
+		}
+		return m.TLSConfig(), m.HTTPHandler(http.HandlerFunc(redirectHTTPS)), nil
+	case config.TLSCert != "" || config.TLSKey != "":
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, http.HandlerFunc(redirectHTTPS), nil
+	}
+	return nil, nil, nil
+}
+
+// redirectHTTPS redirects requests to the same url with the https scheme.
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Use HTTPS", http.StatusBadRequest)
+		return
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+}
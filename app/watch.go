@@ -0,0 +1,123 @@
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchExclude matches files and directories that change often but
+// never affect templates: version control, dependency directories, and the
+// temporary files that editors write next to the file being edited.
+var defaultWatchExclude = []string{".git", ".hg", ".svn", "node_modules", ".DS_Store", "*~", "*.swp", "*.swx", "*.tmp", ".#*", "#*#", "4913"}
+
+// watchDirs recursively watches dirs and calls fn with the paths that changed
+// once changes have stopped for the debounce interval. Changes to files or directories whose name
+// matches one of the exclude patterns are ignored, and excluded directories
+// are not watched at all.
+func watchDirs(dirs, exclude []string, debounce time.Duration, log *slog.Logger, fn func(changed []string)) (*fsnotify.Watcher, error) {
+	for _, pattern := range exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid watch exclude pattern '%s': %w", pattern, err)
+		}
+	}
+	// only match names below the watched directories
+	excluded := func(path_ string) bool {
+		rel := path_
+		for _, dir := range dirs {
+			if r, err := filepath.Rel(dir, path_); err == nil && !strings.HasPrefix(r, "..") {
+				rel = r
+				break
+			}
+		}
+		for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+			for _, pattern := range exclude {
+				if ok, _ := filepath.Match(pattern, elem); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+	add := func(root string) error {
+		return filepath.WalkDir(root, func(path_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if excluded(path_) {
+				return filepath.SkipDir
+			}
+			return watcher.Add(path_)
+		})
+	}
+	for _, dir := range dirs {
+		if err := add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch directory '%s': %w", dir, err)
+		}
+	}
+
+	var mu sync.Mutex
+	var timer *time.Timer
+	changed := make(map[string]struct{})
+	flush := func() {
+		mu.Lock()
+		paths := make([]string, 0, len(changed))
+		for p := range changed {
+			paths = append(paths, p)
+		}
+		clear(changed)
+		mu.Unlock()
+		fn(paths)
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if excluded(event.Name) || event.Op == fsnotify.Chmod {
+					continue
+				}
+				if event.Has(fsnotify.Create) {
+					if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
+						if err := add(event.Name); err != nil {
+							log.Info("failed to watch new directory", slog.String("directory", event.Name), slog.Any("error", err))
+						}
+					}
+				}
+				log.Debug("file changed", slog.String("path", event.Name), slog.String("op", event.Op.String()))
+				mu.Lock()
+				changed[event.Name] = struct{}{}
+				if timer == nil {
+					timer = time.AfterFunc(debounce, flush)
+				} else {
+					timer.Reset(debounce)
+				}
+				mu.Unlock()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Info("watcher error", slog.Any("error", err))
+			}
+		}
+	}()
+	return watcher, nil
+}
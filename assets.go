@@ -0,0 +1,76 @@
+package xtemplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+)
+
+// Asset describes a static file served by an Instance.
+type Asset struct {
+	// The url path the file is served at.
+	Path string `json:"path"`
+	// The content-hashed url path the file is also served at, if HashedAssets
+	// is enabled.
+	HashedPath  string          `json:"hashed_path,omitempty"`
+	Hash        string          `json:"hash"`
+	ContentType string          `json:"content_type"`
+	Encodings   []AssetEncoding `json:"encodings"`
+}
+
+// AssetEncoding describes one encoding of a static file that is available to
+// serve, including the identity (uncompressed) encoding.
+type AssetEncoding struct {
+	Encoding string `json:"encoding"`
+	// The path of the file in the templates FS.
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+}
+
+// Assets returns the list of static files served by the instance, sorted by
+// path.
+func (x *Instance) Assets() []Asset {
+	assets := make([]Asset, 0, len(x.files))
+	for _, f := range x.files {
+		asset := Asset{
+			Path:        f.identityPath,
+			HashedPath:  f.hashedPath,
+			Hash:        f.hash,
+			ContentType: f.contentType,
+		}
+		for _, e := range f.encodings {
+			asset.Encodings = append(asset.Encodings, AssetEncoding{Encoding: e.encoding, File: e.path, Size: e.size, ModTime: e.modtime})
+		}
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Path < assets[j].Path })
+	return assets
+}
+
+// Assets returns the list of static files served by the instance. See
+// [Instance.Assets].
+func (d DotX) Assets() []Asset {
+	return d.instance.Assets()
+}
+
+func (b *builder) addAssetManifestHandler() error {
+	pattern := "GET " + path.Clean("/"+b.config.AssetManifestPath)
+	instance := b.Instance
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := json.NewEncoder(w).Encode(instance.Assets()); err != nil {
+			GetLogger(r.Context()).Warn("failed to encode asset manifest", "error", err)
+		}
+	}
+	if err := catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.HandleFunc(pattern, handler) }); err != nil {
+		return err
+	}
+	b.Routes += 1
+	b.routes = append(b.routes, InstanceRoute{pattern, http.HandlerFunc(handler)})
+	return nil
+}
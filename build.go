@@ -3,32 +3,58 @@ package xtemplate
 // These types and methods are used while creating an instance
 
 import (
+	"bytes"
 	"compress/gzip"
 	"crypto/sha512"
 	"encoding/base64"
 	"fmt"
+	"hash/maphash"
 	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"text/template/parse"
 	"time"
 
 	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/zstd"
 	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/html"
 )
 
 type builder struct {
 	*Instance
 	*InstanceStats
-	m      *minify.M
-	routes []InstanceRoute
+	m       *minify.M
+	routes  []InstanceRoute
+	pages   []sitemapPage
+	content []*ContentPage
+
+	// templates that are rendered into a layout, and the html templates
+	// defined in each file that layouts are copied from
+	layoutPages []layoutPage
+	fileTrees   map[string]map[string]*parse.Tree
+
+	contentTypes  map[string]string
+	templateKinds []templateKind
+
+	reload *reloadHint
+
+	// the file each INIT template was defined in
+	inits map[string]initSource
+
+	// the global concurrency limit, and the routes that have their own
+	concurrency   *concurrencyLimiter
+	limitedRoutes map[string]bool
 }
 
 type InstanceStats struct {
@@ -36,8 +62,16 @@ type InstanceStats struct {
 	TemplateFiles                 int
 	TemplateDefinitions           int
 	TemplateInitializers          int
+	Initializers                  []string // names of initializers that succeeded, in execution order
+	CronJobs                      int
+	JobTemplates                  int
+	NatsServices                  int
+	ContentPages                  int
 	StaticFiles                   int
 	StaticFilesAlternateEncodings int
+	StaticFilesCached             int
+	StaticCacheBytes              int64
+	ReusedFiles                   int
 }
 
 type InstanceRoute struct {
@@ -46,23 +80,77 @@ type InstanceRoute struct {
 }
 
 type fileInfo struct {
-	identityPath, hash, contentType string
-	encodings                       []encodingInfo
+	identityPath, hashedPath, hash, contentType string
+	encodings                                   []encodingInfo
+	headers                                     http.Header
 }
 
 type encodingInfo struct {
 	encoding, path string
 	size           int64
 	modtime        time.Time
+	content        []byte // nil unless the file is cached in memory
 }
 
+// extensionContentTypes are used instead of http.DetectContentType for file
+// types that it can't identify from their contents.
 var extensionContentTypes = map[string]string{
-	".css": "text/css; charset=utf-8",
-	".js":  "text/javascript; charset=utf-8",
-	".csv": "text/csv",
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".csv":         "text/csv",
+	".json":        "application/json",
+	".map":         "application/json",
+	".svg":         "image/svg+xml",
+	".wasm":        "application/wasm",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".webmanifest": "application/manifest+json",
+	".txt":         "text/plain; charset=utf-8",
+	".xml":         "text/xml; charset=utf-8",
+	".ics":         "text/calendar; charset=utf-8",
+}
+
+// staticContentType returns the content type of a static file by its
+// extension if known, otherwise by sniffing the first 512 bytes of content.
+func (b *builder) staticContentType(ext string, content io.ReadSeeker) (string, error) {
+	ctype, ok := b.contentTypes[strings.ToLower(ext)]
+	if !ok {
+		buf := make([]byte, 512)
+		content.Seek(0, io.SeekStart)
+		count, err := content.Read(buf)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		ctype = http.DetectContentType(buf[:count])
+	}
+	if b.config.StaticCharset != "" && !strings.Contains(ctype, "charset=") && isTextContentType(ctype) {
+		ctype += "; charset=" + b.config.StaticCharset
+	}
+	return ctype, nil
+}
+
+func isTextContentType(ctype string) bool {
+	mediatype, _, _ := strings.Cut(ctype, ";")
+	switch mediatype = strings.TrimSpace(mediatype); {
+	case strings.HasPrefix(mediatype, "text/"), strings.HasSuffix(mediatype, "+json"), strings.HasSuffix(mediatype, "+xml"):
+		return true
+	}
+	switch mediatype {
+	case "application/json", "application/xml", "application/javascript", "image/svg+xml":
+		return true
+	}
+	return false
 }
 
 func (b *builder) addStaticFileHandler(path_ string) error {
+	if !b.config.Development && isDevelopmentFile(path_) {
+		b.config.Logger.Debug("skipping development-only static file", slog.String("filepath", path_))
+		return nil
+	}
+
 	// Open and stat the file
 	fsfile, err := b.config.TemplatesFS.Open(path_)
 	if err != nil {
@@ -75,6 +163,26 @@ func (b *builder) addStaticFileHandler(path_ string) error {
 		return fmt.Errorf("failed to stat file '%s': %w", path_, err)
 	}
 	size := stat.Size()
+	modtime := stat.ModTime()
+	if modtime.IsZero() {
+		modtime = buildTime()
+	}
+
+	// Hash the raw file to check if it changed since the previous instance
+	// before any decompressor reads from it, unless the reload hint says it
+	// didn't change.
+	stamp := fileStamp{size: size, modtime: modtime}
+	prev, hasPrev := b.reload.prevStatic(path_)
+	if hasPrev && b.reload.unchanged(path_, prev.fileStamp, stamp) {
+		stamp.sum = prev.sum
+	} else {
+		if stamp.sum, err = sumReader(fsfile); err == nil {
+			_, err = seeker.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read static file '%s': %w", path_, err)
+		}
+	}
 
 	var file *fileInfo
 	var encoding string
@@ -107,7 +215,10 @@ func (b *builder) addStaticFileHandler(path_ string) error {
 		file = &fileInfo{}
 	}
 
-	{
+	if hasPrev && b.reload.reusable(path_, prev.fileStamp, stamp, true) {
+		sri = prev.sri
+		b.ReusedFiles += 1
+	} else {
 		hash := sha512.New384()
 		_, err = io.Copy(hash, reader)
 		if err != nil {
@@ -115,6 +226,21 @@ func (b *builder) addStaticFileHandler(path_ string) error {
 		}
 		sri = "sha384-" + base64.URLEncoding.EncodeToString(hash.Sum(nil))
 	}
+	b.loaded.static[path_] = staticLoad{stamp, sri}
+
+	// Load small files into memory if caching is enabled and there's room in
+	// the budget
+	var content []byte
+	if b.config.StaticCacheBytes > 0 && size <= b.config.StaticCacheMaxFileSize && b.StaticCacheBytes+size <= b.config.StaticCacheBytes {
+		if _, err = seeker.Seek(0, io.SeekStart); err == nil {
+			content, err = io.ReadAll(seeker)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read static file '%s' into memory: %w", path_, err)
+		}
+		b.StaticFilesCached += 1
+		b.StaticCacheBytes += size
+	}
 
 	// Save precalculated file size, modtime, hash, content type, and encoding
 	// info to enable efficient content negotiation at request time.
@@ -122,21 +248,25 @@ func (b *builder) addStaticFileHandler(path_ string) error {
 		// note: identity file will always be found first because fs.WalkDir sorts files in lexical order
 		file.hash = sri
 		file.identityPath = identityPath
-		if ctype, ok := extensionContentTypes[ext]; ok {
-			file.contentType = ctype
-		} else {
-			content := make([]byte, 512)
-			seeker.Seek(0, io.SeekStart)
-			count, err := seeker.Read(content)
-			if err != nil && err != io.EOF {
-				return fmt.Errorf("failed to read file to guess content type '%s': %w", path_, err)
+		if file.contentType, err = b.staticContentType(ext, seeker); err != nil {
+			return fmt.Errorf("failed to read file to guess content type '%s': %w", path_, err)
+		}
+		file.encodings = []encodingInfo{{encoding: encoding, path: path_, size: size, modtime: modtime, content: content}}
+		for _, rule := range b.config.StaticHeaders {
+			if rule.matches(identityPath) {
+				file.headers = make(http.Header)
+				for k, v := range rule.Headers {
+					file.headers.Set(k, v)
+				}
+				if rule.CacheControl != "" {
+					file.headers.Set("Cache-Control", rule.CacheControl)
+				}
+				break
 			}
-			file.contentType = http.DetectContentType(content[:count])
 		}
-		file.encodings = []encodingInfo{{encoding: encoding, path: path_, size: size, modtime: stat.ModTime()}}
 
 		pattern := "GET " + identityPath
-		handler := staticFileHandler(b.config.TemplatesFS, file)
+		handler := staticFileHandler(b.Instance, file)
 		if err = catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.HandleFunc(pattern, handler) }); err != nil {
 			return err
 		}
@@ -145,19 +275,76 @@ func (b *builder) addStaticFileHandler(path_ string) error {
 		b.files[identityPath] = file
 		b.routes = append(b.routes, InstanceRoute{pattern, handler})
 
-		b.config.Logger.Debug("added static file handler", slog.String("path", identityPath), slog.String("filepath", path_), slog.String("contenttype", file.contentType), slog.Int64("size", size), slog.Time("modtime", stat.ModTime()), slog.String("hash", sri))
+		if b.config.HashedAssets {
+			file.hashedPath = hashedAssetPath(identityPath, sri)
+			pattern := "GET " + file.hashedPath
+			if err = catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.HandleFunc(pattern, handler) }); err != nil {
+				return err
+			}
+			b.Routes += 1
+			b.routes = append(b.routes, InstanceRoute{pattern, handler})
+		}
+
+		b.config.Logger.Debug("added static file handler", slog.String("path", identityPath), slog.String("filepath", path_), slog.String("contenttype", file.contentType), slog.Int64("size", size), slog.Time("modtime", modtime), slog.String("hash", sri))
 	} else {
 		if file.hash != sri {
 			return fmt.Errorf("encoded file contents did not match original file '%s': expected %s, got %s", path_, file.hash, sri)
 		}
-		file.encodings = append(file.encodings, encodingInfo{encoding: encoding, path: path_, size: size, modtime: stat.ModTime()})
+		file.encodings = append(file.encodings, encodingInfo{encoding: encoding, path: path_, size: size, modtime: modtime, content: content})
 		sort.Slice(file.encodings, func(i, j int) bool { return file.encodings[i].size < file.encodings[j].size })
 		b.StaticFilesAlternateEncodings += 1
-		b.config.Logger.Debug("added static file encoding", slog.String("path", identityPath), slog.String("filepath", path_), slog.String("encoding", encoding), slog.Int64("size", size), slog.Time("modtime", stat.ModTime()))
+		b.config.Logger.Debug("added static file encoding", slog.String("path", identityPath), slog.String("filepath", path_), slog.String("encoding", encoding), slog.Int64("size", size), slog.Time("modtime", modtime))
 	}
 	return nil
 }
 
+// isDevelopmentFile reports whether a static file is only served in
+// development mode: source maps like `app.js.map` and unminified variants like
+// `app.debug.js`, including their precompressed encodings.
+func isDevelopmentFile(path_ string) bool {
+	name := path.Base(path_)
+	for _, ext := range []string{".gz", ".zst", ".br"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return path.Ext(name) == ".map" || strings.Contains(name, ".debug.")
+}
+
+// debugAssetPath returns the path of the unminified variant of a static file,
+// e.g. `/assets/app.js` -> `/assets/app.debug.js`.
+func debugAssetPath(urlpath string) string {
+	ext := path.Ext(urlpath)
+	return strings.TrimSuffix(urlpath, ext) + ".debug" + ext
+}
+
+// hashedAssetPath inserts the first 8 characters of the file's content hash
+// before its extension, e.g. `/assets/app.js` -> `/assets/app.5rcfZgbO.js`.
+func hashedAssetPath(urlpath, hash string) string {
+	ext := path.Ext(urlpath)
+	digest := strings.TrimPrefix(hash, "sha384-")
+	return strings.TrimSuffix(urlpath, ext) + "." + digest[:8] + ext
+}
+
+// buildTime is used as the modtime of static files that don't have one, like
+// files in an embed.FS. It is the vcs commit time stamped into the binary if
+// available, otherwise the modtime of the executable.
+var buildTime = sync.OnceValue(func() time.Time {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.time" {
+				if t, err := time.Parse(time.RFC3339, setting.Value); err == nil {
+					return t
+				}
+			}
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		if stat, err := os.Stat(exe); err == nil {
+			return stat.ModTime()
+		}
+	}
+	return time.Now()
+})
+
 func catch(description string, fn func()) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -170,36 +357,178 @@ func catch(description string, fn func()) (err error) {
 
 var routeMatcher *regexp.Regexp = regexp.MustCompile("^(GET|POST|PUT|PATCH|DELETE|SSE) (.*)$")
 
-func (b *builder) addTemplateHandler(path_ string) error {
-	content, err := fs.ReadFile(b.config.TemplatesFS, path_)
-	if err != nil {
-		return fmt.Errorf("could not read template file '%s': %v", path_, err)
+// parseDelimsPragma looks for a comment on the first line of a template file
+// that declares alternate delimiters for the rest of the file, like:
+//
+//	{{/* delims [[ ]] */}}
+//
+// It returns the new delimiters and the content after that line.
+func parseDelimsPragma(content, ldelim, rdelim string) (l, r, rest string, ok bool) {
+	content = strings.TrimLeft(content, "\r\n")
+	line, rest, _ := strings.Cut(content, "\n")
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, ldelim) || !strings.HasSuffix(line, rdelim) {
+		return "", "", "", false
+	}
+	line = strings.TrimSpace(line[len(ldelim) : len(line)-len(rdelim)])
+	line = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "-"), "-"))
+	if !strings.HasPrefix(line, "/*") || !strings.HasSuffix(line, "*/") {
+		return "", "", "", false
+	}
+	fields := strings.Fields(line[2 : len(line)-2])
+	if len(fields) != 3 || fields[0] != "delims" {
+		return "", "", "", false
+	}
+	return fields[1], fields[2], rest, true
+}
+
+// templateKind describes how template files with an extension are parsed and
+// served.
+type templateKind struct {
+	ext string
+	// parsed with text/template instead of html/template
+	text bool
+	// the content type of responses, or empty to let net/http sniff html
+	contentType string
+	// minified with the html minifier at load time
+	minify bool
+}
+
+// textTemplateExtensions are the media extensions of template files that are
+// parsed with text/template. Every other template file is parsed with
+// html/template so its output is escaped.
+var textTemplateExtensions = []string{".txt", ".text", ".json", ".xml", ".ics", ".csv", ".md", ".yaml", ".yml", ".toml"}
+
+func (b *builder) newTemplateKind(ext string) templateKind {
+	mediaExt := path.Ext(strings.TrimSuffix(ext, ".tmpl"))
+	if ext == b.config.TemplateExtension || !slices.Contains(textTemplateExtensions, strings.ToLower(mediaExt)) {
+		return templateKind{ext: ext, minify: b.m != nil}
 	}
-	if b.m != nil {
-		content, err = b.m.Bytes("text/html", content)
+	contentType := b.contentTypes[mediaExt]
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	return templateKind{ext: ext, text: true, contentType: contentType}
+}
+
+// templateKindOf returns the kind of template file at path_ by its longest
+// matching extension, or false if it is not a template file.
+func (b *builder) templateKindOf(path_ string) (templateKind, bool) {
+	for _, kind := range b.templateKinds {
+		if strings.HasSuffix(path_, kind.ext) {
+			return kind, true
+		}
+	}
+	return templateKind{}, false
+}
+
+// parseTemplateFile minifies and parses the content of the template file at
+// path_.
+func (b *builder) parseTemplateFile(path_ string, content []byte, kind templateKind) (map[string]any, map[string]*parse.Tree, error) {
+	var err error
+	// html template files may start with front matter that describes the
+	// page. Text templates don't, since their output can start with a fence,
+	// like a yaml document.
+	var meta map[string]any
+	body := string(content)
+	if !kind.text {
+		if meta, body, err = extractTemplateFrontMatter(body); err != nil {
+			return nil, nil, fmt.Errorf("could not parse front matter in template file '%s': %v", path_, err)
+		}
+	}
+	// a file can use different delimiters than the rest of the instance
+	ldelim, rdelim := b.config.LDelim, b.config.RDelim
+	if l, r, rest, ok := parseDelimsPragma(body, ldelim, rdelim); ok {
+		ldelim, rdelim, body = l, r, rest
+		b.config.Logger.Debug("template file uses alternate delimiters", slog.String("template_path", path_), slog.String("ldelim", l), slog.String("rdelim", r))
+	}
+	content = []byte(body)
+	if kind.minify {
+		if ldelim == b.config.LDelim && rdelim == b.config.RDelim {
+			content, err = b.m.Bytes("text/html", content)
+		} else {
+			buf := new(bytes.Buffer)
+			err = (&html.Minifier{TemplateDelims: [...]string{ldelim, rdelim}}).Minify(b.m, buf, bytes.NewReader(content), nil)
+			content = buf.Bytes()
+		}
 		if err != nil {
-			return fmt.Errorf("could not minify template file '%s': %v", path_, err)
+			return nil, nil, fmt.Errorf("could not minify template file '%s': %v", path_, err)
 		}
 	}
-	path_ = path.Clean("/" + path_)
 	// parse each template file manually to have more control over its final
 	// names in the template namespace.
-	newtemplates, err := parse.Parse(path_, string(content), b.config.LDelim, b.config.RDelim, b.funcs, buliltinsSkeleton)
+	newtemplates, err := parse.Parse(path.Clean("/"+path_), string(content), ldelim, rdelim, b.funcs, buliltinsSkeleton)
 	if err != nil {
-		return fmt.Errorf("could not parse template file '%s': %v", path_, err)
+		return nil, nil, fmt.Errorf("could not parse template file '%s': %v", path_, err)
+	}
+	if err := rewriteCachedBlocks(newtemplates); err != nil {
+		return nil, nil, fmt.Errorf("could not parse template file '%s': %v", path_, err)
+	}
+	if err := rewriteComponents(newtemplates); err != nil {
+		return nil, nil, fmt.Errorf("could not parse template file '%s': %v", path_, err)
+	}
+	return meta, newtemplates, nil
+}
+
+// addTemplateHandler parses the template file at path_ and registers its
+// routes. Text templates are parsed into a separate namespace from html
+// templates.
+func (b *builder) addTemplateHandler(path_ string, kind templateKind) error {
+	// Read and hash the file to check if it changed since the previous
+	// instance, unless the reload hint says it didn't change.
+	stamp, stamped := b.stamp(path_)
+	prev, hasPrev := b.reload.prevTemplate(path_)
+	var content []byte
+	var err error
+	if hasPrev && stamped && b.reload.unchanged(path_, prev.fileStamp, stamp) {
+		stamp.sum = prev.sum
+	} else {
+		if content, err = fs.ReadFile(b.config.TemplatesFS, path_); err != nil {
+			return fmt.Errorf("could not read template file '%s': %v", path_, err)
+		}
+		stamp.sum = maphash.Bytes(stampSeed, content)
+	}
+	var meta map[string]any
+	var newtemplates map[string]*parse.Tree
+	if hasPrev && b.reload.reusable(path_, prev.fileStamp, stamp, stamped) {
+		meta, newtemplates = prev.meta, copyTrees(prev.trees)
+		b.ReusedFiles += 1
+	} else if meta, newtemplates, err = b.parseTemplateFile(path_, content, kind); err != nil {
+		return err
+	}
+	if stamped {
+		// html/template rewrites trees when escaping, so keep a pristine copy
+		b.loaded.templates[path_] = templateLoad{stamp, copyTrees(newtemplates), meta}
 	}
+	path_ = path.Clean("/" + path_)
 	b.TemplateFiles += 1
+	if !kind.text {
+		b.fileTrees[path_] = newtemplates
+	}
 
 	// add parsed templates, register handlers
 	for name, tree := range newtemplates {
-		if b.templates.Lookup(name) != nil {
-			b.config.Logger.Debug("overriding named template '%s' with definition from file: %s", name, path_)
+		var tmpl templateExecutor
+		if kind.text {
+			if b.textTemplates.Lookup(name) != nil {
+				b.config.Logger.Debug("overriding named text template with definition from file", slog.String("name", name), slog.String("template_path", path_))
+			}
+			tmpl, err = b.textTemplates.AddParseTree(name, tree)
+		} else {
+			if b.templates.Lookup(name) != nil {
+				b.config.Logger.Debug("overriding named template '%s' with definition from file: %s", name, path_)
+			}
+			tmpl, err = b.templates.AddParseTree(name, tree)
 		}
-		tmpl, err := b.templates.AddParseTree(name, tree)
 		if err != nil {
 			return fmt.Errorf("could not add template '%s' from '%s': %v", name, path_, err)
 		}
 		b.TemplateDefinitions += 1
+		if strings.HasPrefix(name, "INIT ") {
+			if err := b.recordInit(name, path_, meta); err != nil {
+				return err
+			}
+		}
 
 		var pattern string
 		var handler http.HandlerFunc
@@ -209,8 +538,12 @@ func (b *builder) addTemplateHandler(path_ string) error {
 			if len(file) > 0 && file[0] == '.' {
 				continue
 			}
-			// strip the extension from the handled path
-			routePath := strings.TrimSuffix(path_, b.config.TemplateExtension)
+			// strip the extension from the handled path, or just the `.tmpl`
+			// suffix so `feed.xml.tmpl` is served at `feed.xml`
+			routePath := strings.TrimSuffix(path_, kind.ext)
+			if strings.HasSuffix(kind.ext, ".tmpl") {
+				routePath = strings.TrimSuffix(path_, ".tmpl")
+			}
 			// files named 'index' handle requests to the directory
 			base := path.Base(routePath)
 			if base == "index" {
@@ -221,7 +554,14 @@ func (b *builder) addTemplateHandler(path_ string) error {
 			}
 			routePath = path.Clean(routePath)
 			pattern = "GET " + routePath
-			handler = bufferingTemplateHandler(b.Instance, tmpl)
+			if b.config.Sitemap != nil && !kind.text {
+				b.pages = append(b.pages, sitemapPage{routePath: routePath, modtime: stamp.modtime, meta: meta})
+			}
+			if layout, ok := meta["layout"].(string); ok && !kind.text {
+				b.layoutPages = append(b.layoutPages, layoutPage{pattern, path_, layout, kind, newtemplates})
+				continue
+			}
+			handler = b.limitConcurrency(pattern, bufferingTemplateHandler(b.Instance, tmpl, kind.contentType), false)
 		} else if matches := routeMatcher.FindStringSubmatch(name); len(matches) == 3 {
 			method, path_ := matches[1], matches[2]
 			if method == "SSE" {
@@ -229,8 +569,9 @@ func (b *builder) addTemplateHandler(path_ string) error {
 				handler = flushingTemplateHandler(b.Instance, tmpl)
 			} else {
 				pattern = method + " " + path_
-				handler = bufferingTemplateHandler(b.Instance, tmpl)
+				handler = bufferingTemplateHandler(b.Instance, tmpl, kind.contentType)
 			}
+			handler = b.limitConcurrency(pattern, handler, method == "SSE")
 		} else {
 			continue
 		}
@@ -0,0 +1,75 @@
+package xtemplate
+
+// This file implements caching the rendered output of a region of a template,
+// written like `{{with cachedblock "sidebar" 60}}...{{end}}`.
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// blockCacheEntries is the maximum number of rendered blocks kept by an
+// instance.
+const blockCacheEntries = 10000
+
+// rewriteCachedBlocks replaces each `{{with cachedblock name ttl args...}}`
+// block in trees with an action that renders the block's body as a separate
+// template and caches its output. The body is executed with the same dot as
+// the surrounding template.
+func rewriteCachedBlocks(trees map[string]*parse.Tree) error {
+	return rewriteWithBlocks(trees, "cachedblock", func(tree *parse.Tree, with *parse.BranchNode, n int) (parse.Node, []*parse.Tree, error) {
+		name := fmt.Sprintf("cachedblock %s %d", tree.ParseName, n)
+		block := &parse.Tree{Name: name, ParseName: tree.ParseName, Root: with.List}
+		// pass the block's original arguments after the dot
+		action, err := parseAction(name, "{{cachedblock "+strconv.Quote(name)+" .}}", map[string]any{"cachedblock": (*Instance).cachedBlock})
+		if err != nil {
+			return nil, nil, err
+		}
+		action.Pipe.Cmds[0].Args = append(action.Pipe.Cmds[0].Args, with.Pipe.Cmds[0].Args[1:]...)
+		return action, []*parse.Tree{block}, nil
+	})
+}
+
+// cachedBlock is the func that `{{with cachedblock name ttl args...}}` blocks
+// are rewritten to call. It returns the cached output of the block keyed by
+// its template namespace, file, and position, name, and args, or renders the
+// block with dot and caches it until ttl expires. Blocks with the same name in
+// different places are cached separately.
+func (x *Instance) cachedBlock(block string, dot any, name string, ttl any, args ...any) (template.HTML, error) {
+	if !strings.HasPrefix(block, "cachedblock ") {
+		return "", fmt.Errorf("cachedblock must be used like {{with cachedblock \"name\" ttl}}...{{end}}")
+	}
+	var t templateExecutor
+	var namespace string
+	if ht := x.templates.Lookup(block); ht != nil {
+		t, namespace = ht, "html"
+	} else if tt := x.textTemplates.Lookup(block); tt != nil {
+		t, namespace = tt, "text"
+	} else {
+		return "", fmt.Errorf("failed to lookup cached block '%s'", block)
+	}
+	key := fmt.Sprintf("%s %s %q", namespace, block, name)
+	if len(args) > 0 {
+		key = fmt.Sprintf("%s %v", key, args)
+	}
+	if v, ok := x.blocks.get(key); ok {
+		return v.(template.HTML), nil
+	}
+	dur, err := parseTTL(ttl)
+	if err != nil {
+		return "", err
+	}
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	if err := t.Execute(buf, dot); err != nil {
+		return "", fmt.Errorf("failed to execute cached block '%s': %w", name, err)
+	}
+	result := template.HTML(buf.String())
+	x.blocks.set(key, result, dur)
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package xtemplate
+
+// This file implements components: templates that are called with data and
+// nested markup, written like:
+//
+//	{{with component "card" (dict "Title" "Hello")}}
+//	  <p>The body of the card.</p>
+//	  {{with slot "footer"}}<a href="/more">More</a>{{end}}
+//	{{end}}
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// ComponentDot is the dot value used to execute a component's template.
+type ComponentDot struct {
+	// The data passed to the component, if any.
+	Props any
+	// The rendered body of the component block, without its slots.
+	Body template.HTML
+	// The rendered contents of each slot by name.
+	Slots map[string]template.HTML
+	// The dot of the template that used the component.
+	Caller any
+}
+
+// Slot returns the rendered contents of the slot name, or an empty string if
+// the slot wasn't filled.
+func (c ComponentDot) Slot(name string) template.HTML {
+	return c.Slots[name]
+}
+
+// HasSlot reports whether the slot name was filled.
+func (c ComponentDot) HasSlot(name string) bool {
+	_, ok := c.Slots[name]
+	return ok
+}
+
+// rewriteComponents replaces each `{{with component name data}}` block in
+// trees with an action that renders the block's body and each `{{with slot
+// name}}` directly inside it as separate templates, then executes the
+// component's template with them. The body and slots are executed with the
+// same dot as the surrounding template.
+func rewriteComponents(trees map[string]*parse.Tree) error {
+	return rewriteWithBlocks(trees, "component", func(tree *parse.Tree, with *parse.BranchNode, n int) (parse.Node, []*parse.Tree, error) {
+		name := fmt.Sprintf("component %s %d", tree.ParseName, n)
+		body := &parse.ListNode{NodeType: parse.NodeList, Pos: with.List.Pos}
+		var blocks []*parse.Tree
+		var slots []string
+		for _, node := range with.List.Nodes {
+			if !isWithBlock(node, "slot") {
+				body.Nodes = append(body.Nodes, node)
+				continue
+			}
+			slot := &node.(*parse.WithNode).BranchNode
+			args := slot.Pipe.Cmds[0].Args
+			s, ok := args[len(args)-1].(*parse.StringNode)
+			if len(args) != 2 || !ok || len(slot.Pipe.Decl) > 0 || slot.ElseList != nil {
+				return nil, nil, fmt.Errorf("slot in template '%s' must be used like {{with slot \"name\"}}...{{end}}", tree.Name)
+			}
+			if strings.ContainsAny(s.Text, " ") {
+				return nil, nil, fmt.Errorf("slot name '%s' in template '%s' can't contain spaces", s.Text, tree.Name)
+			}
+			slots = append(slots, s.Text)
+			blocks = append(blocks, &parse.Tree{Name: name + " slot " + s.Text, ParseName: tree.ParseName, Root: slot.List})
+		}
+		blocks = append(blocks, &parse.Tree{Name: name, ParseName: tree.ParseName, Root: body})
+		// pass the block's original arguments after the dot
+		action, err := parseAction(name, "{{component "+strconv.Quote(name)+" "+strconv.Quote(strings.Join(slots, " "))+" .}}", map[string]any{"component": (*Instance).component})
+		if err != nil {
+			return nil, nil, err
+		}
+		action.Pipe.Cmds[0].Args = append(action.Pipe.Cmds[0].Args, with.Pipe.Cmds[0].Args[1:]...)
+		return action, blocks, nil
+	})
+}
+
+// component is the func that `{{with component name data}}` blocks are
+// rewritten to call. It renders the block's body and slots with dot, then
+// returns the output of the template name executed with a [ComponentDot].
+func (x *Instance) component(block, slots string, dot any, name string, data ...any) (template.HTML, error) {
+	if !strings.HasPrefix(block, "component ") {
+		return "", fmt.Errorf("component must be used like {{with component \"name\" data}}...{{end}}")
+	}
+	if len(data) > 1 {
+		return "", fmt.Errorf("component '%s' accepts one data argument, got %d", name, len(data))
+	}
+	c := ComponentDot{Slots: make(map[string]template.HTML), Caller: dot}
+	if len(data) == 1 {
+		c.Props = data[0]
+	}
+	var err error
+	if c.Body, err = x.renderBlock(block, dot); err != nil {
+		return "", err
+	}
+	for _, slot := range strings.Fields(slots) {
+		if c.Slots[slot], err = x.renderBlock(block+" slot "+slot, dot); err != nil {
+			return "", err
+		}
+	}
+	result, err := x.renderBlock(name, c)
+	if err != nil {
+		return "", fmt.Errorf("failed to render component '%s': %w", name, err)
+	}
+	return result, nil
+}
+
+// slot is only valid directly inside a component block, where it's removed
+// when the template is parsed.
+func slot(name string) (string, error) {
+	return "", fmt.Errorf("slot '%s' must be used directly inside a {{with component ...}} block", name)
+}
+
+// renderBlock executes the html template name with dot and returns its output
+// with surrounding whitespace trimmed. Text templates aren't escaped, so their
+// output can't be marked as safe html.
+func (x *Instance) renderBlock(name string, dot any) (template.HTML, error) {
+	t := x.templates.Lookup(name)
+	if t == nil {
+		if x.textTemplates.Lookup(name) != nil {
+			return "", fmt.Errorf("template '%s' is a text template, components must be html templates", name)
+		}
+		return "", fmt.Errorf("failed to lookup template '%s'", name)
+	}
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	if err := t.Execute(buf, dot); err != nil {
+		return "", err
+	}
+	return template.HTML(strings.TrimSpace(buf.String())), nil
+}
@@ -0,0 +1,96 @@
+package xtemplate
+
+// This file implements on-demand compression of static files that don't have
+// precompressed alternatives.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// onDemandEncodings are the encodings that static files can be compressed to
+// on demand, in order of preference.
+var onDemandEncodings = []encodingInfo{{encoding: "br"}, {encoding: "zstd"}, {encoding: "gzip"}, {encoding: "identity"}}
+
+// Files smaller than this are not worth compressing.
+const minCompressSize = 256
+
+// compressible reports whether a file with the given content type and size is
+// likely to benefit from compression.
+func compressible(contentType string, size int64) bool {
+	if size < minCompressSize {
+		return false
+	}
+	mediatype, _, _ := strings.Cut(contentType, ";")
+	if strings.HasPrefix(mediatype, "text/") {
+		return true
+	}
+	for _, s := range []string{"javascript", "json", "xml", "wasm", "font/ttf", "font/otf", "image/x-icon", "image/vnd.microsoft.icon"} {
+		if strings.Contains(mediatype, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type compressedFile struct {
+	once    sync.Once
+	content []byte
+	err     error
+}
+
+// compressed returns the contents of the static file compressed with
+// encoding, compressing it on the first call and caching the result keyed by
+// the file's hash. Returns nil content if compression doesn't make the file
+// smaller.
+func (x *Instance) compressed(fileinfo *fileInfo, encoding string) ([]byte, error) {
+	v, _ := x.compressedFiles.LoadOrStore(fileinfo.hash+" "+encoding, &compressedFile{})
+	cf := v.(*compressedFile)
+	cf.once.Do(func() {
+		identity := fileinfo.encodings[0]
+		var file io.Reader = bytes.NewReader(identity.content)
+		if identity.content == nil {
+			f, err := x.config.TemplatesFS.Open(identity.path)
+			if err != nil {
+				cf.err = fmt.Errorf("failed to open static file '%s': %w", identity.path, err)
+				return
+			}
+			defer f.Close()
+			file = f
+		}
+
+		buf := new(bytes.Buffer)
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w, _ = gzip.NewWriterLevel(buf, gzip.BestCompression)
+		case "br":
+			w = brotli.NewWriter(buf)
+		case "zstd":
+			w, _ = zstd.NewWriter(buf, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+		default:
+			cf.err = fmt.Errorf("unsupported encoding '%s'", encoding)
+			return
+		}
+		if _, err := io.Copy(w, file); err != nil {
+			cf.err = fmt.Errorf("failed to compress static file '%s': %w", identity.path, err)
+			return
+		}
+		if err := w.Close(); err != nil {
+			cf.err = fmt.Errorf("failed to compress static file '%s': %w", identity.path, err)
+			return
+		}
+		if int64(buf.Len()) < identity.size {
+			cf.content = buf.Bytes()
+		}
+		x.config.Logger.Debug("compressed static file on demand", "path", fileinfo.identityPath, "encoding", encoding, "size", identity.size, "compressed_size", buf.Len())
+	})
+	return cf.content, cf.err
+}
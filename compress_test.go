@@ -0,0 +1,58 @@
+package xtemplate
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressStaticOnDemand(t *testing.T) {
+	lorem := strings.Repeat("Lorem ipsum dolor sit amet. ", 40)
+	compress := func(c *Config) error {
+		c.CompressStatic = true
+		return nil
+	}
+	_, ts := newTestServer(t, map[string]string{"lorem.txt": lorem, "small.txt": "small"}, nil, nil, compress)
+
+	for _, test := range []struct {
+		accept, encoding string
+		decode           func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip, br, zstd", "br", func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+		{"zstd, gzip", "zstd", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{"gzip", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"identity", "identity", func(r io.Reader) (io.Reader, error) { return r, nil }},
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+"/lorem.txt", nil)
+		// setting a non-empty header stops the client from asking for gzip
+		// and decoding it itself
+		req.Header.Set("Accept-Encoding", test.accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := test.decode(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", test.encoding, err)
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != test.encoding || string(body) != lorem {
+			t.Errorf("accepting %q got encoding %q and body %q, want %q", test.accept, got, body, test.encoding)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/small.txt", nil)
+	req.Header.Set("Accept-Encoding", "zstd")
+	if resp, body := doRequest(t, req); resp.Header.Get("Content-Encoding") != "identity" || body != "small" {
+		t.Errorf("small file got encoding %q, want identity", resp.Header.Get("Content-Encoding"))
+	}
+}
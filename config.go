@@ -8,6 +8,12 @@ import (
 	"html/template"
 	"io/fs"
 	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"testing/fstest"
+	"time"
 )
 
 func New() (c *Config) {
@@ -23,17 +29,210 @@ type Config struct {
 	// The FS to load templates from. Overrides TemplatesDir if not nil.
 	TemplatesFS fs.FS `json:"-" arg:"-"`
 
-	// File extension to search for to find template files. Default `.html`.
-	TemplateExtension string `json:"template_extension,omitempty" arg:"--template-ext" default:".html"`
+	// Load templates from a bucket in S3-compatible object storage. Overrides
+	// TemplatesDir if not nil.
+	TemplatesS3 *S3Config `json:"templates_s3,omitempty" arg:"-"`
+
+	// Directories of base templates, like a theme, that files loaded from the
+	// templates dir override. Files in earlier directories override files in
+	// later ones. Default `[]`.
+	TemplatesBaseDirs []string `json:"templates_base_dirs,omitempty" arg:"--template-base-dir,separate"`
+
+	// FSs of base templates that are merged after TemplatesBaseDirs.
+	TemplatesBaseFS []fs.FS `json:"-" arg:"-"`
+
+	// Load templates from a SQL table or a NATS KV bucket, or a custom
+	// [TemplateSource]. A [Server] reloads when the source changes. Overrides
+	// TemplatesDir if not nil.
+	TemplatesDB     *SQLSourceConfig `json:"templates_db,omitempty" arg:"-"`
+	TemplatesKV     *KVSourceConfig  `json:"templates_kv,omitempty" arg:"-"`
+	TemplatesSource TemplateSource   `json:"-" arg:"-"`
+
+	// How long a [Server] waits for changes to the templates source to settle
+	// before reloading. Default `250ms`.
+	TemplatesSourceDebounce Duration `json:"templates_source_debounce,omitempty" arg:"-"`
+
+	// File extension of html templates, which are parsed with html/template
+	// so their output is escaped for its context. Default `.html`.
+	TemplateExtension string `json:"template_extension,omitempty" arg:"--template-ext"`
+
+	// File extensions to search for to find template files. The
+	// TemplateExtension and extensions of other kinds of html are parsed with
+	// html/template, while text formats like `.txt`, `.json`, `.xml`, `.ics`,
+	// and `.csv` are parsed with text/template so their output is not
+	// html-escaped, like emails, JSON, feeds, and calendars, and are served
+	// with the content type of their extension. A trailing `.tmpl` is stripped
+	// from the route instead of the whole extension, so `feed.xml.tmpl` is
+	// served at `/feed.xml`. Default `[TemplateExtension, ".txt.tmpl",
+	// ".json.tmpl", ".xml.tmpl", ".ics.tmpl"]`.
+	TemplateExtensions []string `json:"template_extensions,omitempty" arg:"--template-exts,separate"`
 
 	// Whether html templates are minified at load time. Default `true`.
 	Minify bool `json:"minify,omitempty" arg:"-m,--minify" default:"true"`
 
-	Databases       []DotDBConfig    `json:"databases" arg:"-"`
-	Flags           []DotFlagsConfig `json:"flags" arg:"-"`
-	Directories     []DotDirConfig   `json:"directories" arg:"-"`
-	Nats            []DotNatsConfig  `json:"nats" arg:"-"`
-	CustomProviders []DotConfig      `json:"-" arg:"-"`
+	// Whether static files are also served at a content-hashed path like
+	// `/assets/app.<hash>.js`. The `asset` func resolves a static file path to
+	// its hashed path when enabled. Default `false`.
+	HashedAssets bool `json:"hashed_assets,omitempty" arg:"--hashed-assets"`
+
+	// Whether compressible static files without precompressed `.br`, `.zst`,
+	// or `.gz` siblings are compressed on their first request and cached in
+	// memory. Default `false`.
+	CompressStatic bool `json:"compress_static,omitempty" arg:"--compress-static"`
+
+	// The total size in bytes of static files that can be loaded into memory at
+	// load time and served without reading from the FS on each request. Files
+	// are loaded in path order until the budget is used up. Default `0`,
+	// disabled.
+	StaticCacheBytes int64 `json:"static_cache_bytes,omitempty" arg:"--static-cache-bytes"`
+
+	// Static files larger than this size in bytes are never loaded into memory.
+	// Default `65536`.
+	StaticCacheMaxFileSize int64 `json:"static_cache_max_file_size,omitempty" arg:"--static-cache-max-file-size"`
+
+	// The name of a template to render when serving a request panics. It's
+	// executed with a [PanicInfo] as dot. Default ``, a plain error message is
+	// returned that includes the stack trace in development mode.
+	ErrorTemplate string `json:"error_template,omitempty" arg:"--error-template"`
+
+	// Enables the admin API at `/_xtemplate/admin` when served by a [Server],
+	// which can patch individual template files into the running server,
+	// reload it, list its stats and routes, and drain or stop it.
+	// Requests must include the header `Authorization: Bearer <admin_token>`.
+	// Default ``, disabled.
+	AdminToken string `json:"admin_token,omitempty" arg:"--admin-token"`
+
+	// Serve the admin API at this address instead of with the other routes,
+	// like `localhost:9000` or `unix:/run/xtemplate-admin.sock`, so it isn't
+	// public. Requires AdminToken. Default ``, served with the other routes.
+	AdminListen string `json:"admin_listen,omitempty" arg:"--admin-listen"`
+
+	// Timeouts of the http server started by [Server.Serve] and the CLI. See
+	// [http.Server]. The write timeout doesn't apply to SSE templates, which
+	// stay open. Default `10s` for ReadHeaderTimeout, `2m` for IdleTimeout,
+	// and `0`, no timeout, for ReadTimeout and WriteTimeout.
+	ReadTimeout       Duration `json:"read_timeout,omitempty" arg:"--read-timeout"`
+	ReadHeaderTimeout Duration `json:"read_header_timeout,omitempty" arg:"--read-header-timeout"`
+	WriteTimeout      Duration `json:"write_timeout,omitempty" arg:"--write-timeout"`
+	IdleTimeout       Duration `json:"idle_timeout,omitempty" arg:"--idle-timeout"`
+
+	// The largest size in bytes of request headers the http server started
+	// by [Server.Serve] and the CLI reads. Default `1048576`.
+	MaxHeaderBytes int `json:"max_header_bytes,omitempty" arg:"--max-header-bytes"`
+
+	// Record how long each template, `.X.Template` call, and database query
+	// takes for every request. The breakdown is logged and also returned in the
+	// `Server-Timing` header. Default `false`.
+	Trace bool `json:"trace,omitempty" arg:"--trace"`
+
+	// Whether the instance serves development-only static files: source maps
+	// like `app.js.map` and unminified variants like `app.debug.js`. The `asset`
+	// func resolves a file to its `.debug` variant when one exists. A [Server]
+	// in development mode also refreshes browsers after it reloads. Default
+	// `false`, these files are not served.
+	Development bool `json:"development,omitempty" arg:"--dev"`
+
+	// Serve a JSON manifest of all static files with their hashes, content
+	// types, and encodings at this path, like `/assets.json`. Default ``,
+	// disabled.
+	AssetManifestPath string `json:"asset_manifest_path,omitempty" arg:"--asset-manifest-path"`
+
+	// Content types of static files by file extension, like
+	// `{".webmanifest": "application/manifest+json"}`. Overrides the built-in
+	// extension mappings, which override content type sniffing.
+	ContentTypes map[string]string `json:"content_types,omitempty" arg:"-"`
+
+	// The charset added to text static file content types that don't specify
+	// one, like `utf-8`. Default ``, content types are left as is.
+	StaticCharset string `json:"static_charset,omitempty" arg:"--static-charset"`
+
+	// Whether static file responses include `X-Content-Type-Options: nosniff`
+	// to prevent browsers from guessing a different content type. Default
+	// `false`.
+	NoSniff bool `json:"nosniff,omitempty" arg:"--nosniff"`
+
+	// Cache-Control and other headers to add to static file responses by path.
+	// The first rule that matches a file's path is used.
+	StaticHeaders []StaticHeaderRule `json:"static_headers,omitempty" arg:"-"`
+
+	Databases       []DotDBConfig        `json:"databases" arg:"-"`
+	Flags           []DotFlagsConfig     `json:"flags" arg:"-"`
+	Directories     []DotDirConfig       `json:"directories" arg:"-"`
+	Nats            []DotNatsConfig      `json:"nats" arg:"-"`
+	Caches          []DotCacheConfig     `json:"caches" arg:"-"`
+	Memcached       []DotMemcachedConfig `json:"memcached" arg:"-"`
+	Redis           []DotRedisConfig     `json:"redis" arg:"-"`
+	Stores          []DotStoreConfig     `json:"stores" arg:"-"`
+	Buckets         []DotBucketConfig    `json:"buckets" arg:"-"`
+	Mailers         []DotMailConfig      `json:"mailers" arg:"-"`
+	GeoIP           []DotGeoIPConfig     `json:"geoip" arg:"-"`
+	MQTT            []DotMQTTConfig      `json:"mqtt" arg:"-"`
+	Kafka           []DotKafkaConfig     `json:"kafka" arg:"-"`
+	GraphQL         []DotGraphQLConfig   `json:"graphql" arg:"-"`
+	GRPC            []DotGRPCConfig      `json:"grpc" arg:"-"`
+	Exec            []DotExecConfig      `json:"exec" arg:"-"`
+	Bus             []DotBusConfig       `json:"bus" arg:"-"`
+	Sqlite          []DotSqliteConfig    `json:"sqlite" arg:"-"`
+	Search          []DotSearchConfig    `json:"search" arg:"-"`
+	Scripts         []DotScriptConfig    `json:"scripts" arg:"-"`
+	AI              []DotAIConfig        `json:"ai" arg:"-"`
+	Image           []DotImageConfig     `json:"image" arg:"-"`
+	PDF             []DotPDFConfig       `json:"pdf" arg:"-"`
+	KV              []DotKVConfig        `json:"kv" arg:"-"`
+	NatsCache       []DotNatsCacheConfig `json:"nats_cache" arg:"-"`
+	CustomProviders []DotConfig          `json:"-" arg:"-"`
+
+	// Serve resized variants of images. Disabled if nil.
+	Images *ImagesConfig `json:"images,omitempty" arg:"-"`
+
+	// Execute background jobs with templates. Disabled if nil. See
+	// [JobsConfig].
+	Jobs *JobsConfig `json:"jobs,omitempty" arg:"-"`
+
+	// Control cron jobs from templates. Disabled if nil. See [CronConfig].
+	Cron *CronConfig `json:"cron,omitempty" arg:"-"`
+
+	// Serve metrics and let templates record their own. Disabled if nil. See
+	// [MetricsConfig].
+	Metrics *MetricsConfig `json:"metrics,omitempty" arg:"-"`
+
+	// Write a line for each request to an access log. Disabled if nil. The
+	// instances of a [Server] share the log, unless options passed to
+	// [Server.Reload] change it. See [AccessLogConfig].
+	AccessLog *AccessLogConfig `json:"access_log,omitempty" arg:"-"`
+
+	// Limit how many templates execute at once. Disabled if nil. See
+	// [ConcurrencyConfig].
+	Concurrency *ConcurrencyConfig `json:"concurrency,omitempty" arg:"-"`
+
+	// Serve a configured directory over WebDAV. Disabled if nil. See
+	// [WebDAVConfig].
+	WebDAV *WebDAVConfig `json:"webdav,omitempty" arg:"-"`
+
+	// Named markdown renderers available to the `markdown` and `markdownTOC`
+	// funcs and content pages, in addition to the built-in `default` and
+	// `unsafe` renderers. See [MarkdownConfig].
+	Markdown []MarkdownConfig `json:"markdown,omitempty" arg:"-"`
+
+	// Named html sanitization policies available to the `sanitizeHtml` func,
+	// in addition to the built-in `strict`, `ugc`, and `externalugc` policies.
+	// See [SanitizePolicyConfig].
+	SanitizePolicies []SanitizePolicyConfig `json:"sanitize_policies,omitempty" arg:"-"`
+
+	// Serve markdown files as pages rendered into a layout template. Disabled
+	// if nil. See [ContentConfig].
+	Content *ContentConfig `json:"content,omitempty" arg:"-"`
+
+	// Generate `/sitemap.xml` and `/robots.txt` routes. Disabled if nil. See
+	// [SitemapConfig].
+	Sitemap *SitemapConfig `json:"sitemap,omitempty" arg:"-"`
+
+	// Whether rendering fails when a template reads a map key that doesn't
+	// exist instead of printing `<no value>` or an empty string, to catch
+	// typos in templates. Missing struct fields and nil pointers fail in
+	// either mode, and nil values that do exist still print `<no value>`.
+	// Default `false`.
+	Strict bool `json:"strict,omitempty" arg:"--strict"`
 
 	// Left template action delimiter. Default `{{`.
 	LDelim string `json:"left,omitempty" arg:"--ldelim" default:"{{"`
@@ -50,6 +249,24 @@ type Config struct {
 
 	// The default logger. Defaults to `slog.Default()`.
 	Logger *slog.Logger `json:"-" arg:"-"`
+
+	// Execute INIT templates without committing their effects: database
+	// transactions are rolled back after each initializer succeeds. Effects
+	// outside of a transaction, like publishing NATS messages, still happen.
+	// Used by `xtemplate check`.
+	InitDryRun bool `json:"-" arg:"-"`
+
+	// set by Server.ReloadChanged to reuse files from the previous instance
+	reload *reloadHint
+
+	// set by Server in development mode to inject the live reload script
+	liveReload bool
+
+	// set by Server so its instances share the access log
+	accessLog *accessLogger
+
+	// files patched into the templates FS by Server.Patch
+	patches fstest.MapFS
 }
 
 // FillDefaults sets default values for unset fields
@@ -62,6 +279,32 @@ func (config *Config) Defaults() *Config {
 		config.TemplateExtension = ".html"
 	}
 
+	if config.TemplateExtensions == nil {
+		config.TemplateExtensions = []string{config.TemplateExtension, ".txt.tmpl", ".json.tmpl", ".xml.tmpl", ".ics.tmpl"}
+	} else if !slices.Contains(config.TemplateExtensions, config.TemplateExtension) {
+		config.TemplateExtensions = append(config.TemplateExtensions, config.TemplateExtension)
+	}
+
+	if config.StaticCacheMaxFileSize == 0 {
+		config.StaticCacheMaxFileSize = 64 << 10
+	}
+
+	if config.TemplatesSourceDebounce == 0 {
+		config.TemplatesSourceDebounce = Duration(250 * time.Millisecond)
+	}
+
+	if config.ReadHeaderTimeout == 0 {
+		config.ReadHeaderTimeout = Duration(10 * time.Second)
+	}
+
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = Duration(2 * time.Minute)
+	}
+
+	if config.MaxHeaderBytes == 0 {
+		config.MaxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+
 	if config.LDelim == "" {
 		config.LDelim = "{{"
 	}
@@ -92,12 +335,59 @@ func (c *Config) Options(options ...Option) (*Config, error) {
 
 type Option func(*Config) error
 
-func WithTemplateFS(fs fs.FS) Option {
+// WithTemplateFS sets the FS to load templates from, optionally rooted at the
+// subdirectory dir. This is convenient for an [embed.FS] which includes the
+// embedded directory name in its paths:
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	app.Main(xtemplate.WithTemplateFS(templates, "templates"))
+//
+// Files without a modtime, like the files in an embed.FS, are served as if
+// they were modified at the time the binary was built.
+func WithTemplateFS(fsys fs.FS, dir ...string) Option {
+	return func(c *Config) error {
+		if fsys == nil {
+			return fmt.Errorf("nil fs")
+		}
+		switch len(dir) {
+		case 0:
+		case 1:
+			sub, err := fs.Sub(fsys, dir[0])
+			if err != nil {
+				return fmt.Errorf("failed to root template fs at '%s': %w", dir[0], err)
+			}
+			fsys = sub
+		default:
+			return fmt.Errorf("too many dir arguments provided: %v", dir)
+		}
+		c.TemplatesFS = fsys
+		return nil
+	}
+}
+
+// WithTemplateBaseFS adds an FS of base templates, like a theme, optionally
+// rooted at the subdirectory dir. Files in the templates FS override files with
+// the same path in base FSs, and base FSs added earlier override those added
+// later.
+func WithTemplateBaseFS(fsys fs.FS, dir ...string) Option {
 	return func(c *Config) error {
-		if fs == nil {
+		if fsys == nil {
 			return fmt.Errorf("nil fs")
 		}
-		c.TemplatesFS = fs
+		switch len(dir) {
+		case 0:
+		case 1:
+			sub, err := fs.Sub(fsys, dir[0])
+			if err != nil {
+				return fmt.Errorf("failed to root template base fs at '%s': %w", dir[0], err)
+			}
+			fsys = sub
+		default:
+			return fmt.Errorf("too many dir arguments provided: %v", dir)
+		}
+		c.TemplatesBaseFS = append(c.TemplatesBaseFS, fsys)
 		return nil
 	}
 }
@@ -125,3 +415,43 @@ func WithProvider(p DotConfig) Option {
 		return nil
 	}
 }
+
+// StaticHeaderRule sets response headers for static files whose url path
+// matches a glob.
+type StaticHeaderRule struct {
+	// A [path.Match] pattern matched against the url path of static files, like
+	// `/assets/*.js`. A pattern ending in `/**` matches all files under that
+	// directory.
+	Match string `json:"match"`
+
+	// The Cache-Control header value, like `public, max-age=3600`.
+	CacheControl string `json:"cache_control,omitempty"`
+
+	// Additional headers to set.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (r StaticHeaderRule) matches(urlpath string) bool {
+	if dir, ok := strings.CutSuffix(r.Match, "/**"); ok {
+		return strings.HasPrefix(urlpath, dir+"/")
+	}
+	matched, _ := path.Match(r.Match, urlpath)
+	return matched
+}
+
+// Duration is a [time.Duration] that is configured with a string like "1m30s"
+// in JSON and CLI flags.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,217 @@
+package xtemplate
+
+// This file implements serving markdown files as pages rendered into a layout
+// template, configured by ContentConfig.
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContentConfig configures serving markdown files as pages. Each `.md` file in
+// Dir is served at its path without the extension, and files named `index.md`
+// handle requests to their directory, like template files. The markdown body
+// is rendered to html and the page is served by executing a layout template
+// with the page available at `.Page`.
+//
+// The front matter of a markdown file is available at `.Page.Meta`, and may
+// set `layout` to the name of the layout template, `title`, and `date`, which
+// is used to sort pages newest first.
+type ContentConfig struct {
+	// The directory in the templates FS that contains markdown files. Default
+	// `.`, the whole templates FS.
+	Dir string `json:"dir,omitempty"`
+
+	// The name of the layout template used for pages that don't set `layout` in
+	// their front matter, like `/layouts/.page.html`.
+	Layout string `json:"layout,omitempty"`
+
+	// The name of the markdown config used to render pages. Default `default`.
+	Markdown string `json:"markdown,omitempty"`
+}
+
+// WithContent creates an [xtemplate.Option] that serves markdown files as
+// pages.
+func WithContent(config ContentConfig) Option {
+	return func(c *Config) error {
+		c.Content = &config
+		return nil
+	}
+}
+
+// ContentPage is a markdown file served as a page.
+type ContentPage struct {
+	// The url path the page is served at, like `/blog/hello`.
+	Path string
+	// The path of the markdown file in the templates FS.
+	File string
+	// The front matter of the markdown file.
+	Meta map[string]any
+	// The `title` from the front matter.
+	Title string
+	// The `date` from the front matter, or the file's modtime.
+	Date time.Time
+	// The rendered markdown body.
+	Content template.HTML
+	// The headings of the markdown body.
+	TOC []*TOCEntry
+
+	layout string
+}
+
+type pageContextKey struct{}
+
+type dotPageProvider struct {
+	pages []*ContentPage
+}
+
+func (dotPageProvider) FieldName() string            { return "Page" }
+func (dotPageProvider) Init(_ context.Context) error { return nil }
+func (p dotPageProvider) Value(r Request) (any, error) {
+	d := DotPage{pages: p.pages}
+	if page, ok := r.R.Context().Value(pageContextKey{}).(*ContentPage); ok {
+		d.ContentPage = *page
+	}
+	return d, nil
+}
+
+var _ DotConfig = dotPageProvider{}
+
+// DotPage is used as the .Page field when content pages are enabled by
+// [ContentConfig]. In a layout template it describes the page being served,
+// and in any template it can list all pages.
+type DotPage struct {
+	ContentPage
+	pages []*ContentPage
+}
+
+// Pages returns the pages whose path starts with prefix, like `/blog/`,
+// newest first.
+func (d DotPage) Pages(prefix string) []*ContentPage {
+	var pages []*ContentPage
+	for _, page := range d.pages {
+		if strings.HasPrefix(page.Path, prefix) {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
+
+// isContentFile reports whether the file at path_ should be served as a
+// content page.
+func (b *builder) isContentFile(path_ string) bool {
+	if b.config.Content == nil || path.Ext(path_) != ".md" {
+		return false
+	}
+	dir := path.Clean(b.config.Content.Dir)
+	return dir == "." || strings.HasPrefix(path_, dir+"/")
+}
+
+// addContentFile renders the markdown file at path_. Its route is added by
+// addContentHandlers after all templates are loaded so it can use any layout.
+func (b *builder) addContentFile(path_ string) error {
+	content, err := fs.ReadFile(b.config.TemplatesFS, path_)
+	if err != nil {
+		return fmt.Errorf("could not read content file '%s': %v", path_, err)
+	}
+	meta, body, err := extractFrontMatter(string(content))
+	if err != nil {
+		return fmt.Errorf("could not parse front matter in content file '%s': %v", path_, err)
+	}
+	markdown := b.config.Content.Markdown
+	if markdown == "" {
+		markdown = "default"
+	}
+	doc, err := renderMarkdown(b.markdown, body, []string{markdown})
+	if err != nil {
+		return fmt.Errorf("could not render content file '%s': %v", path_, err)
+	}
+	stamp, _ := b.stamp(path_)
+
+	// files named 'index' handle requests to the directory
+	routePath := strings.TrimSuffix("/"+path_, ".md")
+	if path.Base(routePath) == "index" {
+		routePath = strings.TrimSuffix(routePath, "index")
+	}
+	page := &ContentPage{
+		Path:    routePath,
+		File:    path.Clean("/" + path_),
+		Meta:    meta,
+		Date:    stamp.modtime,
+		Content: doc.HTML,
+		TOC:     doc.TOC,
+		layout:  b.config.Content.Layout,
+	}
+	if title, ok := meta["title"].(string); ok {
+		page.Title = title
+	}
+	if layout, ok := meta["layout"].(string); ok {
+		page.layout = layout
+	}
+	switch date := meta["date"].(type) {
+	case time.Time:
+		page.Date = date
+	case string:
+		if page.Date, err = parseContentDate(date); err != nil {
+			return fmt.Errorf("invalid date '%s' in content file '%s': %w", date, path_, err)
+		}
+	}
+	if b.config.Sitemap != nil {
+		b.pages = append(b.pages, sitemapPage{routePath: page.Path, modtime: stamp.modtime, meta: meta})
+	}
+	b.content = append(b.content, page)
+	return nil
+}
+
+func parseContentDate(date string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected a date like 2006-01-02")
+}
+
+// addContentHandlers registers a route for each content page that executes
+// its layout template.
+func (b *builder) addContentHandlers() error {
+	sort.SliceStable(b.content, func(i, j int) bool {
+		if !b.content[i].Date.Equal(b.content[j].Date) {
+			return b.content[i].Date.After(b.content[j].Date)
+		}
+		return b.content[i].Path < b.content[j].Path
+	})
+	for _, page := range b.content {
+		if page.layout == "" {
+			return fmt.Errorf("content file '%s' has no layout and no default layout is configured", page.File)
+		}
+		layout := b.lookupLayout(page.layout, page.File)
+		if layout == nil {
+			return fmt.Errorf("layout template '%s' of content file '%s' is not defined", page.layout, page.File)
+		}
+		pattern := "GET " + page.Path
+		if strings.HasSuffix(page.Path, "/") {
+			// unlike index templates, an index page doesn't handle subpaths
+			pattern += "{$}"
+		}
+		render := b.limitConcurrency(pattern, bufferingTemplateHandler(b.Instance, layout, "text/html; charset=utf-8"), false)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			render(w, r.WithContext(context.WithValue(r.Context(), pageContextKey{}, page)))
+		}
+		if err := catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.HandleFunc(pattern, handler) }); err != nil {
+			return err
+		}
+		b.routes = append(b.routes, InstanceRoute{pattern, http.HandlerFunc(handler)})
+		b.Routes += 1
+		b.config.Logger.Debug("added content handler", "pattern", pattern, "content_path", page.File, "layout", page.layout)
+	}
+	b.ContentPages = len(b.content)
+	return nil
+}
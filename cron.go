@@ -0,0 +1,192 @@
+package xtemplate
+
+// This file implements executing templates on a schedule.
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronJob is a template named like `CRON <spec> <name>` that is executed on a
+// schedule, for example `CRON 0 * * * * /cleanup` or `CRON @daily /digest`.
+type cronJob struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	tmpl     templateExecutor
+
+	lastRun atomic.Pointer[cronRun]
+}
+
+// CronConfig adds a dot provider that lets templates list, trigger, pause, and
+// resume cron jobs, like on an admin page. Paused jobs stay paused when the
+// server is reloaded.
+type CronConfig struct {
+	// The name of the dot field. Default `Cron`.
+	Name string `json:"name,omitempty"`
+}
+
+func (c *CronConfig) defaults() {
+	if c.Name == "" {
+		c.Name = "Cron"
+	}
+}
+
+// cronOverrides are changes made to cron jobs at runtime and the jobs that
+// are running, which the next instance keeps so a job doesn't overlap a run
+// started by the previous instance.
+type cronOverrides struct {
+	mu      sync.Mutex
+	paused  map[string]bool
+	running map[string]bool
+}
+
+func (o *cronOverrides) isPaused(name string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.paused[name]
+}
+
+func (o *cronOverrides) setPaused(name string, paused bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if paused {
+		o.paused[name] = true
+	} else {
+		delete(o.paused, name)
+	}
+}
+
+func (o *cronOverrides) isRunning(name string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.running[name]
+}
+
+// start marks the named job as running, or returns false if it already is.
+func (o *cronOverrides) start(name string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.running[name] {
+		return false
+	}
+	o.running[name] = true
+	return true
+}
+
+func (o *cronOverrides) finish(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.running, name)
+}
+
+type cronRun struct {
+	start    time.Time
+	duration time.Duration
+	err      error
+}
+
+// parseCronName splits a template name like `CRON 0 * * * * /cleanup` into its
+// schedule spec and job name. Specs are standard 5 field cron expressions or
+// descriptors like `@hourly` or `@every 10m`, optionally prefixed with a
+// `CRON_TZ=` time zone.
+func parseCronName(name string) (spec, jobName string, err error) {
+	fields := strings.Fields(strings.TrimPrefix(name, "CRON "))
+	n := 0
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		n += 1
+	}
+	switch {
+	case n < len(fields) && fields[n] == "@every":
+		n += 2
+	case n < len(fields) && strings.HasPrefix(fields[n], "@"):
+		n += 1
+	default:
+		n += 5
+	}
+	if len(fields) <= n {
+		return "", "", fmt.Errorf("cron template name must be formatted like 'CRON <spec> <name>': '%s'", name)
+	}
+	return strings.Join(fields[:n], " "), strings.Join(fields[n:], " "), nil
+}
+
+func (b *builder) addCronJob(tmpl templateExecutor) error {
+	spec, name, err := parseCronName(tmpl.Name())
+	if err != nil {
+		return err
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid cron schedule for template '%s': %w", tmpl.Name(), err)
+	}
+	b.cronJobs = append(b.cronJobs, &cronJob{name: name, spec: spec, schedule: schedule, tmpl: tmpl})
+	b.CronJobs += 1
+	b.config.Logger.Debug("added cron job", slog.String("name", name), slog.String("spec", spec))
+	return nil
+}
+
+// startCron schedules all cron jobs until the instance's context is cancelled.
+func (x *Instance) startCron() {
+	if len(x.cronJobs) == 0 {
+		return
+	}
+	c := cron.New()
+	for _, job := range x.cronJobs {
+		c.Schedule(job.schedule, cron.FuncJob(func() {
+			if x.cronOverrides.isPaused(job.name) {
+				x.config.Logger.Debug("skipping paused cron job", slog.String("cron_job", job.name))
+				return
+			}
+			x.runCronJob(job)
+		}))
+	}
+	c.Start()
+	go func() {
+		<-x.config.Ctx.Done()
+		<-c.Stop().Done()
+		x.config.Logger.Debug("stopped cron jobs")
+	}()
+}
+
+// runCronJob executes the job's template with the same dot value as a
+// buffered http request. If the previous run of the job is still running, even
+// in the previous instance, this run is skipped.
+func (x *Instance) runCronJob(job *cronJob) {
+	log := x.config.Logger.With(slog.String("cron_job", job.name))
+	if !x.cronOverrides.start(job.name) {
+		log.Warn("skipping cron job because the previous run is still running")
+		return
+	}
+	defer x.cronOverrides.finish(job.name)
+
+	start := time.Now()
+	w, r := httptest.NewRecorder(), httptest.NewRequest("CRON", "/", nil)
+	r.URL.Path = path.Clean("/" + job.name)
+	r = r.WithContext(x.config.Ctx)
+
+	err := func() error {
+		val, err := x.bufferDot.value(x.config.Ctx, w, r)
+		if err != nil {
+			return fmt.Errorf("failed to initialize dot value: %w", err)
+		}
+		buf := new(bytes.Buffer)
+		err = job.tmpl.Execute(buf, *val)
+		return x.bufferDot.cleanup(val, err)
+	}()
+
+	job.lastRun.Store(&cronRun{start: start, duration: time.Since(start), err: err})
+	if err != nil {
+		log.Error("cron job failed", slog.Any("error", err), slog.Duration("duration", time.Since(start)))
+	} else {
+		log.Info("cron job succeeded", slog.Duration("duration", time.Since(start)))
+	}
+}
@@ -0,0 +1,41 @@
+package xtemplate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSkipsOverlapAcrossReloads(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server, _ := newTestServer(t, map[string]string{"jobs.html": `{{define "CRON @every 1h /slow"}}{{wait}}{{end}}`}, started, release)
+
+	old := server.Instance()
+	go old.runCronJob(old.cronJobs[0])
+	<-started
+
+	if err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	current := server.Instance()
+	if !current.cronOverrides.isRunning("/slow") {
+		t.Fatalf("job started by the previous instance isn't running after a reload")
+	}
+	skipped := make(chan struct{})
+	go func() {
+		current.runCronJob(current.cronJobs[0])
+		close(skipped)
+	}()
+	select {
+	case <-skipped:
+	case <-started:
+		release <- struct{}{}
+		t.Fatalf("job ran while the previous instance's run was still running")
+	case <-time.After(time.Second):
+		t.Fatalf("job didn't return")
+	}
+
+	release <- struct{}{}
+	for current.cronOverrides.isRunning("/slow") {
+		time.Sleep(time.Millisecond)
+	}
+}
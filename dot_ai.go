@@ -0,0 +1,245 @@
+package xtemplate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// WithAI creates an [xtemplate.Option] that adds an LLM completion provider
+// at the dot field name that uses the OpenAI-compatible API at endpoint.
+func WithAI(name, endpoint, model, apiKey string) Option {
+	return func(c *Config) error {
+		c.AI = append(c.AI, DotAIConfig{Name: name, Endpoint: endpoint, Model: model, APIKey: apiKey})
+		return nil
+	}
+}
+
+// DotAIConfig configures an OpenAI-compatible chat completions API that
+// templates can send prompts to, like OpenAI, a hosted open model, or a local
+// model server.
+type DotAIConfig struct {
+	Name string `json:"name"`
+
+	// The base url of the API, which `/chat/completions` is added to. Default
+	// `https://api.openai.com/v1`.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// The model to use, like `gpt-4o-mini`.
+	Model string `json:"model"`
+
+	// The API key sent as a bearer token. Environment variables like
+	// `${OPENAI_API_KEY}` are expanded when the instance loads, so the key
+	// doesn't have to be kept in the config file.
+	APIKey string `json:"api_key,omitempty"`
+
+	// A system message to send before the prompt of each completion.
+	System string `json:"system,omitempty"`
+
+	// How long a completion can take, including streaming it. Default `2m`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	client *http.Client
+	url    string
+	apiKey string
+}
+
+var _ DotConfig = &DotAIConfig{}
+
+func (d *DotAIConfig) FieldName() string { return d.Name }
+func (d *DotAIConfig) Init(_ context.Context) error {
+	if d.Model == "" {
+		return fmt.Errorf("ai model is required")
+	}
+	if d.Endpoint == "" {
+		d.Endpoint = "https://api.openai.com/v1"
+	}
+	if _, err := url.Parse(d.Endpoint); err != nil {
+		return fmt.Errorf("failed to parse ai endpoint: %w", err)
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(2 * time.Minute)
+	}
+	d.client = &http.Client{Timeout: time.Duration(d.Timeout)}
+	d.url = strings.TrimSuffix(d.Endpoint, "/") + "/chat/completions"
+	d.apiKey = os.ExpandEnv(d.APIKey)
+	return nil
+}
+func (d *DotAIConfig) Value(r Request) (any, error) {
+	return DotAI{d, r.R.Context()}, nil
+}
+
+// DotAI is used as the dot field to get completions from an LLM, configured
+// by [DotAIConfig].
+//
+// The prompt is a string for a single user message, or a list of messages
+// like `(list (dict "role" "user" "content" "Hi"))` to continue a chat. The
+// optional opts map is added to the request, like `(dict "temperature" 0.2
+// "max_tokens" 500)` or a different `model`, and its `system` key replaces
+// the configured system message.
+//
+//	{{define "POST /chat"}}<div hx-ext="sse" sse-connect="/chat/stream?q={{.Req.FormValue "q" | urlquery}}" sse-swap="token" hx-swap="beforeend"></div>{{end}}
+//	{{define "SSE /chat/stream"}}{{range .AI.Stream (.Req.URL.Query.Get "q")}}{{$.Flush.SendSSE "token" .}}{{end}}{{end}}
+type DotAI struct {
+	config *DotAIConfig
+	ctx    context.Context
+}
+
+type aiMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type aiResponse struct {
+	Choices []struct {
+		Message aiMessage `json:"message"`
+		Delta   aiMessage `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Complete sends prompt and returns the completion.
+func (d DotAI) Complete(prompt any, opts ...map[string]any) (string, error) {
+	resp, err := d.send(prompt, opts, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result aiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode ai completion: %w", err)
+	}
+	if result.Error != nil {
+		return "", fmt.Errorf("ai completion failed: %s", result.Error.Message)
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("ai completion has no choices")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+// Stream sends prompt and returns a channel of the pieces of the completion
+// as they are generated, which is closed when the completion is done or the
+// request ends. Use it with `.Flush` to stream the completion to the client
+// with server sent events.
+func (d DotAI) Stream(prompt any, opts ...map[string]any) (<-chan string, error) {
+	resp, err := d.send(prompt, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				return
+			}
+			var chunk aiResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				GetLogger(d.ctx).Warn("failed to decode ai completion chunk", slog.Any("error", err))
+				return
+			}
+			if chunk.Error != nil {
+				GetLogger(d.ctx).Warn("ai completion failed", slog.String("error", chunk.Error.Message))
+				return
+			}
+			if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+				continue
+			}
+			select {
+			case ch <- chunk.Choices[0].Delta.Content:
+			case <-d.ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && d.ctx.Err() == nil {
+			GetLogger(d.ctx).Warn("failed to read ai completion stream", slog.Any("error", err))
+		}
+	}()
+	return ch, nil
+}
+
+func (d DotAI) send(prompt any, opts []map[string]any, stream bool) (*http.Response, error) {
+	body := map[string]any{"model": d.config.Model}
+	system := d.config.System
+	switch len(opts) {
+	case 0:
+	case 1:
+		for k, v := range opts[0] {
+			if k == "system" {
+				system = fmt.Sprint(v)
+				continue
+			}
+			body[k] = v
+		}
+	default:
+		return nil, fmt.Errorf("too many opts arguments provided: %v", opts)
+	}
+	var messages []any
+	if system != "" {
+		messages = append(messages, aiMessage{"system", system})
+	}
+	switch p := prompt.(type) {
+	case string:
+		messages = append(messages, aiMessage{"user", p})
+	case []any:
+		messages = append(messages, p...)
+	case []map[string]any:
+		for _, m := range p {
+			messages = append(messages, m)
+		}
+	default:
+		return nil, fmt.Errorf("ai prompt must be a string or a list of messages, got %T", prompt)
+	}
+	body["messages"] = messages
+	body["stream"] = stream
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ai request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, "POST", d.config.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ai request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+	if d.config.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+d.config.apiKey)
+	}
+
+	defer startSpan(d.ctx, "ai "+d.config.Model)()
+	resp, err := d.config.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send ai request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("ai request failed with status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,143 @@
+package xtemplate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// WithBus creates an [xtemplate.Option] that adds an in-process message bus
+// provider at the dot field name.
+func WithBus(name string) Option {
+	return func(c *Config) error {
+		c.Bus = append(c.Bus, DotBusConfig{Name: name})
+		return nil
+	}
+}
+
+// DotBusConfig configures an in-process message bus that is shared by all
+// requests to an instance, so a template handling a form post can notify
+// pages streaming server sent events without running NATS or redis. The bus
+// is kept when the server reloads, so subscribers of the previous instance
+// still receive messages. Messages aren't persisted, and are only delivered to
+// subscribers in the same process.
+type DotBusConfig struct {
+	Name string `json:"name"`
+
+	// The number of messages kept for each subscriber that hasn't received
+	// them yet. Messages are dropped for subscribers that fall further behind.
+	// Default `100`.
+	BufferSize int `json:"buffer_size,omitempty"`
+
+	bus *busBroker
+}
+
+// busBroker delivers messages to the subscribers of each topic.
+type busBroker struct {
+	sync.Mutex
+	topics map[string]map[*busSubscriber]struct{}
+}
+
+type busSubscriber struct {
+	topics []string
+	msgs   chan BusMessage
+}
+
+var _ DotConfig = &DotBusConfig{}
+
+func (d *DotBusConfig) FieldName() string { return d.Name }
+func (d *DotBusConfig) Init(_ context.Context) error {
+	if d.BufferSize == 0 {
+		d.BufferSize = 100
+	}
+	if d.bus == nil {
+		d.bus = &busBroker{topics: map[string]map[*busSubscriber]struct{}{}}
+	}
+	return nil
+}
+func (d *DotBusConfig) Value(r Request) (any, error) {
+	return DotBus{d, r.R.Context()}, nil
+}
+
+// DotBus is used as the dot field to publish messages to and subscribe to
+// topics on an in-process message bus, configured by [DotBusConfig].
+//
+//	{{define "POST /chat"}}{{.Bus.Publish "chat" (.Req.FormValue "message")}}{{end}}
+//	{{define "SSE /chat"}}{{range .Bus.Subscribe "chat"}}{{$.Flush.SendSSE "message" .Data}}{{end}}{{end}}
+type DotBus struct {
+	config *DotBusConfig
+	ctx    context.Context
+}
+
+// BusMessage is a message received by [DotBus.Subscribe].
+type BusMessage struct {
+	Topic string
+	// The message as it was published, which can be any value. Subscribers
+	// share it, so templates must not modify it.
+	Data any
+}
+
+// Publish sends msg to the current subscribers of topic and returns the
+// number of subscribers that received it.
+func (d DotBus) Publish(topic string, msg any) int {
+	m := BusMessage{Topic: topic, Data: msg}
+	b := d.config.bus
+	b.Lock()
+	defer b.Unlock()
+	n := 0
+	for s := range b.topics[topic] {
+		select {
+		case s.msgs <- m:
+			n += 1
+		default:
+		}
+	}
+	return n
+}
+
+// Subscribe subscribes to topics and returns a channel of messages that is
+// closed when the request ends. Use it with `.Flush` to stream messages to
+// the client with server sent events.
+func (d DotBus) Subscribe(topics ...string) (<-chan BusMessage, error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no bus topics to subscribe to")
+	}
+	s := &busSubscriber{topics: topics, msgs: make(chan BusMessage, d.config.BufferSize)}
+	b := d.config.bus
+	b.Lock()
+	for _, topic := range topics {
+		if b.topics[topic] == nil {
+			b.topics[topic] = map[*busSubscriber]struct{}{}
+		}
+		b.topics[topic][s] = struct{}{}
+	}
+	b.Unlock()
+
+	ch := make(chan BusMessage)
+	go func() {
+		defer close(ch)
+		defer func() {
+			b.Lock()
+			defer b.Unlock()
+			for _, topic := range s.topics {
+				delete(b.topics[topic], s)
+				if len(b.topics[topic]) == 0 {
+					delete(b.topics, topic)
+				}
+			}
+		}()
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case msg := <-s.msgs:
+				select {
+				case ch <- msg:
+				case <-d.ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,41 @@
+package xtemplate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBusKeptAcrossReloads(t *testing.T) {
+	server, _ := newTestServer(t, map[string]string{"index.html": "hello"}, nil, nil, WithBus("Bus"))
+	bus := func() DotBus {
+		config := &DotBusConfig{Name: "Bus"}
+		config.bus = server.Instance().buses["Bus"]
+		config.Init(context.Background())
+		return DotBus{config, context.Background()}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscriber := bus()
+	subscriber.ctx = ctx
+	msgs, err := subscriber.Subscribe("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if n := bus().Publish("chat", "hello"); n != 1 {
+		t.Fatalf("published to %d subscribers after a reload, want 1", n)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.Topic != "chat" || msg.Data != "hello" {
+			t.Fatalf("received %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("subscriber of the previous instance didn't receive the message")
+	}
+}
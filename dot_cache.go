@@ -0,0 +1,205 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+)
+
+// WithCache creates an [xtemplate.Option] that adds a cache provider at the
+// dot field name. If persist is true, entries are kept when the server is
+// reloaded.
+func WithCache(name string, maxEntries int, persist bool) Option {
+	return func(c *Config) error {
+		c.Caches = append(c.Caches, DotCacheConfig{Name: name, MaxEntries: maxEntries, Persist: persist})
+		return nil
+	}
+}
+
+// DotCacheConfig configures an in-memory cache with per-entry expiration that
+// is shared by all requests to an instance.
+type DotCacheConfig struct {
+	Name string `json:"name"`
+
+	// The maximum number of entries to keep. When full, expired entries are
+	// evicted first, then the entry closest to expiring. Default `10000`.
+	MaxEntries int `json:"max_entries,omitempty"`
+
+	// Keep entries from the previous instance when the server is reloaded.
+	Persist bool `json:"persist,omitempty"`
+
+	cache     *ttlCache
+	templates *template.Template
+}
+
+var _ DotConfig = &DotCacheConfig{}
+
+func (d *DotCacheConfig) FieldName() string { return d.Name }
+func (d *DotCacheConfig) Init(_ context.Context) error {
+	if d.MaxEntries == 0 {
+		d.MaxEntries = 10000
+	}
+	if d.cache == nil {
+		d.cache = &ttlCache{entries: make(map[string]ttlEntry), maxEntries: d.MaxEntries}
+	} else {
+		// a persisted cache adopts the new config
+		d.cache.mu.Lock()
+		d.cache.maxEntries = d.MaxEntries
+		d.cache.mu.Unlock()
+	}
+	return nil
+}
+func (d *DotCacheConfig) Value(r Request) (any, error) {
+	return DotCache{d.cache, d.templates, r.R.Context()}, nil
+}
+
+// DotCache is used as the dot field to cache values across requests,
+// configured by [DotCacheConfig]. Values are shared, so templates must not
+// modify them. TTLs can be a duration string like `5m`, a [time.Duration], or
+// a number of seconds.
+type DotCache struct {
+	cache     *ttlCache
+	templates *template.Template
+	ctx       context.Context
+}
+
+// Get returns the value stored at key, or nil if it is missing or expired.
+func (d DotCache) Get(key string) any {
+	v, _ := d.cache.get(key)
+	return v
+}
+
+// Set stores value at key until ttl expires. It returns an empty string.
+func (d DotCache) Set(key string, value any, ttl any) (string, error) {
+	dur, err := parseTTL(ttl)
+	if err != nil {
+		return "", err
+	}
+	d.cache.set(key, value, dur)
+	return "", nil
+}
+
+// Delete removes the value stored at key. It returns an empty string.
+func (d DotCache) Delete(key string) string {
+	d.cache.delete(key)
+	return ""
+}
+
+// GetOrCompute returns the value stored at key, or invokes the template name
+// with dot, stores its output at key until ttl expires, and returns it.
+func (d DotCache) GetOrCompute(key string, ttl any, name string, dot any) (any, error) {
+	if v, ok := d.cache.get(key); ok {
+		return v, nil
+	}
+	dur, err := parseTTL(ttl)
+	if err != nil {
+		return nil, err
+	}
+	t := d.templates.Lookup(name)
+	if t == nil {
+		return nil, fmt.Errorf("failed to lookup template name: '%s'", name)
+	}
+	defer startSpan(d.ctx, "compute "+key)()
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	if err := t.Execute(buf, dot); err != nil {
+		return nil, fmt.Errorf("failed to execute template '%s': %w", name, err)
+	}
+	result := template.HTML(buf.String())
+	d.cache.set(key, result, dur)
+	return result, nil
+}
+
+// parseTTL converts a duration string, [time.Duration], or number of seconds
+// to a duration.
+func parseTTL(ttl any) (time.Duration, error) {
+	var dur time.Duration
+	switch t := ttl.(type) {
+	case time.Duration:
+		dur = t
+	case Duration:
+		dur = time.Duration(t)
+	case string:
+		var err error
+		if dur, err = time.ParseDuration(t); err != nil {
+			return 0, fmt.Errorf("failed to parse ttl: %w", err)
+		}
+	case int:
+		dur = time.Duration(t) * time.Second
+	case int64:
+		dur = time.Duration(t) * time.Second
+	case float64:
+		dur = time.Duration(t * float64(time.Second))
+	default:
+		return 0, fmt.Errorf("ttl must be a duration or number of seconds, got %T", ttl)
+	}
+	if dur <= 0 {
+		return 0, fmt.Errorf("ttl must be positive, got %s", dur)
+	}
+	return dur, nil
+}
+
+type ttlEntry struct {
+	value   any
+	expires time.Time
+}
+
+// ttlCache is a map of values that expire.
+type ttlCache struct {
+	mu         sync.Mutex
+	entries    map[string]ttlEntry
+	maxEntries int
+}
+
+func (c *ttlCache) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache) set(key string, value any, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+	c.entries[key] = ttlEntry{value, time.Now().Add(ttl)}
+}
+
+func (c *ttlCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// evict removes expired entries, or the entry closest to expiring if none
+// have expired. c.mu must be held.
+func (c *ttlCache) evict() {
+	now := time.Now()
+	var soonest string
+	var soonestExpires time.Time
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if soonestExpires.IsZero() || e.expires.Before(soonestExpires) {
+			soonest, soonestExpires = key, e.expires
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, soonest)
+	}
+}
@@ -0,0 +1,115 @@
+package xtemplate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+type dotCronProvider struct {
+	instance *Instance
+}
+
+func (p dotCronProvider) FieldName() string          { return p.instance.config.Cron.Name }
+func (dotCronProvider) Init(_ context.Context) error { return nil }
+func (p dotCronProvider) Value(_ Request) (any, error) {
+	return DotCron{p.instance}, nil
+}
+
+// DotCron is used as the dot field to control cron jobs, configured by
+// [CronConfig].
+//
+//	{{range .Cron.List}}
+//	<tr><td>{{.Name}}</td><td>{{.Spec}}</td><td>{{if .Paused}}paused{{else}}{{.Next.Format "15:04"}}{{end}}</td></tr>
+//	{{end}}
+type DotCron struct {
+	instance *Instance
+}
+
+// CronJobInfo describes a cron job, returned by [DotCron.List].
+type CronJobInfo struct {
+	// The name of the job, like `/cleanup`.
+	Name string
+	// The schedule of the job, like `0 3 * * *` or `@daily`.
+	Spec string
+	// When the job is next scheduled to run.
+	Next    time.Time
+	Paused  bool
+	Running bool
+
+	// When the last run of the job started and how long it took, or zero if
+	// it hasn't run since the server was reloaded.
+	LastRun      time.Time
+	LastDuration time.Duration
+	// The error of the last run, or empty if it succeeded.
+	LastError string
+}
+
+// List returns the cron jobs sorted by name.
+func (d DotCron) List() []CronJobInfo {
+	now := time.Now()
+	jobs := make([]CronJobInfo, 0, len(d.instance.cronJobs))
+	for _, job := range d.instance.cronJobs {
+		info := CronJobInfo{
+			Name:    job.name,
+			Spec:    job.spec,
+			Next:    job.schedule.Next(now),
+			Paused:  d.instance.cronOverrides.isPaused(job.name),
+			Running: d.instance.cronOverrides.isRunning(job.name),
+		}
+		if run := job.lastRun.Load(); run != nil {
+			info.LastRun, info.LastDuration = run.start, run.duration
+			if run.err != nil {
+				info.LastError = run.err.Error()
+			}
+		}
+		jobs = append(jobs, info)
+	}
+	slices.SortFunc(jobs, func(a, b CronJobInfo) int { return strings.Compare(a.Name, b.Name) })
+	return jobs
+}
+
+// Trigger starts running the named job now, even if it's paused, without
+// waiting for it to finish. It returns an empty string.
+func (d DotCron) Trigger(name string) (string, error) {
+	job, err := d.job(name)
+	if err != nil {
+		return "", err
+	}
+	if d.instance.cronOverrides.isRunning(name) {
+		return "", fmt.Errorf("cron job '%s' is already running", name)
+	}
+	go d.instance.runCronJob(job)
+	return "", nil
+}
+
+// Pause stops the named job from running on its schedule until it's resumed,
+// including after the server is reloaded. It returns an empty string.
+func (d DotCron) Pause(name string) (string, error) {
+	if _, err := d.job(name); err != nil {
+		return "", err
+	}
+	d.instance.cronOverrides.setPaused(name, true)
+	return "", nil
+}
+
+// Resume lets the named job run on its schedule again after it was paused.
+// It returns an empty string.
+func (d DotCron) Resume(name string) (string, error) {
+	if _, err := d.job(name); err != nil {
+		return "", err
+	}
+	d.instance.cronOverrides.setPaused(name, false)
+	return "", nil
+}
+
+func (d DotCron) job(name string) (*cronJob, error) {
+	for _, job := range d.instance.cronJobs {
+		if job.name == name {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("cron job '%s' does not exist", name)
+}
@@ -37,6 +37,7 @@ func (c *DotDB) Exec(query string, params ...any) (result sql.Result, err error)
 		return
 	}
 
+	defer startSpan(c.ctx, spanName("Exec", query))()
 	defer func(start time.Time) {
 		c.log.Debug("Exec", slog.String("query", query), slog.Any("params", params), slog.Any("error", err), slog.Duration("queryduration", time.Since(start)))
 	}(time.Now())
@@ -50,6 +51,7 @@ func (c *DotDB) QueryRows(query string, params ...any) (rows []map[string]any, e
 		return
 	}
 
+	defer startSpan(c.ctx, spanName("QueryRows", query))()
 	defer func(start time.Time) {
 		c.log.Debug("QueryRows", slog.String("query", query), slog.Any("params", params), slog.Any("error", err), slog.Duration("queryduration", time.Since(start)))
 	}(time.Now())
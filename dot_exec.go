@@ -0,0 +1,209 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// WithExec creates an [xtemplate.Option] that adds an exec provider at the dot
+// field name that can run commands.
+func WithExec(name string, commands ...ExecCommand) Option {
+	return func(c *Config) error {
+		c.Exec = append(c.Exec, DotExecConfig{Name: name, Commands: commands})
+		return nil
+	}
+}
+
+// DotExecConfig configures the external commands that templates are allowed
+// to run. Commands are run directly, not with a shell, so arguments from
+// templates can't run other commands, and arguments from templates that start
+// with `-` are rejected so request data can't add options.
+type DotExecConfig struct {
+	Name string `json:"name"`
+
+	// The commands that can be run. Commands that aren't listed can't be run.
+	Commands []ExecCommand `json:"commands"`
+
+	// How long a command can run before it's killed. Default `10s`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// The maximum number of bytes a command can write to stdout before it's
+	// killed. Default `1048576` (1MiB).
+	MaxOutput int `json:"max_output,omitempty"`
+
+	commands map[string]ExecCommand
+}
+
+// ExecCommand is a command that templates can run with [DotExec.Run].
+type ExecCommand struct {
+	// The name that templates run the command by, like `ffprobe`.
+	Name string `json:"name"`
+
+	// The path of the executable, or a name to find in PATH. Default Name.
+	Path string `json:"path,omitempty"`
+
+	// Arguments that come before the arguments from templates, like `-v
+	// error -of json`. Options must be configured here because arguments from
+	// templates can't start with `-`.
+	Args []string `json:"args,omitempty"`
+
+	// Pass `--` between Args and the arguments from templates, for commands
+	// that support it, so arguments from templates that start with `-` are
+	// allowed and treated as operands instead of options.
+	Separator bool `json:"separator,omitempty"`
+
+	// The working directory of the command. Default the working directory of
+	// the server.
+	Dir string `json:"dir,omitempty"`
+
+	// Environment variables like `KEY=value` to set. Commands only inherit
+	// the PATH of the server.
+	Env []string `json:"env,omitempty"`
+
+	// How long the command can run, overriding the provider's Timeout.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+var _ DotConfig = &DotExecConfig{}
+
+func (d *DotExecConfig) FieldName() string { return d.Name }
+func (d *DotExecConfig) Init(_ context.Context) error {
+	if d.Timeout == 0 {
+		d.Timeout = Duration(10 * time.Second)
+	}
+	if d.MaxOutput == 0 {
+		d.MaxOutput = 1 << 20
+	}
+	d.commands = make(map[string]ExecCommand, len(d.Commands))
+	for _, c := range d.Commands {
+		if c.Name == "" {
+			return fmt.Errorf("exec command name is required")
+		}
+		if _, ok := d.commands[c.Name]; ok {
+			return fmt.Errorf("exec command '%s' is defined more than once", c.Name)
+		}
+		if c.Path == "" {
+			c.Path = c.Name
+		}
+		path, err := exec.LookPath(c.Path)
+		if err != nil {
+			return fmt.Errorf("failed to find exec command '%s': %w", c.Name, err)
+		}
+		c.Path = path
+		if c.Timeout == 0 {
+			c.Timeout = d.Timeout
+		}
+		d.commands[c.Name] = c
+	}
+	return nil
+}
+func (d *DotExecConfig) Value(r Request) (any, error) {
+	return DotExec{d, r.R.Context()}, nil
+}
+
+// DotExec is used as the dot field to run allowed external commands,
+// configured by [DotExecConfig].
+//
+//	{{$file := .Req.PathValue "file"}}
+//	{{if not (reMatch `^[a-z0-9_-]+\.mp4$` $file)}}{{failf "invalid file name '%s'" $file}}{{end}}
+//	{{$info := .Exec.Run "ffprobe" $file | fromJson}}
+//	<p>Duration: {{$info.format.duration}}s</p>
+//
+// with the ffprobe command configured with the args `-v error -of json
+// -show_format` and the dir `media`.
+type DotExec struct {
+	config *DotExecConfig
+	ctx    context.Context
+}
+
+// Run runs the command name with args after its configured args, and returns
+// what it wrote to stdout. It fails if an arg starts with `-` and the command
+// isn't configured with Separator, or if the command exits with an error,
+// takes too long, or writes too much.
+func (d DotExec) Run(name string, args ...any) (string, error) {
+	return d.run(name, nil, args)
+}
+
+// RunInput is like Run, but writes input to the command's stdin.
+func (d DotExec) RunInput(name string, input string, args ...any) (string, error) {
+	return d.run(name, strings.NewReader(input), args)
+}
+
+func (d DotExec) run(name string, stdin *strings.Reader, args []any) (string, error) {
+	c, ok := d.config.commands[name]
+	if !ok {
+		return "", fmt.Errorf("exec command '%s' is not allowed", name)
+	}
+	cmdArgs := append([]string{}, c.Args...)
+	if c.Separator {
+		cmdArgs = append(cmdArgs, "--")
+	}
+	for _, arg := range args {
+		arg := fmt.Sprint(arg)
+		if !c.Separator && strings.HasPrefix(arg, "-") {
+			return "", fmt.Errorf("exec command '%s' arg '%s' can't start with '-'", name, arg)
+		}
+		cmdArgs = append(cmdArgs, arg)
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, time.Duration(c.Timeout))
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.Path, cmdArgs...)
+	cmd.Dir = c.Dir
+	// don't wait for children of the command that keep its output open
+	cmd.WaitDelay = time.Second
+	cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, c.Env...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	stdout := &execLimitWriter{limit: d.config.MaxOutput, cancel: cancel}
+	stderr := &execLimitWriter{limit: 4096}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	defer startSpan(d.ctx, "exec "+name)()
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// the command succeeded, but left a child running
+		err = nil
+	}
+	switch {
+	case stdout.exceeded:
+		return "", fmt.Errorf("exec command '%s' wrote more than %d bytes", name, d.config.MaxOutput)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "", fmt.Errorf("exec command '%s' timed out after %s", name, time.Duration(c.Timeout))
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.buf.String()); msg != "" {
+			return "", fmt.Errorf("exec command '%s' failed: %w: %s", name, err, msg)
+		}
+		return "", fmt.Errorf("exec command '%s' failed: %w", name, err)
+	}
+	return stdout.buf.String(), nil
+}
+
+// execLimitWriter keeps up to limit bytes written to it. If cancel is set, it
+// is called when the limit is exceeded to kill the command, otherwise the
+// rest is discarded.
+type execLimitWriter struct {
+	buf      bytes.Buffer
+	limit    int
+	cancel   func()
+	exceeded bool
+}
+
+func (w *execLimitWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		w.buf.Write(p[:w.limit-w.buf.Len()])
+		if w.cancel != nil && !w.exceeded {
+			w.cancel()
+		}
+		w.exceeded = true
+		return len(p), nil
+	}
+	return w.buf.Write(p)
+}
@@ -1,18 +1,29 @@
 package xtemplate
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"fmt"
+	"image"
 	"io"
 	"io/fs"
 	"log/slog"
+	"mime"
+	"net/http"
 	"path"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
 )
 
 type dotFS struct {
 	fs     fs.FS
 	log    *slog.Logger
 	opened map[fs.File]struct{}
+	w      http.ResponseWriter
+	r      *http.Request
 }
 
 // Dir
@@ -99,3 +110,176 @@ func (d Dir) Open(name string) (fs.File, error) {
 
 	return d.dot.fs.Open(name)
 }
+
+// ServeZip aborts execution of the template and instead responds to the
+// request with a zip archive of the named directory as a download. If patterns
+// are given, only files whose path relative to the directory matches at least
+// one of them (see [path.Match]) are included.
+func (d Dir) ServeZip(name string, patterns ...string) (string, error) {
+	return d.serveArchive(name, ".zip", "application/zip", patterns, func(w io.Writer, walk archiveWalker) error {
+		zw := zip.NewWriter(w)
+		err := walk(func(rel string, info fs.FileInfo, file fs.File) error {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = rel
+			header.Method = zip.Deflate
+			fw, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fw, file)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// ServeTarGz aborts execution of the template and instead responds to the
+// request with a gzipped tar archive of the named directory as a download. See
+// [Dir.ServeZip] for the meaning of patterns.
+func (d Dir) ServeTarGz(name string, patterns ...string) (string, error) {
+	return d.serveArchive(name, ".tar.gz", "application/gzip", patterns, func(w io.Writer, walk archiveWalker) error {
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		err := walk(func(rel string, info fs.FileInfo, file fs.File) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = rel
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, file)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gw.Close()
+	})
+}
+
+// ImageInfo describes an image file, see [Dir.ImageInfo].
+type ImageInfo struct {
+	// The dimensions of the image as it's displayed, after applying the EXIF
+	// orientation.
+	Width, Height int
+	// The image format, like `jpeg`, `png`, or `gif`.
+	Format string
+	// The EXIF orientation, from 1 to 8, or 1 if the image doesn't have one.
+	Orientation int
+	// When the photo was taken according to its EXIF data, or the zero time.
+	TakenAt time.Time
+}
+
+// ImageInfo reads the dimensions, format, and basic EXIF metadata of the image
+// file at name without decoding the whole image, so templates can set the
+// width and height of img elements:
+//
+//	{{with .FS.ImageInfo "photos/cat.jpg"}}<img src="/photos/cat.jpg" width="{{.Width}}" height="{{.Height}}">{{end}}
+func (d Dir) ImageInfo(name string) (ImageInfo, error) {
+	name = path.Join(d.path, path.Clean(name))
+	content, err := fs.ReadFile(d.dot.fs, name)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return ImageInfo{}, fmt.Errorf("failed to decode image config of '%s': %w", name, err)
+	}
+	info := ImageInfo{Width: cfg.Width, Height: cfg.Height, Format: format, Orientation: 1}
+	if format == "jpeg" {
+		// a missing or invalid EXIF segment just leaves the defaults
+		if x, err := exif.Decode(bytes.NewReader(content)); err == nil {
+			if tag, err := x.Get(exif.Orientation); err == nil {
+				if o, err := tag.Int(0); err == nil && o >= 1 && o <= 8 {
+					info.Orientation = o
+				}
+			}
+			if t, err := x.DateTime(); err == nil {
+				info.TakenAt = t
+			}
+		}
+	}
+	if info.Orientation >= 5 {
+		// orientations 5-8 are rotated a quarter turn
+		info.Width, info.Height = info.Height, info.Width
+	}
+	return info, nil
+}
+
+type archiveWalker func(add func(rel string, info fs.FileInfo, file fs.File) error) error
+
+// serveArchive streams an archive of the regular files under name to the
+// response without buffering it in memory or on disk.
+func (d Dir) serveArchive(name, ext, contentType string, patterns []string, write func(io.Writer, archiveWalker) error) (string, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", fmt.Errorf("invalid archive pattern '%s': %w", pattern, err)
+		}
+	}
+	root := path.Join(d.path, path.Clean(name))
+	if st, err := fs.Stat(d.dot.fs, root); err != nil {
+		return "", err
+	} else if !st.IsDir() {
+		return "", fmt.Errorf("not a directory: %s", name)
+	}
+
+	filename := path.Base(root)
+	if filename == "." || filename == "/" {
+		filename = "archive"
+	}
+	d.dot.w.Header().Set("Content-Type", contentType)
+	d.dot.w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename + ext}))
+
+	walk := func(add func(rel string, info fs.FileInfo, file fs.File) error) error {
+		return fs.WalkDir(d.dot.fs, root, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			rel := p
+			if root != "." {
+				rel = p[len(root)+1:]
+			}
+			if len(patterns) > 0 && !matchAny(patterns, rel) {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			file, err := d.dot.fs.Open(p)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return add(rel, info, file)
+		})
+	}
+
+	d.dot.log.Debug("serving archive response", slog.String("path", root), slog.String("content_type", contentType))
+	if err := write(d.dot.w, walk); err != nil {
+		// the response has already started, so the best we can do is log it and
+		// leave the client with a truncated archive
+		d.dot.log.Warn("failed to write archive response", slog.String("path", root), slog.Any("error", err))
+	}
+	return "", ReturnError{}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -30,6 +30,9 @@ type DotDirConfig struct {
 	Name  string `json:"name"`
 	fs.FS `json:"-"`
 	Path  string `json:"path"`
+
+	// Load files from a bucket in S3-compatible object storage instead of Path.
+	S3 *S3Config `json:"s3,omitempty"`
 }
 
 var _ CleanupDotProvider = &DotDirConfig{}
@@ -39,6 +42,17 @@ func (p *DotDirConfig) Init(ctx context.Context) error {
 	if p.FS != nil {
 		return nil
 	}
+	if p.S3 != nil {
+		s3fs, err := NewS3FS(*p.S3)
+		if err != nil {
+			return fmt.Errorf("failed to create s3 fs for directory '%s': %w", p.Name, err)
+		}
+		if _, err := fs.Stat(s3fs, "."); err != nil {
+			return fmt.Errorf("failed to list s3 bucket '%s': %w", p.S3.Bucket, err)
+		}
+		p.FS = s3fs
+		return nil
+	}
 	newfs := os.DirFS(p.Path)
 	if _, err := newfs.(interface {
 		Stat(string) (fs.FileInfo, error)
@@ -49,7 +63,7 @@ func (p *DotDirConfig) Init(ctx context.Context) error {
 	return nil
 }
 func (p *DotDirConfig) Value(r Request) (any, error) {
-	return Dir{dot: &dotFS{p.FS, GetLogger(r.R.Context()), make(map[fs.File]struct{})}, path: "."}, nil
+	return Dir{dot: &dotFS{p.FS, GetLogger(r.R.Context()), make(map[fs.File]struct{}), r.W, r.R}, path: "."}, nil
 }
 func (p *DotDirConfig) Cleanup(a any, err error) error {
 	v := a.(Dir).dot
@@ -0,0 +1,199 @@
+package xtemplate
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// WithGeoIP creates an [xtemplate.Option] that adds a GeoIP provider at the
+// dot field name that looks up addresses in the MaxMind-format database files
+// at paths.
+func WithGeoIP(name string, paths ...string) Option {
+	return func(c *Config) error {
+		c.GeoIP = append(c.GeoIP, DotGeoIPConfig{Name: name, Paths: paths})
+		return nil
+	}
+}
+
+// DotGeoIPConfig configures lookups of the location and network of IP
+// addresses in MaxMind-format database files, like the GeoLite2 City, Country,
+// and ASN databases. The files are read into memory when the instance loads.
+type DotGeoIPConfig struct {
+	Name string `json:"name"`
+
+	// The paths of the database files. Lookups merge the results from each
+	// file, so a City database can be combined with an ASN database.
+	Paths []string `json:"paths"`
+
+	// The language of place names. Default `en`.
+	Language string `json:"language,omitempty"`
+
+	// A request header that contains the client's address when xtemplate runs
+	// behind a proxy, like `X-Forwarded-For` or `CF-Connecting-IP`. The first
+	// address in the header is used. Default empty, use the address of the
+	// connection.
+	ClientIPHeader string `json:"client_ip_header,omitempty"`
+
+	readers []*maxminddb.Reader
+}
+
+var _ DotConfig = &DotGeoIPConfig{}
+
+func (d *DotGeoIPConfig) FieldName() string { return d.Name }
+func (d *DotGeoIPConfig) Init(_ context.Context) error {
+	if len(d.Paths) == 0 {
+		return fmt.Errorf("geoip database paths are required")
+	}
+	if d.Language == "" {
+		d.Language = "en"
+	}
+	d.readers = nil
+	for _, path := range d.Paths {
+		// read the file instead of mapping it so requests to a previous
+		// instance are never left reading a closed file
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read geoip database '%s': %w", path, err)
+		}
+		reader, err := maxminddb.FromBytes(data)
+		if err != nil {
+			return fmt.Errorf("failed to open geoip database '%s': %w", path, err)
+		}
+		d.readers = append(d.readers, reader)
+	}
+	return nil
+}
+func (d *DotGeoIPConfig) Value(r Request) (any, error) {
+	return DotGeoIP{d, r.R}, nil
+}
+
+// DotGeoIP is used as the dot field to look up the location and network of IP
+// addresses, configured by [DotGeoIPConfig].
+//
+//	{{$geo := .GeoIP.Client}}
+//	{{if eq $geo.CountryCode "DE"}}{{template "impressum"}}{{end}}
+type DotGeoIP struct {
+	config *DotGeoIPConfig
+	r      *http.Request
+}
+
+// GeoIPRecord is the result of a GeoIP lookup. Fields are empty if the
+// databases don't have them or don't contain the address.
+type GeoIPRecord struct {
+	IP string
+	// Whether any database contains the address.
+	Found bool
+
+	// The ISO 3166 code and name of the country, like `GB` and `United
+	// Kingdom`.
+	CountryCode string
+	Country     string
+	// The two letter code of the continent, like `EU`.
+	ContinentCode string
+	// The name and ISO 3166-2 code of the largest subdivision, like a state or
+	// province.
+	Region     string
+	RegionCode string
+	City       string
+	PostalCode string
+	Latitude   float64
+	Longitude  float64
+	// The IANA time zone, like `Europe/London`.
+	TimeZone string
+
+	// The autonomous system number and organization of the network.
+	ASN          uint
+	Organization string
+}
+
+type geoipNamed struct {
+	Code    string            `maxminddb:"code"`
+	ISOCode string            `maxminddb:"iso_code"`
+	Names   map[string]string `maxminddb:"names"`
+}
+
+// geoipData is the union of the fields of the MaxMind City, Country, and ASN
+// databases.
+type geoipData struct {
+	City         geoipNamed   `maxminddb:"city"`
+	Continent    geoipNamed   `maxminddb:"continent"`
+	Country      geoipNamed   `maxminddb:"country"`
+	Subdivisions []geoipNamed `maxminddb:"subdivisions"`
+	Postal       geoipNamed   `maxminddb:"postal"`
+	Location     struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+		TimeZone  string  `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+	ASN          uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Lookup returns the location and network of ip, which can include a port
+// like `.Req.RemoteAddr`.
+func (d DotGeoIP) Lookup(ip string) (GeoIPRecord, error) {
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return GeoIPRecord{}, fmt.Errorf("invalid ip address '%s'", ip)
+	}
+	record := GeoIPRecord{IP: addr.String()}
+	lang := d.config.Language
+	// earlier databases take precedence
+	set := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	for _, reader := range d.config.readers {
+		if addr.To4() == nil && reader.Metadata.IPVersion == 4 {
+			continue
+		}
+		var data geoipData
+		_, found, err := reader.LookupNetwork(addr, &data)
+		if err != nil {
+			return GeoIPRecord{}, fmt.Errorf("failed to look up ip address '%s': %w", ip, err)
+		}
+		if !found {
+			continue
+		}
+		record.Found = true
+		set(&record.CountryCode, data.Country.ISOCode)
+		set(&record.Country, data.Country.Names[lang])
+		set(&record.ContinentCode, data.Continent.Code)
+		if len(data.Subdivisions) > 0 {
+			set(&record.Region, data.Subdivisions[0].Names[lang])
+			set(&record.RegionCode, data.Subdivisions[0].ISOCode)
+		}
+		set(&record.City, data.City.Names[lang])
+		set(&record.PostalCode, data.Postal.Code)
+		set(&record.TimeZone, data.Location.TimeZone)
+		if record.Latitude == 0 && record.Longitude == 0 {
+			record.Latitude, record.Longitude = data.Location.Latitude, data.Location.Longitude
+		}
+		if record.ASN == 0 {
+			record.ASN, record.Organization = data.ASN, data.Organization
+		}
+	}
+	return record, nil
+}
+
+// Client returns the location and network of the address of the client that
+// made the request.
+func (d DotGeoIP) Client() (GeoIPRecord, error) {
+	if d.config.ClientIPHeader != "" {
+		if v := d.r.Header.Get(d.config.ClientIPHeader); v != "" {
+			first, _, _ := strings.Cut(v, ",")
+			return d.Lookup(first)
+		}
+	}
+	return d.Lookup(d.r.RemoteAddr)
+}
@@ -0,0 +1,246 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// WithGraphQL creates an [xtemplate.Option] that adds a GraphQL client
+// provider at the dot field name that sends queries to endpoint.
+func WithGraphQL(name, endpoint string, headers map[string]string) Option {
+	return func(c *Config) error {
+		c.GraphQL = append(c.GraphQL, DotGraphQLConfig{Name: name, Endpoint: endpoint, Headers: headers})
+		return nil
+	}
+}
+
+// DotGraphQLConfig configures a GraphQL endpoint that templates can query, like
+// a headless CMS or the GitHub API.
+type DotGraphQLConfig struct {
+	Name string `json:"name"`
+
+	// The url of the GraphQL endpoint.
+	Endpoint string `json:"endpoint"`
+
+	// Headers to send with each request, like `Authorization`. Environment
+	// variables in values like `Bearer ${GITHUB_TOKEN}` are expanded when the
+	// instance loads, so secrets don't have to be kept in the config file.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Headers to copy from the request being served, like `Authorization` to
+	// query on behalf of the user.
+	ForwardHeaders []string `json:"forward_headers,omitempty"`
+
+	// Send the hash of the query instead of the whole query, and only send the
+	// query if the server doesn't know the hash yet, as in Apollo's automatic
+	// persisted queries. Queries are sent with GET so they can be cached by a
+	// CDN.
+	PersistedQueries bool `json:"persisted_queries,omitempty"`
+
+	// How long a request can take. Default `30s`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	client  *http.Client
+	headers http.Header
+}
+
+var _ DotConfig = &DotGraphQLConfig{}
+
+func (d *DotGraphQLConfig) FieldName() string { return d.Name }
+func (d *DotGraphQLConfig) Init(_ context.Context) error {
+	if d.Endpoint == "" {
+		return fmt.Errorf("graphql endpoint is required")
+	}
+	if _, err := url.Parse(d.Endpoint); err != nil {
+		return fmt.Errorf("failed to parse graphql endpoint: %w", err)
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(30 * time.Second)
+	}
+	d.client = &http.Client{Timeout: time.Duration(d.Timeout)}
+	d.headers = http.Header{}
+	for k, v := range d.Headers {
+		d.headers.Set(k, os.ExpandEnv(v))
+	}
+	return nil
+}
+func (d *DotGraphQLConfig) Value(r Request) (any, error) {
+	return DotGraphQL{d, r.R}, nil
+}
+
+// DotGraphQL is used as the dot field to send queries and mutations to a
+// GraphQL endpoint, configured by [DotGraphQLConfig]. Both return the `data`
+// of the response, and fail if the response has any errors.
+//
+//	{{$data := .GQL.Query `query($slug: String!) { post(slug: $slug) { title body } }` (dict "slug" (.Req.PathValue "slug"))}}
+//	<h1>{{$data.post.title}}</h1>
+type DotGraphQL struct {
+	config *DotGraphQLConfig
+	r      *http.Request
+}
+
+// Query sends query with optional variables and returns the data of the
+// response.
+func (d DotGraphQL) Query(query string, vars ...map[string]any) (any, error) {
+	return d.do(query, vars, true)
+}
+
+// Mutate sends the mutation with optional variables and returns the data of
+// the response. Mutations are always sent with POST.
+func (d DotGraphQL) Mutate(mutation string, vars ...map[string]any) (any, error) {
+	return d.do(mutation, vars, false)
+}
+
+type graphqlRequest struct {
+	Query      string         `json:"query,omitempty"`
+	Variables  map[string]any `json:"variables,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data   any            `json:"data"`
+	Errors []graphqlError `json:"errors"`
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path"`
+	// Extensions.Code is `PERSISTED_QUERY_NOT_FOUND` if the server doesn't
+	// know a persisted query's hash.
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+func (d DotGraphQL) do(query string, vars []map[string]any, get bool) (any, error) {
+	req := graphqlRequest{Query: query}
+	switch len(vars) {
+	case 0:
+	case 1:
+		req.Variables = vars[0]
+	default:
+		return nil, fmt.Errorf("too many vars arguments provided: %v", vars)
+	}
+	if d.config.PersistedQueries {
+		hash := sha256.Sum256([]byte(query))
+		req.Extensions = map[string]any{"persistedQuery": map[string]any{"version": 1, "sha256Hash": hex.EncodeToString(hash[:])}}
+		hashOnly := req
+		hashOnly.Query = ""
+		resp, err := d.send(hashOnly, get)
+		if err != nil {
+			return nil, err
+		}
+		if !graphqlPersistedQueryNotFound(resp) {
+			return graphqlData(resp)
+		}
+		// the server doesn't know the query yet, send it with its hash so
+		// it's stored for next time
+	}
+	resp, err := d.send(req, false)
+	if err != nil {
+		return nil, err
+	}
+	return graphqlData(resp)
+}
+
+func (d DotGraphQL) send(body graphqlRequest, get bool) (*graphqlResponse, error) {
+	var httpReq *http.Request
+	var err error
+	if get {
+		u, _ := url.Parse(d.config.Endpoint)
+		q := u.Query()
+		if body.Query != "" {
+			q.Set("query", body.Query)
+		}
+		if len(body.Variables) > 0 {
+			data, err := json.Marshal(body.Variables)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode graphql variables: %w", err)
+			}
+			q.Set("variables", string(data))
+		}
+		if body.Extensions != nil {
+			data, _ := json.Marshal(body.Extensions)
+			q.Set("extensions", string(data))
+		}
+		u.RawQuery = q.Encode()
+		httpReq, err = http.NewRequestWithContext(d.r.Context(), "GET", u.String(), nil)
+	} else {
+		var data []byte
+		if data, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("failed to encode graphql request: %w", err)
+		}
+		httpReq, err = http.NewRequestWithContext(d.r.Context(), "POST", d.config.Endpoint, bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create graphql request: %w", err)
+	}
+	if !get {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Accept", "application/graphql-response+json, application/json")
+	for k, v := range d.config.headers {
+		httpReq.Header[k] = v
+	}
+	for _, k := range d.config.ForwardHeaders {
+		if v := d.r.Header.Values(k); len(v) > 0 {
+			httpReq.Header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+
+	resp, err := d.config.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send graphql request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read graphql response: %w", err)
+	}
+	var result graphqlResponse
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("graphql request failed with status %s: %s", resp.Status, strings.TrimSpace(string(data)))
+		}
+		return nil, fmt.Errorf("failed to decode graphql response: %w", err)
+	}
+	if result.Data == nil && len(result.Errors) == 0 && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("graphql request failed with status %s", resp.Status)
+	}
+	return &result, nil
+}
+
+func graphqlPersistedQueryNotFound(resp *graphqlResponse) bool {
+	for _, e := range resp.Errors {
+		if e.Extensions.Code == "PERSISTED_QUERY_NOT_FOUND" || e.Message == "PersistedQueryNotFound" {
+			return true
+		}
+	}
+	return false
+}
+
+func graphqlData(resp *graphqlResponse) (any, error) {
+	if len(resp.Errors) == 0 {
+		return resp.Data, nil
+	}
+	messages := make([]string, len(resp.Errors))
+	for i, e := range resp.Errors {
+		messages[i] = e.Message
+		if len(e.Path) > 0 {
+			messages[i] += fmt.Sprintf(" (at %v)", e.Path)
+		}
+	}
+	return nil, fmt.Errorf("graphql query failed: %s", strings.Join(messages, "; "))
+}
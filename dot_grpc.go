@@ -0,0 +1,307 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// WithGRPC creates an [xtemplate.Option] that adds a gRPC client provider at
+// the dot field name that calls the server at target. Services are described
+// by the descriptor set files at descriptorSets, or by the server's reflection
+// service if none are given.
+func WithGRPC(name, target string, descriptorSets ...string) Option {
+	return func(c *Config) error {
+		c.GRPC = append(c.GRPC, DotGRPCConfig{Name: name, Target: target, DescriptorSets: descriptorSets})
+		return nil
+	}
+}
+
+// DotGRPCConfig configures a connection to a gRPC server that templates can
+// call methods on without generated code. Messages are converted to and from
+// maps with the protobuf JSON mapping, using service descriptors that are
+// loaded when the instance loads.
+type DotGRPCConfig struct {
+	Name string `json:"name"`
+
+	// The address of the server, like `localhost:50051` or
+	// `dns:///orders.internal:443`.
+	Target string `json:"target"`
+
+	// Connect with TLS.
+	TLS bool `json:"tls,omitempty"`
+
+	// The paths of descriptor set files that describe the server's services,
+	// created with `protoc --include_imports --descriptor_set_out`. Default
+	// empty, load the descriptors from the server's reflection service.
+	DescriptorSets []string `json:"descriptor_sets,omitempty"`
+
+	// Metadata to send with each call, like `authorization`. Environment
+	// variables in values like `Bearer ${API_TOKEN}` are expanded when the
+	// instance loads.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Headers to copy from the request being served into the metadata of each
+	// call.
+	ForwardHeaders []string `json:"forward_headers,omitempty"`
+
+	// How long a call can take. Default `30s`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	conn  *grpc.ClientConn
+	files *protoregistry.Files
+	types *dynamicpb.Types
+	md    metadata.MD
+}
+
+var _ DotConfig = &DotGRPCConfig{}
+
+func (d *DotGRPCConfig) FieldName() string { return d.Name }
+func (d *DotGRPCConfig) Init(ctx context.Context) error {
+	if d.Target == "" {
+		return fmt.Errorf("grpc target is required")
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(30 * time.Second)
+	}
+	d.md = metadata.MD{}
+	for k, v := range d.Headers {
+		d.md.Set(k, os.ExpandEnv(v))
+	}
+	creds := insecure.NewCredentials()
+	if d.TLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(d.Target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("failed to create grpc client for '%s': %w", d.Target, err)
+	}
+	var fds []*descriptorpb.FileDescriptorProto
+	if len(d.DescriptorSets) > 0 {
+		fds, err = grpcReadDescriptorSets(d.DescriptorSets)
+	} else {
+		fds, err = d.reflect(ctx, conn)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: fds})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to build grpc descriptors: %w", err)
+	}
+	d.conn, d.files, d.types = conn, files, dynamicpb.NewTypes(files)
+	// close the connection when the instance is cancelled
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			conn.Close()
+		}()
+	}
+	return nil
+}
+func (d *DotGRPCConfig) Value(r Request) (any, error) {
+	return DotGRPC{d, r.R}, nil
+}
+
+func grpcReadDescriptorSets(paths []string) ([]*descriptorpb.FileDescriptorProto, error) {
+	var fds []*descriptorpb.FileDescriptorProto
+	seen := map[string]bool{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read grpc descriptor set '%s': %w", path, err)
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("failed to decode grpc descriptor set '%s': %w", path, err)
+		}
+		// sets often share imports
+		for _, fd := range set.File {
+			if !seen[fd.GetName()] {
+				seen[fd.GetName()] = true
+				fds = append(fds, fd)
+			}
+		}
+	}
+	return fds, nil
+}
+
+// reflect loads the descriptors of every service the server lists, and the
+// files they import, from the server's reflection service.
+func (d *DotGRPCConfig) reflect(ctx context.Context, conn *grpc.ClientConn) ([]*descriptorpb.FileDescriptorProto, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(d.Timeout))
+	defer cancel()
+	stream, err := reflectpb.NewServerReflectionClient(conn).ServerReflectionInfo(metadata.NewOutgoingContext(ctx, d.md))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call grpc reflection service of '%s': %w", d.Target, err)
+	}
+	defer stream.CloseSend()
+	ask := func(req *reflectpb.ServerReflectionRequest) (*reflectpb.ServerReflectionResponse, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, fmt.Errorf("failed to send grpc reflection request to '%s': %w", d.Target, err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive grpc reflection response from '%s': %w", d.Target, err)
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("grpc reflection request to '%s' failed: %s", d.Target, e.GetErrorMessage())
+		}
+		return resp, nil
+	}
+
+	resp, err := ask(&reflectpb.ServerReflectionRequest{MessageRequest: &reflectpb.ServerReflectionRequest_ListServices{}})
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	var order []string
+	add := func(resp *reflectpb.ServerReflectionResponse) error {
+		for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(data, fd); err != nil {
+				return fmt.Errorf("failed to decode grpc file descriptor from '%s': %w", d.Target, err)
+			}
+			if files[fd.GetName()] == nil {
+				files[fd.GetName()] = fd
+				order = append(order, fd.GetName())
+			}
+		}
+		return nil
+	}
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		if strings.HasPrefix(svc.GetName(), "grpc.reflection.") {
+			continue
+		}
+		resp, err := ask(&reflectpb.ServerReflectionRequest{MessageRequest: &reflectpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: svc.GetName()}})
+		if err != nil {
+			return nil, err
+		}
+		if err := add(resp); err != nil {
+			return nil, err
+		}
+	}
+	// servers skip files they already sent on the stream, but may not send
+	// every import up front
+	for i := 0; i < len(order); i++ {
+		for _, dep := range files[order[i]].GetDependency() {
+			if files[dep] != nil {
+				continue
+			}
+			resp, err := ask(&reflectpb.ServerReflectionRequest{MessageRequest: &reflectpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep}})
+			if err != nil {
+				return nil, err
+			}
+			if err := add(resp); err != nil {
+				return nil, err
+			}
+		}
+	}
+	fds := make([]*descriptorpb.FileDescriptorProto, len(order))
+	for i, name := range order {
+		fds[i] = files[name]
+	}
+	return fds, nil
+}
+
+// DotGRPC is used as the dot field to call methods on a gRPC server,
+// configured by [DotGRPCConfig].
+//
+//	{{$order := .GRPC.Call "shop.v1.Orders/GetOrder" (dict "id" (.Req.PathValue "id"))}}
+//	<h1>Order {{$order.id}}: {{$order.status}}</h1>
+type DotGRPC struct {
+	config *DotGRPCConfig
+	r      *http.Request
+}
+
+// Call calls the unary method, like `pkg.Service/Method`, with the optional
+// request message and returns the response message. Messages use the field
+// names from the .proto file, fields that aren't set in the response have
+// their default value, and 64-bit integers are strings as in the protobuf
+// JSON mapping.
+func (d DotGRPC) Call(method string, req ...map[string]any) (map[string]any, error) {
+	var body map[string]any
+	switch len(req) {
+	case 0:
+	case 1:
+		body = req[0]
+	default:
+		return nil, fmt.Errorf("too many req arguments provided: %v", req)
+	}
+	svcName, methodName, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("grpc method must be like 'pkg.Service/Method', got '%s'", method)
+	}
+	desc, err := d.config.files.FindDescriptorByName(protoreflect.FullName(svcName))
+	if err != nil {
+		return nil, fmt.Errorf("unknown grpc service '%s': %w", svcName, err)
+	}
+	svc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a grpc service", svcName)
+	}
+	m := svc.Methods().ByName(protoreflect.Name(methodName))
+	if m == nil {
+		return nil, fmt.Errorf("unknown grpc method '%s' of service '%s'", methodName, svcName)
+	}
+	if m.IsStreamingClient() || m.IsStreamingServer() {
+		return nil, fmt.Errorf("cannot call streaming grpc method '%s'", method)
+	}
+
+	in := dynamicpb.NewMessage(m.Input())
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode grpc request: %w", err)
+		}
+		if err := (protojson.UnmarshalOptions{Resolver: d.config.types}).Unmarshal(data, in); err != nil {
+			return nil, fmt.Errorf("failed to convert grpc request to '%s': %w", m.Input().FullName(), err)
+		}
+	}
+	out := dynamicpb.NewMessage(m.Output())
+
+	md := d.config.md.Copy()
+	for _, k := range d.config.ForwardHeaders {
+		if v := d.r.Header.Values(k); len(v) > 0 {
+			md.Set(k, v...)
+		}
+	}
+	ctx, cancel := context.WithTimeout(d.r.Context(), time.Duration(d.config.Timeout))
+	defer cancel()
+	if err := d.config.conn.Invoke(metadata.NewOutgoingContext(ctx, md), "/"+svcName+"/"+methodName, in, out); err != nil {
+		return nil, fmt.Errorf("grpc call '%s' failed: %w", method, err)
+	}
+
+	data, err := protojson.MarshalOptions{Resolver: d.config.types, UseProtoNames: true, EmitUnpopulated: true}.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert grpc response from '%s': %w", m.Output().FullName(), err)
+	}
+	var result map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode grpc response: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,158 @@
+package xtemplate
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// grpcTestServer serves the grpc health service with reflection on a local
+// port, and records the metadata of the last call.
+type grpcTestServer struct {
+	addr   string
+	health *health.Server
+	mu     sync.Mutex
+	md     metadata.MD
+}
+
+func newGRPCTestServer(t *testing.T) *grpcTestServer {
+	t.Helper()
+	ts := &grpcTestServer{health: health.NewServer()}
+	s := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ts.mu.Lock()
+		ts.md = md
+		ts.mu.Unlock()
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(s, ts.health)
+	reflection.Register(s)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	ts.addr = l.Addr().String()
+	return ts
+}
+
+func initTestGRPC(t *testing.T, d *DotGRPCConfig) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := d.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGRPCCall(t *testing.T) {
+	server := newGRPCTestServer(t)
+	server.health.SetServingStatus("orders", healthpb.HealthCheckResponse_NOT_SERVING)
+	t.Setenv("TEST_GRPC_TOKEN", "secret")
+	d := &DotGRPCConfig{
+		Name:           "GRPC",
+		Target:         server.addr,
+		Headers:        map[string]string{"authorization": "Bearer ${TEST_GRPC_TOKEN}"},
+		ForwardHeaders: []string{"X-Request-Id"},
+	}
+	initTestGRPC(t, d)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "abc")
+	g := DotGRPC{d, r}
+
+	resp, err := g.Call("grpc.health.v1.Health/Check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp["status"] != "SERVING" {
+		t.Fatalf("response %v, want status SERVING", resp)
+	}
+	resp, err = g.Call("/grpc.health.v1.Health/Check", map[string]any{"service": "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp["status"] != "NOT_SERVING" {
+		t.Fatalf("response %v, want status NOT_SERVING", resp)
+	}
+
+	server.mu.Lock()
+	md := server.md
+	server.mu.Unlock()
+	if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer secret" {
+		t.Fatalf("authorization metadata %q", got)
+	}
+	if got := md.Get("x-request-id"); len(got) != 1 || got[0] != "abc" {
+		t.Fatalf("forwarded metadata %q", got)
+	}
+
+	if _, err := g.Call("grpc.health.v1.Health/Check", map[string]any{"service": "missing"}); err == nil || !strings.Contains(err.Error(), "NotFound") {
+		t.Fatalf("call with an unknown service returned %v, want the server's error", err)
+	}
+}
+
+func TestGRPCCallErrors(t *testing.T) {
+	server := newGRPCTestServer(t)
+	d := &DotGRPCConfig{Name: "GRPC", Target: server.addr}
+	initTestGRPC(t, d)
+	g := DotGRPC{d, httptest.NewRequest("GET", "/", nil)}
+	for _, test := range []struct {
+		method string
+		req    []map[string]any
+		err    string
+	}{
+		{"Check", nil, "must be like"},
+		{"grpc.health.v1.Nope/Check", nil, "unknown grpc service"},
+		{"grpc.health.v1.HealthCheckRequest/Check", nil, "is not a grpc service"},
+		{"grpc.health.v1.Health/Nope", nil, "unknown grpc method"},
+		{"grpc.health.v1.Health/Watch", nil, "streaming"},
+		{"grpc.health.v1.Health/Check", []map[string]any{{"nope": 1}}, "failed to convert grpc request"},
+		{"grpc.health.v1.Health/Check", []map[string]any{{}, {}}, "too many"},
+	} {
+		if _, err := g.Call(test.method, test.req...); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("call %s %v returned %v, want an error containing %q", test.method, test.req, err, test.err)
+		}
+	}
+}
+
+func TestGRPCDescriptorSets(t *testing.T) {
+	server := newGRPCTestServer(t)
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto),
+	}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "health.binpb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := &DotGRPCConfig{Name: "GRPC", Target: server.addr, DescriptorSets: []string{path, path}}
+	initTestGRPC(t, d)
+	resp, err := DotGRPC{d, httptest.NewRequest("GET", "/", nil)}.Call("grpc.health.v1.Health/Check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp["status"] != "SERVING" {
+		t.Fatalf("response %v, want status SERVING", resp)
+	}
+	// the reflection service isn't in the descriptor set
+	if _, err := (DotGRPC{d, httptest.NewRequest("GET", "/", nil)}).Call("grpc.reflection.v1.ServerReflection/ServerReflectionInfo"); err == nil {
+		t.Fatalf("called a service that isn't in the descriptor set")
+	}
+}
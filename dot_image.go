@@ -0,0 +1,301 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// WithImage creates an [xtemplate.Option] that adds an image processing
+// provider at the dot field name that opens images from the configured
+// directory named directory.
+func WithImage(name, directory string) Option {
+	return func(c *Config) error {
+		c.Image = append(c.Image, DotImageConfig{Name: name, Directory: directory})
+		return nil
+	}
+}
+
+// DotImageConfig configures image processing for templates, like making
+// thumbnails of uploaded photos in a POST handler. Results of processing files
+// in Directory are cached.
+type DotImageConfig struct {
+	Name string `json:"name"`
+
+	// The name of a configured directory to open images from by path.
+	Directory string `json:"directory,omitempty"`
+
+	// The maximum number of pixels an image can have to be decoded. Default
+	// `40000000` (40 megapixels).
+	MaxSourcePixels int `json:"max_source_pixels,omitempty"`
+
+	// The maximum number of images processed at once, to bound memory usage.
+	// Default `4`.
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+
+	// The maximum total size in bytes of processed files kept in memory.
+	// Default `64MiB`.
+	CacheBytes int `json:"cache_bytes,omitempty"`
+
+	// The quality of jpeg images, from 1 to 100. Default `85`.
+	Quality int `json:"quality,omitempty"`
+
+	dir   *DotDirConfig
+	sem   chan struct{}
+	cache *imageCache
+}
+
+var _ DotConfig = &DotImageConfig{}
+
+func (d *DotImageConfig) FieldName() string { return d.Name }
+func (d *DotImageConfig) Init(_ context.Context) error {
+	if d.Directory != "" && d.dir == nil {
+		return fmt.Errorf("image directory '%s' is not a configured directory", d.Directory)
+	}
+	if d.MaxSourcePixels == 0 {
+		d.MaxSourcePixels = 40_000_000
+	}
+	if d.MaxConcurrency == 0 {
+		d.MaxConcurrency = 4
+	}
+	if d.CacheBytes == 0 {
+		d.CacheBytes = 64 << 20
+	}
+	if d.Quality == 0 {
+		d.Quality = 85
+	}
+	d.sem = make(chan struct{}, d.MaxConcurrency)
+	d.cache = &imageCache{entries: make(map[string]*resizedImage), maxBytes: d.CacheBytes}
+	return nil
+}
+func (d *DotImageConfig) Value(r Request) (any, error) {
+	return DotImage{d, r.R}, nil
+}
+
+// DotImage is used as the dot field to process images, configured by
+// [DotImageConfig]. Each operation takes a source as its last argument, so
+// operations can be piped, which is the path of an image in the configured
+// directory or the result of another operation, and returns a new [Image].
+//
+//	{{define "POST /photos"}}
+//	{{$thumb := .Image.Upload "photo" | .Image.Crop 200 200 | .Image.EncodeWebP}}
+//	{{.Bucket.Put "thumbs/photo.webp" $thumb.Bytes $thumb.ContentType}}
+//	{{end}}
+type DotImage struct {
+	config *DotImageConfig
+	r      *http.Request
+}
+
+// Image is an encoded image returned by [DotImage].
+type Image struct {
+	Width  int
+	Height int
+	// `jpeg`, `png`, `gif`, or `webp`.
+	Format      string
+	ContentType string
+	// The encoded image, which can be stored with `.Bucket.Put`.
+	Bytes []byte
+
+	// identifies the file it was processed from, to cache results
+	key string
+	// decoded lazily
+	img image.Image
+}
+
+// DataURL returns the image as a `data:` url to use as the src of an img.
+func (i *Image) DataURL() template.URL {
+	return template.URL("data:" + i.ContentType + ";base64," + base64.StdEncoding.EncodeToString(i.Bytes))
+}
+
+// Open opens the image at path in the configured directory.
+func (d DotImage) Open(path_ string) (*Image, error) {
+	name, key, err := d.stat(path_)
+	if err != nil {
+		return nil, err
+	}
+	content, err := fs.ReadFile(d.config.dir.FS, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image '%s': %w", path_, err)
+	}
+	img, err := d.newImage(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image '%s': %w", path_, err)
+	}
+	img.key = key
+	return img, nil
+}
+
+// stat returns the name of the file at path_ in the configured directory, and
+// a key that identifies its current version.
+func (d DotImage) stat(path_ string) (name, key string, err error) {
+	if d.config.dir == nil {
+		return "", "", fmt.Errorf("image provider '%s' has no directory to open '%s' from", d.config.Name, path_)
+	}
+	name = path.Clean(strings.TrimPrefix(path_, "/"))
+	stat, err := fs.Stat(d.config.dir.FS, name)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open image '%s': %w", path_, err)
+	}
+	return name, fmt.Sprintf("%s %d", name, stat.ModTime().UnixNano()), nil
+}
+
+// Upload reads the image uploaded in the multipart form field of the request.
+func (d DotImage) Upload(field string) (*Image, error) {
+	f, _, err := d.r.FormFile(field)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read form file '%s': %w", field, err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read form file '%s': %w", field, err)
+	}
+	img, err := d.newImage(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded image '%s': %w", field, err)
+	}
+	return img, nil
+}
+
+// Resize scales src down to fit within width x height, preserving its aspect
+// ratio. Either dimension may be 0 to scale proportionally to the other.
+// Images are never scaled up.
+func (d DotImage) Resize(width, height int, src any) (*Image, error) {
+	if width < 0 || height < 0 || (width == 0 && height == 0) {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	return d.process(src, fmt.Sprintf("resize %dx%d", width, height), "", func(img image.Image) image.Image {
+		b := img.Bounds()
+		size := fitImage(b.Dx(), b.Dy(), width, height)
+		if size == b.Size() {
+			return img
+		}
+		dst := image.NewRGBA(image.Rectangle{Max: size})
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+		return dst
+	})
+}
+
+// Crop scales src to cover width x height and crops the rest from its center,
+// like a thumbnail.
+func (d DotImage) Crop(width, height int, src any) (*Image, error) {
+	if width <= 0 || height <= 0 || width*height > d.config.MaxSourcePixels {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	return d.process(src, fmt.Sprintf("crop %dx%d", width, height), "", func(img image.Image) image.Image {
+		b := img.Bounds()
+		crop := b
+		if b.Dx()*height > b.Dy()*width {
+			w := max(1, b.Dy()*width/height)
+			crop.Min.X += (b.Dx() - w) / 2
+			crop.Max.X = crop.Min.X + w
+		} else {
+			h := max(1, b.Dx()*height/width)
+			crop.Min.Y += (b.Dy() - h) / 2
+			crop.Max.Y = crop.Min.Y + h
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Over, nil)
+		return dst
+	})
+}
+
+// Convert encodes src as format, which is `jpeg`, `png`, `gif`, or `webp`.
+func (d DotImage) Convert(format string, src any) (*Image, error) {
+	switch format {
+	case "jpeg", "png", "gif", "webp":
+	case "jpg":
+		format = "jpeg"
+	default:
+		return nil, fmt.Errorf("unknown image format '%s'", format)
+	}
+	return d.process(src, "convert "+format, format, func(img image.Image) image.Image { return img })
+}
+
+// EncodeWebP encodes src as a lossless webp image.
+func (d DotImage) EncodeWebP(src any) (*Image, error) {
+	return d.Convert("webp", src)
+}
+
+func (d DotImage) newImage(content []byte) (*Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image config: %w", err)
+	}
+	if cfg.Width*cfg.Height > d.config.MaxSourcePixels {
+		return nil, fmt.Errorf("image is too large: %dx%d", cfg.Width, cfg.Height)
+	}
+	return &Image{Width: cfg.Width, Height: cfg.Height, Format: format, ContentType: "image/" + format, Bytes: content}, nil
+}
+
+// process applies op to src and encodes the result in format, default the
+// format of src. Results of images opened from files are cached.
+func (d DotImage) process(src any, op, format string, apply func(image.Image) image.Image) (*Image, error) {
+	var in *Image
+	var key string
+	switch s := src.(type) {
+	case string:
+		_, fileKey, err := d.stat(s)
+		if err != nil {
+			return nil, err
+		}
+		key = fileKey + " " + op
+		if cached := d.config.cache.get(key); cached != nil {
+			out, err := d.newImage(cached.content)
+			if err != nil {
+				return nil, err
+			}
+			out.key = key
+			return out, nil
+		}
+		if in, err = d.Open(s); err != nil {
+			return nil, err
+		}
+	case *Image:
+		in = s
+		if in.key != "" {
+			key = in.key + " " + op
+		}
+	default:
+		return nil, fmt.Errorf("image source must be a path or an image, got %T", src)
+	}
+	if format == "" {
+		format = in.Format
+	}
+
+	select {
+	case d.config.sem <- struct{}{}:
+	case <-d.r.Context().Done():
+		return nil, d.r.Context().Err()
+	}
+	defer func() { <-d.config.sem }()
+	defer startSpan(d.r.Context(), "image "+op)()
+
+	if in.img == nil {
+		var err error
+		if in.img, _, err = image.Decode(bytes.NewReader(in.Bytes)); err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+	}
+	img := apply(in.img)
+	content, contentType, err := encodeImage(img, format, d.config.Quality)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		d.config.cache.put(&resizedImage{key: key, content: content, contentType: contentType})
+	}
+	b := img.Bounds()
+	return &Image{Width: b.Dx(), Height: b.Dy(), Format: format, ContentType: contentType, Bytes: content, key: key, img: img}, nil
+}
@@ -3,10 +3,16 @@ package xtemplate
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"path"
+	"reflect"
+	"strings"
 )
 
 type dotXProvider struct {
@@ -15,7 +21,9 @@ type dotXProvider struct {
 
 func (dotXProvider) FieldName() string            { return "X" }
 func (dotXProvider) Init(_ context.Context) error { return nil }
-func (p dotXProvider) Value(Request) (any, error) { return DotX(p), nil }
+func (p dotXProvider) Value(r Request) (any, error) {
+	return DotX{p.instance, r.R.Context(), r.R}, nil
+}
 
 func (dotXProvider) Cleanup(_ any, err error) error {
 	if errors.As(err, &ReturnError{}) {
@@ -29,6 +37,8 @@ var _ CleanupDotProvider = dotXProvider{}
 // DotX is used as the field at .X in all template invocations.
 type DotX struct {
 	instance *Instance
+	ctx      context.Context
+	req      *http.Request
 }
 
 // StaticFileHash returns the sha-384 hash of the named asset file to be used
@@ -42,6 +52,29 @@ func (d DotX) StaticFileHash(urlpath string) (string, error) {
 	return fileinfo.hash, nil
 }
 
+// Asset returns the url path that clients should use to request the named
+// static file so that it can be cached indefinitely. If HashedAssets is enabled
+// this is the content-hashed path like `/assets/app.5rcfZgbO.js`, otherwise
+// the content hash is added as the `hash` query parameter. In development mode
+// the file's `.debug` variant is used instead if it exists. Also available as
+// the `asset` func.
+func (d DotX) Asset(urlpath string) (string, error) {
+	urlpath = path.Clean("/" + urlpath)
+	fileinfo, ok := d.instance.files[urlpath]
+	if !ok {
+		return "", fmt.Errorf("file does not exist: '%s'", urlpath)
+	}
+	if d.instance.config.Development {
+		if debug, ok := d.instance.files[debugAssetPath(urlpath)]; ok {
+			fileinfo = debug
+		}
+	}
+	if fileinfo.hashedPath != "" {
+		return fileinfo.hashedPath, nil
+	}
+	return fileinfo.identityPath + "?hash=" + fileinfo.hash, nil
+}
+
 // Template invokes the template name with the given dot value, returning the
 // result as a html string.
 func (c DotX) Template(name string, dot any) (template.HTML, error) {
@@ -53,6 +86,9 @@ func (c DotX) Template(name string, dot any) (template.HTML, error) {
 	if t == nil {
 		return "", fmt.Errorf("failed to lookup template name: '%s'", name)
 	}
+	if c.ctx != nil {
+		defer startSpan(c.ctx, "template "+name)()
+	}
 	if err := t.Execute(buf, dot); err != nil {
 		return "", fmt.Errorf("failed to execute template '%s': %w", name, err)
 	}
@@ -65,6 +101,131 @@ func (c DotX) Func(name string) any {
 	return c.instance.funcs[name]
 }
 
+// maxCallDepth is the maximum number of nested internal requests made with
+// [DotX.Call], to stop templates that call themselves.
+const maxCallDepth = 8
+
+type callDepthKey struct{}
+
+// callHeaders are the request headers passed on to internal requests.
+var callHeaders = []string{"Cookie", "Authorization", "Accept-Language"}
+
+// CallResponse is the response to an internal request made with [DotX.Call].
+type CallResponse struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
+// HTML returns the body of the response as html that isn't escaped when
+// written into a template.
+func (r CallResponse) HTML() template.HTML {
+	return template.HTML(r.Body)
+}
+
+// JSON parses the body of the response as json.
+func (r CallResponse) JSON() (any, error) {
+	var v any
+	if err := json.Unmarshal([]byte(r.Body), &v); err != nil {
+		return nil, fmt.Errorf("failed to parse response body as json: %w", err)
+	}
+	return v, nil
+}
+
+// Call makes an internal request to this instance's routes with method and
+// url, like `GET` and `/api/items?page=2`, without going through the network,
+// and returns the response. If data is given, like a dict, its entries are
+// added to the url's query for GET, HEAD, and DELETE requests, or sent as a
+// form body for other methods. The request has the same Cookie,
+// Authorization, and Accept-Language headers as the current request.
+//
+//	{{with .X.Call "GET" "/api/items" (dict "category" "books")}}{{.HTML}}{{end}}
+func (c DotX) Call(method, url_ string, data ...any) (CallResponse, error) {
+	if len(data) > 1 {
+		return CallResponse{}, fmt.Errorf("too many data arguments provided: %v", data)
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = c.instance.config.Ctx
+	}
+	depth, _ := ctx.Value(callDepthKey{}).(int)
+	if depth >= maxCallDepth {
+		return CallResponse{}, fmt.Errorf("internal request to '%s' exceeds the maximum depth of %d nested calls", url_, maxCallDepth)
+	}
+	u, err := url.Parse(url_)
+	if err != nil || u.IsAbs() || !strings.HasPrefix(u.Path, "/") {
+		return CallResponse{}, fmt.Errorf("internal request url must be a path like /api/items, got '%s'", url_)
+	}
+	method = strings.ToUpper(method)
+	var values url.Values
+	if len(data) == 1 {
+		if values, err = callValues(data[0]); err != nil {
+			return CallResponse{}, err
+		}
+	}
+	var body *strings.Reader
+	switch {
+	case values == nil:
+		body = strings.NewReader("")
+	case method == http.MethodGet || method == http.MethodHead || method == http.MethodDelete:
+		q := u.Query()
+		for k, vs := range values {
+			q[k] = append(q[k], vs...)
+		}
+		u.RawQuery = q.Encode()
+		body = strings.NewReader("")
+	default:
+		body = strings.NewReader(values.Encode())
+	}
+	r, err := http.NewRequestWithContext(context.WithValue(ctx, callDepthKey{}, depth+1), method, u.String(), body)
+	if err != nil {
+		return CallResponse{}, fmt.Errorf("failed to create internal request: %w", err)
+	}
+	r.RequestURI = u.RequestURI()
+	if values != nil && body.Len() > 0 {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if c.req != nil {
+		r.Host, r.RemoteAddr = c.req.Host, c.req.RemoteAddr
+		for _, name := range callHeaders {
+			if v := c.req.Header.Values(name); len(v) > 0 {
+				r.Header[name] = v
+			}
+		}
+	}
+	if c.ctx != nil {
+		defer startSpan(c.ctx, "call "+method+" "+u.Path)()
+	}
+	w := httptest.NewRecorder()
+	c.instance.handler.ServeHTTP(w, r)
+	return CallResponse{Status: w.Code, Header: w.Header(), Body: w.Body.String()}, nil
+}
+
+// callValues converts a map to url values, where list values become
+// repeated keys.
+func callValues(data any) (url.Values, error) {
+	m := reflect.ValueOf(data)
+	if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("internal request data must be a map with string keys like a dict, got %T", data)
+	}
+	values := url.Values{}
+	iter := m.MapRange()
+	for iter.Next() {
+		key, v := iter.Key().String(), iter.Value()
+		for v.Kind() == reflect.Interface && !v.IsNil() {
+			v = v.Elem()
+		}
+		if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				values.Add(key, fmt.Sprint(v.Index(i).Interface()))
+			}
+			continue
+		}
+		values.Add(key, csvValue(v))
+	}
+	return values, nil
+}
+
 // ReturnError is a sentinel value that indicates a successful/normal exit but
 // causes template execution to stop immediately. Used by funcs and dot field
 // methods to perform custom actions.
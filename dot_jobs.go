@@ -0,0 +1,39 @@
+package xtemplate
+
+import (
+	"context"
+	"time"
+)
+
+type dotJobsProvider struct {
+	queue *jobQueue
+}
+
+func (p dotJobsProvider) FieldName() string          { return p.queue.config.Name }
+func (dotJobsProvider) Init(_ context.Context) error { return nil }
+func (p dotJobsProvider) Value(r Request) (any, error) {
+	job, _ := r.R.Context().Value(jobContextKey{}).(*Job)
+	return DotJobs{queue: p.queue, ctx: r.R.Context(), Job: job}, nil
+}
+
+// DotJobs is used as the dot field to enqueue background jobs, configured by
+// [JobsConfig].
+type DotJobs struct {
+	queue *jobQueue
+	ctx   context.Context
+
+	// The job being executed if this is a `JOB` template, otherwise nil.
+	Job *Job
+}
+
+// Enqueue adds a job to the queue to be executed by the template named
+// `JOB name` with payload available at `.Jobs.Job.Payload`. The payload is
+// encoded as JSON. It returns an empty string.
+func (d DotJobs) Enqueue(name string, payload any) (string, error) {
+	return "", d.queue.enqueue(d.ctx, name, payload, 0)
+}
+
+// EnqueueIn is like Enqueue, but the job isn't executed until after delay.
+func (d DotJobs) EnqueueIn(delay time.Duration, name string, payload any) (string, error) {
+	return "", d.queue.enqueue(d.ctx, name, payload, delay)
+}
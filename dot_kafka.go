@@ -0,0 +1,308 @@
+package xtemplate
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// WithKafka creates an [xtemplate.Option] that adds a Kafka provider at the
+// dot field name that connects to brokers.
+func WithKafka(name string, brokers ...string) Option {
+	return func(c *Config) error {
+		c.Kafka = append(c.Kafka, DotKafkaConfig{Name: name, Brokers: brokers})
+		return nil
+	}
+}
+
+// DotKafkaConfig configures a Kafka producer that is shared by all requests to
+// an instance, and the consumers that templates subscribe with.
+type DotKafkaConfig struct {
+	Name string `json:"name"`
+
+	// The addresses of the bootstrap brokers, like `localhost:9092`.
+	Brokers []string `json:"brokers"`
+
+	// Connect with TLS.
+	TLS bool `json:"tls,omitempty"`
+
+	// SASL authentication. Mechanism is `plain`, `scram-sha-256`, or
+	// `scram-sha-512`. Authentication is skipped if Mechanism is empty.
+	SASL struct {
+		Mechanism string `json:"mechanism,omitempty"`
+		Username  string `json:"username,omitempty"`
+		Password  string `json:"password,omitempty"`
+	} `json:"sasl"`
+
+	// The default consumer group of subscriptions. If empty, each
+	// subscription without a group reads every partition of its topic
+	// directly, gets every new message, and doesn't commit offsets.
+	GroupID string `json:"group_id,omitempty"`
+
+	// Where consumer groups without committed offsets start reading:
+	// `latest` or `earliest`. Default `latest`.
+	StartOffset string `json:"start_offset,omitempty"`
+
+	// How often consumer groups commit the offsets of messages that were
+	// received. Default `0`, commit after each message.
+	CommitInterval Duration `json:"commit_interval,omitempty"`
+
+	// How long to wait to connect or publish. Default `10s`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	writer *kafka.Writer
+	dialer *kafka.Dialer
+}
+
+var _ DotConfig = &DotKafkaConfig{}
+
+func (d *DotKafkaConfig) FieldName() string { return d.Name }
+func (d *DotKafkaConfig) Init(ctx context.Context) error {
+	if len(d.Brokers) == 0 {
+		return fmt.Errorf("kafka brokers are required")
+	}
+	switch d.StartOffset {
+	case "", "latest", "earliest":
+	default:
+		return fmt.Errorf("kafka start offset must be 'latest' or 'earliest', got '%s'", d.StartOffset)
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(10 * time.Second)
+	}
+	var mechanism sasl.Mechanism
+	var err error
+	switch strings.ToLower(d.SASL.Mechanism) {
+	case "":
+	case "plain":
+		mechanism = plain.Mechanism{Username: d.SASL.Username, Password: d.SASL.Password}
+	case "scram-sha-256":
+		mechanism, err = scram.Mechanism(scram.SHA256, d.SASL.Username, d.SASL.Password)
+	case "scram-sha-512":
+		mechanism, err = scram.Mechanism(scram.SHA512, d.SASL.Username, d.SASL.Password)
+	default:
+		return fmt.Errorf("unknown kafka sasl mechanism '%s'", d.SASL.Mechanism)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create kafka sasl mechanism: %w", err)
+	}
+	var tlsConfig *tls.Config
+	if d.TLS {
+		tlsConfig = &tls.Config{}
+	}
+	d.dialer = &kafka.Dialer{Timeout: time.Duration(d.Timeout), DualStack: true, TLS: tlsConfig, SASLMechanism: mechanism}
+
+	// check that a broker is reachable
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+
+	d.writer = &kafka.Writer{
+		Addr:         kafka.TCP(d.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// send single messages immediately instead of waiting for a batch
+		BatchTimeout: time.Millisecond,
+		WriteTimeout: time.Duration(d.Timeout),
+		Transport:    &kafka.Transport{DialTimeout: time.Duration(d.Timeout), TLS: tlsConfig, SASL: mechanism},
+	}
+	// close the producer when the instance is cancelled
+	if done := ctx.Done(); done != nil {
+		writer := d.writer
+		go func() {
+			<-done
+			writer.Close()
+		}()
+	}
+	return nil
+}
+
+// dial connects to the first reachable broker.
+func (d *DotKafkaConfig) dial(ctx context.Context) (*kafka.Conn, error) {
+	var errs []error
+	for _, broker := range d.Brokers {
+		conn, err := d.dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("failed to connect to kafka broker '%s': %w", broker, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (d *DotKafkaConfig) Value(r Request) (any, error) {
+	return &DotKafka{d, r.R.Context()}, nil
+}
+
+// DotKafka is used as the dot field to publish messages to and subscribe to
+// Kafka topics, configured by [DotKafkaConfig].
+//
+//	{{.Kafka.Publish "orders" (toJson $order) $order.id}}
+type DotKafka struct {
+	config *DotKafkaConfig
+	ctx    context.Context
+}
+
+// KafkaMessage is a message received by [DotKafka.Subscribe].
+type KafkaMessage struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       string
+	Value     string
+	Headers   map[string]string
+	Time      time.Time
+}
+
+// Publish publishes msg, a string or bytes, to topic and waits until all
+// in-sync replicas have it. Messages with the same optional key are sent to
+// the same partition so they are received in order. It returns an empty
+// string.
+func (d *DotKafka) Publish(topic string, msg any, key ...string) (string, error) {
+	m := kafka.Message{Topic: topic}
+	switch v := msg.(type) {
+	case string:
+		m.Value = []byte(v)
+	case template.HTML:
+		m.Value = []byte(v)
+	case []byte:
+		m.Value = v
+	default:
+		return "", fmt.Errorf("kafka message must be a string or bytes, got %T", msg)
+	}
+	switch len(key) {
+	case 0:
+	case 1:
+		m.Key = []byte(key[0])
+	default:
+		return "", fmt.Errorf("too many key arguments provided: %v", key)
+	}
+	if err := d.config.writer.WriteMessages(d.ctx, m); err != nil {
+		return "", fmt.Errorf("failed to publish to kafka topic '%s': %w", topic, err)
+	}
+	return "", nil
+}
+
+// Subscribe consumes topic and returns a channel of messages that is closed
+// when the request ends. With the optional group, or the configured GroupID,
+// the messages of the topic are divided among the group's subscribers and
+// their offsets are committed, so a subscriber that reconnects continues where
+// it left off. Otherwise the subscription reads every partition of the topic
+// without a consumer group and gets every new message. Use it with `.Flush` to
+// stream messages to the client with server sent events:
+//
+//	{{range .Kafka.Subscribe "orders"}}
+//	{{$.Flush.SendSSE "order" .Value}}
+//	{{end}}
+func (d *DotKafka) Subscribe(topic string, group ...string) (<-chan KafkaMessage, error) {
+	groupID := d.config.GroupID
+	switch len(group) {
+	case 0:
+	case 1:
+		groupID = group[0]
+	default:
+		return nil, fmt.Errorf("too many group arguments provided: %v", group)
+	}
+	var readers []*kafka.Reader
+	if groupID != "" {
+		startOffset := kafka.LastOffset
+		if d.config.StartOffset == "earliest" {
+			startOffset = kafka.FirstOffset
+		}
+		readers = append(readers, kafka.NewReader(kafka.ReaderConfig{
+			Brokers:        d.config.Brokers,
+			GroupID:        groupID,
+			Topic:          topic,
+			Dialer:         d.config.dialer,
+			StartOffset:    startOffset,
+			CommitInterval: time.Duration(d.config.CommitInterval),
+		}))
+	} else {
+		// read each partition from the end without a consumer group, so
+		// subscriptions don't leave groups behind on the brokers
+		conn, err := d.config.dial(d.ctx)
+		if err != nil {
+			return nil, err
+		}
+		partitions, err := conn.ReadPartitions(topic)
+		conn.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read partitions of kafka topic '%s': %w", topic, err)
+		}
+		for _, p := range partitions {
+			reader := kafka.NewReader(kafka.ReaderConfig{
+				Brokers:   d.config.Brokers,
+				Topic:     topic,
+				Partition: p.ID,
+				Dialer:    d.config.dialer,
+			})
+			if err := reader.SetOffset(kafka.LastOffset); err != nil {
+				for _, r := range append(readers, reader) {
+					r.Close()
+				}
+				return nil, fmt.Errorf("failed to subscribe to kafka topic '%s': %w", topic, err)
+			}
+			readers = append(readers, reader)
+		}
+	}
+
+	ch := make(chan KafkaMessage)
+	var wg sync.WaitGroup
+	for _, reader := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer reader.Close()
+			d.consume(reader, groupID, ch)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// consume sends the messages read by reader to ch until the request ends, and
+// commits their offsets if it reads for a consumer group.
+func (d *DotKafka) consume(reader *kafka.Reader, groupID string, ch chan<- KafkaMessage) {
+	topic := reader.Config().Topic
+	for {
+		m, err := reader.FetchMessage(d.ctx)
+		if err != nil {
+			if d.ctx.Err() == nil {
+				GetLogger(d.ctx).Warn("failed to read kafka message", slog.String("topic", topic), slog.Any("error", err))
+			}
+			return
+		}
+		msg := KafkaMessage{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Key: string(m.Key), Value: string(m.Value), Time: m.Time}
+		if len(m.Headers) > 0 {
+			msg.Headers = make(map[string]string, len(m.Headers))
+			for _, h := range m.Headers {
+				msg.Headers[h.Key] = string(h.Value)
+			}
+		}
+		select {
+		case ch <- msg:
+		case <-d.ctx.Done():
+			return
+		}
+		if groupID != "" {
+			if err := reader.CommitMessages(d.ctx, m); err != nil && d.ctx.Err() == nil {
+				GetLogger(d.ctx).Warn("failed to commit kafka offset", slog.String("topic", topic), slog.String("group", groupID), slog.Any("error", err))
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package xtemplate
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// closedAddr returns the address of a tcp listener that was closed, so
+// connecting to it fails.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func TestKafkaInitDialsEachBroker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	down := closedAddr(t)
+	d := &DotKafkaConfig{Name: "Kafka", Brokers: []string{down, ln.Addr().String()}}
+	if err := d.Init(ctx); err != nil {
+		t.Fatalf("init failed with a reachable broker after an unreachable one: %v", err)
+	}
+
+	other := closedAddr(t)
+	d = &DotKafkaConfig{Name: "Kafka", Brokers: []string{down, other}}
+	err = d.Init(ctx)
+	if err == nil || !strings.Contains(err.Error(), down) || !strings.Contains(err.Error(), other) {
+		t.Fatalf("init error %v, want the errors of each unreachable broker", err)
+	}
+}
+
+func TestKafkaConfigValidation(t *testing.T) {
+	unknownSASL := &DotKafkaConfig{Name: "Kafka", Brokers: []string{"localhost:9092"}}
+	unknownSASL.SASL.Mechanism = "gssapi"
+	for _, d := range []*DotKafkaConfig{
+		{Name: "Kafka"},
+		{Name: "Kafka", Brokers: []string{"localhost:9092"}, StartOffset: "middle"},
+		unknownSASL,
+	} {
+		if err := d.Init(context.Background()); err == nil {
+			t.Errorf("kafka config %+v is valid, want an error", d)
+		}
+	}
+}
@@ -2,15 +2,97 @@ package xtemplate
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+// WithKV creates an [xtemplate.Option] that adds a NATS KV provider at the dot
+// field name that uses bucket with the configured nats client named nats.
+func WithKV(name, nats, bucket string) Option {
+	return func(c *Config) error {
+		c.KV = append(c.KV, DotKVConfig{Name: name, Nats: nats, Bucket: bucket})
+		return nil
+	}
+}
+
+// DotKVConfig configures a NATS JetStream KV bucket that templates can read,
+// write, and watch for changes.
+type DotKVConfig struct {
+	Name string `json:"name"`
+
+	// The name of a configured nats client with JetStream enabled.
+	Nats string `json:"nats"`
+
+	// The name of the bucket, which is created if it doesn't exist.
+	Bucket string `json:"bucket"`
+
+	// The number of past values to keep for each key, up to 64. Default `1`.
+	History uint8 `json:"history,omitempty"`
+
+	// How long to keep values. Default forever.
+	TTL Duration `json:"ttl,omitempty"`
+
+	nats *DotNatsConfig
+	kv   jetstream.KeyValue
+}
+
+var _ DotConfig = &DotKVConfig{}
+
+func (d *DotKVConfig) FieldName() string { return d.Name }
+func (d *DotKVConfig) Init(ctx context.Context) error {
+	if d.nats == nil {
+		return fmt.Errorf("kv nats '%s' is not a configured nats client", d.Nats)
+	}
+	if d.Bucket == "" {
+		return fmt.Errorf("kv bucket is required")
+	}
+	var err error
+	d.kv, err = d.nats.js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:  d.Bucket,
+		History: d.History,
+		TTL:     time.Duration(d.TTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create kv bucket '%s': %w", d.Bucket, err)
+	}
+	return nil
+}
+func (d *DotKVConfig) Value(r Request) (any, error) {
+	return &DotKV{d.kv, r.R.Context()}, nil
+}
+
+// DotKV is used as the dot field to use a NATS KV bucket, configured by
+// [DotKVConfig]. Keys are NATS subjects, so `Keys` and `Watch` accept
+// wildcards like `users.*` or `users.>`.
 type DotKV struct {
 	kv  jetstream.KeyValue
 	ctx context.Context
 }
 
+// KVEntry is a value of a key in a NATS KV bucket.
+type KVEntry struct {
+	Key      string
+	Value    string
+	Revision uint64
+	Created  time.Time
+	// `PUT`, `DEL`, or `PURGE`.
+	Operation string
+}
+
+func newKVEntry(e jetstream.KeyValueEntry) KVEntry {
+	op := "PUT"
+	switch e.Operation() {
+	case jetstream.KeyValueDelete:
+		op = "DEL"
+	case jetstream.KeyValuePurge:
+		op = "PURGE"
+	}
+	return KVEntry{Key: e.Key(), Value: string(e.Value()), Revision: e.Revision(), Created: e.Created(), Operation: op}
+}
+
 func (d *DotKV) Put(key, value string) error {
 	_, err := d.kv.PutString(d.ctx, key, value)
 	return err
@@ -32,6 +114,51 @@ func (d *DotKV) Purge(key string) error {
 	return d.kv.Purge(d.ctx, key)
 }
 
+// DeleteKey deletes key, keeping its history. Unlike Delete it returns an
+// empty string, so it can be called in a template action without printing.
+func (d *DotKV) DeleteKey(key string) (string, error) {
+	return "", d.kv.Delete(d.ctx, key)
+}
+
+// PurgeKey deletes key and its history. Unlike Purge it returns an empty
+// string, so it can be called in a template action without printing.
+func (d *DotKV) PurgeKey(key string) (string, error) {
+	return "", d.kv.Purge(d.ctx, key)
+}
+
+// Keys returns the keys in the bucket, or only the keys that match filter.
+func (d *DotKV) Keys(filter ...string) ([]string, error) {
+	watcher, err := d.kv.WatchFiltered(d.ctx, filter, jetstream.IgnoreDeletes(), jetstream.MetaOnly())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+	keys := []string{}
+	// a nil entry marks the end of the current values
+	for e := range watcher.Updates() {
+		if e == nil {
+			break
+		}
+		keys = append(keys, e.Key())
+	}
+	return keys, nil
+}
+
+// History returns the past values of key, oldest first.
+func (d *DotKV) History(key string) ([]KVEntry, error) {
+	history, err := d.kv.History(d.ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return []KVEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entries := make([]KVEntry, len(history))
+	for i, e := range history {
+		entries[i] = newKVEntry(e)
+	}
+	return entries, nil
+}
+
 func (d *DotKV) Watch(keys string) (<-chan jetstream.KeyValueEntry, error) {
 	// ctx unsubscribes the watcher on cancel
 	watcher, err := d.kv.Watch(d.ctx, keys, jetstream.UpdatesOnly())
@@ -40,3 +167,31 @@ func (d *DotKV) Watch(keys string) (<-chan jetstream.KeyValueEntry, error) {
 	}
 	return watcher.Updates(), nil
 }
+
+// WatchEntries returns a channel of the changes to the keys that match keys,
+// which is closed when the request ends.
+//
+//	{{define "SSE /settings"}}{{range .KV.WatchEntries "settings.>"}}{{$.Flush.SendSSE .Key .Value}}{{end}}{{end}}
+func (d *DotKV) WatchEntries(keys string) (<-chan KVEntry, error) {
+	// ctx unsubscribes the watcher on cancel, which closes its updates
+	watcher, err := d.kv.Watch(d.ctx, keys, jetstream.UpdatesOnly())
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan KVEntry)
+	go func() {
+		defer close(ch)
+		defer watcher.Stop()
+		for e := range watcher.Updates() {
+			if e == nil {
+				continue
+			}
+			select {
+			case ch <- newKVEntry(e):
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
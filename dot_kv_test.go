@@ -0,0 +1,155 @@
+package xtemplate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type fakeKVEntry struct {
+	key   string
+	value string
+	rev   uint64
+	op    jetstream.KeyValueOp
+}
+
+func (e fakeKVEntry) Bucket() string                  { return "test" }
+func (e fakeKVEntry) Key() string                     { return e.key }
+func (e fakeKVEntry) Value() []byte                   { return []byte(e.value) }
+func (e fakeKVEntry) Revision() uint64                { return e.rev }
+func (e fakeKVEntry) Created() time.Time              { return time.Time{} }
+func (e fakeKVEntry) Delta() uint64                   { return 0 }
+func (e fakeKVEntry) Operation() jetstream.KeyValueOp { return e.op }
+
+type fakeKVWatcher struct {
+	updates chan jetstream.KeyValueEntry
+	stopped chan struct{}
+}
+
+func (w *fakeKVWatcher) Updates() <-chan jetstream.KeyValueEntry { return w.updates }
+func (w *fakeKVWatcher) Stop() error                             { close(w.stopped); return nil }
+
+// fakeKV implements the parts of a KV bucket that DotKV uses. Other methods
+// panic on the nil embedded interface.
+type fakeKV struct {
+	jetstream.KeyValue
+	watcher *fakeKVWatcher
+	history map[string][]jetstream.KeyValueEntry
+	deleted []string
+	purged  []string
+}
+
+func (kv *fakeKV) Watch(ctx context.Context, keys string, opts ...jetstream.WatchOpt) (jetstream.KeyWatcher, error) {
+	// like a real watcher, updates are closed when ctx is cancelled
+	go func() {
+		<-ctx.Done()
+		close(kv.watcher.updates)
+	}()
+	return kv.watcher, nil
+}
+
+func (kv *fakeKV) WatchFiltered(ctx context.Context, keys []string, opts ...jetstream.WatchOpt) (jetstream.KeyWatcher, error) {
+	return kv.watcher, nil
+}
+
+func (kv *fakeKV) History(ctx context.Context, key string, opts ...jetstream.WatchOpt) ([]jetstream.KeyValueEntry, error) {
+	if h, ok := kv.history[key]; ok {
+		return h, nil
+	}
+	return nil, jetstream.ErrKeyNotFound
+}
+
+func (kv *fakeKV) Delete(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	kv.deleted = append(kv.deleted, key)
+	return nil
+}
+
+func (kv *fakeKV) Purge(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	kv.purged = append(kv.purged, key)
+	return nil
+}
+
+func newFakeKVWatcher() *fakeKVWatcher {
+	return &fakeKVWatcher{updates: make(chan jetstream.KeyValueEntry, 4), stopped: make(chan struct{})}
+}
+
+func TestKVWatchEntries(t *testing.T) {
+	kv := &fakeKV{watcher: newFakeKVWatcher()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := (&DotKV{kv, ctx}).WatchEntries("settings.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.watcher.updates <- fakeKVEntry{"settings.theme", "dark", 1, jetstream.KeyValuePut}
+	kv.watcher.updates <- nil
+	kv.watcher.updates <- fakeKVEntry{"settings.theme", "", 2, jetstream.KeyValueDelete}
+	for _, want := range []KVEntry{
+		{Key: "settings.theme", Value: "dark", Revision: 1, Operation: "PUT"},
+		{Key: "settings.theme", Revision: 2, Operation: "DEL"},
+	} {
+		if got := <-ch; got != want {
+			t.Fatalf("watched %+v, want %+v", got, want)
+		}
+	}
+
+	// ending the request stops the watcher and closes the channel
+	cancel()
+	select {
+	case <-kv.watcher.stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("watcher wasn't stopped after the request ended")
+	}
+	if _, ok := <-ch; ok {
+		t.Fatalf("channel is open after the request ended")
+	}
+}
+
+func TestKVKeys(t *testing.T) {
+	kv := &fakeKV{watcher: newFakeKVWatcher()}
+	kv.watcher.updates <- fakeKVEntry{key: "a"}
+	kv.watcher.updates <- fakeKVEntry{key: "b"}
+	kv.watcher.updates <- nil
+	keys, err := (&DotKV{kv, context.Background()}).Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("keys %q, want [a b]", keys)
+	}
+}
+
+func TestKVHistoryAndDelete(t *testing.T) {
+	kv := &fakeKV{history: map[string][]jetstream.KeyValueEntry{
+		"theme": {fakeKVEntry{"theme", "light", 1, jetstream.KeyValuePut}, fakeKVEntry{"theme", "", 2, jetstream.KeyValuePurge}},
+	}}
+	d := &DotKV{kv, context.Background()}
+	history, err := d.History("theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Value != "light" || history[1].Operation != "PURGE" {
+		t.Fatalf("history %+v", history)
+	}
+	if history, err := d.History("missing"); err != nil || len(history) != 0 {
+		t.Fatalf("history of a missing key %+v, %v, want none", history, err)
+	}
+
+	if err := d.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := d.DeleteKey("b"); s != "" || err != nil {
+		t.Fatalf("DeleteKey returned %q, %v", s, err)
+	}
+	if err := d.Purge("c"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := d.PurgeKey("d"); s != "" || err != nil {
+		t.Fatalf("PurgeKey returned %q, %v", s, err)
+	}
+	if len(kv.deleted) != 2 || kv.deleted[1] != "b" || len(kv.purged) != 2 || kv.purged[1] != "d" {
+		t.Fatalf("deleted %q and purged %q", kv.deleted, kv.purged)
+	}
+}
@@ -0,0 +1,472 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	texttemplate "text/template"
+
+	"github.com/google/uuid"
+)
+
+// WithMailer creates an [xtemplate.Option] that adds a mail provider at the dot
+// field name that sends email with config.
+func WithMailer(config DotMailConfig) Option {
+	return func(c *Config) error {
+		c.Mailers = append(c.Mailers, config)
+		return nil
+	}
+}
+
+// DotMailConfig configures an SMTP server that templates can send email
+// through.
+type DotMailConfig struct {
+	Name string `json:"name"`
+
+	// The host name of the SMTP server.
+	Host string `json:"host"`
+
+	// The port of the SMTP server. Default `587`.
+	Port int `json:"port,omitempty"`
+
+	// Credentials for PLAIN authentication. Authentication is skipped if
+	// Username is empty.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// How to secure the connection: `starttls` to upgrade the connection after
+	// connecting, `tls` to connect with TLS, or `none` for local development
+	// servers. Default `tls` if Port is `465`, otherwise `starttls`.
+	TLS string `json:"tls,omitempty"`
+
+	// The default From address, like `Example <noreply@example.com>`.
+	From string `json:"from,omitempty"`
+
+	// How long sending a message can take. Default `30s`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// The number of messages that can wait to be sent by Queue. Default `100`.
+	QueueSize int `json:"queue_size,omitempty"`
+
+	templates     *template.Template
+	textTemplates *texttemplate.Template
+	log           *slog.Logger
+	queue         *mailQueue
+}
+
+// mailQueue holds messages to be sent in the background.
+type mailQueue struct {
+	sync.Mutex
+	messages chan *mailMessage
+
+	// closed is set when the instance's context is cancelled, after which
+	// messages can't be queued
+	closed bool
+}
+
+var _ DotConfig = &DotMailConfig{}
+
+func (d *DotMailConfig) FieldName() string { return d.Name }
+func (d *DotMailConfig) Init(ctx context.Context) error {
+	if d.Host == "" {
+		return fmt.Errorf("mail host is required")
+	}
+	if d.Port == 0 {
+		d.Port = 587
+	}
+	if d.TLS == "" {
+		d.TLS = "starttls"
+		if d.Port == 465 {
+			d.TLS = "tls"
+		}
+	}
+	if d.TLS != "starttls" && d.TLS != "tls" && d.TLS != "none" {
+		return fmt.Errorf("mail tls must be 'starttls', 'tls', or 'none', got '%s'", d.TLS)
+	}
+	if d.From != "" {
+		if _, err := mail.ParseAddress(d.From); err != nil {
+			return fmt.Errorf("failed to parse mail from address '%s': %w", d.From, err)
+		}
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(30 * time.Second)
+	}
+	if d.QueueSize == 0 {
+		d.QueueSize = 100
+	}
+	if d.log == nil {
+		d.log = slog.Default()
+	}
+	d.log = d.log.With(slog.String("mailer", d.Name))
+	d.queue = &mailQueue{messages: make(chan *mailMessage, d.QueueSize)}
+	go d.sendQueued(ctx)
+	return nil
+}
+func (d *DotMailConfig) Value(r Request) (any, error) {
+	return DotMail{d, r.R.Context()}, nil
+}
+
+// sendQueued sends queued messages until ctx is cancelled, then sends the
+// messages that are still queued so they aren't lost when the instance is
+// reloaded.
+func (d *DotMailConfig) sendQueued(ctx context.Context) {
+	send := func(m *mailMessage) {
+		if err := d.send(context.Background(), m); err != nil {
+			d.log.Error("failed to send queued mail", slog.Any("to", m.recipients), slog.String("subject", m.subject), slog.Any("error", err))
+		} else {
+			d.log.Debug("sent queued mail", slog.Any("to", m.recipients), slog.String("subject", m.subject))
+		}
+	}
+	for {
+		select {
+		case m := <-d.queue.messages:
+			send(m)
+		case <-ctx.Done():
+			d.queue.Lock()
+			d.queue.closed = true
+			d.queue.Unlock()
+			for {
+				select {
+				case m := <-d.queue.messages:
+					send(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// DotMail is used as the dot field to send email, configured by
+// [DotMailConfig]. Messages are described by a dict with these keys:
+//
+//   - `to`, `cc`, `bcc`: an address or a list of addresses
+//   - `from`: the sender address, default the configured From
+//   - `reply_to`: an address replies are sent to
+//   - `subject`: the subject line
+//   - `template`: the name of a template to render the body with `data` as
+//     dot. An html template is sent as the html part and its text content as
+//     the plain text part. A text template is sent as the plain text part.
+//   - `data`: the dot value of `template`
+//   - `html`, `text`: the html and plain text parts, instead of `template`
+//
+// Example:
+//
+//	{{.Mail.Send (dict "to" $email "subject" "Welcome!" "template" "/emails/welcome.html" "data" $user)}}
+type DotMail struct {
+	config *DotMailConfig
+	ctx    context.Context
+}
+
+// Send renders and sends the message and waits until the server accepts it.
+// It returns an empty string.
+func (d DotMail) Send(message map[string]any) (string, error) {
+	m, err := d.config.render(message)
+	if err != nil {
+		return "", err
+	}
+	return "", d.config.send(d.ctx, m)
+}
+
+// Queue renders the message and queues it to be sent in the background, so
+// the response doesn't wait on the mail server. Errors sending queued messages
+// are logged. It returns an error if the queue is full or the instance was
+// replaced by a reload. It returns an empty string.
+func (d DotMail) Queue(message map[string]any) (string, error) {
+	m, err := d.config.render(message)
+	if err != nil {
+		return "", err
+	}
+	d.config.queue.Lock()
+	defer d.config.queue.Unlock()
+	if d.config.queue.closed {
+		return "", fmt.Errorf("mail queue is closed")
+	}
+	select {
+	case d.config.queue.messages <- m:
+		return "", nil
+	default:
+		return "", fmt.Errorf("mail queue is full")
+	}
+}
+
+// mailMessage is a rendered message ready to be sent.
+type mailMessage struct {
+	from       string
+	recipients []string
+	subject    string
+	data       []byte
+}
+
+var mailKeys = map[string]bool{"to": true, "cc": true, "bcc": true, "from": true, "reply_to": true, "subject": true, "template": true, "data": true, "html": true, "text": true}
+
+func (d *DotMailConfig) render(message map[string]any) (*mailMessage, error) {
+	for key := range message {
+		if !mailKeys[key] {
+			return nil, fmt.Errorf("unknown mail message key '%s'", key)
+		}
+	}
+	str := func(key string) (string, error) {
+		switch v := message[key].(type) {
+		case nil:
+			return "", nil
+		case string:
+			return v, nil
+		case template.HTML:
+			return string(v), nil
+		default:
+			return "", fmt.Errorf("mail message '%s' must be a string, got %T", key, v)
+		}
+	}
+
+	m := &mailMessage{}
+	header := textproto.MIMEHeader{}
+
+	from, err := str("from")
+	if err != nil {
+		return nil, err
+	}
+	if from == "" {
+		from = d.From
+	}
+	if from == "" {
+		return nil, fmt.Errorf("mail message has no from address and no default is configured")
+	}
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mail from address '%s': %w", from, err)
+	}
+	m.from = fromAddr.Address
+	header.Set("From", fromAddr.String())
+
+	for _, key := range []string{"to", "cc", "bcc", "reply_to"} {
+		addrs, err := mailAddresses(key, message[key])
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		if key != "reply_to" {
+			for _, a := range addrs {
+				m.recipients = append(m.recipients, a.Address)
+			}
+		}
+		if key == "bcc" {
+			continue
+		}
+		formatted := make([]string, len(addrs))
+		for i, a := range addrs {
+			formatted[i] = a.String()
+		}
+		header.Set(map[string]string{"to": "To", "cc": "Cc", "reply_to": "Reply-To"}[key], strings.Join(formatted, ", "))
+	}
+	if len(m.recipients) == 0 {
+		return nil, fmt.Errorf("mail message has no recipients")
+	}
+
+	if m.subject, err = str("subject"); err != nil {
+		return nil, err
+	}
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	domain := fromAddr.Address[strings.LastIndexByte(fromAddr.Address, '@')+1:]
+	header.Set("Message-ID", "<"+uuid.NewString()+"@"+domain+">")
+	header.Set("MIME-Version", "1.0")
+
+	htmlBody, err := str("html")
+	if err != nil {
+		return nil, err
+	}
+	textBody, err := str("text")
+	if err != nil {
+		return nil, err
+	}
+	if name, err := str("template"); err != nil {
+		return nil, err
+	} else if name != "" {
+		if htmlBody != "" || textBody != "" {
+			return nil, fmt.Errorf("mail message can have a template or html and text parts, not both")
+		}
+		if htmlBody, textBody, err = d.renderTemplate(name, message["data"]); err != nil {
+			return nil, err
+		}
+	} else if htmlBody != "" && textBody == "" {
+		if textBody, err = htmlText(htmlBody); err != nil {
+			return nil, err
+		}
+	}
+	if htmlBody == "" && textBody == "" {
+		return nil, fmt.Errorf("mail message has no body")
+	}
+
+	var body bytes.Buffer
+	switch {
+	case htmlBody == "":
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writeQuotedPrintable(&body, textBody)
+	default:
+		w := multipart.NewWriter(&body)
+		header.Set("Content-Type", "multipart/alternative; boundary="+w.Boundary())
+		for _, part := range []struct{ contentType, content string }{
+			{"text/plain; charset=utf-8", textBody},
+			{"text/html; charset=utf-8", htmlBody},
+		} {
+			pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}, "Content-Transfer-Encoding": {"quoted-printable"}})
+			if err != nil {
+				return nil, err
+			}
+			writeQuotedPrintable(pw, part.content)
+		}
+		w.Close()
+	}
+
+	var data bytes.Buffer
+	for _, key := range []string{"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"} {
+		if v := header.Get(key); v != "" {
+			fmt.Fprintf(&data, "%s: %s\r\n", key, v)
+		}
+	}
+	data.WriteString("\r\n")
+	data.Write(body.Bytes())
+	m.data = data.Bytes()
+	return m, nil
+}
+
+// renderTemplate executes the html or text template name with dot and returns
+// the html and plain text parts of the message.
+func (d *DotMailConfig) renderTemplate(name string, dot any) (htmlBody, textBody string, err error) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	if t := d.templates.Lookup(name); t != nil {
+		if err := t.Execute(buf, dot); err != nil {
+			return "", "", fmt.Errorf("failed to execute mail template '%s': %w", name, err)
+		}
+		htmlBody = buf.String()
+		textBody, err = htmlText(htmlBody)
+		return htmlBody, textBody, err
+	}
+	if t := d.textTemplates.Lookup(name); t != nil {
+		if err := t.Execute(buf, dot); err != nil {
+			return "", "", fmt.Errorf("failed to execute mail template '%s': %w", name, err)
+		}
+		return "", buf.String(), nil
+	}
+	return "", "", fmt.Errorf("failed to lookup mail template '%s'", name)
+}
+
+// mailAddresses parses a string or a list of address strings.
+func mailAddresses(key string, value any) ([]*mail.Address, error) {
+	var list []string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		list = []string{v}
+	case []string:
+		list = v
+	case []any:
+		for _, a := range v {
+			s, ok := a.(string)
+			if !ok {
+				return nil, fmt.Errorf("mail message '%s' must be a list of strings, got %T in list", key, a)
+			}
+			list = append(list, s)
+		}
+	default:
+		return nil, fmt.Errorf("mail message '%s' must be a string or a list of strings, got %T", key, value)
+	}
+	var addrs []*mail.Address
+	for _, s := range list {
+		parsed, err := mail.ParseAddressList(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse mail address '%s': %w", s, err)
+		}
+		addrs = append(addrs, parsed...)
+	}
+	return addrs, nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")))
+	qp.Close()
+}
+
+// send delivers m to the SMTP server.
+func (d *DotMailConfig) send(ctx context.Context, m *mailMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(d.Timeout))
+	defer cancel()
+
+	addr := net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if d.TLS == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: d.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail server '%s': %w", addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, d.Host)
+	if err != nil {
+		return fmt.Errorf("failed to start smtp session with '%s': %w", addr, err)
+	}
+	defer c.Close()
+	if d.TLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("mail server '%s' doesn't support STARTTLS", addr)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: d.Host}); err != nil {
+			return fmt.Errorf("failed to start tls with mail server '%s': %w", addr, err)
+		}
+	}
+	if d.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", d.Username, d.Password, d.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate with mail server '%s': %w", addr, err)
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return fmt.Errorf("mail server rejected sender '%s': %w", m.from, err)
+	}
+	for _, rcpt := range m.recipients {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("mail server rejected recipient '%s': %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if _, err := w.Write(m.data); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mail server rejected message: %w", err)
+	}
+	return c.Quit()
+}
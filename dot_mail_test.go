@@ -0,0 +1,170 @@
+package xtemplate
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+	"time"
+)
+
+func newTestMailer(t *testing.T) *DotMailConfig {
+	t.Helper()
+	d := &DotMailConfig{Name: "Mail", From: "Example <noreply@example.com>"}
+	d.templates = template.Must(template.New(".").Parse(`{{define "/emails/welcome.html"}}<p>Hi {{.}} &amp; welcome</p>{{end}}`))
+	d.textTemplates = texttemplate.Must(texttemplate.New(".").Parse(`{{define "/emails/welcome.txt"}}Hi {{.}} & welcome{{end}}`))
+	return d
+}
+
+// readMailParts parses a rendered message and returns its header and the
+// decoded content of each part by content type.
+func readMailParts(t *testing.T, data []byte) (mail.Header, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/alternative" {
+		body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[mediaType] = string(body)
+		return msg.Header, parts
+	}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		// multipart decodes quoted-printable parts itself
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	return msg.Header, parts
+}
+
+func TestMailRender(t *testing.T) {
+	d := newTestMailer(t)
+	m, err := d.render(map[string]any{
+		"to":      []any{"Alice <alice@example.com>", "bob@example.com"},
+		"cc":      "carol@example.com",
+		"bcc":     "dave@example.com",
+		"subject": "Grüße",
+		"html":    template.HTML("<p>Hello <b>world</b></p>"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.from != "noreply@example.com" {
+		t.Errorf("envelope from %q", m.from)
+	}
+	if got := strings.Join(m.recipients, " "); got != "alice@example.com bob@example.com carol@example.com dave@example.com" {
+		t.Errorf("recipients %q", got)
+	}
+	if strings.Contains(string(m.data), "dave@") {
+		t.Errorf("bcc recipient is in the message:\n%s", m.data)
+	}
+
+	header, parts := readMailParts(t, m.data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || subject != "Grüße" {
+		t.Errorf("subject %q, %v", subject, err)
+	}
+	if header.Get("To") != `"Alice" <alice@example.com>, <bob@example.com>` || header.Get("Cc") != "<carol@example.com>" {
+		t.Errorf("to %q cc %q", header.Get("To"), header.Get("Cc"))
+	}
+	if !strings.HasSuffix(header.Get("Message-ID"), "@example.com>") || header.Get("Date") == "" {
+		t.Errorf("message id %q date %q", header.Get("Message-ID"), header.Get("Date"))
+	}
+	if parts["text/html"] != "<p>Hello <b>world</b></p>" || !strings.Contains(parts["text/plain"], "Hello world") {
+		t.Errorf("parts %q", parts)
+	}
+}
+
+func TestMailRenderTemplates(t *testing.T) {
+	d := newTestMailer(t)
+
+	m, err := d.render(map[string]any{"to": "alice@example.com", "subject": "Welcome", "template": "/emails/welcome.html", "data": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, parts := readMailParts(t, m.data)
+	if parts["text/html"] != "<p>Hi Alice &amp; welcome</p>" || !strings.Contains(parts["text/plain"], "Hi Alice & welcome") {
+		t.Errorf("html template parts %q", parts)
+	}
+
+	m, err = d.render(map[string]any{"to": "alice@example.com", "subject": "Welcome", "template": "/emails/welcome.txt", "data": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, parts = readMailParts(t, m.data)
+	if len(parts) != 1 || parts["text/plain"] != "Hi Alice & welcome" {
+		t.Errorf("text template parts %q", parts)
+	}
+}
+
+func TestMailRenderErrors(t *testing.T) {
+	d := newTestMailer(t)
+	for _, message := range []map[string]any{
+		{"to": "alice@example.com", "subject": "hi", "text": "hi", "attachment": "x"},
+		{"subject": "hi", "text": "hi"},
+		{"to": "alice@example.com", "subject": "hi"},
+		{"to": "not an address", "subject": "hi", "text": "hi"},
+		{"to": "alice@example.com", "subject": 1, "text": "hi"},
+		{"to": "alice@example.com", "subject": "hi", "text": "hi", "template": "/emails/welcome.html"},
+		{"to": "alice@example.com", "subject": "hi", "template": "/emails/missing.html"},
+	} {
+		if _, err := d.render(message); err == nil {
+			t.Errorf("rendered %v, want an error", message)
+		}
+	}
+
+	d.From = ""
+	if _, err := d.render(map[string]any{"to": "alice@example.com", "subject": "hi", "text": "hi"}); err == nil {
+		t.Errorf("rendered a message without a from address")
+	}
+}
+
+func TestMailQueueClosed(t *testing.T) {
+	d := newTestMailer(t)
+	d.Host = "localhost"
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := d.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	closed := func() bool {
+		d.queue.Lock()
+		defer d.queue.Unlock()
+		return d.queue.closed
+	}
+	for deadline := time.Now().Add(time.Second); !closed(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue wasn't closed after the instance's context was cancelled")
+		}
+	}
+
+	_, err := DotMail{d, context.Background()}.Queue(map[string]any{"to": "alice@example.com", "subject": "hi", "text": "hi"})
+	if err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("queued a message after the queue was closed: %v", err)
+	}
+}
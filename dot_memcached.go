@@ -0,0 +1,170 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// WithMemcached creates an [xtemplate.Option] that adds a memcached cache
+// provider at the dot field name that uses servers.
+func WithMemcached(name string, servers ...string) Option {
+	return func(c *Config) error {
+		c.Memcached = append(c.Memcached, DotMemcachedConfig{Name: name, Servers: servers})
+		return nil
+	}
+}
+
+// DotMemcachedConfig configures a cache stored in memcached that is shared by
+// all requests to an instance and by every server that uses the same
+// memcached servers.
+type DotMemcachedConfig struct {
+	Name string `json:"name"`
+
+	// The addresses of the memcached servers, like `localhost:11211`. Keys are
+	// distributed among the servers.
+	Servers []string `json:"servers"`
+
+	// A prefix added to every key, so several sites can share servers.
+	Prefix string `json:"prefix,omitempty"`
+
+	// How long to wait for a server to respond. Default `500ms`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	client    *memcache.Client
+	templates *template.Template
+}
+
+var _ DotConfig = &DotMemcachedConfig{}
+
+func (d *DotMemcachedConfig) FieldName() string { return d.Name }
+func (d *DotMemcachedConfig) Init(ctx context.Context) error {
+	if len(d.Servers) == 0 {
+		return fmt.Errorf("memcached servers are required")
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(500 * time.Millisecond)
+	}
+	client := memcache.New(d.Servers...)
+	client.Timeout = time.Duration(d.Timeout)
+	if err := client.Ping(); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to ping memcached on open: %w", err)
+	}
+	// close the client when the instance is cancelled
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			client.Close()
+		}()
+	}
+	d.client = client
+	return nil
+}
+func (d *DotMemcachedConfig) Value(r Request) (any, error) {
+	return DotMemcached{d, r.R.Context()}, nil
+}
+
+// DotMemcached is used as the dot field to cache values across requests and
+// servers in memcached, configured by [DotMemcachedConfig]. It has the same
+// methods as [DotCache], but values are encoded as JSON so they must be
+// strings, numbers, bools, lists, or maps, and Get can fail. TTLs can be a
+// duration string like `5m`, a [time.Duration], or a number of seconds.
+//
+//	{{.Memcached.GetOrCompute (print "nav:" .Req.URL.Path) "10m" "nav" .}}
+type DotMemcached struct {
+	config *DotMemcachedConfig
+	ctx    context.Context
+}
+
+// memcachedHTML is the flag of items that hold rendered template output, so
+// it isn't escaped again when it's used.
+const memcachedHTML = 1
+
+// Get returns the value stored at key, or nil if it is missing or expired.
+func (d DotMemcached) Get(key string) (any, error) {
+	item, err := d.config.client.Get(d.config.Prefix + key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memcached key '%s': %w", key, err)
+	}
+	if item.Flags == memcachedHTML {
+		return template.HTML(item.Value), nil
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(item.Value))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode memcached key '%s': %w", key, err)
+	}
+	return v, nil
+}
+
+// Set stores value at key until ttl expires. It returns an empty string.
+func (d DotMemcached) Set(key string, value any, ttl any) (string, error) {
+	dur, err := parseTTL(ttl)
+	if err != nil {
+		return "", err
+	}
+	item := &memcache.Item{Key: d.config.Prefix + key, Expiration: memcachedExpiration(dur)}
+	if html, ok := value.(template.HTML); ok {
+		item.Value, item.Flags = []byte(html), memcachedHTML
+	} else if item.Value, err = json.Marshal(value); err != nil {
+		return "", fmt.Errorf("failed to encode memcached key '%s': %w", key, err)
+	}
+	if err := d.config.client.Set(item); err != nil {
+		return "", fmt.Errorf("failed to set memcached key '%s': %w", key, err)
+	}
+	return "", nil
+}
+
+// Delete removes the value stored at key. It returns an empty string.
+func (d DotMemcached) Delete(key string) (string, error) {
+	err := d.config.client.Delete(d.config.Prefix + key)
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return "", fmt.Errorf("failed to delete memcached key '%s': %w", key, err)
+	}
+	return "", nil
+}
+
+// GetOrCompute returns the value stored at key, or invokes the template name
+// with dot, stores its output at key until ttl expires, and returns it.
+func (d DotMemcached) GetOrCompute(key string, ttl any, name string, dot any) (any, error) {
+	if v, err := d.Get(key); err != nil || v != nil {
+		return v, err
+	}
+	t := d.config.templates.Lookup(name)
+	if t == nil {
+		return nil, fmt.Errorf("failed to lookup template name: '%s'", name)
+	}
+	defer startSpan(d.ctx, "compute "+key)()
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	if err := t.Execute(buf, dot); err != nil {
+		return nil, fmt.Errorf("failed to execute template '%s': %w", name, err)
+	}
+	result := template.HTML(buf.String())
+	if _, err := d.Set(key, result, ttl); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// memcachedExpiration converts ttl to whole seconds, or to a unix timestamp
+// if it's longer than memcached's limit of 30 days for relative times.
+func memcachedExpiration(ttl time.Duration) int32 {
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if secs > 30*24*60*60 {
+		return int32(time.Now().Add(ttl).Unix())
+	}
+	return int32(secs)
+}
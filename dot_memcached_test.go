@@ -0,0 +1,204 @@
+package xtemplate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMemcachedItem struct {
+	flags, exptime int
+	value          []byte
+}
+
+// fakeMemcached serves the parts of the memcached text protocol that the
+// client uses on a local port.
+type fakeMemcached struct {
+	addr  string
+	mu    sync.Mutex
+	items map[string]fakeMemcachedItem
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	m := &fakeMemcached{addr: l.Addr().String(), items: map[string]fakeMemcachedItem{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			return
+		}
+		m.mu.Lock()
+		switch args[0] {
+		case "version":
+			fmt.Fprintf(rw, "VERSION 1.6.0\r\n")
+		case "gets":
+			for _, key := range args[1:] {
+				if item, ok := m.items[key]; ok {
+					fmt.Fprintf(rw, "VALUE %s %d %d 1\r\n%s\r\n", key, item.flags, len(item.value), item.value)
+				}
+			}
+			fmt.Fprintf(rw, "END\r\n")
+		case "set":
+			flags, _ := strconv.Atoi(args[2])
+			exptime, _ := strconv.Atoi(args[3])
+			size, _ := strconv.Atoi(args[4])
+			value := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, value); err != nil {
+				m.mu.Unlock()
+				return
+			}
+			m.items[args[1]] = fakeMemcachedItem{flags, exptime, value[:size]}
+			fmt.Fprintf(rw, "STORED\r\n")
+		case "delete":
+			if _, ok := m.items[args[1]]; ok {
+				delete(m.items, args[1])
+				fmt.Fprintf(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprintf(rw, "NOT_FOUND\r\n")
+			}
+		default:
+			fmt.Fprintf(rw, "ERROR\r\n")
+		}
+		m.mu.Unlock()
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (m *fakeMemcached) item(key string) (fakeMemcachedItem, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[key]
+	return item, ok
+}
+
+func newTestMemcached(t *testing.T) (DotMemcached, *fakeMemcached) {
+	t.Helper()
+	server := newFakeMemcached(t)
+	d := &DotMemcachedConfig{Name: "Memcached", Servers: []string{server.addr}, Prefix: "site:"}
+	d.templates = template.Must(template.New("nav").Parse(`<nav>{{.}}</nav>`))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := d.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return DotMemcached{d, context.Background()}, server
+}
+
+func TestMemcachedGetSet(t *testing.T) {
+	m, server := newTestMemcached(t)
+	if v, err := m.Get("missing"); v != nil || err != nil {
+		t.Fatalf("got %v, %v for a missing key, want nil", v, err)
+	}
+	if _, err := m.Set("user", map[string]any{"name": "alice", "age": 30}, "5m"); err != nil {
+		t.Fatal(err)
+	}
+	item, ok := server.item("site:user")
+	if !ok || item.exptime != 300 || item.flags != 0 {
+		t.Fatalf("stored %+v, %v, want the prefixed key with a 300s expiration", item, ok)
+	}
+	v, err := m.Get("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, ok := v.(map[string]any)
+	if !ok || user["name"] != "alice" || user["age"] != json.Number("30") {
+		t.Fatalf("got %#v", v)
+	}
+
+	if _, err := m.Set("html", template.HTML("<b>hi</b>"), 60); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := m.Get("html"); v != template.HTML("<b>hi</b>") || err != nil {
+		t.Fatalf("got %#v, %v, want the html unescaped", v, err)
+	}
+
+	if _, err := m.Delete("user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Delete("user"); err != nil {
+		t.Fatalf("deleting a missing key failed: %v", err)
+	}
+	if v, _ := m.Get("user"); v != nil {
+		t.Fatalf("got %v after deleting", v)
+	}
+	if _, err := m.Set("bad", func() {}, "1m"); err == nil {
+		t.Fatalf("stored a value that can't be encoded")
+	}
+}
+
+func TestMemcachedGetOrCompute(t *testing.T) {
+	m, server := newTestMemcached(t)
+	v, err := m.GetOrCompute("nav", "10m", "nav", "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != template.HTML("<nav>home</nav>") {
+		t.Fatalf("computed %#v", v)
+	}
+	// the stored value is used instead of computing it again
+	if v, err := m.GetOrCompute("nav", "10m", "nav", "other"); v != template.HTML("<nav>home</nav>") || err != nil {
+		t.Fatalf("got %#v, %v, want the stored value", v, err)
+	}
+	if item, _ := server.item("site:nav"); item.flags != memcachedHTML {
+		t.Fatalf("stored flags %d, want the html flag", item.flags)
+	}
+	if _, err := m.GetOrCompute("other", "10m", "missing", nil); err == nil {
+		t.Fatalf("computed a missing template")
+	}
+}
+
+func TestMemcachedExpiration(t *testing.T) {
+	if got := memcachedExpiration(1500 * time.Millisecond); got != 2 {
+		t.Errorf("expiration of 1.5s is %d, want 2", got)
+	}
+	if got := memcachedExpiration(30 * 24 * time.Hour); got != 30*24*60*60 {
+		t.Errorf("expiration of 30 days is %d, want relative seconds", got)
+	}
+	if got, want := memcachedExpiration(31*24*time.Hour), time.Now().Add(31*24*time.Hour).Unix(); int64(got) < want-1 || int64(got) > want+1 {
+		t.Errorf("expiration of 31 days is %d, want the unix time %d", got, want)
+	}
+}
+
+func TestMemcachedInitFails(t *testing.T) {
+	if err := (&DotMemcachedConfig{}).Init(context.Background()); err == nil {
+		t.Errorf("initialized without servers")
+	}
+	if err := (&DotMemcachedConfig{Servers: []string{closedAddr(t)}}).Init(context.Background()); err == nil {
+		t.Errorf("initialized with a server that isn't running")
+	}
+}
@@ -0,0 +1,93 @@
+package xtemplate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type dotMetricsProvider struct {
+	config  *MetricsConfig
+	metrics *metricsRegistry
+}
+
+func (p dotMetricsProvider) FieldName() string          { return p.config.Name }
+func (dotMetricsProvider) Init(_ context.Context) error { return nil }
+func (p dotMetricsProvider) Value(_ Request) (any, error) {
+	return DotMetrics{p.config, p.metrics}, nil
+}
+
+// DotMetrics is used as the dot field to record metrics that are served at
+// the metrics endpoint alongside the server's metrics, configured by
+// [MetricsConfig]. A metric is created the first time it's recorded, and must
+// be recorded with the same method and label names each time. Each method
+// takes an optional map of labels and returns an empty string. A metric can
+// have at most MaxSeries combinations of label values, so prefer values from a
+// fixed set over user input.
+//
+//	{{.Metrics.Inc "signups_total" (dict "plan" $account.Plan)}}
+//	{{.Metrics.Observe "checkout_seconds" $elapsed}}
+type DotMetrics struct {
+	config  *MetricsConfig
+	metrics *metricsRegistry
+}
+
+// Inc adds one to the counter name.
+func (d DotMetrics) Inc(name string, labels ...map[string]any) (string, error) {
+	return d.Add(name, 1, labels...)
+}
+
+// Add adds value, which must not be negative, to the counter name.
+func (d DotMetrics) Add(name string, value any, labels ...map[string]any) (string, error) {
+	v, err := metricsValue(name, value)
+	if err != nil {
+		return "", err
+	}
+	if v < 0 {
+		return "", fmt.Errorf("cannot add negative value %v to counter '%s'", v, name)
+	}
+	vec, l, err := d.metrics.vec("counter", name, nil, d.config.MaxSeries, labels)
+	if err != nil {
+		return "", err
+	}
+	vec.(*prometheus.CounterVec).With(l).Add(v)
+	return "", nil
+}
+
+// Set sets the gauge name to value.
+func (d DotMetrics) Set(name string, value any, labels ...map[string]any) (string, error) {
+	v, err := metricsValue(name, value)
+	if err != nil {
+		return "", err
+	}
+	vec, l, err := d.metrics.vec("gauge", name, nil, d.config.MaxSeries, labels)
+	if err != nil {
+		return "", err
+	}
+	vec.(*prometheus.GaugeVec).With(l).Set(v)
+	return "", nil
+}
+
+// Observe records value in the histogram name, which has the configured
+// buckets.
+func (d DotMetrics) Observe(name string, value any, labels ...map[string]any) (string, error) {
+	v, err := metricsValue(name, value)
+	if err != nil {
+		return "", err
+	}
+	vec, l, err := d.metrics.vec("histogram", name, d.config.Buckets, d.config.MaxSeries, labels)
+	if err != nil {
+		return "", err
+	}
+	vec.(*prometheus.HistogramVec).With(l).Observe(v)
+	return "", nil
+}
+
+func metricsValue(name string, value any) (float64, error) {
+	v, ok := toNumber(value)
+	if !ok {
+		return 0, fmt.Errorf("value of metric '%s' must be a number, got %T", name, value)
+	}
+	return v, nil
+}
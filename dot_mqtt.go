@@ -0,0 +1,303 @@
+package xtemplate
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+// WithMQTT creates an [xtemplate.Option] that adds an MQTT provider at the dot
+// field name that uses client, which must already be connected.
+func WithMQTT(name string, client mqtt.Client) Option {
+	return func(c *Config) error {
+		if client == nil {
+			return fmt.Errorf("cannot create mqtt provider with nil mqtt.Client. name: %s", name)
+		}
+		c.MQTT = append(c.MQTT, DotMQTTConfig{Name: name, Client: client})
+		return nil
+	}
+}
+
+// DotMQTTConfig configures an MQTT client that is shared by all requests to an
+// instance.
+type DotMQTTConfig struct {
+	mqtt.Client `json:"-"`
+
+	Name string `json:"name"`
+
+	// The urls of the brokers, like `tcp://localhost:1883`, `ssl://` to connect
+	// with TLS, or `ws://` to connect over websockets.
+	Brokers []string `json:"brokers"`
+
+	// The client id. Default a random id.
+	ClientID string `json:"client_id,omitempty"`
+
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// The quality of service of publishes and subscriptions: 0, 1, or 2.
+	// Default 0.
+	QoS byte `json:"qos,omitempty"`
+
+	// How long to wait to connect, publish, or subscribe. Default `10s`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	subs *mqttSubscriptions
+}
+
+// mqttSubscriptions are the subscribers of each topic filter, since the
+// client only calls one handler per topic filter.
+type mqttSubscriptions struct {
+	sync.Mutex
+	topics map[string]map[*mqttSubscriber]struct{}
+}
+
+var _ DotConfig = &DotMQTTConfig{}
+
+func (d *DotMQTTConfig) FieldName() string { return d.Name }
+func (d *DotMQTTConfig) Init(ctx context.Context) error {
+	if d.QoS > 2 {
+		return fmt.Errorf("mqtt qos must be 0, 1, or 2, got %d", d.QoS)
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(10 * time.Second)
+	}
+	d.subs = &mqttSubscriptions{topics: map[string]map[*mqttSubscriber]struct{}{}}
+	if d.Client != nil {
+		return nil
+	}
+	if len(d.Brokers) == 0 {
+		return fmt.Errorf("mqtt brokers are required")
+	}
+	opts := mqtt.NewClientOptions()
+	for _, broker := range d.Brokers {
+		opts.AddBroker(broker)
+	}
+	if d.ClientID == "" {
+		d.ClientID = "xtemplate-" + uuid.NewString()
+	}
+	opts.SetClientID(d.ClientID)
+	opts.SetUsername(d.Username)
+	opts.SetPassword(d.Password)
+	opts.SetConnectTimeout(time.Duration(d.Timeout))
+	opts.SetAutoReconnect(true)
+	// subscriptions are lost when the client reconnects with a clean session
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		d.subs.Lock()
+		defer d.subs.Unlock()
+		for topic := range d.subs.topics {
+			client.Subscribe(topic, d.QoS, d.handle)
+		}
+	})
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(time.Duration(d.Timeout)) {
+		return fmt.Errorf("timed out connecting to mqtt brokers %v", d.Brokers)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to mqtt brokers %v: %w", d.Brokers, err)
+	}
+	// disconnect the client when the instance is cancelled
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			client.Disconnect(250)
+		}()
+	}
+	d.Client = client
+	return nil
+}
+func (d *DotMQTTConfig) Value(r Request) (any, error) {
+	return &DotMQTT{d, r.R.Context()}, nil
+}
+
+// mqttSubscriber receives the messages of the topics it's subscribed to.
+type mqttSubscriber struct {
+	topics []string
+	msgs   chan MQTTMessage
+}
+
+// handle delivers a message to the subscribers of the topic filter it
+// matched. Messages are dropped for subscribers that are too far behind so a
+// slow template doesn't hold up the client.
+func (d *DotMQTTConfig) handle(_ mqtt.Client, msg mqtt.Message) {
+	m := MQTTMessage{Topic: msg.Topic(), Payload: string(msg.Payload()), Retained: msg.Retained()}
+	d.subs.Lock()
+	defer d.subs.Unlock()
+	delivered := map[*mqttSubscriber]bool{}
+	for topic, subs := range d.subs.topics {
+		if !mqttMatch(topic, m.Topic) {
+			continue
+		}
+		for s := range subs {
+			if delivered[s] {
+				continue
+			}
+			delivered[s] = true
+			select {
+			case s.msgs <- m:
+			default:
+			}
+		}
+	}
+}
+
+// mqttMatch reports whether topic matches filter, which can contain the
+// wildcards `+` for one level and `#` for any number of levels.
+func mqttMatch(filter, topic string) bool {
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	// wildcards don't match topics like `$SYS` that are reserved by the broker
+	if strings.HasPrefix(topic, "$") && (f[0] == "+" || f[0] == "#") {
+		return false
+	}
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func (d *DotMQTTConfig) subscribe(s *mqttSubscriber) error {
+	d.subs.Lock()
+	var added []string
+	for _, topic := range s.topics {
+		if d.subs.topics[topic] == nil {
+			d.subs.topics[topic] = map[*mqttSubscriber]struct{}{}
+			added = append(added, topic)
+		}
+		d.subs.topics[topic][s] = struct{}{}
+	}
+	d.subs.Unlock()
+	if len(added) == 0 {
+		return nil
+	}
+	filters := make(map[string]byte, len(added))
+	for _, topic := range added {
+		filters[topic] = d.QoS
+	}
+	token := d.Client.SubscribeMultiple(filters, d.handle)
+	if !token.WaitTimeout(time.Duration(d.Timeout)) {
+		d.unsubscribe(s)
+		return fmt.Errorf("timed out subscribing to mqtt topics %v", added)
+	}
+	if err := token.Error(); err != nil {
+		d.unsubscribe(s)
+		return fmt.Errorf("failed to subscribe to mqtt topics %v: %w", added, err)
+	}
+	return nil
+}
+
+func (d *DotMQTTConfig) unsubscribe(s *mqttSubscriber) {
+	d.subs.Lock()
+	var removed []string
+	for _, topic := range s.topics {
+		delete(d.subs.topics[topic], s)
+		if len(d.subs.topics[topic]) == 0 {
+			delete(d.subs.topics, topic)
+			removed = append(removed, topic)
+		}
+	}
+	d.subs.Unlock()
+	if len(removed) > 0 && d.Client.IsConnected() {
+		d.Client.Unsubscribe(removed...)
+	}
+}
+
+// DotMQTT is used as the dot field to publish and subscribe to topics on MQTT
+// brokers, configured by [DotMQTTConfig].
+//
+//	{{range .MQTT.Subscribe "sensors/+/temperature"}}
+//	{{$.Flush.SendSSE "reading" .Payload}}
+//	{{end}}
+type DotMQTT struct {
+	config *DotMQTTConfig
+	ctx    context.Context
+}
+
+// MQTTMessage is a message received by [DotMQTT.Subscribe].
+type MQTTMessage struct {
+	Topic    string
+	Payload  string
+	Retained bool
+}
+
+// Publish publishes payload, a string or bytes, to topic and waits until the
+// broker acknowledges it if qos is greater than 0. If the optional retained
+// argument is true, the broker keeps the message and sends it to future
+// subscribers. It returns an empty string.
+func (d *DotMQTT) Publish(topic string, payload any, retained ...bool) (string, error) {
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	case template.HTML:
+		data = []byte(p)
+	case []byte:
+		data = p
+	default:
+		return "", fmt.Errorf("mqtt payload must be a string or bytes, got %T", payload)
+	}
+	var retain bool
+	switch len(retained) {
+	case 0:
+	case 1:
+		retain = retained[0]
+	default:
+		return "", fmt.Errorf("too many retained arguments provided: %v", retained)
+	}
+	token := d.config.Client.Publish(topic, d.config.QoS, retain, data)
+	select {
+	case <-token.Done():
+	case <-d.ctx.Done():
+		return "", d.ctx.Err()
+	case <-time.After(time.Duration(d.config.Timeout)):
+		return "", fmt.Errorf("timed out publishing to mqtt topic '%s'", topic)
+	}
+	if err := token.Error(); err != nil {
+		return "", fmt.Errorf("failed to publish to mqtt topic '%s': %w", topic, err)
+	}
+	return "", nil
+}
+
+// Subscribe subscribes to topics, which can contain the wildcards `+` and
+// `#`, and returns a channel of messages that is closed when the request
+// ends. Use it with `.Flush` to stream messages to the client with server
+// sent events. Messages are dropped if the template falls more than 100
+// messages behind.
+func (d *DotMQTT) Subscribe(topics ...string) (<-chan MQTTMessage, error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no mqtt topics to subscribe to")
+	}
+	s := &mqttSubscriber{topics: topics, msgs: make(chan MQTTMessage, 100)}
+	if err := d.config.subscribe(s); err != nil {
+		return nil, err
+	}
+	ch := make(chan MQTTMessage)
+	go func() {
+		defer close(ch)
+		defer d.config.unsubscribe(s)
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case msg := <-s.msgs:
+				select {
+				case ch <- msg:
+				case <-d.ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,217 @@
+package xtemplate
+
+import (
+	"context"
+	"html/template"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// doneToken is an mqtt.Token that has already completed with err.
+type doneToken struct{ err error }
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Error() error                   { return t.err }
+func (t doneToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+type fakeMQTTMessage struct {
+	mqtt.Message
+	topic   string
+	payload string
+}
+
+func (m fakeMQTTMessage) Topic() string   { return m.topic }
+func (m fakeMQTTMessage) Payload() []byte { return []byte(m.payload) }
+func (m fakeMQTTMessage) Retained() bool  { return false }
+
+// fakeMQTTClient records the calls that DotMQTT makes. Other methods panic
+// on the nil embedded interface.
+type fakeMQTTClient struct {
+	mqtt.Client
+	mu           sync.Mutex
+	subscribed   []string
+	unsubscribed []string
+	published    []string
+	handler      mqtt.MessageHandler
+}
+
+func (c *fakeMQTTClient) IsConnected() bool { return true }
+
+func (c *fakeMQTTClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic := range filters {
+		c.subscribed = append(c.subscribed, topic)
+	}
+	c.handler = callback
+	return doneToken{}
+}
+
+func (c *fakeMQTTClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unsubscribed = append(c.unsubscribed, topics...)
+	return doneToken{}
+}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, topic+" "+string(payload.([]byte)))
+	return doneToken{}
+}
+
+func (c *fakeMQTTClient) send(topic, payload string) {
+	c.mu.Lock()
+	handler := c.handler
+	c.mu.Unlock()
+	handler(c, fakeMQTTMessage{topic: topic, payload: payload})
+}
+
+func newTestMQTT(t *testing.T) (*DotMQTTConfig, *fakeMQTTClient) {
+	t.Helper()
+	client := &fakeMQTTClient{}
+	d := &DotMQTTConfig{Name: "MQTT", Client: client}
+	if err := d.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return d, client
+}
+
+func TestMQTTMatch(t *testing.T) {
+	for _, test := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"+/b", "$SYS/b", false},
+		{"#", "$SYS/b", false},
+		{"$SYS/#", "$SYS/b", true},
+		{"a/b/c", "a/b", false},
+	} {
+		if got := mqttMatch(test.filter, test.topic); got != test.match {
+			t.Errorf("mqttMatch(%q, %q) = %v, want %v", test.filter, test.topic, got, test.match)
+		}
+	}
+}
+
+func TestMQTTSubscribe(t *testing.T) {
+	d, client := newTestMQTT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// overlapping filters deliver each message once
+	ch, err := (&DotMQTT{d, ctx}).Subscribe("sensors/+/temp", "sensors/#")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.send("sensors/kitchen/temp", "21")
+	client.send("other/kitchen/temp", "0")
+	client.send("sensors/kitchen/humidity", "40")
+	for _, want := range []string{"sensors/kitchen/temp 21", "sensors/kitchen/humidity 40"} {
+		select {
+		case msg := <-ch:
+			if got := msg.Topic + " " + msg.Payload; got != want {
+				t.Fatalf("received %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("didn't receive %q", want)
+		}
+	}
+
+	// the topics are unsubscribed and the channel closed when the request ends
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatalf("received a message after the request ended")
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		client.mu.Lock()
+		unsubscribed := slices.Clone(client.unsubscribed)
+		client.mu.Unlock()
+		slices.Sort(unsubscribed)
+		if slices.Equal(unsubscribed, []string{"sensors/#", "sensors/+/temp"}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unsubscribed %q after the request ended", unsubscribed)
+		}
+	}
+}
+
+func TestMQTTSubscribeShared(t *testing.T) {
+	d, client := newTestMQTT(t)
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	ch1, err := (&DotMQTT{d, ctx1}).Subscribe("news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch2, err := (&DotMQTT{d, ctx2}).Subscribe("news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.subscribed) != 1 {
+		t.Fatalf("subscribed to %q, want one subscription shared by both requests", client.subscribed)
+	}
+	client.send("news", "hi")
+	for _, ch := range []<-chan MQTTMessage{ch1, ch2} {
+		if msg := <-ch; msg.Payload != "hi" {
+			t.Fatalf("received %+v", msg)
+		}
+	}
+
+	// the topic stays subscribed until the last subscriber leaves
+	cancel1()
+	for range ch1 {
+	}
+	time.Sleep(10 * time.Millisecond)
+	client.mu.Lock()
+	unsubscribed := len(client.unsubscribed)
+	client.mu.Unlock()
+	if unsubscribed != 0 {
+		t.Fatalf("unsubscribed while another request is subscribed")
+	}
+	client.send("news", "still here")
+	if msg := <-ch2; msg.Payload != "still here" {
+		t.Fatalf("received %+v", msg)
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	d, client := newTestMQTT(t)
+	m := &DotMQTT{d, context.Background()}
+	for _, payload := range []any{"text", template.HTML("<b>html</b>"), []byte("bytes")} {
+		if _, err := m.Publish("out", payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(client.published, []string{"out text", "out <b>html</b>", "out bytes"}) {
+		t.Fatalf("published %q", client.published)
+	}
+	if _, err := m.Publish("out", 1); err == nil {
+		t.Fatalf("published an int payload")
+	}
+	if _, err := m.Publish("out", "x", true, false); err == nil {
+		t.Fatalf("published with two retained arguments")
+	}
+	if _, err := (&DotMQTT{d, context.Background()}).Subscribe(); err == nil {
+		t.Fatalf("subscribed to no topics")
+	}
+}
@@ -2,7 +2,10 @@ package xtemplate
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -14,11 +17,34 @@ type DotNats struct {
 
 	*nats.Conn
 	jetstream.JetStream
+
+	// The message being handled if this is a `NATS <subject>` template,
+	// otherwise nil.
+	Msg *nats.Msg
 }
 
 func (d *DotNats) Subscribe(subject string) (<-chan *nats.Msg, error) {
-	ch := make(chan *nats.Msg)
-	sub, err := d.Conn.ChanSubscribe(subject, ch)
+	return d.subscribe(func(ch chan *nats.Msg) (*nats.Subscription, error) {
+		return d.Conn.ChanSubscribe(subject, ch)
+	})
+}
+
+// QueueSubscribe is like Subscribe, but each message is only received by one
+// of the subscribers in the queue group named group, so several servers can
+// share the work of a subject.
+func (d *DotNats) QueueSubscribe(subject, group string) (<-chan *nats.Msg, error) {
+	return d.subscribe(func(ch chan *nats.Msg) (*nats.Subscription, error) {
+		return d.Conn.ChanQueueSubscribe(subject, group, ch)
+	})
+}
+
+// natsSubscribeBuffer is the number of messages a subscription holds while
+// the template is busy. nats drops messages that don't fit in the channel.
+const natsSubscribeBuffer = 64
+
+func (d *DotNats) subscribe(subscribe func(chan *nats.Msg) (*nats.Subscription, error)) (<-chan *nats.Msg, error) {
+	ch := make(chan *nats.Msg, natsSubscribeBuffer)
+	sub, err := subscribe(ch)
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +65,7 @@ func (d *DotNats) Request(subject, data string, timeout_ ...time.Duration) (*nat
 	var timeout time.Duration
 	switch len(timeout_) {
 	case 0:
-		timeout = 5 * time.Second
+		timeout = natsRequestTimeout
 	case 1:
 		timeout = timeout_[0]
 	default:
@@ -48,3 +74,219 @@ func (d *DotNats) Request(subject, data string, timeout_ ...time.Duration) (*nat
 
 	return d.Conn.Request(subject, []byte(data), timeout)
 }
+
+const natsRequestTimeout = 5 * time.Second
+
+// PublishMsg publishes payload to subject with headers. Payloads other than
+// strings and bytes are encoded as JSON with the `Content-Type` header
+// `application/json`.
+//
+//	{{.Nats.PublishMsg "orders.created" (dict "id" $id "total" $total) (dict "Source" "web")}}
+func (d *DotNats) PublishMsg(subject string, payload any, headers ...map[string]any) (string, error) {
+	msg, err := newNatsMsg(subject, payload, headers)
+	if err != nil {
+		return "", err
+	}
+	return "", d.Conn.PublishMsg(msg)
+}
+
+// RequestMsg sends payload to subject with headers like PublishMsg, and waits
+// up to 5 seconds for the reply.
+func (d *DotNats) RequestMsg(subject string, payload any, headers ...map[string]any) (*NatsReply, error) {
+	msg, err := newNatsMsg(subject, payload, headers)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, natsRequestTimeout)
+	defer cancel()
+	reply, err := d.Conn.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("nats request to '%s' failed: %w", subject, err)
+	}
+	return newNatsReply(reply), nil
+}
+
+// NatsReply is a reply to a request sent with [DotNats.RequestMsg].
+type NatsReply struct {
+	Header nats.Header
+	Data   []byte
+	// The data decoded from JSON, or the data as a string if it isn't JSON.
+	Value any
+}
+
+func newNatsReply(msg *nats.Msg) *NatsReply {
+	reply := &NatsReply{Header: msg.Header, Data: msg.Data, Value: string(msg.Data)}
+	var value any
+	if json.Unmarshal(msg.Data, &value) == nil {
+		reply.Value = value
+	}
+	return reply
+}
+
+// RequestJSON encodes payload as JSON, sends it to subject, and decodes the
+// reply as a JSON object. It waits for the reply up to timeout, which can be a
+// duration string like `2s` or a number of seconds, default 5 seconds. Errors
+// replied by `NATS <subject>` templates are returned as errors.
+//
+//	{{$quote := .Nats.RequestJSON "prices.quote" (dict "sku" $sku "qty" 3) "2s"}}{{$quote.total}}
+func (d *DotNats) RequestJSON(subject string, payload any, timeout_ ...any) (map[string]any, error) {
+	timeout := natsRequestTimeout
+	switch len(timeout_) {
+	case 0:
+	case 1:
+		var err error
+		if timeout, err = toDuration(timeout_[0]); err != nil {
+			return nil, fmt.Errorf("invalid nats request timeout: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("too many timeout args")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode nats payload: %w", err)
+	}
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(d.ctx, timeout)
+	defer cancel()
+	reply, err := d.Conn.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("nats request to '%s' failed: %w", subject, err)
+	}
+	if e := reply.Header.Get("Nats-Service-Error"); e != "" {
+		return nil, fmt.Errorf("nats request to '%s' failed: %s", subject, e)
+	}
+	var value map[string]any
+	if err := json.Unmarshal(reply.Data, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode nats reply from '%s': %w", subject, err)
+	}
+	return value, nil
+}
+
+func newNatsMsg(subject string, payload any, headers []map[string]any) (*nats.Msg, error) {
+	msg := nats.NewMsg(subject)
+	switch p := payload.(type) {
+	case string:
+		msg.Data = []byte(p)
+	case []byte:
+		msg.Data = p
+	default:
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode nats payload: %w", err)
+		}
+		msg.Data = data
+		msg.Header.Set("Content-Type", "application/json")
+	}
+	switch len(headers) {
+	case 0:
+	case 1:
+		for k, v := range headers[0] {
+			msg.Header.Set(k, fmt.Sprint(v))
+		}
+	default:
+		return nil, fmt.Errorf("too many headers args")
+	}
+	return msg, nil
+}
+
+// JSPublish publishes message to subject and waits for a JetStream stream to
+// acknowledge that it was stored.
+func (d *DotNats) JSPublish(subject, message string) (*jetstream.PubAck, error) {
+	if d.JetStream == nil {
+		return nil, fmt.Errorf("jetstream is not available")
+	}
+	return d.JetStream.Publish(d.ctx, subject, []byte(message))
+}
+
+// JSConsume returns a channel of the messages of the durable consumer named
+// durable on stream, which is created if it doesn't exist yet. Messages must
+// be acknowledged with Ack or they are redelivered after the consumer's ack
+// wait. The channel is closed when the request ends.
+//
+//	{{define "SSE /orders"}}{{range .Nats.JSConsume "ORDERS" "dashboard"}}{{$.Flush.SendSSE "order" .Text}}{{.Ack}}{{end}}{{end}}
+func (d *DotNats) JSConsume(stream, durable string) (<-chan *JetStreamMsg, error) {
+	if d.JetStream == nil {
+		return nil, fmt.Errorf("jetstream is not available")
+	}
+	consumer, err := d.JetStream.CreateOrUpdateConsumer(d.ctx, stream, jetstream.ConsumerConfig{
+		Durable:   durable,
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer '%s' on stream '%s': %w", durable, stream, err)
+	}
+	msgs, err := consumer.Messages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume messages of consumer '%s': %w", durable, err)
+	}
+	stop := context.AfterFunc(d.ctx, msgs.Stop)
+	ch := make(chan *JetStreamMsg)
+	go func() {
+		defer close(ch)
+		defer stop()
+		defer msgs.Stop()
+		for {
+			msg, err := msgs.Next()
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return
+			} else if err != nil {
+				GetLogger(d.ctx).Warn("failed to get next jetstream message", slog.String("consumer", durable), slog.Any("error", err))
+				return
+			}
+			select {
+			case ch <- &JetStreamMsg{msg}:
+			case <-d.ctx.Done():
+				// not acked, so it will be redelivered
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// JetStreamMsg is a message received from a JetStream consumer by
+// [DotNats.JSConsume].
+type JetStreamMsg struct {
+	jetstream.Msg
+}
+
+// Text returns the message data as a string.
+func (m *JetStreamMsg) Text() string {
+	return string(m.Msg.Data())
+}
+
+// Ack acknowledges that the message was processed.
+func (m *JetStreamMsg) Ack() (string, error) {
+	return "", m.Msg.Ack()
+}
+
+// Nak tells the server to redeliver the message, after delay if given, like
+// `"30s"` or a number of seconds.
+func (m *JetStreamMsg) Nak(delay ...any) (string, error) {
+	switch len(delay) {
+	case 0:
+		return "", m.Msg.Nak()
+	case 1:
+		d, err := toDuration(delay[0])
+		if err != nil {
+			return "", err
+		}
+		return "", m.Msg.NakWithDelay(d)
+	default:
+		return "", fmt.Errorf("too many delay args")
+	}
+}
+
+// InProgress tells the server that the message is still being processed, to
+// reset its ack wait.
+func (m *JetStreamMsg) InProgress() (string, error) {
+	return "", m.Msg.InProgress()
+}
+
+// Term tells the server to never redeliver the message.
+func (m *JetStreamMsg) Term() (string, error) {
+	return "", m.Msg.Term()
+}
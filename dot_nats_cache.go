@@ -0,0 +1,189 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// WithNatsCache creates an [xtemplate.Option] that adds a cache provider at
+// the dot field name that stores entries in bucket with the configured nats
+// client named nats.
+func WithNatsCache(name, nats, bucket string) Option {
+	return func(c *Config) error {
+		c.NatsCache = append(c.NatsCache, DotNatsCacheConfig{Name: name, Nats: nats, Bucket: bucket})
+		return nil
+	}
+}
+
+// DotNatsCacheConfig configures a cache stored in a NATS JetStream KV bucket
+// that is shared by all requests to an instance and by every server connected
+// to the same NATS cluster, without running a separate cache server.
+type DotNatsCacheConfig struct {
+	Name string `json:"name"`
+
+	// The name of a configured nats client with JetStream enabled.
+	Nats string `json:"nats"`
+
+	// The name of the bucket, which is created if it doesn't exist. Default
+	// `cache`.
+	Bucket string `json:"bucket,omitempty"`
+
+	// The longest time entries are kept, which limits longer TTLs. Default
+	// `24h`.
+	MaxTTL Duration `json:"max_ttl,omitempty"`
+
+	nats      *DotNatsConfig
+	kv        jetstream.KeyValue
+	templates *template.Template
+}
+
+var _ DotConfig = &DotNatsCacheConfig{}
+
+func (d *DotNatsCacheConfig) FieldName() string { return d.Name }
+func (d *DotNatsCacheConfig) Init(ctx context.Context) error {
+	if d.nats == nil {
+		return fmt.Errorf("cache nats '%s' is not a configured nats client", d.Nats)
+	}
+	if d.Bucket == "" {
+		d.Bucket = "cache"
+	}
+	if d.MaxTTL == 0 {
+		d.MaxTTL = Duration(24 * time.Hour)
+	}
+	var err error
+	d.kv, err = d.nats.js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: d.Bucket,
+		TTL:    time.Duration(d.MaxTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create cache bucket '%s': %w", d.Bucket, err)
+	}
+	return nil
+}
+func (d *DotNatsCacheConfig) Value(r Request) (any, error) {
+	return DotNatsCache{d, r.R.Context()}, nil
+}
+
+// DotNatsCache is used as the dot field to cache values across requests and
+// servers in a NATS KV bucket, configured by [DotNatsCacheConfig]. It has the
+// same methods as [DotCache], but values are encoded as JSON so they must be
+// strings, numbers, bools, lists, or maps, and Get can fail. TTLs can be a
+// duration string like `5m`, a [time.Duration], or a number of seconds.
+//
+//	{{.NatsCache.GetOrCompute (print "nav:" .Req.URL.Path) "10m" "nav" .}}
+type DotNatsCache struct {
+	config *DotNatsCacheConfig
+	ctx    context.Context
+}
+
+// natsCacheEntry is the value stored in the bucket. KV buckets only expire
+// all entries after the same age, so each entry has its own expiry too.
+type natsCacheEntry struct {
+	// Value holds rendered template output, so it isn't escaped again when
+	// it's used.
+	HTML    bool            `json:"html,omitempty"`
+	Value   json.RawMessage `json:"value"`
+	Expires int64           `json:"expires"`
+}
+
+// natsCacheKey encodes key so it only has characters that are valid in KV
+// keys.
+func natsCacheKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// Get returns the value stored at key, or nil if it is missing or expired.
+func (d DotNatsCache) Get(key string) (any, error) {
+	e, err := d.config.kv.Get(d.ctx, natsCacheKey(key))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache key '%s': %w", key, err)
+	}
+	var entry natsCacheEntry
+	if err := json.Unmarshal(e.Value(), &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode cache key '%s': %w", key, err)
+	}
+	if time.Now().UnixNano() > entry.Expires {
+		return nil, nil
+	}
+	if entry.HTML {
+		var html string
+		if err := json.Unmarshal(entry.Value, &html); err != nil {
+			return nil, fmt.Errorf("failed to decode cache key '%s': %w", key, err)
+		}
+		return template.HTML(html), nil
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(entry.Value))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to decode cache key '%s': %w", key, err)
+	}
+	return v, nil
+}
+
+// Set stores value at key until ttl expires. It returns an empty string.
+func (d DotNatsCache) Set(key string, value any, ttl any) (string, error) {
+	dur, err := parseTTL(ttl)
+	if err != nil {
+		return "", err
+	}
+	entry := natsCacheEntry{Expires: time.Now().Add(dur).UnixNano()}
+	if html, ok := value.(template.HTML); ok {
+		entry.HTML = true
+		value = string(html)
+	}
+	if entry.Value, err = json.Marshal(value); err != nil {
+		return "", fmt.Errorf("failed to encode cache key '%s': %w", key, err)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cache key '%s': %w", key, err)
+	}
+	if _, err := d.config.kv.Put(d.ctx, natsCacheKey(key), data); err != nil {
+		return "", fmt.Errorf("failed to set cache key '%s': %w", key, err)
+	}
+	return "", nil
+}
+
+// Delete removes the value stored at key. It returns an empty string.
+func (d DotNatsCache) Delete(key string) (string, error) {
+	if err := d.config.kv.Purge(d.ctx, natsCacheKey(key)); err != nil {
+		return "", fmt.Errorf("failed to delete cache key '%s': %w", key, err)
+	}
+	return "", nil
+}
+
+// GetOrCompute returns the value stored at key, or invokes the template name
+// with dot, stores its output at key until ttl expires, and returns it.
+func (d DotNatsCache) GetOrCompute(key string, ttl any, name string, dot any) (any, error) {
+	if v, err := d.Get(key); err != nil || v != nil {
+		return v, err
+	}
+	t := d.config.templates.Lookup(name)
+	if t == nil {
+		return nil, fmt.Errorf("failed to lookup template name: '%s'", name)
+	}
+	defer startSpan(d.ctx, "compute "+key)()
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	if err := t.Execute(buf, dot); err != nil {
+		return nil, fmt.Errorf("failed to execute template '%s': %w", name, err)
+	}
+	result := template.HTML(buf.String())
+	if _, err := d.Set(key, result, ttl); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
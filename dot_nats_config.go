@@ -2,7 +2,15 @@ package xtemplate
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
@@ -11,15 +19,272 @@ import (
 
 func WithNats(name string, serverOpts *server.Options, connOpts *nats.Options, jsOpts []jetstream.JetStreamOpt) Option {
 	return func(c *Config) error {
-		c.Nats = append(c.Nats, DotNatsConfig{Name: name, NatsConfig: &NatsConfig{serverOpts, connOpts, jsOpts}})
+		c.Nats = append(c.Nats, DotNatsConfig{Name: name, NatsConfig: &NatsConfig{InProcessServerOptions: serverOpts, ConnOptions: connOpts, JetStreamOptions: jsOpts}})
 		return nil
 	}
 }
 
 type NatsConfig struct {
+	// Start a nats server in the process and connect to it, configured by
+	// json. Can't be used with InProcessServerOptions.
+	InProcessServer *NatsServerConfig `json:"in_process_server,omitempty"`
+
 	InProcessServerOptions *server.Options          `json:"in_process_server_options"`
 	ConnOptions            *nats.Options            `json:"conn_options"`
 	JetStreamOptions       []jetstream.JetStreamOpt // encode jetstream opts into json?
+
+	// The urls of the servers to connect to, separated by commas, like
+	// `nats://nats-1:4222,nats://nats-2:4222`. Can't be used with an
+	// in-process server.
+	URL string `json:"url,omitempty"`
+
+	// The path of a credentials file with a user JWT and nkey seed, like one
+	// created by `nsc`.
+	CredsFile string `json:"creds_file,omitempty"`
+
+	// The path of a file with the nkey seed to authenticate with.
+	NkeyFile string `json:"nkey_file,omitempty"`
+
+	// A username and password or a token to authenticate with. Environment
+	// variables like `${NATS_PASSWORD}` are expanded when the instance loads,
+	// so secrets don't have to be kept in the config file.
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+
+	// Connect with TLS. Enabled by urls with the `tls://` scheme.
+	TLS *NatsTLSConfig `json:"tls,omitempty"`
+}
+
+// NatsTLSConfig configures the TLS connection to NATS servers.
+type NatsTLSConfig struct {
+	// The path of a PEM file of CA certificates to verify the server with,
+	// instead of the system's.
+	CAFile string `json:"ca_file,omitempty"`
+
+	// The paths of a PEM client certificate and key, for servers that verify
+	// clients.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+
+	// Do the TLS handshake before the server sends its info, for servers
+	// configured with `handshake_first`.
+	HandshakeFirst bool `json:"handshake_first,omitempty"`
+}
+
+// NatsServerConfig configures a nats server started in the process. By default
+// it only accepts the in-process connection, but it can listen for other
+// clients, persist JetStream streams to disk, and join a cluster or connect to
+// other servers as a leaf node. The server is kept when the instance reloads
+// unless its config changes. A changed server starts before the previous one
+// stops, so a reload that changes the config but keeps the same listen
+// addresses or JetStream store dir fails until the process restarts.
+type NatsServerConfig struct {
+	// The path of a nats-server config file to load, for settings that aren't
+	// listed here like accounts and authorization. The other fields override
+	// the settings in the file.
+	ConfigFile string `json:"config_file,omitempty"`
+
+	// The name of the server, which should be unique in a cluster.
+	ServerName string `json:"server_name,omitempty"`
+
+	// The address to accept client connections at, like `0.0.0.0:4222`. By
+	// default the server doesn't listen unless the config file sets it.
+	Listen string `json:"listen,omitempty"`
+
+	// Enable JetStream.
+	JetStream bool `json:"jetstream,omitempty"`
+
+	// The directory to persist JetStream streams in, so they survive restarts.
+	// Default a directory in the system's temp dir.
+	StoreDir string `json:"store_dir,omitempty"`
+
+	// Join a cluster of servers.
+	Cluster *NatsClusterConfig `json:"cluster,omitempty"`
+
+	// Accept leaf node connections or connect to other servers as a leaf node.
+	Leafnodes *NatsLeafnodesConfig `json:"leafnodes,omitempty"`
+}
+
+// NatsClusterConfig configures the cluster an in-process nats server joins.
+type NatsClusterConfig struct {
+	// The name of the cluster, which must be the same on every server.
+	Name string `json:"name,omitempty"`
+
+	// The address to accept connections from other servers in the cluster at,
+	// like `0.0.0.0:6222`.
+	Listen string `json:"listen"`
+
+	// The urls of other servers in the cluster, like `nats://nats-2:6222`.
+	Routes []string `json:"routes,omitempty"`
+}
+
+// NatsLeafnodesConfig configures leaf node connections of an in-process nats
+// server.
+type NatsLeafnodesConfig struct {
+	// The address to accept leaf node connections at, like `0.0.0.0:7422`.
+	Listen string `json:"listen,omitempty"`
+
+	// The servers to connect to as a leaf node.
+	Remotes []NatsLeafnodeRemote `json:"remotes,omitempty"`
+}
+
+// NatsLeafnodeRemote is a server that an in-process nats server connects to as
+// a leaf node.
+type NatsLeafnodeRemote struct {
+	// The url of the server's leaf node address, like `nats-leaf://hub:7422`.
+	URL string `json:"url"`
+
+	// The path of a credentials file to authenticate with.
+	CredsFile string `json:"creds_file,omitempty"`
+}
+
+// options returns the server options configured by c.
+func (c *NatsServerConfig) options() (*server.Options, error) {
+	opts := &server.Options{}
+	if c.ConfigFile != "" {
+		var err error
+		if opts, err = server.ProcessConfigFile(c.ConfigFile); err != nil {
+			return nil, fmt.Errorf("failed to load nats server config file: %w", err)
+		}
+	}
+	if c.ServerName != "" {
+		opts.ServerName = c.ServerName
+	}
+	if c.Listen != "" {
+		host, port, err := splitHostPort(c.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nats server listen address: %w", err)
+		}
+		opts.Host, opts.Port = host, port
+	} else if c.ConfigFile == "" {
+		opts.DontListen = true
+	}
+	if c.JetStream {
+		opts.JetStream = true
+	}
+	if c.StoreDir != "" {
+		opts.StoreDir = c.StoreDir
+	}
+	if c.Cluster != nil {
+		host, port, err := splitHostPort(c.Cluster.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nats cluster listen address: %w", err)
+		}
+		opts.Cluster.Name, opts.Cluster.Host, opts.Cluster.Port = c.Cluster.Name, host, port
+		if len(c.Cluster.Routes) > 0 {
+			opts.Routes = server.RoutesFromStr(strings.Join(c.Cluster.Routes, ","))
+		}
+	}
+	if c.Leafnodes != nil {
+		if c.Leafnodes.Listen != "" {
+			host, port, err := splitHostPort(c.Leafnodes.Listen)
+			if err != nil {
+				return nil, fmt.Errorf("invalid nats leafnodes listen address: %w", err)
+			}
+			opts.LeafNode.Host, opts.LeafNode.Port = host, port
+		}
+		for _, r := range c.Leafnodes.Remotes {
+			u, err := url.Parse(r.URL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid nats leafnode remote url '%s': %w", r.URL, err)
+			}
+			opts.LeafNode.Remotes = append(opts.LeafNode.Remotes, &server.RemoteLeafOpts{URLs: []*url.URL{u}, Credentials: r.CredsFile})
+		}
+	}
+	return opts, nil
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port '%s'", p)
+	}
+	return host, port, nil
+}
+
+// embeddedNats is a nats server started by an instance that the next instance
+// keeps when it reloads, so clients connected to it and its listeners aren't
+// interrupted. It shuts down when no instance uses it.
+type embeddedNats struct {
+	server *server.Server
+	opts   *server.Options
+	config string
+	refs   atomic.Int32
+}
+
+// conflicts returns what the server configured by opts would share with e,
+// which can't be used by two servers at once, or "" if nothing.
+func (e *embeddedNats) conflicts(opts *server.Options) string {
+	ports := func(o *server.Options) map[int]bool {
+		ports := map[int]bool{o.Cluster.Port: true, o.LeafNode.Port: true}
+		if !o.DontListen {
+			if o.Port == 0 {
+				ports[server.DEFAULT_PORT] = true
+			} else {
+				ports[o.Port] = true
+			}
+		}
+		return ports
+	}
+	prev := ports(e.opts)
+	for port := range ports(opts) {
+		if port > 0 && prev[port] {
+			return fmt.Sprintf("port %d", port)
+		}
+	}
+	if opts.JetStream && e.opts.JetStream && opts.StoreDir == e.opts.StoreDir {
+		return "jetstream store dir"
+	}
+	return ""
+}
+
+// options returns the connection options that configure c's server url,
+// authentication, and TLS.
+func (c *NatsConfig) options() ([]nats.Option, error) {
+	var opts []nats.Option
+	if c.URL != "" {
+		if c.InProcessServerOptions != nil || c.InProcessServer != nil {
+			return nil, fmt.Errorf("nats url can't be used with an in-process server")
+		}
+		opts = append(opts, func(o *nats.Options) error {
+			o.Url, o.Servers = "", strings.Split(c.URL, ",")
+			return nil
+		})
+	}
+	if c.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(c.CredsFile))
+	}
+	if c.NkeyFile != "" {
+		opt, err := nats.NkeyOptionFromSeed(c.NkeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load nats nkey: %w", err)
+		}
+		opts = append(opts, opt)
+	}
+	if c.User != "" {
+		opts = append(opts, nats.UserInfo(os.ExpandEnv(c.User), os.ExpandEnv(c.Password)))
+	}
+	if c.Token != "" {
+		opts = append(opts, nats.Token(os.ExpandEnv(c.Token)))
+	}
+	if c.TLS != nil {
+		opts = append(opts, nats.Secure())
+		if c.TLS.CAFile != "" {
+			opts = append(opts, nats.RootCAs(c.TLS.CAFile))
+		}
+		if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+			opts = append(opts, nats.ClientCert(c.TLS.CertFile, c.TLS.KeyFile))
+		}
+		if c.TLS.HandshakeFirst {
+			opts = append(opts, nats.TLSHandshakeFirst())
+		}
+	}
+	return opts, nil
 }
 
 type DotNatsConfig struct {
@@ -28,14 +293,49 @@ type DotNatsConfig struct {
 	*NatsConfig `json:"nats_config"`
 	Conn        *nats.Conn
 
-	server *server.Server
-	js     jetstream.JetStream
+	// JetStream streams to create or update when the instance loads, so
+	// templates can publish to and consume from them.
+	Streams []jetstream.StreamConfig `json:"streams,omitempty"`
+
+	// Subscribe templates named `NATS <subject>` with this connection. Only
+	// one nats provider can enable it.
+	Services bool `json:"services,omitempty"`
+
+	// The queue group that templates named `NATS <subject>` subscribe with, so
+	// each message is handled by only one of several servers. Default
+	// `xtemplate`.
+	Queue string `json:"queue,omitempty"`
+
+	// The most messages that templates named `NATS <subject>` handle at once.
+	// More messages wait in the subscription until a template finishes.
+	// Default `16`.
+	ServiceWorkers int `json:"service_workers,omitempty"`
+
+	// How long a template named `NATS <subject>` can run before its context is
+	// cancelled. Default `30s`.
+	ServiceTimeout Duration `json:"service_timeout,omitempty"`
+
+	server   *server.Server
+	embedded *embeddedNats
+	js       jetstream.JetStream
 }
 
 var _ DotConfig = &DotNatsConfig{}
 
 func (d *DotNatsConfig) FieldName() string { return d.Name }
 func (d *DotNatsConfig) Init(ctx context.Context) error {
+	if d.Queue == "" {
+		d.Queue = "xtemplate"
+	}
+	if d.ServiceWorkers == 0 {
+		d.ServiceWorkers = 16
+	}
+	if d.ServiceWorkers < 0 {
+		return fmt.Errorf("nats service_workers must not be negative, got %d", d.ServiceWorkers)
+	}
+	if d.ServiceTimeout == 0 {
+		d.ServiceTimeout = Duration(30 * time.Second)
+	}
 	var err error
 	if d.Conn != nil {
 		if d.js == nil {
@@ -43,10 +343,11 @@ func (d *DotNatsConfig) Init(ctx context.Context) error {
 			if d.NatsConfig != nil {
 				jsOpts = d.NatsConfig.JetStreamOptions
 			}
-			d.js, err = jetstream.New(d.Conn, jsOpts...)
-			return err
+			if d.js, err = jetstream.New(d.Conn, jsOpts...); err != nil {
+				return err
+			}
 		}
-		return nil
+		return d.createStreams(ctx)
 	}
 	if d.NatsConfig == nil {
 		return fmt.Errorf("no nats client and no config provided to initialzie nats client")
@@ -57,7 +358,24 @@ func (d *DotNatsConfig) Init(ctx context.Context) error {
 	} else {
 		connOpt = *d.NatsConfig.ConnOptions
 	}
-	if d.NatsConfig.InProcessServerOptions != nil {
+	opts, err := d.NatsConfig.options()
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		if err := opt(&connOpt); err != nil {
+			return fmt.Errorf("failed to configure nats connection: %w", err)
+		}
+	}
+	if d.NatsConfig.InProcessServer != nil {
+		if d.NatsConfig.InProcessServerOptions != nil {
+			return fmt.Errorf("nats in_process_server can't be used with in_process_server_options")
+		}
+		if err := d.startEmbedded(ctx); err != nil {
+			return err
+		}
+		nats.InProcessServer(d.server)(&connOpt)
+	} else if d.NatsConfig.InProcessServerOptions != nil {
 		// start an internal server for this instance
 		d.server, err = server.NewServer(d.NatsConfig.InProcessServerOptions)
 		if err != nil {
@@ -78,11 +396,80 @@ func (d *DotNatsConfig) Init(ctx context.Context) error {
 	}
 	d.Conn, err = connOpt.Connect()
 	if err != nil {
-		return fmt.Errorf("failed to connect to in-process server: %w", err)
+		return fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	// close the connection when the instance is cancelled
+	if done := ctx.Done(); done != nil {
+		conn := d.Conn
+		go func() {
+			<-done
+			conn.Close()
+		}()
+	}
+	if d.js, err = jetstream.New(d.Conn, d.NatsConfig.JetStreamOptions...); err != nil {
+		return err
+	}
+	return d.createStreams(ctx)
+}
+
+// startEmbedded starts the in-process server configured by InProcessServer, or
+// keeps the previous instance's server if its config is the same.
+func (d *DotNatsConfig) startEmbedded(ctx context.Context) error {
+	config, err := json.Marshal(d.NatsConfig.InProcessServer)
+	if err != nil {
+		return fmt.Errorf("failed to encode nats server config: %w", err)
+	}
+	if prev := d.embedded; prev != nil && prev.config == string(config) && prev.server.Running() {
+		prev.refs.Add(1)
+	} else {
+		opts, err := d.NatsConfig.InProcessServer.options()
+		if err != nil {
+			return err
+		}
+		// the previous server keeps running until this instance replaces the
+		// previous one, in case this instance fails to load, so the new server
+		// can't use the same listeners or store dir
+		if prev != nil && prev.server.Running() {
+			if conflict := prev.conflicts(opts); conflict != "" {
+				return fmt.Errorf("in-process nats server config changed but uses the same %s as the running server, restart to apply it", conflict)
+			}
+		}
+		srv, err := server.NewServer(opts)
+		if err != nil {
+			return fmt.Errorf("failed to start in-process nats server: %w", err)
+		}
+		srv.Start()
+		if !srv.ReadyForConnections(10 * time.Second) {
+			srv.Shutdown()
+			return fmt.Errorf("in-process nats server is not ready for connections")
+		}
+		d.embedded = &embeddedNats{server: srv, opts: opts, config: string(config)}
+		d.embedded.refs.Add(1)
+	}
+	d.server = d.embedded.server
+
+	// shut down the server when no instance uses it
+	if done := ctx.Done(); done != nil {
+		e := d.embedded
+		go func() {
+			<-done
+			if e.refs.Add(-1) == 0 {
+				e.server.Shutdown()
+			}
+		}()
+	}
+	return nil
+}
+
+func (d *DotNatsConfig) createStreams(ctx context.Context) error {
+	for _, stream := range d.Streams {
+		if _, err := d.js.CreateOrUpdateStream(ctx, stream); err != nil {
+			return fmt.Errorf("failed to create jetstream stream '%s': %w", stream.Name, err)
+		}
 	}
-	d.js, err = jetstream.New(d.Conn, d.NatsConfig.JetStreamOptions...)
-	return err
+	return nil
 }
 func (d *DotNatsConfig) Value(r Request) (any, error) {
-	return &DotNats{Conn: d.Conn, JetStream: d.js, ctx: r.R.Context()}, nil
+	msg, _ := r.R.Context().Value(natsMsgContextKey{}).(*nats.Msg)
+	return &DotNats{Conn: d.Conn, JetStream: d.js, Msg: msg, ctx: r.R.Context()}, nil
 }
@@ -0,0 +1,150 @@
+package xtemplate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newTestNatsConn(t *testing.T) *nats.Conn {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{DontListen: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	t.Cleanup(s.Shutdown)
+	conn, err := nats.Connect("", nats.InProcessServer(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestNatsQueueSubscribe(t *testing.T) {
+	conn := newTestNatsConn(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &DotNats{ctx: ctx, Conn: conn}
+
+	worker1, err := d.QueueSubscribe("jobs", "workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker2, err := d.QueueSubscribe("jobs", "workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := d.Subscribe("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		if err := d.Publish("jobs", "job"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each message is received once by the queue group, and once by the
+	// subscriber that isn't in the group
+	var queued, watched int
+	for queued+watched < 2*n {
+		select {
+		case <-worker1:
+			queued++
+		case <-worker2:
+			queued++
+		case <-watcher:
+			watched++
+		case <-time.After(time.Second):
+			t.Fatalf("received %d queued and %d watched messages, want %d of each", queued, watched, n)
+		}
+	}
+	select {
+	case <-worker1:
+		t.Fatalf("queue group received a message more than once")
+	case <-worker2:
+		t.Fatalf("queue group received a message more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if queued != n || watched != n {
+		t.Fatalf("received %d queued and %d watched messages, want %d of each", queued, watched, n)
+	}
+
+	// the channels are closed when the request ends
+	cancel()
+	for _, ch := range []<-chan *nats.Msg{worker1, worker2, watcher} {
+		if _, ok := <-ch; ok {
+			t.Fatalf("received a message after the request ended")
+		}
+	}
+}
+
+// fakeJSMsg records the acknowledgements of a jetstream message. Other
+// methods panic on the nil embedded interface.
+type fakeJSMsg struct {
+	jetstream.Msg
+	acks []string
+}
+
+func (m *fakeJSMsg) Data() []byte { return []byte("hello") }
+func (m *fakeJSMsg) Ack() error   { m.acks = append(m.acks, "ack"); return nil }
+func (m *fakeJSMsg) Nak() error   { m.acks = append(m.acks, "nak"); return nil }
+func (m *fakeJSMsg) Term() error  { m.acks = append(m.acks, "term"); return nil }
+func (m *fakeJSMsg) NakWithDelay(delay time.Duration) error {
+	m.acks = append(m.acks, "nak "+delay.String())
+	return nil
+}
+
+func TestJetStreamMsgAcks(t *testing.T) {
+	fake := &fakeJSMsg{}
+	msg := &JetStreamMsg{fake}
+	if msg.Text() != "hello" {
+		t.Fatalf("text %q", msg.Text())
+	}
+	for _, call := range []func() (string, error){
+		msg.Ack,
+		func() (string, error) { return msg.Nak() },
+		func() (string, error) { return msg.Nak("30s") },
+		func() (string, error) { return msg.Nak(2) },
+		msg.Term,
+	} {
+		if s, err := call(); s != "" || err != nil {
+			t.Fatalf("returned %q, %v", s, err)
+		}
+	}
+	want := []string{"ack", "nak", "nak 30s", "nak 2s", "term"}
+	if len(fake.acks) != len(want) {
+		t.Fatalf("acks %q, want %q", fake.acks, want)
+	}
+	for i := range want {
+		if fake.acks[i] != want[i] {
+			t.Fatalf("acks %q, want %q", fake.acks, want)
+		}
+	}
+	if _, err := msg.Nak("soon"); err == nil {
+		t.Fatalf("nak with an invalid delay succeeded")
+	}
+	if _, err := msg.Nak(1, 2); err == nil {
+		t.Fatalf("nak with two delays succeeded")
+	}
+}
+
+func TestJetStreamUnavailable(t *testing.T) {
+	d := &DotNats{}
+	if _, err := d.JSPublish("orders.new", "x"); err == nil {
+		t.Fatalf("published without jetstream")
+	}
+	if _, err := d.JSConsume("ORDERS", "dashboard"); err == nil {
+		t.Fatalf("consumed without jetstream")
+	}
+}
@@ -0,0 +1,174 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	texttemplate "text/template"
+)
+
+// WithPDF creates an [xtemplate.Option] that adds a PDF rendering provider at
+// the dot field name that converts html with backend, which is `basic` or
+// `chromium`.
+func WithPDF(name, backend string) Option {
+	return func(c *Config) error {
+		c.PDF = append(c.PDF, DotPDFConfig{Name: name, Backend: backend})
+		return nil
+	}
+}
+
+// DotPDFConfig configures rendering templates to PDF documents, like invoices
+// and reports.
+type DotPDFConfig struct {
+	Name string `json:"name"`
+
+	// How to convert html to PDF, default `basic`:
+	//
+	//   - `basic` lays out text, headings, lists, links, and tables in pure Go.
+	//     It ignores CSS and images, but it's fast and needs nothing else
+	//     installed, which is enough for simple invoices.
+	//   - `chromium` prints the page with a running chromium or chrome browser
+	//     over the DevTools protocol, so the document looks like it would when
+	//     printed from the browser. Start it with `chromium --headless
+	//     --remote-debugging-port=9222` and set ChromiumURL.
+	Backend string `json:"backend,omitempty"`
+
+	// The url of the DevTools HTTP endpoint of the browser for the `chromium`
+	// backend. Default `http://localhost:9222`.
+	ChromiumURL string `json:"chromium_url,omitempty"`
+
+	// The size of the pages: `A3`, `A4`, `A5`, `Letter`, or `Legal`. Default
+	// `A4`.
+	PageSize string `json:"page_size,omitempty"`
+
+	// Lay out pages in landscape orientation.
+	Landscape bool `json:"landscape,omitempty"`
+
+	// How long converting a document can take. Default `30s`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	templates     *template.Template
+	textTemplates *texttemplate.Template
+	backend       pdfBackend
+}
+
+// pdfBackend converts an html document to PDF.
+type pdfBackend interface {
+	convert(ctx context.Context, html string) ([]byte, error)
+}
+
+// pdfPageSizes are the sizes of pages in inches.
+var pdfPageSizes = map[string][2]float64{
+	"a3":     {11.69, 16.54},
+	"a4":     {8.27, 11.69},
+	"a5":     {5.83, 8.27},
+	"letter": {8.5, 11},
+	"legal":  {8.5, 14},
+}
+
+var _ DotConfig = &DotPDFConfig{}
+
+func (d *DotPDFConfig) FieldName() string { return d.Name }
+func (d *DotPDFConfig) Init(_ context.Context) error {
+	if d.PageSize == "" {
+		d.PageSize = "A4"
+	}
+	size, ok := pdfPageSizes[strings.ToLower(d.PageSize)]
+	if !ok {
+		return fmt.Errorf("unknown pdf page size '%s'", d.PageSize)
+	}
+	if d.Landscape {
+		size[0], size[1] = size[1], size[0]
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(30 * time.Second)
+	}
+	switch d.Backend {
+	case "", "basic":
+		d.Backend = "basic"
+		d.backend = &pdfBasic{pageSize: strings.ToLower(d.PageSize), landscape: d.Landscape}
+	case "chromium":
+		if d.ChromiumURL == "" {
+			d.ChromiumURL = "http://localhost:9222"
+		}
+		d.backend = &pdfChromium{endpoint: strings.TrimSuffix(d.ChromiumURL, "/"), width: size[0], height: size[1]}
+	default:
+		return fmt.Errorf("unknown pdf backend '%s'", d.Backend)
+	}
+	return nil
+}
+func (d *DotPDFConfig) Value(r Request) (any, error) {
+	return DotPDF{d, r.W, r.R}, nil
+}
+
+// DotPDF is used as the dot field to render templates to PDF, configured by
+// [DotPDFConfig].
+//
+//	{{define "GET /invoices/{id}/pdf"}}
+//	{{.PDF.Render "invoice" (.DB.QueryRow "SELECT * FROM invoices WHERE id=?" (.Req.PathValue "id"))}}
+//	{{end}}
+type DotPDF struct {
+	config *DotPDFConfig
+	w      http.ResponseWriter
+	r      *http.Request
+}
+
+// Render renders the template name with data to PDF and aborts execution of
+// the template to respond with it as a download instead. The file is named
+// after the template unless a filename is given.
+func (d DotPDF) Render(name string, data any, filename ...string) (string, error) {
+	content, err := d.Bytes(name, data)
+	if err != nil {
+		return "", err
+	}
+	var file string
+	switch len(filename) {
+	case 0:
+		file = strings.TrimSuffix(path.Base(name), path.Ext(name)) + ".pdf"
+	case 1:
+		file = filename[0]
+	default:
+		return "", fmt.Errorf("too many filename arguments provided: %v", filename)
+	}
+	GetLogger(d.r.Context()).Debug("serving pdf response", slog.String("template", name), slog.Int("size", len(content)))
+	d.w.Header().Set("Content-Type", "application/pdf")
+	d.w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file}))
+	http.ServeContent(d.w, d.r, file, time.Time{}, bytes.NewReader(content))
+	return "", ReturnError{}
+}
+
+// Bytes renders the template name with data to PDF and returns it, to attach
+// it to an email or store it in a bucket.
+func (d DotPDF) Bytes(name string, data any) ([]byte, error) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	if t := d.config.templates.Lookup(name); t != nil {
+		if err := t.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("failed to execute pdf template '%s': %w", name, err)
+		}
+	} else if t := d.config.textTemplates.Lookup(name); t != nil {
+		if err := t.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("failed to execute pdf template '%s': %w", name, err)
+		}
+	} else {
+		return nil, fmt.Errorf("failed to lookup pdf template '%s'", name)
+	}
+
+	ctx, cancel := context.WithTimeout(d.r.Context(), time.Duration(d.config.Timeout))
+	defer cancel()
+	defer startSpan(ctx, "pdf "+d.config.Backend)()
+	content, err := d.config.backend.convert(ctx, buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert template '%s' to pdf: %w", name, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,242 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// WithBucket creates an [xtemplate.Option] that adds an object storage
+// provider at the dot field name for a bucket in S3-compatible object
+// storage.
+func WithBucket(name string, config S3Config) Option {
+	return func(c *Config) error {
+		c.Buckets = append(c.Buckets, DotBucketConfig{Name: name, S3Config: config})
+		return nil
+	}
+}
+
+// DotBucketConfig configures a bucket in S3-compatible object storage that
+// templates can upload, download, list, and delete objects in.
+type DotBucketConfig struct {
+	Name string `json:"name"`
+
+	S3Config
+
+	client *s3Client
+}
+
+var _ DotConfig = &DotBucketConfig{}
+
+func (d *DotBucketConfig) FieldName() string { return d.Name }
+func (d *DotBucketConfig) Init(_ context.Context) error {
+	client, err := newS3Client(d.S3Config)
+	if err != nil {
+		return fmt.Errorf("failed to create s3 client for bucket '%s': %w", d.Name, err)
+	}
+	d.client = client
+	return nil
+}
+func (d *DotBucketConfig) Value(r Request) (any, error) {
+	return DotBucket{d.client, r.R}, nil
+}
+
+// DotBucket is used as the dot field to store files in a bucket in
+// S3-compatible object storage, configured by [DotBucketConfig]. Keys are
+// relative to the configured prefix.
+//
+//	{{define "POST /avatar"}}
+//	{{$obj := .S3.Upload "avatar" (print "avatars/" (uuidv7))}}
+//	<img src="{{.S3.PresignURL "GET" $obj.Key "1h"}}">
+//	{{end}}
+type DotBucket struct {
+	client *s3Client
+	r      *http.Request
+}
+
+// BucketObject describes an object in a bucket.
+type BucketObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	ContentType  string
+	// The name of the uploaded file, set by [DotBucket.Upload].
+	Filename string
+}
+
+// Put stores body, a string or bytes, at key with an optional content type.
+// Default content type is detected from the key's extension, or else the
+// content.
+func (d DotBucket) Put(key string, body any, contentType ...string) (BucketObject, error) {
+	var data []byte
+	switch b := body.(type) {
+	case string:
+		data = []byte(b)
+	case template.HTML:
+		data = []byte(b)
+	case []byte:
+		data = b
+	default:
+		return BucketObject{}, fmt.Errorf("put body must be a string or bytes, got %T", body)
+	}
+	var ctype string
+	switch len(contentType) {
+	case 0:
+		ctype = bucketContentType(key, data)
+	case 1:
+		ctype = contentType[0]
+	default:
+		return BucketObject{}, fmt.Errorf("too many contentType arguments provided: %v", contentType)
+	}
+	size, etag, err := d.client.upload(d.r.Context(), d.client.key(key), bytes.NewReader(data), ctype)
+	if err != nil {
+		return BucketObject{}, err
+	}
+	return BucketObject{Key: key, Size: size, ETag: etag, ContentType: ctype, LastModified: time.Now()}, nil
+}
+
+// Upload streams the file in the multipart form field of the request to key
+// without buffering the whole file, and returns the stored object with the
+// file's original Filename. If the request's form was already parsed, the
+// file is read from the parsed form.
+func (d DotBucket) Upload(field, key string) (BucketObject, error) {
+	var file io.Reader
+	var filename, ctype string
+	if d.r.MultipartForm != nil {
+		f, header, err := d.r.FormFile(field)
+		if err != nil {
+			return BucketObject{}, fmt.Errorf("failed to read form file '%s': %w", field, err)
+		}
+		defer f.Close()
+		file, filename, ctype = f, header.Filename, header.Header.Get("Content-Type")
+	} else {
+		mr, err := d.r.MultipartReader()
+		if err != nil {
+			return BucketObject{}, fmt.Errorf("failed to read multipart request: %w", err)
+		}
+		var part *multipart.Part
+		for {
+			part, err = mr.NextPart()
+			if err == io.EOF {
+				return BucketObject{}, fmt.Errorf("request has no form file '%s'", field)
+			} else if err != nil {
+				return BucketObject{}, fmt.Errorf("failed to read multipart request: %w", err)
+			}
+			if part.FormName() == field && part.FileName() != "" {
+				break
+			}
+			part.Close()
+		}
+		defer part.Close()
+		file, filename, ctype = part, part.FileName(), part.Header.Get("Content-Type")
+	}
+	if ctype == "" || ctype == "application/octet-stream" {
+		if c := bucketContentType(filename, nil); c != "" {
+			ctype = c
+		}
+	}
+	size, etag, err := d.client.upload(d.r.Context(), d.client.key(key), file, ctype)
+	if err != nil {
+		return BucketObject{}, err
+	}
+	return BucketObject{Key: key, Size: size, ETag: etag, ContentType: ctype, LastModified: time.Now(), Filename: filename}, nil
+}
+
+// Get returns the content of the object at key as a string.
+func (d DotBucket) Get(key string) (string, error) {
+	resp, err := d.client.do(d.r.Context(), "GET", d.client.key(key), nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get object '%s': %w", key, s3Error(resp))
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read object '%s': %w", key, err)
+	}
+	return string(body), nil
+}
+
+// List returns the objects whose keys start with prefix in key order, up to
+// an optional limit.
+func (d DotBucket) List(prefix string, limit ...int) ([]BucketObject, error) {
+	n := 0
+	switch len(limit) {
+	case 0:
+	case 1:
+		n = limit[0]
+	default:
+		return nil, fmt.Errorf("too many limit arguments provided: %v", limit)
+	}
+	base := d.client.key("")
+	if base != "" {
+		base += "/"
+	}
+	result, err := d.client.list(d.r.Context(), base+prefix, "", n)
+	if err != nil {
+		return nil, err
+	}
+	var objects []BucketObject
+	for _, o := range result.Contents {
+		if n > 0 && len(objects) == n {
+			break
+		}
+		objects = append(objects, BucketObject{Key: strings.TrimPrefix(o.Key, base), Size: o.Size, LastModified: o.LastModified, ETag: o.ETag})
+	}
+	return objects, nil
+}
+
+// Delete deletes the object at key. It returns an empty string.
+func (d DotBucket) Delete(key string) (string, error) {
+	resp, err := d.client.do(d.r.Context(), "DELETE", d.client.key(key), nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to delete object '%s': %w", key, s3Error(resp))
+	}
+	return "", nil
+}
+
+// PresignURL returns a url that anyone can use to request the object at key
+// with method, like `GET` to download it or `PUT` to upload it directly from
+// a browser, until expires has passed. The expiration can be a duration
+// string like `15m`, a [time.Duration], or a number of seconds, up to 7 days.
+func (d DotBucket) PresignURL(method, key string, expires any) (string, error) {
+	dur, err := parseTTL(expires)
+	if err != nil {
+		return "", err
+	}
+	if dur > 7*24*time.Hour {
+		return "", fmt.Errorf("presigned url expiration must be at most 7 days, got %s", dur)
+	}
+	return d.client.presign(strings.ToUpper(method), d.client.key(key), dur, time.Now())
+}
+
+// bucketContentType returns the content type of an object by the extension
+// of its name, or else detected from its content if it isn't nil.
+func bucketContentType(name string, data []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ctype, ok := extensionContentTypes[ext]; ok {
+		return ctype
+	}
+	if ctype := mime.TypeByExtension(ext); ctype != "" {
+		return ctype
+	}
+	if data != nil {
+		return http.DetectContentType(data)
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package xtemplate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// WithScript creates an [xtemplate.Option] that adds a script provider at the
+// dot field name that runs the javascript files in the directory path.
+func WithScript(name, path string) Option {
+	return func(c *Config) error {
+		c.Scripts = append(c.Scripts, DotScriptConfig{Name: name, Path: path})
+		return nil
+	}
+}
+
+// DotScriptConfig configures a directory of javascript files that templates
+// can run for logic that is awkward to write in template syntax, like pricing
+// rules. Scripts are compiled when the instance is created, and each run uses
+// a new javascript runtime, so runs can't affect each other.
+//
+// Keep scripts outside of the templates directory, where they would be served
+// as static files.
+type DotScriptConfig struct {
+	Name string `json:"name"`
+
+	// The directory of the `.js` files to run.
+	Path string `json:"path"`
+
+	// How long a script can run before it's interrupted. Default `1s`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// A guard against runaway scripts that interrupts a script if the heap of
+	// the whole process grows by more than this many bytes while it runs.
+	// It's not a limit on the memory of each script: the heap is sampled
+	// every 10ms, so memory allocated by other requests at the same time
+	// counts too, and a script can exceed it between samples. Default
+	// `67108864` (64MiB).
+	MaxMemory int `json:"max_memory,omitempty"`
+
+	programs map[string]*goja.Program
+}
+
+var _ DotConfig = &DotScriptConfig{}
+
+func (d *DotScriptConfig) FieldName() string { return d.Name }
+func (d *DotScriptConfig) Init(_ context.Context) error {
+	if d.Timeout == 0 {
+		d.Timeout = Duration(time.Second)
+	}
+	if d.MaxMemory == 0 {
+		d.MaxMemory = 64 << 20
+	}
+	d.programs = make(map[string]*goja.Program)
+	fsys := os.DirFS(d.Path)
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, ".js") {
+			return err
+		}
+		src, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("failed to read script '%s': %w", path, err)
+		}
+		program, err := goja.Compile(path, string(src), true)
+		if err != nil {
+			return fmt.Errorf("failed to compile script '%s': %w", path, err)
+		}
+		d.programs[path] = program
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load scripts from '%s': %w", d.Path, err)
+	}
+	return nil
+}
+func (d *DotScriptConfig) Value(r Request) (any, error) {
+	return DotScript{d, r.R.Context()}, nil
+}
+
+// DotScript is used as the dot field to run javascript files, configured by
+// [DotScriptConfig].
+//
+// The arguments of a run are in the global `args` array, and the result is
+// the value of the script's last statement:
+//
+//	// lib/pricing.js
+//	const [items, coupon] = args;
+//	const total = items.reduce((sum, item) => sum + item.price * item.qty, 0);
+//	coupon === "HALF" ? total / 2 : total;
+//
+//	<p>Total: {{.Script.Run "lib/pricing.js" $items (.Req.FormValue "coupon")}}</p>
+type DotScript struct {
+	config *DotScriptConfig
+	ctx    context.Context
+}
+
+var (
+	errScriptTimeout = errors.New("timed out")
+	errScriptMemory  = errors.New("interrupted because the heap grew too much")
+)
+
+// Run runs the script at path, relative to the configured directory, with
+// args and returns its result. Javascript objects and arrays are returned as
+// maps and slices.
+func (d DotScript) Run(path string, args ...any) (any, error) {
+	program, ok := d.config.programs[path]
+	if !ok {
+		return nil, fmt.Errorf("script '%s' does not exist", path)
+	}
+	vm := goja.New()
+	vm.SetMaxCallStackSize(1024)
+	if err := vm.Set("args", args); err != nil {
+		return nil, fmt.Errorf("failed to set args of script '%s': %w", path, err)
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, time.Duration(d.config.Timeout))
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			vm.Interrupt(errScriptTimeout)
+		} else {
+			vm.Interrupt(ctx.Err())
+		}
+	})
+	defer stop()
+	go watchScriptMemory(ctx, uint64(d.config.MaxMemory), func() { vm.Interrupt(errScriptMemory) })
+
+	defer startSpan(d.ctx, "script "+path)()
+	result, err := vm.RunProgram(program)
+	if err != nil {
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			if reason, ok := interrupted.Value().(error); ok {
+				return nil, fmt.Errorf("script '%s' %w", path, reason)
+			}
+		}
+		return nil, fmt.Errorf("script '%s' failed: %w", path, err)
+	}
+	return result.Export(), nil
+}
+
+// watchScriptMemory calls interrupt if the heap of the process grows by more
+// than max bytes before ctx is done. Goja can't measure the memory of a
+// runtime, so this guards the process from scripts that allocate a lot
+// quickly, but also counts allocations by anything else running at the same
+// time.
+func watchScriptMemory(ctx context.Context, max uint64, interrupt func()) {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	start := sample[0].Value.Uint64()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.Read(sample)
+			if heap := sample[0].Value.Uint64(); heap > start && heap-start > max {
+				interrupt()
+				return
+			}
+		}
+	}
+}
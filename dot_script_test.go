@@ -0,0 +1,50 @@
+package xtemplate
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWatchScriptMemory(t *testing.T) {
+	// collect garbage left by other tests first, so freeing it doesn't offset
+	// the growth below
+	runtime.GC()
+	interrupted := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchScriptMemory(ctx, 16<<20, func() { close(interrupted) })
+
+	select {
+	case <-interrupted:
+		t.Fatalf("interrupted before the heap grew")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	grown := make([]byte, 64<<20)
+	for i := range grown {
+		grown[i] = 1
+	}
+	select {
+	case <-interrupted:
+	case <-time.After(time.Second):
+		t.Fatalf("not interrupted after the heap grew by %d bytes", len(grown))
+	}
+	runtime.KeepAlive(grown)
+}
+
+func TestWatchScriptMemoryStops(t *testing.T) {
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		watchScriptMemory(ctx, 16<<20, func() { t.Errorf("interrupted after ctx was done") })
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("still watching after ctx was done")
+	}
+}
@@ -0,0 +1,248 @@
+package xtemplate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// WithSearch creates an [xtemplate.Option] that adds a full-text search
+// provider at the dot field name.
+func WithSearch(name string) Option {
+	return func(c *Config) error {
+		c.Search = append(c.Search, DotSearchConfig{Name: name})
+		return nil
+	}
+}
+
+// DotSearchConfig configures an in-memory full-text search index that is
+// shared by all requests to an instance. The index is rebuilt when the
+// instance is created: from the rows of Query, and from INIT templates that
+// call [DotSearch.Index].
+type DotSearchConfig struct {
+	Name string `json:"name"`
+
+	// The name of a configured database to run Query on.
+	Database string `json:"database,omitempty"`
+
+	// A SQL query whose rows are indexed when the instance is created. The
+	// `id` column is the id of each document, and the other columns are its
+	// fields.
+	Query string `json:"query,omitempty"`
+
+	// The maximum number of hits returned by a query. Default `10`.
+	Size int `json:"size,omitempty"`
+
+	// The maximum number of terms returned for each facet. Default `10`.
+	FacetSize int `json:"facet_size,omitempty"`
+
+	db    *DotDBConfig
+	index bleve.Index
+}
+
+var _ DotConfig = &DotSearchConfig{}
+
+func (d *DotSearchConfig) FieldName() string { return d.Name }
+func (d *DotSearchConfig) Init(ctx context.Context) error {
+	if d.Size == 0 {
+		d.Size = 10
+	}
+	if d.FacetSize == 0 {
+		d.FacetSize = 10
+	}
+	if d.Query != "" && (d.db == nil || d.db.DB == nil) {
+		return fmt.Errorf("search database '%s' is not a configured database", d.Database)
+	}
+	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+	d.index = index
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			index.Close()
+		}()
+	}
+	if d.Query != "" {
+		if err := d.indexQuery(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (d *DotSearchConfig) Value(r Request) (any, error) {
+	return DotSearch{d, r.R.Context()}, nil
+}
+
+// indexQuery indexes the rows of the configured query in one batch.
+func (d *DotSearchConfig) indexQuery(ctx context.Context) error {
+	rows, err := d.db.DB.QueryContext(ctx, d.Query)
+	if err != nil {
+		return fmt.Errorf("failed to query documents to index: %w", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to get columns of documents to index: %w", err)
+	}
+	batch := d.index.NewBatch()
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("failed to scan document to index: %w", err)
+		}
+		var id string
+		doc := make(map[string]any, len(columns))
+		for i, column := range columns {
+			v := values[i]
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			if column == "id" {
+				id = fmt.Sprint(v)
+				continue
+			}
+			doc[column] = v
+		}
+		if id == "" {
+			return fmt.Errorf("search query must return an 'id' column")
+		}
+		if err := batch.Index(id, doc); err != nil {
+			return fmt.Errorf("failed to index document '%s': %w", id, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query documents to index: %w", err)
+	}
+	if err := d.index.Batch(batch); err != nil {
+		return fmt.Errorf("failed to index documents: %w", err)
+	}
+	return nil
+}
+
+// DotSearch is used as the dot field to index documents and search them,
+// configured by [DotSearchConfig].
+//
+//	{{define "INIT index posts"}}
+//	{{range .DB.QueryRows `SELECT id, title, body, tag FROM posts`}}{{$.Search.Index .id .}}{{end}}
+//	{{end}}
+//
+//	{{$results := .Search.Query (.Req.URL.Query.Get "q") "tag"}}
+//	<p>{{$results.Total}} results</p>
+//	{{range $results.Hits}}<a href="/posts/{{.ID}}">{{.Fields.title}}</a>{{end}}
+type DotSearch struct {
+	config *DotSearchConfig
+	ctx    context.Context
+}
+
+// SearchResult is the result of [DotSearch.Query].
+type SearchResult struct {
+	// The number of documents that match the query, which can be more than
+	// the number of Hits.
+	Total int
+	// The best matching documents, best first.
+	Hits []SearchHit
+	// The most common terms of each facet field among the matching documents.
+	Facets map[string]SearchFacet
+}
+
+// SearchHit is a document that matches a query.
+type SearchHit struct {
+	ID     string
+	Score  float64
+	Fields map[string]any
+}
+
+// SearchFacet counts the matching documents by the terms of a field.
+type SearchFacet struct {
+	Terms []SearchFacetTerm
+	// The number of matching documents without the field.
+	Missing int
+	// The number of matching documents with terms that aren't in Terms.
+	Other int
+}
+
+// SearchFacetTerm is a term of a facet field, and the number of matching
+// documents with it.
+type SearchFacetTerm struct {
+	Term  string
+	Count int
+}
+
+// Index adds doc to the index with id, replacing the document with the same
+// id. The fields of doc are indexed by their names. It returns an empty
+// string.
+func (d DotSearch) Index(id any, doc any) (string, error) {
+	if err := d.config.index.Index(fmt.Sprint(id), doc); err != nil {
+		return "", fmt.Errorf("failed to index document '%v': %w", id, err)
+	}
+	return "", nil
+}
+
+// Delete removes the document with id from the index. It returns an empty
+// string.
+func (d DotSearch) Delete(id any) (string, error) {
+	if err := d.config.index.Delete(fmt.Sprint(id)); err != nil {
+		return "", fmt.Errorf("failed to delete document '%v': %w", id, err)
+	}
+	return "", nil
+}
+
+// Query searches all fields of the indexed documents for any of the words of
+// q, which can be user input, and counts the matching documents by the terms
+// of the facets fields. An empty q matches all documents.
+func (d DotSearch) Query(q string, facets ...string) (SearchResult, error) {
+	var match query.Query = bleve.NewMatchAllQuery()
+	if q != "" {
+		match = bleve.NewMatchQuery(q)
+	}
+	return d.search(q, match, facets)
+}
+
+// QueryString searches the index with q in bleve's query string syntax, like
+// `+title:go tutorial`, and counts the matching documents by the terms of the
+// facets fields. The syntax can match any field and expensive patterns, so q
+// should be written by the template instead of coming from user input.
+func (d DotSearch) QueryString(q string, facets ...string) (SearchResult, error) {
+	return d.search(q, bleve.NewQueryStringQuery(q), facets)
+}
+
+func (d DotSearch) search(q string, match query.Query, facets []string) (SearchResult, error) {
+	req := bleve.NewSearchRequestOptions(match, d.config.Size, 0, false)
+	req.Fields = []string{"*"}
+	for _, field := range facets {
+		req.AddFacet(field, bleve.NewFacetRequest(field, d.config.FacetSize))
+	}
+
+	defer startSpan(d.ctx, "search "+d.config.Name)()
+	res, err := d.config.index.SearchInContext(d.ctx, req)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("failed to search for '%s': %w", q, err)
+	}
+
+	result := SearchResult{
+		Total:  int(res.Total),
+		Hits:   make([]SearchHit, 0, len(res.Hits)),
+		Facets: make(map[string]SearchFacet, len(res.Facets)),
+	}
+	for _, hit := range res.Hits {
+		result.Hits = append(result.Hits, SearchHit{ID: hit.ID, Score: hit.Score, Fields: hit.Fields})
+	}
+	for name, f := range res.Facets {
+		facet := SearchFacet{Terms: []SearchFacetTerm{}, Missing: f.Missing, Other: f.Other}
+		if f.Terms != nil {
+			for _, t := range f.Terms.Terms() {
+				facet.Terms = append(facet.Terms, SearchFacetTerm{t.Term, t.Count})
+			}
+		}
+		result.Facets[name] = facet
+	}
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package xtemplate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// WithSqlite creates an [xtemplate.Option] that adds a sqlite maintenance
+// provider at the dot field name for the configured database named database.
+func WithSqlite(name, database string) Option {
+	return func(c *Config) error {
+		c.Sqlite = append(c.Sqlite, DotSqliteConfig{Name: name, Database: database})
+		return nil
+	}
+}
+
+// DotSqliteConfig configures maintenance operations on a configured sqlite
+// database, like backups from a protected admin page or a CRON template.
+type DotSqliteConfig struct {
+	Name string `json:"name"`
+
+	// The name of a configured database that uses a sqlite driver.
+	Database string `json:"database"`
+
+	db *DotDBConfig
+}
+
+var _ DotConfig = &DotSqliteConfig{}
+
+func (d *DotSqliteConfig) FieldName() string { return d.Name }
+func (d *DotSqliteConfig) Init(ctx context.Context) error {
+	if d.db == nil || d.db.DB == nil {
+		return fmt.Errorf("sqlite database '%s' is not a configured database", d.Database)
+	}
+	var version string
+	if err := d.db.DB.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version); err != nil {
+		return fmt.Errorf("database '%s' is not a sqlite database: %w", d.Database, err)
+	}
+	return nil
+}
+func (d *DotSqliteConfig) Value(r Request) (any, error) {
+	return DotSqlite{d.db.DB, r.R.Context()}, nil
+}
+
+// DotSqlite is used as the dot field to maintain a sqlite database,
+// configured by [DotSqliteConfig]. Operations use their own connection, not
+// the transaction of the `.DB` provider.
+//
+//	{{define "CRON @daily /backup"}}
+//	{{.Sqlite.Backup (print "backups/" (now | date "2006-01-02") ".sqlite")}}
+//	{{end}}
+type DotSqlite struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// SqliteCheckpoint is the result of [DotSqlite.Checkpoint].
+type SqliteCheckpoint struct {
+	// Whether the checkpoint couldn't finish because of other connections.
+	Busy bool
+	// The number of frames in the write-ahead log, and how many of them were
+	// copied into the database. Both are -1 if the database isn't in WAL mode.
+	Log          int
+	Checkpointed int
+}
+
+// Backup writes a consistent copy of the database to a new file at path,
+// which must not exist, while other connections keep reading and writing. It
+// returns an empty string.
+func (d DotSqlite) Backup(path string) (string, error) {
+	if _, err := d.db.ExecContext(d.ctx, "VACUUM INTO ?", path); err != nil {
+		return "", fmt.Errorf("failed to back up sqlite database to '%s': %w", path, err)
+	}
+	return "", nil
+}
+
+// Vacuum rebuilds the database file to reclaim unused space. It needs
+// exclusive access, so it fails if other connections are writing. It returns
+// an empty string.
+func (d DotSqlite) Vacuum() (string, error) {
+	if _, err := d.db.ExecContext(d.ctx, "VACUUM"); err != nil {
+		return "", fmt.Errorf("failed to vacuum sqlite database: %w", err)
+	}
+	return "", nil
+}
+
+// Checkpoint copies the write-ahead log into the database. The optional mode
+// is `PASSIVE`, `FULL`, `RESTART`, or `TRUNCATE`. Default `TRUNCATE`, which
+// also truncates the log file.
+func (d DotSqlite) Checkpoint(mode ...string) (SqliteCheckpoint, error) {
+	m := "TRUNCATE"
+	switch len(mode) {
+	case 0:
+	case 1:
+		m = strings.ToUpper(mode[0])
+	default:
+		return SqliteCheckpoint{}, fmt.Errorf("too many mode arguments provided: %v", mode)
+	}
+	switch m {
+	case "PASSIVE", "FULL", "RESTART", "TRUNCATE":
+	default:
+		return SqliteCheckpoint{}, fmt.Errorf("unknown sqlite checkpoint mode '%s'", m)
+	}
+	var result SqliteCheckpoint
+	var busy int
+	if err := d.db.QueryRowContext(d.ctx, "PRAGMA wal_checkpoint("+m+")").Scan(&busy, &result.Log, &result.Checkpointed); err != nil {
+		return SqliteCheckpoint{}, fmt.Errorf("failed to checkpoint sqlite database: %w", err)
+	}
+	result.Busy = busy != 0
+	return result, nil
+}
+
+// IntegrityCheck checks the whole database for corruption and returns the
+// problems it finds, or an empty list if there are none.
+func (d DotSqlite) IntegrityCheck() ([]string, error) {
+	rows, err := d.db.QueryContext(d.ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check sqlite database integrity: %w", err)
+	}
+	defer rows.Close()
+	problems := []string{}
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, fmt.Errorf("failed to check sqlite database integrity: %w", err)
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to check sqlite database integrity: %w", err)
+	}
+	return problems, nil
+}
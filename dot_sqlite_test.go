@@ -0,0 +1,50 @@
+package xtemplate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSqliteBackup(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "db.sqlite")+"?_journal_mode=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE notes(body TEXT); INSERT INTO notes VALUES ('hello');"); err != nil {
+		t.Fatal(err)
+	}
+	d := DotSqlite{db, context.Background()}
+
+	if problems, err := d.IntegrityCheck(); err != nil || len(problems) != 0 {
+		t.Fatalf("integrity check found %q, %v", problems, err)
+	}
+	if result, err := d.Checkpoint("passive"); err != nil || result.Busy || result.Log < result.Checkpointed {
+		t.Fatalf("checkpoint %+v, %v", result, err)
+	}
+	if _, err := d.Checkpoint("sideways"); err == nil {
+		t.Fatalf("checkpointed with an unknown mode")
+	}
+
+	backup := filepath.Join(dir, "backup.sqlite")
+	if _, err := d.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Backup(backup); err == nil {
+		t.Fatalf("backed up over an existing file")
+	}
+	copied, err := sql.Open("sqlite3", backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	var body string
+	if err := copied.QueryRow("SELECT body FROM notes").Scan(&body); err != nil || body != "hello" {
+		t.Fatalf("backup has %q, %v, want hello", body, err)
+	}
+}
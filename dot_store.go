@@ -0,0 +1,273 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// WithStore creates an [xtemplate.Option] that adds a persistent key-value
+// store provider at the dot field name, backed by the database file at path.
+func WithStore(name string, path string) Option {
+	return func(c *Config) error {
+		c.Stores = append(c.Stores, DotStoreConfig{Name: name, Path: path})
+		return nil
+	}
+}
+
+// DotStoreConfig configures a persistent key-value store kept in a bbolt
+// database file, for small apps that need to remember values across restarts
+// without a SQL database or NATS.
+type DotStoreConfig struct {
+	Name string `json:"name"`
+
+	// The path of the database file, which is created if it doesn't exist.
+	Path string `json:"path"`
+
+	// The bucket in the file to keep keys in, so several stores can share a
+	// file. Default the Name.
+	Bucket string `json:"bucket,omitempty"`
+
+	db *storeDB
+}
+
+var _ DotConfig = &DotStoreConfig{}
+
+func (d *DotStoreConfig) FieldName() string { return d.Name }
+func (d *DotStoreConfig) Init(ctx context.Context) error {
+	if d.Path == "" {
+		return fmt.Errorf("store path is required")
+	}
+	if d.Bucket == "" {
+		d.Bucket = d.Name
+	}
+	db, err := openStoreDB(d.Path)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(d.Bucket))
+		return err
+	})
+	if err != nil {
+		db.release()
+		return fmt.Errorf("failed to create store bucket '%s': %w", d.Bucket, err)
+	}
+	d.db = db
+	// release the file when the instance is cancelled
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			db.release()
+		}()
+	}
+	return nil
+}
+func (d *DotStoreConfig) Value(r Request) (any, error) {
+	return DotStore{d.db, []byte(d.Bucket)}, nil
+}
+
+// storeDB is a bbolt database shared by all stores and instances that use the
+// same file, since bbolt locks the file while it's open and a reloaded
+// instance opens it before the previous instance closes it.
+type storeDB struct {
+	*bbolt.DB
+	path string
+	refs int
+	stop chan struct{}
+}
+
+var (
+	storeDBsMutex sync.Mutex
+	storeDBs      = map[string]*storeDB{}
+)
+
+func openStoreDB(path string) (*storeDB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve store path '%s': %w", path, err)
+	}
+	storeDBsMutex.Lock()
+	defer storeDBsMutex.Unlock()
+	if db, ok := storeDBs[abs]; ok {
+		db.refs++
+		return db, nil
+	}
+	bdb, err := bbolt.Open(abs, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store file '%s': %w", path, err)
+	}
+	db := &storeDB{DB: bdb, path: abs, refs: 1, stop: make(chan struct{})}
+	storeDBs[abs] = db
+	go db.sweep(time.Minute)
+	return db, nil
+}
+
+// release closes the file when the last store that uses it is released.
+func (db *storeDB) release() {
+	storeDBsMutex.Lock()
+	defer storeDBsMutex.Unlock()
+	db.refs--
+	if db.refs > 0 {
+		return
+	}
+	delete(storeDBs, db.path)
+	close(db.stop)
+	db.Close()
+}
+
+// sweep periodically deletes expired entries from every bucket.
+func (db *storeDB) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		db.Update(func(tx *bbolt.Tx) error {
+			return tx.ForEach(func(_ []byte, b *bbolt.Bucket) error {
+				var expired [][]byte
+				b.ForEach(func(k, v []byte) error {
+					if e, err := decodeStoreEntry(v); err == nil && e.expired(now) {
+						expired = append(expired, k)
+					}
+					return nil
+				})
+				for _, k := range expired {
+					if err := b.Delete(k); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	}
+}
+
+// storeEntry is how values are encoded in the file.
+type storeEntry struct {
+	Value   any   `json:"v"`
+	Expires int64 `json:"e,omitempty"`
+}
+
+func (e storeEntry) expired(now time.Time) bool {
+	return e.Expires != 0 && now.UnixNano() >= e.Expires
+}
+
+func decodeStoreEntry(data []byte) (storeEntry, error) {
+	var e storeEntry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&e)
+	return e, err
+}
+
+// DotStore is used as the dot field to read and write values that persist
+// across restarts, configured by [DotStoreConfig]. Values can be strings,
+// numbers, bools, lists, or dicts, and are stored as JSON. TTLs can be a
+// duration string like `5m`, a [time.Duration], or a number of seconds.
+//
+//	{{.Store.Set (print "draft:" $id) (dict "title" $title "body" $body) "24h"}}
+//	{{range .Store.List "draft:"}}<li>{{.Key}}: {{.Value.title}}</li>{{end}}
+type DotStore struct {
+	db     *storeDB
+	bucket []byte
+}
+
+// StoreEntry is a key and value returned by [DotStore.List].
+type StoreEntry struct {
+	Key   string
+	Value any
+}
+
+// Get returns the value stored at key, or nil if it is missing or expired.
+func (d DotStore) Get(key string) (any, error) {
+	var value any
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(d.bucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		e, err := decodeStoreEntry(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode value of key '%s': %w", key, err)
+		}
+		if !e.expired(time.Now()) {
+			value = e.Value
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Set stores value at key, with an optional ttl after which it expires. It
+// returns an empty string.
+func (d DotStore) Set(key string, value any, ttl ...any) (string, error) {
+	e := storeEntry{Value: value}
+	switch len(ttl) {
+	case 0:
+	case 1:
+		dur, err := parseTTL(ttl[0])
+		if err != nil {
+			return "", err
+		}
+		e.Expires = time.Now().Add(dur).UnixNano()
+	default:
+		return "", fmt.Errorf("too many ttl arguments provided: %v", ttl)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode value of key '%s': %w", key, err)
+	}
+	return "", d.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.bucket).Put([]byte(key), data)
+	})
+}
+
+// Delete removes the value stored at key. It returns an empty string.
+func (d DotStore) Delete(key string) (string, error) {
+	return "", d.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(d.bucket).Delete([]byte(key))
+	})
+}
+
+// List returns the entries whose keys start with prefix in key order,
+// skipping expired entries. An optional limit caps the number of entries
+// returned.
+func (d DotStore) List(prefix string, limit ...int) ([]StoreEntry, error) {
+	count := -1
+	switch len(limit) {
+	case 0:
+	case 1:
+		count = limit[0]
+	default:
+		return nil, fmt.Errorf("too many limit arguments provided: %v", limit)
+	}
+	var entries []StoreEntry
+	now := time.Now()
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(d.bucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p) && len(entries) != count; k, v = c.Next() {
+			e, err := decodeStoreEntry(v)
+			if err != nil {
+				return fmt.Errorf("failed to decode value of key '%s': %w", k, err)
+			}
+			if e.expired(now) {
+				continue
+			}
+			entries = append(entries, StoreEntry{string(k), e.Value})
+		}
+		return nil
+	})
+	return entries, err
+}
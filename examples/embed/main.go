@@ -0,0 +1,19 @@
+// This example embeds its templates directory into the binary so it can be
+// deployed as a single file. Build it with:
+//
+//	go build -o xtemplate-embed ./examples/embed
+package main
+
+import (
+	"embed"
+
+	"github.com/infogulch/xtemplate"
+	"github.com/infogulch/xtemplate/app"
+)
+
+//go:embed templates
+var templates embed.FS
+
+func main() {
+	app.Main(xtemplate.WithTemplateFS(templates, "templates"))
+}
@@ -77,6 +77,17 @@ func extractFrontMatter(input string) (map[string]any, string, error) {
 	return fm, body, nil
 }
 
+// extractTemplateFrontMatter extracts the front matter of an html template
+// file, which must open with a `---` or `+++` fence on its very first line.
+// JSON front matter isn't recognized, since a template can start with `{`.
+func extractTemplateFrontMatter(input string) (map[string]any, string, error) {
+	line, _, _ := strings.Cut(input, "\n")
+	if line = strings.TrimSuffix(line, "\r"); line != "---" && line != "+++" {
+		return nil, input, nil
+	}
+	return extractFrontMatter(input)
+}
+
 func yamlFrontMatter(input []byte) (map[string]any, error) {
 	m := make(map[string]any)
 	err := yaml.Unmarshal(input, &m)
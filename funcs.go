@@ -4,28 +4,29 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
 	"github.com/dustin/go-humanize"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/yuin/goldmark"
-	highlighting "github.com/yuin/goldmark-highlighting/v2"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
 )
 
 var xtemplateFuncs template.FuncMap = template.FuncMap{
 	"sanitizeHtml":     FuncSanitizeHtml,
 	"markdown":         FuncMarkdown,
+	"markdownTOC":      FuncMarkdownTOC,
 	"splitFrontMatter": FuncSplitFrontMatter,
 	"return":           FuncReturn,
 	"failf":            FuncFailf,
-	"humanize":         FuncHumanize,
+	"humanize":         FuncHumanizeValue,
 	"trustHtml":        FuncTrustHtml,
 	"trustAttr":        FuncTrustAttr,
 	"trustJS":          FuncTrustJS,
@@ -33,21 +34,77 @@ var xtemplateFuncs template.FuncMap = template.FuncMap{
 	"trustSrcSet":      FuncTrustSrcSet,
 	"idx":              FuncIdx,
 	"try":              FuncTry,
+	"fromYaml":         FuncFromYaml,
+	"toYaml":           FuncToYaml,
+	"fromToml":         FuncFromToml,
+	"parseCsv":         FuncParseCsv,
+	"toCsv":            FuncToCsv,
+	"toXml":            FuncToXml,
+	"fromXml":          FuncFromXml,
+	"uuidv7":           FuncUuidv7,
+	"ksuid":            FuncKsuid,
+	"nanoid":           FuncNanoid,
+	"sha256":           FuncSha256,
+	"sha384":           FuncSha384,
+	"md5":              FuncMd5,
+	"hmacSha256":       FuncHmacSha256,
+	"secureCompare":    FuncSecureCompare,
+	"randomToken":      FuncRandomToken,
+	"reMatch":          FuncReMatch,
+	"reFind":           FuncReFind,
+	"reFindAll":        FuncReFindAll,
+	"reReplace":        FuncReReplace,
+	"slugify":          FuncSlugify,
+	"stripTags":        FuncStripTags,
+	"excerpt":          FuncExcerpt,
+	"readingTime":      FuncReadingTime,
+	"diff":             FuncDiff,
+	"sparkline":        FuncSparkline,
+	"lineChart":        FuncLineChart,
+	"barChart":         FuncBarChart,
+	"newFeed":          FuncNewFeed,
+	"validEmail":       FuncValidEmail,
+	"emailDomain":      FuncEmailDomain,
+	"obfuscateEmail":   FuncObfuscateEmail,
+	"parsePhone":       FuncParsePhone,
+	"b64enc":           FuncB64enc,
+	"b64urlenc":        FuncB64urlenc,
+	"b64dec":           FuncB64dec,
+	"hexenc":           FuncHexenc,
+	"hexdec":           FuncHexdec,
+	"gravatar":         FuncGravatar,
+	"identicon":        FuncIdenticon,
+	"pluralize":        FuncPluralize,
+	"ordinal":          FuncOrdinal,
+	"formatTime":       FuncFormatTime,
+	"formatDateLocale": FuncFormatDateLocale,
+	"sumBy":            FuncSumBy,
+	"avgBy":            FuncAvgBy,
+	"minBy":            FuncMinBy,
+	"maxBy":            FuncMaxBy,
+	"groupBy":          FuncGroupBy,
+	"formatNumber":     FuncFormatNumber,
+	"formatCurrency":   FuncFormatCurrency,
 }
 
+var blueMondayPoliciesMutex sync.RWMutex
+
 // blueMondayPolicies is the map of names of bluemonday policies available to
-// templates.
+// all instances, which [Config.SanitizePolicies] can override or add to.
 var blueMondayPolicies map[string]*bluemonday.Policy = map[string]*bluemonday.Policy{
-	"strict": bluemonday.StrictPolicy(),
-	"ugc":    bluemonday.UGCPolicy(),
-	"externalugc": bluemonday.UGCPolicy().
-		AddTargetBlankToFullyQualifiedLinks(true).
-		AllowRelativeURLs(false),
+	"strict":      sanitizeBasePolicies["strict"](),
+	"ugc":         sanitizeBasePolicies["ugc"](),
+	"externalugc": sanitizeBasePolicies["externalugc"](),
 }
 
 // AddBlueMondayPolicy adds a bluemonday policy to the global policy list available to all
 // xtemplate instances.
+//
+// Deprecated: configure sanitization policies per instance with
+// [Config.SanitizePolicies].
 func AddBlueMondayPolicy(name string, policy *bluemonday.Policy) {
+	blueMondayPoliciesMutex.Lock()
+	defer blueMondayPoliciesMutex.Unlock()
 	if old, ok := blueMondayPolicies[name]; ok {
 		panic(fmt.Sprintf("bluemonday policy with name %s already exists: %v", name, old))
 	}
@@ -55,39 +112,34 @@ func AddBlueMondayPolicy(name string, policy *bluemonday.Policy) {
 }
 
 // sanitizeHtml Uses the BlueMonday library to sanitize strings with html content.
-// First parameter is the name of the chosen sanitization policy.
+// First parameter is the name of the chosen sanitization policy: `strict`,
+// `ugc`, `externalugc`, or a policy configured with [Config.SanitizePolicies].
 func FuncSanitizeHtml(policyName string, html string) (template.HTML, error) {
-	policy, ok := blueMondayPolicies[policyName]
-	if !ok {
-		return "", fmt.Errorf("failed to find policy name '%s'", policyName)
-	}
-	return template.HTML(policy.Sanitize(html)), nil
+	blueMondayPoliciesMutex.RLock()
+	defer blueMondayPoliciesMutex.RUnlock()
+	return sanitizeHtml(blueMondayPolicies, policyName, html)
 }
 
-var mdOpts = []goldmark.Option{
-	goldmark.WithExtensions(
-		extension.GFM,
-		extension.Footnote,
-		highlighting.NewHighlighting(
-			highlighting.WithFormatOptions(
-				chromahtml.WithClasses(true),
-			),
-		),
-	),
-	goldmark.WithParserOptions(
-		parser.WithAutoHeadingID(),
-	),
+// markdownConfigs are the markdown renderers available to all instances,
+// which [Config.Markdown] can override or add to.
+var markdownConfigs map[string]goldmark.Markdown = map[string]goldmark.Markdown{
+	"default": must(MarkdownConfig{Name: "default"}.New()),
+	"unsafe":  must(MarkdownConfig{Name: "unsafe", Unsafe: true}.New()),
 }
 
-var markdownConfigs map[string]goldmark.Markdown = map[string]goldmark.Markdown{
-	"default": goldmark.New(mdOpts...),
-	"unsafe": goldmark.New(append(mdOpts,
-		goldmark.WithRendererOptions(gmhtml.WithUnsafe()),
-	)...),
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
 }
 
 // AddMarkdownConifg adds a custom markdown configuration to xtemplate's
 // markdown config map, available to all xtemplate instances.
+//
+// Deprecated: configure markdown renderers per instance with
+// [Config.Markdown], and add custom goldmark extensions they can enable with
+// [AddMarkdownExtension].
 func AddMarkdownConifg(name string, md goldmark.Markdown) {
 	if old, ok := markdownConfigs[name]; ok {
 		panic(fmt.Sprintf("markdown policy with name %s already exists: %v", name, old))
@@ -101,29 +153,118 @@ func AddMarkdownConifg(name string, md goldmark.Markdown) {
 // extensions enabled: Github Flavored Markdown, Footnote, and syntax
 // highlighting provided by Chroma.
 func FuncMarkdown(input string, configName ...string) (template.HTML, error) {
+	doc, err := renderMarkdown(markdownConfigs, input, configName)
+	return doc.HTML, err
+}
+
+// MarkdownDoc is markdown rendered as html along with its table of contents.
+type MarkdownDoc struct {
+	HTML template.HTML
+	TOC  []*TOCEntry
+}
+
+// TOCEntry is a heading in a markdown document. The ID is the heading's
+// anchor, so it can be linked to like `<a href="#{{.ID}}">{{.Title}}</a>`.
+type TOCEntry struct {
+	Level    int
+	ID       string
+	Title    string
+	Children []*TOCEntry
+}
+
+// markdownTOC is like markdown, but also returns the tree of the document's
+// headings so templates can render a table of contents. Each heading is given
+// an id generated from its text.
+func FuncMarkdownTOC(input string, configName ...string) (MarkdownDoc, error) {
+	return renderMarkdown(markdownConfigs, input, configName)
+}
+
+// renderMarkdown is the `markdown` func of an instance, which uses the
+// instance's markdown configs.
+func (x *Instance) renderMarkdown(input string, configName ...string) (template.HTML, error) {
+	doc, err := renderMarkdown(x.markdown, input, configName)
+	return doc.HTML, err
+}
+
+// renderMarkdownTOC is the `markdownTOC` func of an instance.
+func (x *Instance) renderMarkdownTOC(input string, configName ...string) (MarkdownDoc, error) {
+	return renderMarkdown(x.markdown, input, configName)
+}
+
+func renderMarkdown(configs map[string]goldmark.Markdown, input string, configName []string) (MarkdownDoc, error) {
 	config := "default"
 	switch len(configName) {
 	case 0:
 	case 1:
 		config = configName[0]
 	default:
-		return "", fmt.Errorf("too many configName arguments provided: %v", configName)
+		return MarkdownDoc{}, fmt.Errorf("too many configName arguments provided: %v", configName)
 	}
-	md, ok := markdownConfigs[config]
+	md, ok := configs[config]
 	if !ok {
-		return "", fmt.Errorf("unknown markdown config name: %s", config)
+		return MarkdownDoc{}, fmt.Errorf("unknown markdown config name: %s", config)
 	}
 
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer bufPool.Put(buf)
 
-	err := md.Convert([]byte(input), buf)
-	if err != nil {
-		return "", err
+	source := []byte(input)
+	doc := md.Parser().Parse(text.NewReader(source))
+	if err := md.Renderer().Render(buf, source, doc); err != nil {
+		return MarkdownDoc{}, err
 	}
 
-	return template.HTML(buf.String()), nil
+	return MarkdownDoc{HTML: template.HTML(buf.String()), TOC: markdownTOC(doc, source)}, nil
+}
+
+// markdownTOC nests each heading in doc under the closest preceding heading
+// with a lower level.
+func markdownTOC(doc ast.Node, source []byte) []*TOCEntry {
+	var toc []*TOCEntry
+	var stack []*TOCEntry
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		heading, ok := n.(*ast.Heading)
+		if !ok {
+			continue
+		}
+		entry := &TOCEntry{Level: heading.Level, Title: string(markdownText(heading, source))}
+		if id, ok := heading.AttributeString("id"); ok {
+			if id, ok := id.([]byte); ok {
+				entry.ID = string(id)
+			}
+		}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= entry.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			toc = append(toc, entry)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, entry)
+	}
+	return toc
+}
+
+// markdownText returns the plain text of an inline node and its children.
+func markdownText(n ast.Node, source []byte) []byte {
+	var out []byte
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c := c.(type) {
+		case *ast.Text:
+			out = append(out, c.Segment.Value(source)...)
+			if c.SoftLineBreak() {
+				out = append(out, ' ')
+			}
+		case *ast.String:
+			out = append(out, c.Value...)
+		default:
+			out = append(out, markdownText(c, source)...)
+		}
+	}
+	return out
 }
 
 // splitFrontMatter parses front matter out from the beginning of input,
@@ -185,50 +326,208 @@ func FuncIdx(idx int, arr any) any {
 	return reflect.ValueOf(arr).Index(idx).Interface()
 }
 
-// humanize transforms size and time inputs to a human readable format using
-// the go-humanize library.
+// FuncHumanize is like [FuncHumanizeValue] for string values.
+func FuncHumanize(formatType, data string) (string, error) {
+	return FuncHumanizeValue(formatType, data)
+}
+
+// humanize transforms sizes, times, durations, and numbers to a human
+// readable format using the go-humanize library.
 //
-// Call with two parameters: format type and value to format. Supported format
-// types are:
+// Call with two parameters: format type and value to format. Values can be
+// strings or native values like the results of `.DB.QueryRows`. Supported
+// format types are:
 //
 // "size" which turns an integer amount of bytes into a string like "2.3 MB",
 // for example:
 //
 //	{{humanize "size" "2048000"}}
 //
-// "time" which turns a time string into a relative time string like "2 weeks
-// ago", for example:
+// "time" which turns a time into a relative time string like "2 weeks ago".
+// The time can be a time.Time, unix seconds, or a string in RFC1123Z format or
+// any format formatTime accepts. Add a layout after a colon to parse strings
+// with a different layout, for example:
 //
 //	{{humanize "time" "Fri, 05 May 2022 15:04:05 +0200"}}
-func FuncHumanize(formatType, data string) (string, error) {
+//	{{humanize "time:2006-01-02" "2022-05-05"}}
+//
+// "duration" which turns a time.Duration, a duration string like "1h32m", or
+// a number of seconds into an approximate string like "about an hour". Add a
+// precision after a colon to show more units, like "1 hour 32 minutes" for
+// "duration:2", for example:
+//
+//	{{humanize "duration" "1h32m"}}
+//
+// "comma" which separates groups of thousands of a number with commas, like
+// "1,234,567". Integer strings can be any size, for example:
+//
+//	{{humanize "comma" .Row.visits}}
+//
+// "ordinal" which turns an integer into an ordinal string like "22nd", for
+// example:
+//
+//	{{humanize "ordinal" 22}}
+func FuncHumanizeValue(formatType string, data any) (string, error) {
 	// The format type can optionally be followed
 	// by a colon to provide arguments for the format
 	parts := strings.Split(formatType, ":")
 
 	switch parts[0] {
 	case "size":
-		dataint, dataerr := strconv.ParseUint(data, 10, 64)
-		if dataerr != nil {
-			return "", fmt.Errorf("humanize: size cannot be parsed: %s", dataerr.Error())
+		if s, ok := data.(string); ok {
+			dataint, dataerr := strconv.ParseUint(s, 10, 64)
+			if dataerr != nil {
+				return "", fmt.Errorf("humanize: size cannot be parsed: %s", dataerr.Error())
+			}
+			return humanize.Bytes(dataint), nil
+		}
+		n, ok := toNumber(data)
+		if !ok || n < 0 {
+			return "", fmt.Errorf("humanize: size must be a positive number, got %T: %v", data, data)
 		}
-		return humanize.Bytes(dataint), nil
+		return humanize.Bytes(uint64(n)), nil
 
 	case "time":
-		timelayout := time.RFC1123Z
+		if s, ok := data.(string); ok {
+			timelayout := time.RFC1123Z
+			if len(parts) > 1 {
+				timelayout = formatType[len(parts[0])+1:]
+			}
+			t, dataerr := time.Parse(timelayout, s)
+			if dataerr == nil {
+				return humanize.Time(t), nil
+			}
+			if len(parts) > 1 {
+				return "", fmt.Errorf("humanize: time cannot be parsed: %s", dataerr.Error())
+			}
+		}
+		t, err := toTime(data)
+		if err != nil {
+			return "", fmt.Errorf("humanize: time cannot be parsed: %w", err)
+		}
+		return humanize.Time(t), nil
+
+	case "duration":
+		precision := 1
 		if len(parts) > 1 {
-			timelayout = formatType[len(parts[0])+1:]
+			var err error
+			if precision, err = strconv.Atoi(parts[1]); err != nil || precision < 1 {
+				return "", fmt.Errorf("humanize: duration precision must be a positive integer, got '%s'", parts[1])
+			}
+		}
+		d, err := toDuration(data)
+		if err != nil {
+			return "", fmt.Errorf("humanize: duration cannot be parsed: %w", err)
 		}
+		return humanizeDuration(d, precision), nil
 
-		dataint, dataerr := time.Parse(timelayout, data)
-		if dataerr != nil {
-			return "", fmt.Errorf("humanize: time cannot be parsed: %s", dataerr.Error())
+	case "comma":
+		if s, ok := data.(string); ok {
+			if n, ok := new(big.Int).SetString(strings.TrimSpace(s), 10); ok {
+				return humanize.BigComma(n), nil
+			}
+		}
+		if n, ok := data.(*big.Int); ok {
+			return humanize.BigComma(n), nil
+		}
+		n, ok := toNumber(data)
+		if !ok {
+			return "", fmt.Errorf("humanize: comma requires a number, got %T: %v", data, data)
 		}
-		return humanize.Time(dataint), nil
+		if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+			return humanize.Comma(int64(n)), nil
+		}
+		return humanize.Commaf(n), nil
+
+	case "ordinal":
+		n, ok := toNumber(data)
+		if !ok || n != math.Trunc(n) {
+			return "", fmt.Errorf("humanize: ordinal requires an integer, got %T: %v", data, data)
+		}
+		return humanize.Ordinal(int(n)), nil
 	}
 
 	return "", fmt.Errorf("no know function was given")
 }
 
+// durationUnits are the units used by humanizeDuration, largest first.
+var durationUnits = []struct {
+	name string
+	size time.Duration
+}{
+	{"year", 365 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"day", 24 * time.Hour},
+	{"hour", time.Hour},
+	{"minute", time.Minute},
+	{"second", time.Second},
+}
+
+// humanizeDuration writes d with up to precision units, like "1 hour 32
+// minutes", prefixed by "about" if it leaves out a remainder.
+func humanizeDuration(d time.Duration, precision int) string {
+	if d < 0 {
+		d = -d
+	}
+	if d < time.Second {
+		return "less than a second"
+	}
+	var words []string
+	for _, unit := range durationUnits {
+		if len(words) == precision {
+			break
+		}
+		n := d / unit.size
+		if n == 0 {
+			if len(words) > 0 {
+				// stop at the first empty unit so "about" covers the rest
+				break
+			}
+			continue
+		}
+		d -= n * unit.size
+		word := fmt.Sprintf("%d %s", n, unit.name)
+		if n != 1 {
+			word += "s"
+		}
+		words = append(words, word)
+	}
+	result := strings.Join(words, " ")
+	if precision == 1 {
+		// read "1 hour" as "an hour"
+		if after, ok := strings.CutPrefix(result, "1 "); ok {
+			if after == "hour" {
+				result = "an hour"
+			} else {
+				result = "a " + after
+			}
+		}
+	}
+	if d >= time.Second {
+		result = "about " + result
+	}
+	return result
+}
+
+// toDuration converts a time.Duration, a duration string like "1h30m", or a
+// number of seconds to a time.Duration.
+func toDuration(value any) (time.Duration, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		if d, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+			return d, nil
+		}
+	}
+	n, ok := toNumber(value)
+	if !ok {
+		return 0, fmt.Errorf("expected a duration like 1h30m or a number of seconds, got %T: %v", value, value)
+	}
+	return time.Duration(n * float64(time.Second)), nil
+}
+
 // The try template func accepts a fallible function object and calls it with
 // the provided args. If the function and args are valid, try returns the result
 // wrapped in a result object that exposes the return value and error to
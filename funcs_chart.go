@@ -0,0 +1,272 @@
+package xtemplate
+
+// This file contains template funcs that draw simple charts as inline svg.
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// chartOptions are the options accepted by the chart funcs as a dict:
+//
+//   - `width`, `height`: the size of the svg in pixels.
+//   - `color`: the color of lines and bars. Default `currentColor`.
+//   - `fill`: the color of the area under lines. Default none.
+//   - `title`: a title for screen readers and tooltips.
+//   - `labels`: a list of labels for each value, shown under the chart by
+//     lineChart and barChart.
+//   - `key`: the column to chart if values are rows, like the results of
+//     `.DB.QueryRows`.
+type chartOptions struct {
+	width, height float64
+	color, fill   string
+	title         string
+	labels        []string
+}
+
+// sparkline draws values as a small line chart without axes or labels,
+// sized to fit in a line of text. Default size 100x20.
+//
+//	{{sparkline (list 3 5 2 8 6)}}
+//	{{.DB.QueryRows "SELECT day, visits FROM stats" | sparkline (dict "key" "visits" "color" "green")}}
+func FuncSparkline(args ...any) (template.HTML, error) {
+	values, opts, err := chartArgs("sparkline", args, 100, 20)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	svgOpen(&b, "sparkline", opts)
+	points := chartPoints(values, 1, 1, opts.width-2, opts.height-2)
+	chartArea(&b, opts, points, opts.height)
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5" stroke-linejoin="round" stroke-linecap="round"/>`, strings.Join(points, " "), template.HTMLEscapeString(opts.color))
+	b.WriteString("</svg>")
+	return template.HTML(b.String()), nil
+}
+
+// lineChart draws values as a line chart with a point for each value and a
+// baseline, and labels under it if given. Default size 400x200.
+//
+//	{{lineChart (list 3 5 2 8 6) (dict "labels" (list "Mon" "Tue" "Wed" "Thu" "Fri"))}}
+func FuncLineChart(args ...any) (template.HTML, error) {
+	values, opts, err := chartArgs("lineChart", args, 400, 200)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	svgOpen(&b, "line-chart", opts)
+	plot := chartPlotHeight(opts)
+	points := chartPoints(values, 8, 8, opts.width-16, plot-8)
+	fmt.Fprintf(&b, `<line x1="0" y1="%s" x2="%s" y2="%s" stroke="currentColor" stroke-opacity="0.3"/>`, svgNum(plot), svgNum(opts.width), svgNum(plot))
+	chartArea(&b, opts, points, plot)
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2" stroke-linejoin="round" stroke-linecap="round"/>`, strings.Join(points, " "), template.HTMLEscapeString(opts.color))
+	for i, point := range points {
+		x, y, _ := strings.Cut(point, ",")
+		fmt.Fprintf(&b, `<circle cx="%s" cy="%s" r="3" fill="%s"><title>%s</title></circle>`, x, y, template.HTMLEscapeString(opts.color), chartValueTitle(opts, values, i))
+		chartLabel(&b, opts, i, x)
+	}
+	b.WriteString("</svg>")
+	return template.HTML(b.String()), nil
+}
+
+// barChart draws values as a bar chart, with bars below the baseline for
+// negative values, and labels under it if given. Default size 400x200.
+//
+//	{{.DB.QueryRows "SELECT month, total FROM sales" | barChart (dict "key" "total")}}
+func FuncBarChart(args ...any) (template.HTML, error) {
+	values, opts, err := chartArgs("barChart", args, 400, 200)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	svgOpen(&b, "bar-chart", opts)
+	plot := chartPlotHeight(opts)
+	lo, hi := chartRange(values)
+	lo, hi = math.Min(lo, 0), math.Max(hi, 0)
+	scale := 0.0
+	if hi > lo {
+		scale = plot / (hi - lo)
+	}
+	zero := hi * scale
+	if len(values) > 0 {
+		slot := opts.width / float64(len(values))
+		gap := slot * 0.2
+		for i, v := range values {
+			x := float64(i)*slot + gap/2
+			y, h := zero-v*scale, v*scale
+			if v < 0 {
+				y, h = zero, -v*scale
+			}
+			fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"><title>%s</title></rect>`, svgNum(x), svgNum(y), svgNum(slot-gap), svgNum(h), template.HTMLEscapeString(opts.color), chartValueTitle(opts, values, i))
+			chartLabel(&b, opts, i, svgNum(x+(slot-gap)/2))
+		}
+	}
+	fmt.Fprintf(&b, `<line x1="0" y1="%s" x2="%s" y2="%s" stroke="currentColor" stroke-opacity="0.3"/>`, svgNum(zero), svgNum(opts.width), svgNum(zero))
+	b.WriteString("</svg>")
+	return template.HTML(b.String()), nil
+}
+
+// chartArgs parses the `values [opts]` arguments of the chart funcs.
+func chartArgs(fn string, args []any, width, height float64) ([]float64, chartOptions, error) {
+	opts := chartOptions{width: width, height: height, color: "currentColor"}
+	var optsMap map[string]any
+	switch len(args) {
+	case 1:
+	case 2:
+		// values are last so they can be piped in
+		var ok bool
+		if optsMap, ok = args[0].(map[string]any); !ok {
+			return nil, opts, fmt.Errorf("%s options must be a dict, got %T", fn, args[0])
+		}
+	default:
+		return nil, opts, fmt.Errorf("%s requires optional options and values, got %d arguments", fn, len(args))
+	}
+	key := ""
+	for name, v := range optsMap {
+		var err error
+		switch name {
+		case "width":
+			err = chartSize(&opts.width, v)
+		case "height":
+			err = chartSize(&opts.height, v)
+		case "color":
+			opts.color = fmt.Sprint(v)
+		case "fill":
+			opts.fill = fmt.Sprint(v)
+		case "title":
+			opts.title = fmt.Sprint(v)
+		case "key":
+			key = fmt.Sprint(v)
+		case "labels":
+			list := reflect.ValueOf(v)
+			if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+				err = fmt.Errorf("must be a list, got %T", v)
+				break
+			}
+			for i := 0; i < list.Len(); i++ {
+				opts.labels = append(opts.labels, csvValue(list.Index(i)))
+			}
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return nil, opts, fmt.Errorf("%s option '%s': %w", fn, name, err)
+		}
+	}
+
+	list, err := rowList(fn, args[len(args)-1])
+	if err != nil {
+		return nil, opts, err
+	}
+	values := make([]float64, list.Len())
+	for i := range values {
+		var value any
+		if key != "" {
+			if value, err = rowColumn(fn, key, list, i); err != nil {
+				return nil, opts, err
+			}
+		} else {
+			value = csvRow(list, i).Interface()
+		}
+		n, ok := toNumber(value)
+		if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, opts, fmt.Errorf("%s value %d isn't a number: %v", fn, i, value)
+		}
+		values[i] = n
+	}
+	return values, opts, nil
+}
+
+func chartSize(size *float64, v any) error {
+	n, ok := toNumber(v)
+	if !ok || n <= 0 || n > 10000 {
+		return fmt.Errorf("must be a number between 0 and 10000, got %v", v)
+	}
+	*size = n
+	return nil
+}
+
+// svgOpen writes the opening svg tag and title.
+func svgOpen(b *strings.Builder, class string, opts chartOptions) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" class="%s" width="%s" height="%s" viewBox="0 0 %s %s" role="img"`, class, svgNum(opts.width), svgNum(opts.height), svgNum(opts.width), svgNum(opts.height))
+	if opts.title != "" {
+		fmt.Fprintf(b, ` aria-label="%s"><title>%s</title>`, template.HTMLEscapeString(opts.title), template.HTMLEscapeString(opts.title))
+	} else {
+		b.WriteString(">")
+	}
+}
+
+// chartPlotHeight returns the height of the chart above the labels.
+func chartPlotHeight(opts chartOptions) float64 {
+	if len(opts.labels) > 0 {
+		return opts.height - 16
+	}
+	return opts.height
+}
+
+// chartLabel writes the label of the i-th value centered at x.
+func chartLabel(b *strings.Builder, opts chartOptions, i int, x string) {
+	if i < len(opts.labels) {
+		fmt.Fprintf(b, `<text x="%s" y="%s" font-size="11" text-anchor="middle" fill="currentColor">%s</text>`, x, svgNum(opts.height-3), template.HTMLEscapeString(opts.labels[i]))
+	}
+}
+
+// chartArea fills the area between the line through points and the baseline
+// at y if the fill option is set.
+func chartArea(b *strings.Builder, opts chartOptions, points []string, y float64) {
+	if opts.fill == "" || len(points) == 0 {
+		return
+	}
+	first, _, _ := strings.Cut(points[0], ",")
+	last, _, _ := strings.Cut(points[len(points)-1], ",")
+	fmt.Fprintf(b, `<polygon points="%s %s,%s %s,%s" fill="%s" stroke="none"/>`, strings.Join(points, " "), last, svgNum(y), first, svgNum(y), template.HTMLEscapeString(opts.fill))
+}
+
+// chartValueTitle returns the tooltip of the i-th value.
+func chartValueTitle(opts chartOptions, values []float64, i int) string {
+	value := strconv.FormatFloat(values[i], 'f', -1, 64)
+	if i < len(opts.labels) {
+		return template.HTMLEscapeString(opts.labels[i]) + ": " + value
+	}
+	return value
+}
+
+// chartRange returns the smallest and largest values.
+func chartRange(values []float64) (lo, hi float64) {
+	for i, v := range values {
+		if i == 0 || v < lo {
+			lo = v
+		}
+		if i == 0 || v > hi {
+			hi = v
+		}
+	}
+	return
+}
+
+// chartPoints scales values to `x,y` points spread evenly across a box at
+// left, top with the size width x height, with the largest value at the top.
+func chartPoints(values []float64, left, top, width, height float64) []string {
+	lo, hi := chartRange(values)
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := left + width/2
+		if len(values) > 1 {
+			x = left + width*float64(i)/float64(len(values)-1)
+		}
+		y := top + height/2
+		if hi > lo {
+			y = top + height*(hi-v)/(hi-lo)
+		}
+		points[i] = svgNum(x) + "," + svgNum(y)
+	}
+	return points
+}
+
+// svgNum formats a coordinate with at most two decimal places.
+func svgNum(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,235 @@
+package xtemplate
+
+// This file contains template funcs that validate and format contact details
+// like email addresses.
+
+import (
+	"fmt"
+	"html/template"
+	"net/mail"
+	"strings"
+)
+
+// validEmail reports whether email is a single plain email address like
+// `ann@example.com`, without a display name, whose domain is a valid host
+// name with at least two labels. It doesn't check that the domain exists.
+//
+//	{{if not (validEmail (.Req.FormValue "email"))}}{{failf "invalid email"}}{{end}}
+func FuncValidEmail(email string) bool {
+	local, domain, ok := splitEmail(email)
+	return ok && len(local) <= 64 && validDomain(domain)
+}
+
+// emailDomain returns the lowercased domain of email, like `example.com` for
+// `Ann@Example.com`.
+func FuncEmailDomain(email string) (string, error) {
+	_, domain, ok := splitEmail(email)
+	if !ok || !validDomain(domain) {
+		return "", fmt.Errorf("invalid email address '%s'", email)
+	}
+	return strings.ToLower(domain), nil
+}
+
+// obfuscateEmail encodes every character of email as an html character
+// reference, so it displays normally in a browser but doesn't appear as an
+// email address in the page source to simple scrapers.
+//
+//	<p>Contact us at {{obfuscateEmail "info@example.com"}}</p>
+func FuncObfuscateEmail(email string) template.HTML {
+	var b strings.Builder
+	for i, r := range []rune(email) {
+		// alternate between decimal and hex references
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "&#%d;", r)
+		} else {
+			fmt.Fprintf(&b, "&#x%x;", r)
+		}
+	}
+	return template.HTML(b.String())
+}
+
+// splitEmail parses email as a plain address and splits it into its local
+// part and domain.
+func splitEmail(email string) (local, domain string, ok bool) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", "", false
+	}
+	i := strings.LastIndexByte(email, '@')
+	return email[:i], email[i+1:], true
+}
+
+// validDomain reports whether domain is a host name with at least two labels
+// made of letters, digits, and hyphens.
+func validDomain(domain string) bool {
+	if len(domain) > 253 {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// PhoneNumber is a phone number parsed by parsePhone.
+type PhoneNumber struct {
+	// Whether the number has a valid length for its region.
+	Valid bool
+	// The region of the number, like `US`, or empty if it's from a region that
+	// parsePhone doesn't know.
+	Region string
+	// The country calling code, like `1` or `44`.
+	CountryCode string
+	// The number in E.164 format, like `+14155550123`, for storing and `tel:`
+	// links.
+	E164 string
+	// The number formatted for display within its region, like `(415)
+	// 555-0123`.
+	National string
+	// The number formatted for display internationally, like `+1 415-555-0123`.
+	International string
+}
+
+// phoneRegion describes the phone numbers of a region.
+type phoneRegion struct {
+	code  string
+	trunk string
+	// the group format of national numbers by length, where x is a digit
+	formats map[int]string
+	// the national format if it's not the trunk prefix and the group format
+	national map[int]string
+	// whether another region with the same code is used for international
+	// numbers
+	shared bool
+}
+
+// phoneRegions are the regions parsePhone can parse local numbers for, by
+// ISO 3166 code.
+var phoneRegions = map[string]phoneRegion{
+	"US": {code: "1", trunk: "1", formats: map[int]string{10: "xxx-xxx-xxxx"}, national: map[int]string{10: "(xxx) xxx-xxxx"}},
+	"CA": {code: "1", trunk: "1", formats: map[int]string{10: "xxx-xxx-xxxx"}, national: map[int]string{10: "(xxx) xxx-xxxx"}, shared: true},
+	"GB": {code: "44", trunk: "0", formats: map[int]string{9: "xxxx xxxxx", 10: "xx xxxx xxxx"}},
+	"IE": {code: "353", trunk: "0", formats: map[int]string{8: "x xxx xxxx", 9: "xx xxx xxxx"}},
+	"DE": {code: "49", trunk: "0", formats: map[int]string{10: "xxx xxxxxxx", 11: "xxx xxxxxxxx"}},
+	"AT": {code: "43", trunk: "0", formats: map[int]string{10: "xxx xxxxxxx", 11: "xxx xxxxxxxx"}},
+	"CH": {code: "41", trunk: "0", formats: map[int]string{9: "xx xxx xx xx"}},
+	"FR": {code: "33", trunk: "0", formats: map[int]string{9: "x xx xx xx xx"}},
+	"BE": {code: "32", trunk: "0", formats: map[int]string{8: "x xxx xx xx", 9: "xxx xx xx xx"}},
+	"NL": {code: "31", trunk: "0", formats: map[int]string{9: "xx xxx xxxx"}},
+	"ES": {code: "34", formats: map[int]string{9: "xxx xx xx xx"}},
+	"PT": {code: "351", formats: map[int]string{9: "xxx xxx xxx"}},
+	"IT": {code: "39", formats: map[int]string{9: "xxx xxx xxx", 10: "xxx xxx xxxx"}},
+	"SE": {code: "46", trunk: "0", formats: map[int]string{9: "xx xxx xx xx"}},
+	"NO": {code: "47", formats: map[int]string{8: "xxx xx xxx"}},
+	"DK": {code: "45", formats: map[int]string{8: "xx xx xx xx"}},
+	"PL": {code: "48", formats: map[int]string{9: "xxx xxx xxx"}},
+	"AU": {code: "61", trunk: "0", formats: map[int]string{9: "x xxxx xxxx"}},
+	"NZ": {code: "64", trunk: "0", formats: map[int]string{8: "x xxx xxxx", 9: "xx xxx xxxx"}},
+	"JP": {code: "81", trunk: "0", formats: map[int]string{9: "x xxxx xxxx", 10: "xx xxxx xxxx"}},
+	"IN": {code: "91", trunk: "0", formats: map[int]string{10: "xxxxx xxxxx"}},
+	"BR": {code: "55", trunk: "0", formats: map[int]string{10: "xx xxxx-xxxx", 11: "xx xxxxx-xxxx"}},
+	"MX": {code: "52", formats: map[int]string{10: "xx xxxx xxxx"}},
+}
+
+// parsePhone parses number as a phone number dialed from region, an ISO
+// 3166 code like `US` or `GB`, and returns it in E.164, national, and
+// international formats. Numbers starting with `+` or the `00` international
+// prefix can be from any region. Numbers with an invalid length aren't an
+// error but have Valid set to false, so forms can show a message.
+//
+//	{{$phone := parsePhone "US" (.Req.FormValue "phone")}}
+//	{{if not $phone.Valid}}{{failf "invalid phone number"}}{{end}}
+//	{{.DB.Exec "UPDATE users SET phone=? WHERE id=?" $phone.E164 $id}}
+func FuncParsePhone(region, number string) (PhoneNumber, error) {
+	region = strings.ToUpper(region)
+	r, ok := phoneRegions[region]
+	if !ok {
+		return PhoneNumber{}, fmt.Errorf("unknown phone region '%s'", region)
+	}
+	var digits strings.Builder
+	for i, c := range strings.TrimSpace(number) {
+		switch {
+		case '0' <= c && c <= '9':
+			digits.WriteRune(c)
+		case c == '+' && i == 0:
+			digits.WriteString("00")
+		case strings.ContainsRune(" -.()/", c):
+		default:
+			return PhoneNumber{Region: region}, nil
+		}
+	}
+	n := digits.String()
+
+	// find the region and the national significant number
+	var nsn string
+	rest, international := strings.CutPrefix(n, "00")
+	if !international && r.code == "1" {
+		rest, international = strings.CutPrefix(n, "011")
+	}
+	if international {
+		if !strings.HasPrefix(rest, r.code) {
+			region, r = "", phoneRegion{}
+			for name, candidate := range phoneRegions {
+				if strings.HasPrefix(rest, candidate.code) && !candidate.shared {
+					region, r = name, candidate
+					break
+				}
+			}
+		}
+		if region == "" {
+			// a region we don't know, accept any valid E.164 length
+			p := PhoneNumber{Valid: len(rest) >= 8 && len(rest) <= 15, E164: "+" + rest}
+			p.National, p.International = p.E164, p.E164
+			return p, nil
+		}
+		nsn = strings.TrimPrefix(rest, r.code)
+	} else {
+		nsn = n
+		if r.trunk != "" {
+			nsn = strings.TrimPrefix(n, r.trunk)
+		}
+	}
+
+	p := PhoneNumber{Region: region, CountryCode: r.code, E164: "+" + r.code + nsn}
+	format, ok := r.formats[len(nsn)]
+	if !ok {
+		p.International = "+" + r.code + " " + nsn
+		p.National = r.trunk + nsn
+		return p, nil
+	}
+	p.Valid = true
+	p.International = "+" + r.code + " " + phoneFormat(format, nsn)
+	if national, ok := r.national[len(nsn)]; ok {
+		p.National = phoneFormat(national, nsn)
+	} else {
+		p.National = r.trunk + phoneFormat(format, nsn)
+	}
+	return p, nil
+}
+
+// phoneFormat replaces each x in format with the next digit.
+func phoneFormat(format, digits string) string {
+	var b strings.Builder
+	i := 0
+	for _, c := range format {
+		if c == 'x' && i < len(digits) {
+			b.WriteByte(digits[i])
+			i++
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
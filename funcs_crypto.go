@@ -0,0 +1,105 @@
+package xtemplate
+
+// This file contains template funcs for hashing and signing.
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// encodeDigest formats a digest as `hex` (the default), `base64`, or
+// `base64url` without padding.
+func encodeDigest(sum []byte, encoding string) (string, error) {
+	switch encoding {
+	case "", "hex":
+		return hex.EncodeToString(sum), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum), nil
+	case "base64url":
+		return base64.RawURLEncoding.EncodeToString(sum), nil
+	}
+	return "", fmt.Errorf("unknown digest encoding '%s', expected hex, base64, or base64url", encoding)
+}
+
+// digest hashes the last arg, which may be preceded by an encoding name.
+func digest(name string, h hash.Hash, args []string) (string, error) {
+	var encoding string
+	switch len(args) {
+	case 1:
+	case 2:
+		encoding = args[0]
+	default:
+		return "", fmt.Errorf("%s accepts an optional encoding and the input, got %d args", name, len(args))
+	}
+	h.Write([]byte(args[len(args)-1]))
+	return encodeDigest(h.Sum(nil), encoding)
+}
+
+// sha256 returns the SHA-256 digest of its input as hex, or in the encoding
+// named before the input: `hex`, `base64`, or `base64url`.
+//
+//	{{sha256 "hello"}} {{.Body | sha256 "base64"}}
+func FuncSha256(args ...string) (string, error) {
+	return digest("sha256", sha256.New(), args)
+}
+
+// sha384 returns the SHA-384 digest of its input, like sha256.
+func FuncSha384(args ...string) (string, error) {
+	return digest("sha384", sha512.New384(), args)
+}
+
+// md5 returns the MD5 digest of its input, like sha256. MD5 is broken for
+// security purposes, but some services use it as an identifier, like
+// gravatar.
+func FuncMd5(args ...string) (string, error) {
+	return digest("md5", md5.New(), args)
+}
+
+// hmacSha256 returns the HMAC-SHA256 signature of msg with key as hex, or in
+// the encoding named before the key, for signing urls and verifying webhooks.
+// Compare signatures with secureCompare.
+//
+//	{{hmacSha256 .Secret .Body}} {{hmacSha256 "base64" .Secret .Body}}
+func FuncHmacSha256(args ...string) (string, error) {
+	var encoding string
+	switch len(args) {
+	case 2:
+	case 3:
+		encoding = args[0]
+	default:
+		return "", fmt.Errorf("hmacSha256 accepts an optional encoding, the key, and the message, got %d args", len(args))
+	}
+	mac := hmac.New(sha256.New, []byte(args[len(args)-2]))
+	mac.Write([]byte(args[len(args)-1]))
+	return encodeDigest(mac.Sum(nil), encoding)
+}
+
+// secureCompare reports whether a and b are equal in constant time, so
+// comparing a secret or signature doesn't leak how much of it matched.
+func FuncSecureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// randomToken returns n cryptographically random bytes encoded as url-safe
+// base64 without padding, for CSRF tokens, verification links, and
+// unguessable share urls. Use at least 16 bytes for secrets.
+//
+//	{{randomToken 32}}
+func FuncRandomToken(n int) (string, error) {
+	if n <= 0 || n > 1024 {
+		return "", fmt.Errorf("randomToken size must be between 1 and 1024 bytes, got %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
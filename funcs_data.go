@@ -0,0 +1,375 @@
+package xtemplate
+
+// This file contains template funcs that parse and format structured data.
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fromYaml parses a YAML document into maps, slices, and scalar values, like
+// sprig's fromJson. For example, to read site metadata from a file:
+//
+//	{{$site := .FS.Read "site.yaml" | fromYaml}}
+func FuncFromYaml(input string) (any, error) {
+	var v any
+	if err := yaml.Unmarshal([]byte(input), &v); err != nil {
+		return nil, fmt.Errorf("failed to parse yaml: %w", err)
+	}
+	return v, nil
+}
+
+// toYaml formats v as a YAML document.
+func FuncToYaml(v any) (string, error) {
+	buf := new(bytes.Buffer)
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return "", fmt.Errorf("failed to format yaml: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", fmt.Errorf("failed to format yaml: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// fromToml parses a TOML document into a map.
+func FuncFromToml(input string) (map[string]any, error) {
+	m := make(map[string]any)
+	if err := toml.Unmarshal([]byte(input), &m); err != nil {
+		return nil, fmt.Errorf("failed to parse toml: %w", err)
+	}
+	return m, nil
+}
+
+// parseCsv parses CSV text where the first row is a header into a list of
+// rows that map each column name to its value.
+//
+//	{{range .FS.Read "prices.csv" | parseCsv}}{{.name}}: {{.price}}{{end}}
+func FuncParseCsv(input string) ([]map[string]string, error) {
+	r := csv.NewReader(strings.NewReader(input))
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csv: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			row[name] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// toCsv formats rows as CSV text. Rows can be maps, like the results of
+// `.DB.QueryRows`, which are written with a header row of columns, or lists
+// of values, which are written as is. If columns aren't given, the columns of
+// maps are all their keys in sorted order.
+//
+//	{{.DB.QueryRows "SELECT id, name FROM users" | toCsv "id" "name"}}
+func FuncToCsv(args ...any) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("toCsv requires rows")
+	}
+	// rows are last so they can be piped in
+	rows := reflect.ValueOf(args[len(args)-1])
+	var columns []string
+	for _, c := range args[:len(args)-1] {
+		name, ok := c.(string)
+		if !ok {
+			return "", fmt.Errorf("toCsv column names must be strings, got %T", c)
+		}
+		columns = append(columns, name)
+	}
+	if rows.Kind() != reflect.Slice && rows.Kind() != reflect.Array {
+		return "", fmt.Errorf("toCsv rows must be a list, got %T", args[len(args)-1])
+	}
+
+	var records [][]string
+	isMaps := rows.Len() > 0 && csvRow(rows, 0).Kind() == reflect.Map
+	if isMaps && columns == nil {
+		seen := map[string]bool{}
+		for i := 0; i < rows.Len(); i++ {
+			if row := csvRow(rows, i); row.Kind() == reflect.Map {
+				for _, key := range row.MapKeys() {
+					if name := key.String(); !seen[name] {
+						seen[name] = true
+						columns = append(columns, name)
+					}
+				}
+			}
+		}
+		slices.Sort(columns)
+	}
+	if columns != nil {
+		records = append(records, columns)
+	}
+	for i := 0; i < rows.Len(); i++ {
+		row := csvRow(rows, i)
+		var record []string
+		switch {
+		case isMaps && row.Kind() == reflect.Map && row.Type().Key().Kind() == reflect.String:
+			record = make([]string, len(columns))
+			for j, name := range columns {
+				record[j] = csvValue(row.MapIndex(reflect.ValueOf(name).Convert(row.Type().Key())))
+			}
+		case !isMaps && (row.Kind() == reflect.Slice || row.Kind() == reflect.Array):
+			record = make([]string, row.Len())
+			for j := range record {
+				record[j] = csvValue(row.Index(j))
+			}
+		default:
+			return "", fmt.Errorf("toCsv rows must all be maps with string keys or all be lists, got %s in row %d", row.Kind(), i)
+		}
+		records = append(records, record)
+	}
+
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	if err := w.WriteAll(records); err != nil {
+		return "", fmt.Errorf("failed to format csv: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// csvRow returns the i-th row of rows without any pointer or interface
+// indirection.
+func csvRow(rows reflect.Value, i int) reflect.Value {
+	row := rows.Index(i)
+	for row.Kind() == reflect.Interface || row.Kind() == reflect.Pointer {
+		row = row.Elem()
+	}
+	return row
+}
+
+// csvValue formats a value as a CSV field, where missing and nil values are
+// empty.
+func csvValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	if v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case []byte:
+		return string(x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// toXml formats value as an XML element named name, with its text escaped.
+// Maps become child elements in key order, except keys starting with `@`
+// which become attributes and the key `#text` which becomes text. Lists
+// become repeated elements with the same name. Element and attribute names
+// that aren't valid XML names are an error, so the result can be marked as
+// safe html and isn't escaped again when written into a template.
+//
+//	{{toXml "item" (dict "@id" 5 "title" "Fish & Chips")}}
+//
+// outputs `<item id="5"><title>Fish &amp; Chips</title></item>`.
+func FuncToXml(name string, value any) (template.HTML, error) {
+	buf := new(bytes.Buffer)
+	enc := xml.NewEncoder(buf)
+	if err := encodeXml(enc, name, reflect.ValueOf(value)); err != nil {
+		return "", fmt.Errorf("failed to format xml: %w", err)
+	}
+	if err := enc.Flush(); err != nil {
+		return "", fmt.Errorf("failed to format xml: %w", err)
+	}
+	return template.HTML(buf.String()), nil
+}
+
+func encodeXml(enc *xml.Encoder, name string, v reflect.Value) error {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer) {
+		v = v.Elem()
+	}
+	if !isXmlName(name) {
+		return fmt.Errorf("invalid element name '%s'", name)
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch {
+	case !v.IsValid():
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8, v.Kind() == reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeXml(enc, name, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case v.Kind() == reflect.Map:
+		keys := make([]string, 0, v.Len())
+		values := make(map[string]reflect.Value, v.Len())
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = v.MapIndex(k)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if attr, ok := strings.CutPrefix(key, "@"); ok {
+				if !isXmlName(attr) {
+					return fmt.Errorf("invalid attribute name '%s'", attr)
+				}
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: attr}, Value: xmlText(values[key])})
+			}
+		}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			switch {
+			case strings.HasPrefix(key, "@"):
+			case key == "#text":
+				if err := enc.EncodeToken(xml.CharData(xmlText(values[key]))); err != nil {
+					return err
+				}
+			default:
+				if err := encodeXml(enc, key, values[key]); err != nil {
+					return err
+				}
+			}
+		}
+		return enc.EncodeToken(start.End())
+	default:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(xml.CharData(xmlText(v))); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	}
+}
+
+// isXmlName reports whether name matches the Name production of the XML
+// spec, so it can't break out of the tag it's written in.
+func isXmlName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isXmlNameStartChar(r) && (i == 0 || !isXmlNameChar(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isXmlNameStartChar(r rune) bool {
+	return r == ':' || r == '_' || 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' ||
+		0xC0 <= r && r <= 0xD6 || 0xD8 <= r && r <= 0xF6 || 0xF8 <= r && r <= 0x2FF ||
+		0x370 <= r && r <= 0x37D || 0x37F <= r && r <= 0x1FFF || 0x200C <= r && r <= 0x200D ||
+		0x2070 <= r && r <= 0x218F || 0x2C00 <= r && r <= 0x2FEF || 0x3001 <= r && r <= 0xD7FF ||
+		0xF900 <= r && r <= 0xFDCF || 0xFDF0 <= r && r <= 0xFFFD || 0x10000 <= r && r <= 0xEFFFF
+}
+
+func isXmlNameChar(r rune) bool {
+	return r == '-' || r == '.' || '0' <= r && r <= '9' || r == 0xB7 ||
+		0x300 <= r && r <= 0x36F || 0x203F <= r && r <= 0x2040
+}
+
+func xmlText(v reflect.Value) string {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer) {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return ""
+	}
+	if b, ok := v.Interface().([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// fromXml parses an XML document into a map from the root element's name to
+// its value, using the same shape as toXml: attributes are keys starting with
+// `@`, child elements are keys by name with a list value if repeated, and
+// text is the key `#text`. An element with only text is just its text.
+//
+//	{{$feed := fromXml .Body}}{{range $feed.rss.channel.item}}{{.title}}{{end}}
+func FuncFromXml(input string) (map[string]any, error) {
+	dec := xml.NewDecoder(strings.NewReader(input))
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse xml: no root element")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse xml: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			value, err := decodeXml(dec, start)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse xml: %w", err)
+			}
+			return map[string]any{start.Name.Local: value}, nil
+		}
+	}
+}
+
+func decodeXml(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	m := map[string]any{}
+	for _, attr := range start.Attr {
+		m["@"+attr.Name.Local] = attr.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXml(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			switch prev := m[t.Name.Local].(type) {
+			case nil:
+				m[t.Name.Local] = child
+			case []any:
+				m[t.Name.Local] = append(prev, child)
+			default:
+				m[t.Name.Local] = []any{prev, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(m) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				m["#text"] = s
+			}
+			return m, nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package xtemplate
+
+// This file contains template funcs that encode and decode binary data as
+// text.
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// b64enc encodes data as standard base64 with padding. Data can be a string,
+// like the result of `.FS.Read`, or a byte slice, and may contain any bytes.
+// Replaces sprig's b64enc.
+//
+//	<img src="data:image/png;base64,{{.FS.Read "logo.png" | b64enc}}">
+func FuncB64enc(data any) (string, error) {
+	b, err := bytesArg("b64enc", data)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// b64urlenc encodes data as url-safe base64 without padding, as used in
+// tokens and JWTs.
+func FuncB64urlenc(data any) (string, error) {
+	b, err := bytesArg("b64urlenc", data)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// b64dec decodes standard or url-safe base64, with or without padding, and
+// returns an error if s isn't valid base64 instead of an error message like
+// sprig's b64dec, which it replaces. The result may contain any bytes.
+func FuncB64dec(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	enc := base64.StdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.URLEncoding
+	}
+	if !strings.HasSuffix(s, "=") {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	b, err := enc.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode base64: %w", err)
+	}
+	return string(b), nil
+}
+
+// hexenc encodes data as lowercase hex.
+func FuncHexenc(data any) (string, error) {
+	b, err := bytesArg("hexenc", data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hexdec decodes hex in upper or lower case. The result may contain any
+// bytes.
+func FuncHexdec(s string) (string, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode hex: %w", err)
+	}
+	return string(b), nil
+}
+
+// bytesArg converts a string-like argument to bytes.
+func bytesArg(fn string, data any) ([]byte, error) {
+	switch v := data.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case template.HTML:
+		return []byte(v), nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	}
+	return nil, fmt.Errorf("%s requires a string or bytes, got %T", fn, data)
+}
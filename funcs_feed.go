@@ -0,0 +1,262 @@
+package xtemplate
+
+// This file implements building RSS and Atom feeds in templates.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Feed is an RSS or Atom feed built by a template, created with the
+// `newFeed` func.
+//
+//	{{- $f := newFeed "My Blog" "https://example.com/" (dict "author" "Ann")}}
+//	{{- range .Page.Pages "/blog/"}}
+//	{{- $f.Add (dict "title" .Title "link" (print "https://example.com" .Path) "date" .Date "content" .Content)}}
+//	{{- end}}
+//	{{- $f.RenderAtom}}
+type Feed struct {
+	Title       string
+	Link        string
+	ID          string
+	Description string
+	Author      string
+	// The url the feed itself is served at.
+	Self  string
+	Items []*FeedItem
+}
+
+// FeedItem is an entry in a [Feed].
+type FeedItem struct {
+	Title   string
+	Link    string
+	ID      string
+	Date    time.Time
+	Updated time.Time
+	Summary string
+	// The html content of the item.
+	Content string
+	Author  string
+}
+
+// newFeed creates a [Feed] with a title and the url of the site it's for. The
+// optional dict can set the feed's `id`, `description`, `author`, and `self`,
+// the url the feed is served at. Add items with the Add method, then write
+// the feed with RenderAtom or RenderRSS.
+func FuncNewFeed(title, link string, opts ...map[string]any) (*Feed, error) {
+	if title == "" || link == "" {
+		return nil, fmt.Errorf("newFeed requires a title and link")
+	}
+	f := &Feed{Title: title, Link: link, ID: link}
+	for _, o := range opts {
+		for key, v := range o {
+			switch s := xmlText(reflect.ValueOf(v)); key {
+			case "id":
+				f.ID = s
+			case "description":
+				f.Description = s
+			case "author":
+				f.Author = s
+			case "self":
+				f.Self = s
+			default:
+				return nil, fmt.Errorf("newFeed unknown option '%s'", key)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Add adds an item to the feed from a dict with its `title`, `link`, and
+// `date`, which are required, and optionally its `id` (default the link),
+// `updated` date (default date), `summary`, html `content`, and `author`.
+// Dates can be anything formatTime accepts.
+func (f *Feed) Add(item map[string]any) (string, error) {
+	var it FeedItem
+	for key, v := range item {
+		var err error
+		switch s := xmlText(reflect.ValueOf(v)); key {
+		case "title":
+			it.Title = s
+		case "link":
+			it.Link = s
+		case "id":
+			it.ID = s
+		case "date":
+			it.Date, err = toTime(v)
+		case "updated":
+			it.Updated, err = toTime(v)
+		case "summary":
+			it.Summary = s
+		case "content":
+			it.Content = s
+		case "author":
+			it.Author = s
+		default:
+			err = fmt.Errorf("unknown field")
+		}
+		if err != nil {
+			return "", fmt.Errorf("feed item '%s': %w", key, err)
+		}
+	}
+	if it.Title == "" || it.Link == "" || it.Date.IsZero() {
+		return "", fmt.Errorf("feed item requires a title, link, and date, got: %v", item)
+	}
+	if it.ID == "" {
+		it.ID = it.Link
+	}
+	if it.Updated.IsZero() {
+		it.Updated = it.Date
+	}
+	f.Items = append(f.Items, &it)
+	return "", nil
+}
+
+// sorted returns the items newest first.
+func (f *Feed) sorted() []*FeedItem {
+	items := append([]*FeedItem(nil), f.Items...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Date.After(items[j].Date) })
+	return items
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	Link      atomLink    `xml:"link"`
+	ID        string      `xml:"id"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Summary   *atomText   `xml:"summary,omitempty"`
+	Content   *atomText   `xml:"content,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Links    []atomLink  `xml:"link"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Author   *atomPerson `xml:"author,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// RenderAtom writes the feed as an Atom document. The feed's updated date is
+// the latest updated date of its items.
+func (f *Feed) RenderAtom() (template.HTML, error) {
+	feed := atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Links:    []atomLink{{Href: f.Link}},
+		ID:       f.ID,
+	}
+	if f.Self != "" {
+		feed.Links = append(feed.Links, atomLink{Href: f.Self, Rel: "self"})
+	}
+	if f.Author != "" {
+		feed.Author = &atomPerson{f.Author}
+	}
+	var updated time.Time
+	for _, it := range f.sorted() {
+		if f.Author == "" && it.Author == "" {
+			return "", fmt.Errorf("atom feed requires an author for the feed or for item '%s'", it.Title)
+		}
+		entry := atomEntry{
+			Title:     it.Title,
+			Link:      atomLink{Href: it.Link},
+			ID:        it.ID,
+			Published: it.Date.Format(time.RFC3339),
+			Updated:   it.Updated.Format(time.RFC3339),
+		}
+		if it.Author != "" {
+			entry.Author = &atomPerson{it.Author}
+		}
+		if it.Summary != "" {
+			entry.Summary = &atomText{Text: it.Summary}
+		}
+		if it.Content != "" {
+			entry.Content = &atomText{Type: "html", Text: it.Content}
+		}
+		if it.Updated.After(updated) {
+			updated = it.Updated
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.Format(time.RFC3339)
+	return renderFeed(feed)
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Author      string `xml:"author,omitempty"`
+	Description string `xml:"description,omitempty"`
+}
+
+type rssFeed struct {
+	XMLName       xml.Name  `xml:"rss"`
+	Version       string    `xml:"version,attr"`
+	Title         string    `xml:"channel>title"`
+	Link          string    `xml:"channel>link"`
+	Description   string    `xml:"channel>description"`
+	LastBuildDate string    `xml:"channel>lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"channel>item"`
+}
+
+// RenderRSS writes the feed as an RSS 2.0 document. The description of each
+// item is its content, or its summary if it has no content.
+func (f *Feed) RenderRSS() (template.HTML, error) {
+	feed := rssFeed{Version: "2.0", Title: f.Title, Link: f.Link, Description: f.Description}
+	if feed.Description == "" {
+		feed.Description = f.Title
+	}
+	for _, it := range f.sorted() {
+		item := rssItem{
+			Title:       it.Title,
+			Link:        it.Link,
+			GUID:        it.ID,
+			PubDate:     it.Date.Format(time.RFC1123Z),
+			Author:      it.Author,
+			Description: it.Content,
+		}
+		if item.Description == "" {
+			item.Description = it.Summary
+		}
+		if feed.LastBuildDate == "" {
+			feed.LastBuildDate = item.PubDate
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return renderFeed(feed)
+}
+
+func renderFeed(feed any) (template.HTML, error) {
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render feed: %w", err)
+	}
+	return template.HTML(xml.Header + string(out) + "\n"), nil
+}
@@ -0,0 +1,61 @@
+package xtemplate
+
+// This file contains template funcs that generate random identifiers.
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/ksuid"
+)
+
+// uuidv7 generates a time-ordered UUID, which makes a better database key
+// than sprig's random `uuidv4` because new ids sort after old ones.
+func FuncUuidv7() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	return id.String(), nil
+}
+
+// ksuid generates a 27 character K-Sortable Unique IDentifier, which sorts by
+// creation time.
+func FuncKsuid() (string, error) {
+	id, err := ksuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate ksuid: %w", err)
+	}
+	return id.String(), nil
+}
+
+// nanoidAlphabet is the url-safe alphabet used by nanoid.
+const nanoidAlphabet = "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// nanoid generates a random url-safe id of 21 characters, or of size
+// characters if given, which is short enough for filenames and urls.
+//
+//	{{nanoid}} {{nanoid 10}}
+func FuncNanoid(size ...int) (string, error) {
+	n := 21
+	switch len(size) {
+	case 0:
+	case 1:
+		n = size[0]
+	default:
+		return "", fmt.Errorf("too many size arguments provided: %v", size)
+	}
+	if n <= 0 || n > 1024 {
+		return "", fmt.Errorf("nanoid size must be between 1 and 1024, got %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate nanoid: %w", err)
+	}
+	// the alphabet has 64 characters so masking each byte is unbiased
+	for i := range b {
+		b[i] = nanoidAlphabet[b[i]&63]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,111 @@
+package xtemplate
+
+// This file contains template funcs that format numbers and amounts of money.
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// formatNumber formats value with precision digits after the decimal point
+// and separators between groups of thousands, in the style of an optional
+// locale like `de` or `en-IN`. Default locale `en`. The value can be a number
+// or a numeric string.
+//
+//	{{formatNumber 2 1234567.891}}       -> 1,234,567.89
+//	{{formatNumber 0 "de" .Row.visits}}  -> 1.234.568
+func FuncFormatNumber(precision int, args ...any) (string, error) {
+	tag, value, err := numberArgs("formatNumber", args)
+	if err != nil {
+		return "", err
+	}
+	if precision < 0 || precision > 20 {
+		return "", fmt.Errorf("formatNumber precision must be between 0 and 20, got %d", precision)
+	}
+	return message.NewPrinter(tag).Sprint(number.Decimal(value, number.Scale(precision))), nil
+}
+
+// currencyPatterns are the positions of the currency symbol relative to the
+// amount by locale or language, where `{s}` is the symbol and `{n}` is the
+// amount, separated by a non-breaking space. Other locales put the symbol
+// directly before the amount.
+var currencyPatterns = map[string]string{
+	"de":    "{n}\u00a0{s}",
+	"fr":    "{n}\u00a0{s}",
+	"es":    "{n}\u00a0{s}",
+	"it":    "{n}\u00a0{s}",
+	"pt":    "{n}\u00a0{s}",
+	"pl":    "{n}\u00a0{s}",
+	"cs":    "{n}\u00a0{s}",
+	"sv":    "{n}\u00a0{s}",
+	"da":    "{n}\u00a0{s}",
+	"nb":    "{n}\u00a0{s}",
+	"fi":    "{n}\u00a0{s}",
+	"ru":    "{n}\u00a0{s}",
+	"nl":    "{s}\u00a0{n}",
+	"pt-BR": "{s}\u00a0{n}",
+	"de-CH": "{s}\u00a0{n}",
+}
+
+// formatCurrency formats amount as money in the currency with the ISO 4217
+// code, like `USD` or `EUR`, with the currency's usual number of decimal
+// places and symbol, in the style of an optional locale like `de`. Default
+// locale `en`.
+//
+//	{{formatCurrency "USD" 1234.5}}       -> $1,234.50
+//	{{formatCurrency "EUR" "de" .Total}}  -> 1.234,50 €
+func FuncFormatCurrency(code string, args ...any) (string, error) {
+	tag, value, err := numberArgs("formatCurrency", args)
+	if err != nil {
+		return "", err
+	}
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return "", fmt.Errorf("unknown currency code '%s': %w", code, err)
+	}
+	p := message.NewPrinter(tag)
+	scale, _ := currency.Standard.Rounding(unit)
+	amount := p.Sprint(number.Decimal(math.Abs(value), number.Scale(scale)))
+	pattern, ok := currencyPatterns[tag.String()]
+	if !ok {
+		base, _ := tag.Base()
+		if pattern, ok = currencyPatterns[base.String()]; !ok {
+			pattern = "{s}{n}"
+		}
+	}
+	result := strings.NewReplacer("{s}", p.Sprint(currency.Symbol(unit)), "{n}", amount).Replace(pattern)
+	if value < 0 {
+		result = "-" + result
+	}
+	return result, nil
+}
+
+// numberArgs parses the `[locale,] value` arguments of the number funcs.
+func numberArgs(fn string, args []any) (language.Tag, float64, error) {
+	tag := language.English
+	switch len(args) {
+	case 1:
+	case 2:
+		locale, ok := args[0].(string)
+		if !ok {
+			return tag, 0, fmt.Errorf("%s locale must be a string, got %T", fn, args[0])
+		}
+		var err error
+		if tag, err = language.Parse(locale); err != nil {
+			return tag, 0, fmt.Errorf("%s invalid locale '%s': %w", fn, locale, err)
+		}
+	default:
+		return tag, 0, fmt.Errorf("%s requires an optional locale and a value, got %d arguments", fn, len(args))
+	}
+	value, ok := toNumber(args[len(args)-1])
+	if !ok {
+		return tag, 0, fmt.Errorf("%s value must be a number, got %T: %v", fn, args[len(args)-1], args[len(args)-1])
+	}
+	return tag, value, nil
+}
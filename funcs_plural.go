@@ -0,0 +1,143 @@
+package xtemplate
+
+// This file contains template funcs that pick plural forms and ordinals of
+// numbers in a few languages.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PluralLocale describes how a language writes counts and ordinals.
+type PluralLocale struct {
+	// Singular reports whether a count of n uses the singular form.
+	Singular func(n float64) bool
+	// Ordinal formats n as an ordinal number, like `1st`.
+	Ordinal func(n int64) string
+}
+
+var pluralLocalesMutex sync.RWMutex
+
+// pluralLocales are the languages supported by pluralize and ordinal.
+var pluralLocales = map[string]PluralLocale{
+	"en": {
+		Singular: func(n float64) bool { return n == 1 },
+		Ordinal: func(n int64) string {
+			abs := n
+			if abs < 0 {
+				abs = -abs
+			}
+			suffix := "th"
+			if abs%100 < 11 || abs%100 > 13 {
+				switch abs % 10 {
+				case 1:
+					suffix = "st"
+				case 2:
+					suffix = "nd"
+				case 3:
+					suffix = "rd"
+				}
+			}
+			return strconv.FormatInt(n, 10) + suffix
+		},
+	},
+	"fr": {
+		Singular: func(n float64) bool { return n >= 0 && n < 2 },
+		Ordinal: func(n int64) string {
+			if n == 1 {
+				return "1er"
+			}
+			return strconv.FormatInt(n, 10) + "e"
+		},
+	},
+	"de": {
+		Singular: func(n float64) bool { return n == 1 },
+		Ordinal:  func(n int64) string { return strconv.FormatInt(n, 10) + "." },
+	},
+	"es": {
+		Singular: func(n float64) bool { return n == 1 },
+		Ordinal:  func(n int64) string { return strconv.FormatInt(n, 10) + ".º" },
+	},
+	"it": {
+		Singular: func(n float64) bool { return n == 1 },
+		Ordinal:  func(n int64) string { return strconv.FormatInt(n, 10) + "º" },
+	},
+	"nl": {
+		Singular: func(n float64) bool { return n == 1 },
+		Ordinal:  func(n int64) string { return strconv.FormatInt(n, 10) + "e" },
+	},
+}
+
+// AddPluralLocale adds a language that pluralize and ordinal can use by its
+// name, like `pt` or `en-GB`.
+func AddPluralLocale(name string, locale PluralLocale) {
+	pluralLocalesMutex.Lock()
+	defer pluralLocalesMutex.Unlock()
+	if _, ok := pluralLocales[name]; ok {
+		panic(fmt.Sprintf("plural locale with name %s already exists", name))
+	}
+	pluralLocales[name] = locale
+}
+
+// lookupPluralLocale finds the locale named by the optional locale argument,
+// or its language if there's no locale with the exact name. Default `en`.
+func lookupPluralLocale(fn string, locale []string) (PluralLocale, error) {
+	name := "en"
+	switch len(locale) {
+	case 0:
+	case 1:
+		name = strings.ReplaceAll(locale[0], "_", "-")
+	default:
+		return PluralLocale{}, fmt.Errorf("%s: too many locale arguments provided: %v", fn, locale)
+	}
+	pluralLocalesMutex.RLock()
+	defer pluralLocalesMutex.RUnlock()
+	if l, ok := pluralLocales[name]; ok {
+		return l, nil
+	}
+	lang, _, _ := strings.Cut(name, "-")
+	if l, ok := pluralLocales[strings.ToLower(lang)]; ok {
+		return l, nil
+	}
+	return PluralLocale{}, fmt.Errorf("%s: unsupported locale '%s'", fn, name)
+}
+
+// pluralize returns the count n followed by the singular or plural word,
+// like `1 item` or `2 items`, using the rules of an optional locale like
+// `fr`. Default locale `en`.
+//
+//	{{pluralize (len .Items) "item" "items"}}
+func FuncPluralize(n any, singular, plural string, locale ...string) (string, error) {
+	count, ok := toNumber(n)
+	if !ok {
+		return "", fmt.Errorf("pluralize count must be a number, got %T: %v", n, n)
+	}
+	l, err := lookupPluralLocale("pluralize", locale)
+	if err != nil {
+		return "", err
+	}
+	word := plural
+	if l.Singular(count) {
+		word = singular
+	}
+	return strconv.FormatFloat(count, 'f', -1, 64) + " " + word, nil
+}
+
+// ordinal formats the whole number n as an ordinal, like `1st` or `22nd`,
+// in an optional locale like `fr`. Default locale `en`.
+//
+//	<p>You are the {{ordinal .Position}} person in line.</p>
+func FuncOrdinal(n any, locale ...string) (string, error) {
+	number, ok := toNumber(n)
+	if !ok || number != math.Trunc(number) {
+		return "", fmt.Errorf("ordinal requires a whole number, got %T: %v", n, n)
+	}
+	l, err := lookupPluralLocale("ordinal", locale)
+	if err != nil {
+		return "", err
+	}
+	return l.Ordinal(int64(number)), nil
+}
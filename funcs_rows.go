@@ -0,0 +1,224 @@
+package xtemplate
+
+// This file contains template funcs that aggregate rows, like the results of
+// `.DB.QueryRows`.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sumBy adds up the column key of rows, skipping rows where it's missing or
+// null. Rows can be maps, like the results of `.DB.QueryRows` or `parseCsv`,
+// and values can be numbers or numeric strings.
+//
+//	{{.DB.QueryRows "SELECT name, price FROM items" | sumBy "price"}}
+func FuncSumBy(key string, rows any) (float64, error) {
+	values, err := columnNumbers("sumBy", key, rows)
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum, nil
+}
+
+// avgBy averages the column key of rows like sumBy, or returns 0 if no row
+// has a value.
+func FuncAvgBy(key string, rows any) (float64, error) {
+	values, err := columnNumbers("avgBy", key, rows)
+	if err != nil || len(values) == 0 {
+		return 0, err
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values)), nil
+}
+
+// minBy returns the smallest value of the column key of rows, skipping rows
+// where it's missing or null, or nil if no row has a value. Numbers are
+// compared by value, times chronologically, and anything else as strings.
+func FuncMinBy(key string, rows any) (any, error) {
+	return columnExtreme("minBy", key, rows, -1)
+}
+
+// maxBy returns the largest value of the column key of rows like minBy.
+func FuncMaxBy(key string, rows any) (any, error) {
+	return columnExtreme("maxBy", key, rows, 1)
+}
+
+// RowGroup is a group of rows returned by groupBy that have the same Key.
+type RowGroup struct {
+	Key  any
+	Rows []any
+}
+
+// groupBy groups rows by the value of the column key, in the order each value
+// first appears. Rows where key is missing are grouped under a nil Key.
+//
+//	{{range .DB.QueryRows "SELECT category, price FROM items" | groupBy "category"}}
+//	  <h2>{{.Key}}</h2> Total: {{sumBy "price" .Rows}}
+//	{{end}}
+func FuncGroupBy(key string, rows any) ([]RowGroup, error) {
+	list, err := rowList("groupBy", rows)
+	if err != nil {
+		return nil, err
+	}
+	var groups []RowGroup
+	index := map[any]int{}
+	for i := 0; i < list.Len(); i++ {
+		value, err := rowColumn("groupBy", key, list, i)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		if value != nil && !reflect.TypeOf(value).Comparable() {
+			return nil, fmt.Errorf("groupBy cannot group by %T in column '%s' of row %d", value, key, i)
+		}
+		n, ok := index[value]
+		if !ok {
+			n = len(groups)
+			index[value] = n
+			groups = append(groups, RowGroup{Key: value})
+		}
+		groups[n].Rows = append(groups[n].Rows, list.Index(i).Interface())
+	}
+	return groups, nil
+}
+
+// rowList checks that rows is a list.
+func rowList(fn string, rows any) (reflect.Value, error) {
+	list := reflect.ValueOf(rows)
+	if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+		return reflect.Value{}, fmt.Errorf("%s rows must be a list, got %T", fn, rows)
+	}
+	return list, nil
+}
+
+// rowColumn returns the value of the column key of the i-th row of rows, or
+// nil if it's missing.
+func rowColumn(fn, key string, rows reflect.Value, i int) (any, error) {
+	row := csvRow(rows, i)
+	if row.Kind() != reflect.Map || row.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("%s rows must be maps with string keys, got %s in row %d", fn, row.Kind(), i)
+	}
+	v := row.MapIndex(reflect.ValueOf(key).Convert(row.Type().Key()))
+	if !v.IsValid() || (v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer) && v.IsNil() {
+		return nil, nil
+	}
+	return v.Interface(), nil
+}
+
+// columnValues returns the non-null values of the column key of rows.
+func columnValues(fn, key string, rows any) ([]any, error) {
+	list, err := rowList(fn, rows)
+	if err != nil {
+		return nil, err
+	}
+	var values []any
+	for i := 0; i < list.Len(); i++ {
+		value, err := rowColumn(fn, key, list, i)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// columnNumbers returns the non-null values of the column key of rows as
+// numbers.
+func columnNumbers(fn, key string, rows any) ([]float64, error) {
+	values, err := columnValues(fn, key, rows)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]float64, len(values))
+	for i, value := range values {
+		n, ok := toNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("%s column '%s' has a value that isn't a number: %v", fn, key, value)
+		}
+		numbers[i] = n
+	}
+	return numbers, nil
+}
+
+// columnExtreme returns the value of the column key of rows that compares
+// first in the direction dir, -1 for the smallest or 1 for the largest.
+func columnExtreme(fn, key string, rows any, dir int) (any, error) {
+	values, err := columnValues(fn, key, rows)
+	if err != nil {
+		return nil, err
+	}
+	var result any
+	for _, value := range values {
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		if result == nil || compareValues(value, result) == dir {
+			result = value
+		}
+	}
+	return result, nil
+}
+
+// compareValues compares a and b as numbers if they both are, as times if they
+// both are, and otherwise as strings.
+func compareValues(a, b any) int {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// toNumber converts numbers and numeric strings to a float64.
+func toNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	case []byte:
+		n, err := strconv.ParseFloat(string(bytes.TrimSpace(v)), 64)
+		return n, err == nil
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
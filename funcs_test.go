@@ -0,0 +1,36 @@
+package xtemplate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFuncHumanize(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		data   any
+		want   string
+	}{
+		{"size", "2048000", "2.0 MB"},
+		{"size", 2048000, "2.0 MB"},
+		{"duration:2", "1h32m", "1 hour 32 minutes"},
+		{"duration", 90 * time.Minute, "about an hour"},
+		{"comma", "123456789012345678901234567890", "123,456,789,012,345,678,901,234,567,890"},
+		{"comma", int64(1234567), "1,234,567"},
+		{"ordinal", 22, "22nd"},
+	} {
+		got, err := FuncHumanizeValue(test.format, test.data)
+		if err != nil || got != test.want {
+			t.Errorf("humanize %q %v = %q, %v, want %q", test.format, test.data, got, err, test.want)
+		}
+		// the string version gives the same result for strings
+		if s, ok := test.data.(string); ok {
+			if got, err := FuncHumanize(test.format, s); err != nil || got != test.want {
+				t.Errorf("FuncHumanize(%q, %q) = %q, %v, want %q", test.format, s, got, err, test.want)
+			}
+		}
+	}
+	if _, err := FuncHumanize("size", "big"); err == nil {
+		t.Errorf("humanized a size that isn't a number")
+	}
+}
@@ -0,0 +1,368 @@
+package xtemplate
+
+// This file contains template funcs that search and transform text.
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// regexpCacheSize is the maximum number of compiled patterns kept by the
+// regexp funcs.
+const regexpCacheSize = 1000
+
+var regexpCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compileRegexp compiles pattern, or returns it from the cache if it was
+// compiled before, so templates don't recompile patterns on every call.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	re, ok := regexpCache.m[pattern]
+	regexpCache.Unlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regexp pattern: %w", err)
+	}
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+	if len(regexpCache.m) >= regexpCacheSize {
+		// patterns are usually literals in templates, so the cache only fills
+		// up if patterns are built dynamically; start over
+		clear(regexpCache.m)
+	}
+	regexpCache.m[pattern] = re
+	return re, nil
+}
+
+// reMatch reports whether s contains a match of the regular expression
+// pattern, using Go's RE2 syntax.
+//
+//	{{if reMatch `^\d{5}$` .Zip}}
+func FuncReMatch(pattern, s string) (bool, error) {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+// reFind returns the first match of pattern in s, or an empty string if there
+// is no match.
+func FuncReFind(pattern, s string) (string, error) {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.FindString(s), nil
+}
+
+// reFindAll returns all matches of pattern in s, or at most n matches if n is
+// given.
+//
+//	{{range reFindAll `#\w+` .Post.Body}}{{.}}{{end}}
+func FuncReFindAll(pattern, s string, n ...int) ([]string, error) {
+	limit := -1
+	switch len(n) {
+	case 0:
+	case 1:
+		limit = n[0]
+	default:
+		return nil, fmt.Errorf("too many n arguments provided: %v", n)
+	}
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.FindAllString(s, limit), nil
+}
+
+// reReplace replaces all matches of pattern in s with repl, where `$1` or
+// `${name}` in repl is replaced with the text of the submatch.
+//
+//	{{reReplace `(\w+)@example\.com` "$1 at example" .Email}}
+func FuncReReplace(pattern, repl, s string) (string, error) {
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// slugTransliterations are the ascii spellings of letters that don't
+// decompose into an ascii letter and accents.
+var slugTransliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l",
+	'þ': "th", 'ı': "i", 'ħ': "h", 'ŋ': "ng", 'ŀ': "l", 'ſ': "s", '&': "and",
+	// greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+	// cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya", 'є': "ye",
+	'і': "i", 'ї': "yi", 'ґ': "g",
+}
+
+// slugify converts s to a lowercase, dash-separated slug that can be used in
+// a url path, like `hello-world` for `Hello, World!`. Accents are removed and
+// common latin, greek, and cyrillic letters are transliterated to ascii;
+// other letters and digits, like CJK characters, are kept. If maxLength is
+// given, the slug is shortened to at most that many characters, at a dash if
+// possible.
+//
+//	{{.Form.Get "title" | slugify}}
+//	{{slugify .Post.Title 50}}
+func FuncSlugify(s string, maxLength ...int) (string, error) {
+	limit := -1
+	switch len(maxLength) {
+	case 0:
+	case 1:
+		if limit = maxLength[0]; limit < 1 {
+			return "", fmt.Errorf("slugify maxLength must be positive, got %d", limit)
+		}
+	default:
+		return "", fmt.Errorf("too many maxLength arguments provided: %v", maxLength)
+	}
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFKD.String(s) {
+		r = unicode.ToLower(r)
+		if unicode.Is(unicode.Mn, r) || r == '\'' || r == '’' {
+			continue
+		}
+		var text string
+		if t, ok := slugTransliterations[r]; ok {
+			text = t
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			text = string(r)
+		} else {
+			dash = b.Len() > 0
+			continue
+		}
+		if text == "" {
+			continue
+		}
+		if dash {
+			b.WriteByte('-')
+			dash = false
+		}
+		b.WriteString(text)
+	}
+	slug := []rune(b.String())
+	if limit < 0 || len(slug) <= limit {
+		return string(slug), nil
+	}
+	cut := limit
+	if slug[limit] != '-' {
+		// end at the last whole word if there is one
+		for i := limit - 1; i > 0; i-- {
+			if slug[i] == '-' {
+				cut = i
+				break
+			}
+		}
+	}
+	return strings.TrimRight(string(slug[:cut]), "-"), nil
+}
+
+// inlineTags are the html elements that don't separate words, so removing
+// them doesn't add a space.
+var inlineTags = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "cite": true,
+	"code": true, "data": true, "del": true, "dfn": true, "em": true, "i": true,
+	"ins": true, "kbd": true, "mark": true, "q": true, "s": true, "samp": true,
+	"small": true, "span": true, "strong": true, "sub": true, "sup": true,
+	"time": true, "u": true, "var": true,
+}
+
+// htmlText returns the text content of the html s with whitespace collapsed,
+// skipping the contents of elements that aren't displayed like script and
+// style.
+func htmlText(s any) (string, error) {
+	var input string
+	switch v := s.(type) {
+	case string:
+		input = v
+	case template.HTML:
+		input = string(v)
+	case []byte:
+		input = string(v)
+	default:
+		return "", fmt.Errorf("expected html as a string, got %T", s)
+	}
+	var b strings.Builder
+	skip := 0
+	z := html.NewTokenizer(strings.NewReader(input))
+	for {
+		switch tt := z.Next(); tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return "", fmt.Errorf("failed to parse html: %w", err)
+			}
+			return strings.Join(strings.Fields(b.String()), " "), nil
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch tag := string(name); tag {
+			case "script", "style", "template", "noscript", "head":
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+			default:
+				if !inlineTags[tag] {
+					b.WriteByte(' ')
+				}
+			}
+		}
+	}
+}
+
+// stripTags returns the text content of html without any tags, for example
+// to use rendered markdown in a meta description. The result is plain text
+// that's escaped when written into a template.
+//
+//	<meta name="description" content="{{stripTags .Page.Content}}">
+func FuncStripTags(html any) (string, error) {
+	return htmlText(html)
+}
+
+// excerpt returns the text content of html shortened to at most n
+// characters at a word boundary, with `…` appended if it was shortened.
+//
+//	{{range .Page.Pages "/blog/"}}<p>{{excerpt .Content 200}}</p>{{end}}
+func FuncExcerpt(html any, n int) (string, error) {
+	if n < 1 {
+		return "", fmt.Errorf("excerpt length must be positive, got %d", n)
+	}
+	text, err := htmlText(html)
+	if err != nil {
+		return "", err
+	}
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text, nil
+	}
+	cut := n
+	if runes[n] != ' ' {
+		// end at the last whole word if there is one
+		for i := n - 1; i > 0; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+	}
+	return strings.TrimRightFunc(string(runes[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…", nil
+}
+
+// readingTimeWPM is the reading speed used by readingTime in words per minute.
+const readingTimeWPM = 200
+
+// readingTime returns the estimated number of minutes it takes to read the
+// text content of html, rounded up.
+//
+//	{{readingTime .Page.Content}} min read
+func FuncReadingTime(html any) (int, error) {
+	text, err := htmlText(html)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Ceil(float64(len(strings.Fields(text))) / readingTimeWPM)), nil
+}
+
+// diff compares the lines of old and new and returns a unified diff marked
+// up as html, or an empty string if they're the same. Each hunk header is in
+// a `<span class="diff-hunk">`, unchanged lines in a `<span
+// class="diff-ctx">`, removed lines in a `<del class="diff-del">`, and added
+// lines in an `<ins class="diff-ins">`. Hunks include context lines around
+// each change, default 3.
+//
+//	<pre>{{diff $previous.body $revision.body}}</pre>
+func FuncDiff(old, new string, context ...int) (template.HTML, error) {
+	n := 3
+	switch len(context) {
+	case 0:
+	case 1:
+		if n = context[0]; n < 0 {
+			return "", fmt.Errorf("diff context must not be negative, got %d", n)
+		}
+	default:
+		return "", fmt.Errorf("too many context arguments provided: %v", context)
+	}
+	a, b := diffLines(old), diffLines(new)
+	var buf strings.Builder
+	line := func(tag, class, prefix, text string) {
+		fmt.Fprintf(&buf, "<%s class=\"%s\">%s%s</%s>\n", tag, class, prefix, template.HTMLEscapeString(text), tag)
+	}
+	for _, hunk := range difflib.NewMatcher(a, b).GetGroupedOpCodes(n) {
+		first, last := hunk[0], hunk[len(hunk)-1]
+		line("span", "diff-hunk", "", fmt.Sprintf("@@ -%s +%s @@", diffRange(first.I1, last.I2), diffRange(first.J1, last.J2)))
+		for _, op := range hunk {
+			if op.Tag == 'e' {
+				for _, text := range a[op.I1:op.I2] {
+					line("span", "diff-ctx", " ", text)
+				}
+				continue
+			}
+			if op.Tag == 'r' || op.Tag == 'd' {
+				for _, text := range a[op.I1:op.I2] {
+					line("del", "diff-del", "-", text)
+				}
+			}
+			if op.Tag == 'r' || op.Tag == 'i' {
+				for _, text := range b[op.J1:op.J2] {
+					line("ins", "diff-ins", "+", text)
+				}
+			}
+		}
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// diffLines splits s into lines, ignoring a final newline.
+func diffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n"), "\n")
+}
+
+// diffRange formats the lines start to end of a hunk like `start,count`, with
+// lines numbered from 1.
+func diffRange(start, end int) string {
+	switch count := end - start; count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
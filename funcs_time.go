@@ -0,0 +1,259 @@
+package xtemplate
+
+// This file contains template funcs that format dates and times.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // so time zones work on systems without a tz database
+)
+
+// timeLayouts are the names of layouts that formatTime accepts in place of a
+// Go layout string.
+var timeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC822":      time.RFC822,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+	"Kitchen":     time.Kitchen,
+	"Stamp":       time.Stamp,
+}
+
+// formatTime formats value with layout in the time zone tz. The layout is a
+// Go time layout like `Jan 2, 2006 3:04 PM` or the name of a standard layout
+// like `RFC3339` or `DateOnly`. The time zone is an IANA name like
+// `America/Chicago`, `UTC`, `Local`, or empty to keep the value's time zone.
+// The value can be a time.Time, a string in RFC3339 or a common database
+// format like `2006-01-02 15:04:05`, or a unix timestamp in seconds.
+//
+//	{{formatTime "Jan 2, 2006 3:04 PM" "America/Chicago" .Row.created_at}}
+func FuncFormatTime(layout, tz string, value any) (string, error) {
+	t, err := toTime(value)
+	if err != nil {
+		return "", err
+	}
+	if t, err = inTimeZone(t, tz); err != nil {
+		return "", err
+	}
+	if named, ok := timeLayouts[layout]; ok {
+		layout = named
+	}
+	return t.Format(layout), nil
+}
+
+// inTimeZone converts t to the IANA time zone tz, or returns it unchanged if tz
+// is empty.
+func inTimeZone(t time.Time, tz string) (time.Time, error) {
+	if tz == "" {
+		return t, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return t, fmt.Errorf("unknown time zone '%s': %w", tz, err)
+	}
+	return t.In(loc), nil
+}
+
+// toTime converts a time.Time, a time string, or a unix timestamp to a
+// time.Time. Strings without a time zone and timestamps are in UTC.
+func toTime(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, fmt.Errorf("time value is nil")
+		}
+		return *v, nil
+	case int:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case int32:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	case uint32:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case uint64:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case float64:
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)).UTC(), nil
+	case []byte:
+		return toTime(string(v))
+	case string:
+		s := strings.TrimSpace(v)
+		if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(unix, 0).UTC(), nil
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999", time.DateTime + ".999999999", time.DateOnly} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("failed to parse time '%s', expected a format like RFC3339 or 2006-01-02 15:04:05", v)
+	}
+	return time.Time{}, fmt.Errorf("cannot convert %T to a time", value)
+}
+
+// dateLocale has the names and date patterns of a locale. Patterns use
+// `{d}`, `{dd}`, `{M}`, `{MM}`, `{MMM}`, `{MMMM}`, `{yy}`, `{yyyy}`, and
+// `{EEEE}` for the day, month, year, and weekday.
+type dateLocale struct {
+	months, shortMonths [12]string
+	weekdays            [7]string // starting with Sunday
+	styles              map[string]string
+}
+
+var (
+	enMonths      = [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+	enShortMonths = [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	enWeekdays    = [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+)
+
+// dateLocales are the locales supported by formatDateLocale, by BCP 47 tag.
+// A tag like `de-AT` falls back to its language `de` if it's not listed.
+var dateLocales = map[string]dateLocale{
+	"en": {enMonths, enShortMonths, enWeekdays, map[string]string{
+		"short":  "{M}/{d}/{yy}",
+		"medium": "{MMM} {d}, {yyyy}",
+		"long":   "{MMMM} {d}, {yyyy}",
+		"full":   "{EEEE}, {MMMM} {d}, {yyyy}",
+	}},
+	"en-GB": {enMonths, enShortMonths, enWeekdays, map[string]string{
+		"short":  "{dd}/{MM}/{yyyy}",
+		"medium": "{d} {MMM} {yyyy}",
+		"long":   "{d} {MMMM} {yyyy}",
+		"full":   "{EEEE} {d} {MMMM} {yyyy}",
+	}},
+	"de": {
+		[12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		[12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		[7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		map[string]string{
+			"short":  "{dd}.{MM}.{yy}",
+			"medium": "{dd}.{MM}.{yyyy}",
+			"long":   "{d}. {MMMM} {yyyy}",
+			"full":   "{EEEE}, {d}. {MMMM} {yyyy}",
+		},
+	},
+	"fr": {
+		[12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		[12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		[7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		map[string]string{
+			"short":  "{dd}/{MM}/{yyyy}",
+			"medium": "{d} {MMM} {yyyy}",
+			"long":   "{d} {MMMM} {yyyy}",
+			"full":   "{EEEE} {d} {MMMM} {yyyy}",
+		},
+	},
+	"es": {
+		[12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		[12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		[7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		map[string]string{
+			"short":  "{d}/{M}/{yy}",
+			"medium": "{d} {MMM} {yyyy}",
+			"long":   "{d} de {MMMM} de {yyyy}",
+			"full":   "{EEEE}, {d} de {MMMM} de {yyyy}",
+		},
+	},
+	"it": {
+		[12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		[12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		[7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		map[string]string{
+			"short":  "{dd}/{MM}/{yy}",
+			"medium": "{d} {MMM} {yyyy}",
+			"long":   "{d} {MMMM} {yyyy}",
+			"full":   "{EEEE} {d} {MMMM} {yyyy}",
+		},
+	},
+	"pt": {
+		[12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		[12]string{"jan.", "fev.", "mar.", "abr.", "mai.", "jun.", "jul.", "ago.", "set.", "out.", "nov.", "dez."},
+		[7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+		map[string]string{
+			"short":  "{dd}/{MM}/{yyyy}",
+			"medium": "{d} de {MMM} de {yyyy}",
+			"long":   "{d} de {MMMM} de {yyyy}",
+			"full":   "{EEEE}, {d} de {MMMM} de {yyyy}",
+		},
+	},
+	"nl": {
+		[12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		[12]string{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+		[7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		map[string]string{
+			"short":  "{dd}-{MM}-{yyyy}",
+			"medium": "{d} {MMM} {yyyy}",
+			"long":   "{d} {MMMM} {yyyy}",
+			"full":   "{EEEE} {d} {MMMM} {yyyy}",
+		},
+	},
+	"ja": {
+		[12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"},
+		[12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"},
+		[7]string{"日曜日", "月曜日", "火曜日", "水曜日", "木曜日", "金曜日", "土曜日"},
+		map[string]string{
+			"short":  "{yyyy}/{MM}/{dd}",
+			"medium": "{yyyy}/{MM}/{dd}",
+			"long":   "{yyyy}年{M}月{d}日",
+			"full":   "{yyyy}年{M}月{d}日{EEEE}",
+		},
+	},
+}
+
+// formatDateLocale formats the date of value in the style of locale. The
+// locale is a tag like `en`, `en-GB`, `de`, `fr`, `es`, `it`, `pt`, `nl`, or
+// `ja` in any case, and the style is `short`, `medium`, `long`, or `full`. The
+// value can be anything formatTime accepts. An optional IANA time zone like
+// formatTime's picks the calendar day the date falls on, otherwise the value's
+// own time zone is used.
+//
+//	{{formatDateLocale "de" "long" .Post.Date "Europe/Berlin"}}
+//
+// outputs a date like `2. Januar 2006`.
+func FuncFormatDateLocale(locale, style string, value any, tz ...string) (string, error) {
+	t, err := toTime(value)
+	if err != nil {
+		return "", err
+	}
+	if len(tz) > 1 {
+		return "", fmt.Errorf("too many time zones: %q", tz)
+	} else if len(tz) == 1 {
+		if t, err = inTimeZone(t, tz[0]); err != nil {
+			return "", err
+		}
+	}
+	lang, region, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	lang = strings.ToLower(lang)
+	loc, ok := dateLocales[lang+"-"+strings.ToUpper(region)]
+	if !ok {
+		if loc, ok = dateLocales[lang]; !ok {
+			return "", fmt.Errorf("unsupported date locale '%s'", locale)
+		}
+	}
+	pattern, ok := loc.styles[style]
+	if !ok {
+		return "", fmt.Errorf("unknown date style '%s', expected short, medium, long, or full", style)
+	}
+	year := strconv.Itoa(t.Year())
+	return strings.NewReplacer(
+		"{d}", strconv.Itoa(t.Day()),
+		"{dd}", fmt.Sprintf("%02d", t.Day()),
+		"{M}", strconv.Itoa(int(t.Month())),
+		"{MM}", fmt.Sprintf("%02d", int(t.Month())),
+		"{MMM}", loc.shortMonths[t.Month()-1],
+		"{MMMM}", loc.months[t.Month()-1],
+		"{yy}", year[max(len(year)-2, 0):],
+		"{yyyy}", year,
+		"{EEEE}", loc.weekdays[t.Weekday()],
+	).Replace(pattern), nil
+}
@@ -5,9 +5,7 @@ package xtemplate
 import (
 	"bytes"
 	"fmt"
-	"html/template"
 	"io"
-	"io/fs"
 	"log/slog"
 	"math"
 	"net/http"
@@ -15,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var bufPool = sync.Pool{
@@ -23,10 +22,22 @@ var bufPool = sync.Pool{
 	},
 }
 
-func bufferingTemplateHandler(server *Instance, tmpl *template.Template) http.HandlerFunc {
+// templateExecutor is implemented by both html/template and text/template
+// templates.
+type templateExecutor interface {
+	Name() string
+	Execute(io.Writer, any) error
+}
+
+func bufferingTemplateHandler(server *Instance, tmpl templateExecutor, contentType string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := GetLogger(r.Context())
 
+		if contentType != "" {
+			// set before executing so the template can override it
+			w.Header().Set("Content-Type", contentType)
+		}
+
 		dot, err := server.bufferDot.value(server.config.Ctx, w, r)
 		if err != nil {
 			log.Error("failed to initialize dot value", slog.Any("error", err))
@@ -38,19 +49,32 @@ func bufferingTemplateHandler(server *Instance, tmpl *template.Template) http.Ha
 		buf.Reset()
 		defer bufPool.Put(buf)
 
+		endSpan := startSpan(r.Context(), "template "+tmpl.Name())
 		err = tmpl.Execute(buf, *dot)
+		endSpan()
 
-		if err = server.bufferDot.cleanup(dot, err); err != nil {
+		endSpan = startSpan(r.Context(), "cleanup")
+		err = server.bufferDot.cleanup(dot, err)
+		endSpan()
+		if err != nil {
 			log.Warn("error executing template", slog.Any("error", err))
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
 
+		if trace := getTrace(r.Context()); trace != nil {
+			w.Header().Set("Server-Timing", trace.serverTiming())
+		}
+
+		if server.config.liveReload && shouldInjectLiveReload(w, r) {
+			w.Write(injectLiveReload(buf.Bytes()))
+			return
+		}
 		w.Write(buf.Bytes())
 	}
 }
 
-func flushingTemplateHandler(server *Instance, tmpl *template.Template) http.HandlerFunc {
+func flushingTemplateHandler(server *Instance, tmpl templateExecutor) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := GetLogger(r.Context())
 
@@ -63,6 +87,11 @@ func flushingTemplateHandler(server *Instance, tmpl *template.Template) http.Han
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 
+		// streams stay open longer than the server's write timeout
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && server.config.WriteTimeout != 0 {
+			log.Warn("failed to clear write deadline, the stream will be closed after the write timeout", slog.Any("error", err))
+		}
+
 		dot, err := server.flusherDot.value(server.config.Ctx, w, r)
 		if err != nil {
 			log.Error("failed to initialize dot value", slog.Any("error", err))
@@ -70,7 +99,9 @@ func flushingTemplateHandler(server *Instance, tmpl *template.Template) http.Han
 			return
 		}
 
+		endSpan := startSpan(r.Context(), "template "+tmpl.Name())
 		err = tmpl.Execute(w, *dot)
+		endSpan()
 
 		if err = server.flusherDot.cleanup(dot, err); err != nil {
 			log.Info("error executing template", slog.Any("error", err))
@@ -80,12 +111,14 @@ func flushingTemplateHandler(server *Instance, tmpl *template.Template) http.Han
 	}
 }
 
-func staticFileHandler(fs fs.FS, fileinfo *fileInfo) http.HandlerFunc {
+func staticFileHandler(server *Instance, fileinfo *fileInfo) http.HandlerFunc {
+	fs := server.config.TemplatesFS
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := GetLogger(r.Context())
 
 		urlpath := path.Clean(r.URL.Path)
-		if urlpath != fileinfo.identityPath {
+		hashedPath := fileinfo.hashedPath != "" && urlpath == fileinfo.hashedPath
+		if urlpath != fileinfo.identityPath && !hashedPath {
 			// should not happen; we only add handlers for existent files
 			log.LogAttrs(r.Context(), slog.LevelWarn, "tried to serve a file that doesn't exist")
 			http.NotFound(w, r)
@@ -100,6 +133,14 @@ func staticFileHandler(fs fs.FS, fileinfo *fileInfo) http.HandlerFunc {
 			return
 		}
 
+		// all encodings share the same etag, so a conditional request can be
+		// answered from the precomputed hash without touching the fs at all
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, fileinfo.hash) {
+			setStaticCacheHeaders(w, fileinfo, queryhash != "" || hashedPath)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		// negotiate encoding between the client's q value preference and fileinfo.encodings ordering (prefer earlier listed encodings first)
 		encoding, err := negiotiateEncoding(r.Header["Accept-Encoding"], fileinfo.encodings)
 		if err != nil {
@@ -111,7 +152,39 @@ func staticFileHandler(fs fs.FS, fileinfo *fileInfo) http.HandlerFunc {
 			return
 		}
 
+		// Byte ranges are always served from the identity encoding. Every
+		// encoding shares the same Etag, so a client resuming a download with
+		// If-Range could otherwise splice together ranges of different encodings.
+		ranged := r.Header.Get("Range") != ""
+		if ranged && encoding.encoding != "identity" {
+			if identity := identityEncoding(fileinfo); identity != nil {
+				log.LogAttrs(r.Context(), slog.LevelDebug, "serving range request from identity encoding", slog.String("negotiated", encoding.encoding))
+				encoding = identity
+			}
+		}
+
+		// compress on demand if there are no precompressed alternatives
+		if server.config.CompressStatic && !ranged && len(fileinfo.encodings) == 1 && encoding.encoding == "identity" && compressible(fileinfo.contentType, encoding.size) {
+			if ondemand, _ := negiotiateEncoding(r.Header["Accept-Encoding"], onDemandEncodings); ondemand.encoding != "identity" {
+				content, err := server.compressed(fileinfo, ondemand.encoding)
+				if err != nil {
+					log.LogAttrs(r.Context(), slog.LevelWarn, "failed to compress file on demand", slog.Any("error", err), slog.String("encoding", ondemand.encoding))
+				} else if content != nil {
+					log.LogAttrs(r.Context(), slog.LevelDebug, "serving compressed file request", slog.String("encoding", ondemand.encoding), slog.String("contenttype", fileinfo.contentType))
+					setStaticFileHeaders(server, w, fileinfo, ondemand.encoding, queryhash != "" || hashedPath)
+					http.ServeContent(w, r, encoding.path, encoding.modtime, bytes.NewReader(content))
+					return
+				}
+			}
+		}
+
 		log.LogAttrs(r.Context(), slog.LevelDebug, "serving file request", slog.String("encoding", encoding.encoding), slog.String("contenttype", fileinfo.contentType))
+		if encoding.content != nil {
+			setStaticFileHeaders(server, w, fileinfo, encoding.encoding, queryhash != "" || hashedPath)
+			http.ServeContent(w, r, encoding.path, encoding.modtime, bytes.NewReader(encoding.content))
+			return
+		}
+
 		file, err := fs.Open(encoding.path)
 		if err != nil {
 			log.LogAttrs(r.Context(), slog.LevelWarn, "failed to open file", slog.Any("error", err), slog.String("encoding.path", encoding.path), slog.String("requestpath", r.URL.Path))
@@ -125,25 +198,62 @@ func staticFileHandler(fs fs.FS, fileinfo *fileInfo) http.HandlerFunc {
 			stat, err := file.Stat()
 			if err != nil {
 				log.LogAttrs(r.Context(), slog.LevelError, "error getting stat of file", slog.Any("error", err))
-			} else if modtime := stat.ModTime(); !modtime.Equal(encoding.modtime) {
+			} else if modtime := stat.ModTime(); !modtime.IsZero() && !modtime.Equal(encoding.modtime) {
 				log.LogAttrs(r.Context(), slog.LevelWarn, "file maybe modified since loading", slog.Time("expected-modtime", encoding.modtime), slog.Time("actual-modtime", modtime))
 			}
 		}
 
-		w.Header().Add("Etag", `"`+fileinfo.hash+`"`)
-		w.Header().Add("Content-Type", fileinfo.contentType)
-		w.Header().Add("Content-Encoding", encoding.encoding)
-		w.Header().Add("Vary", "Accept-Encoding")
-		// w.Header().Add("Access-Control-Allow-Origin", "*") // ???
-		if queryhash != "" {
-			// cache aggressively if the request is disambiguated by a valid hash
-			// should be `public` ???
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-		}
+		setStaticFileHeaders(server, w, fileinfo, encoding.encoding, queryhash != "" || hashedPath)
 		http.ServeContent(w, r, encoding.path, encoding.modtime, file.(io.ReadSeeker))
 	}
 }
 
+func setStaticFileHeaders(server *Instance, w http.ResponseWriter, fileinfo *fileInfo, encoding string, immutable bool) {
+	setStaticCacheHeaders(w, fileinfo, immutable)
+	w.Header().Add("Content-Type", fileinfo.contentType)
+	w.Header().Add("Content-Encoding", encoding)
+	if server.config.NoSniff {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+}
+
+// setStaticCacheHeaders sets the headers that are also sent with a 304 Not
+// Modified response.
+func setStaticCacheHeaders(w http.ResponseWriter, fileinfo *fileInfo, immutable bool) {
+	for k, v := range fileinfo.headers {
+		w.Header()[k] = v
+	}
+	w.Header().Add("Etag", `"`+fileinfo.hash+`"`)
+	w.Header().Add("Vary", "Accept-Encoding")
+	// w.Header().Add("Access-Control-Allow-Origin", "*") // ???
+	if immutable {
+		// cache aggressively if the request is disambiguated by a valid hash
+		// should be `public` ???
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+}
+
+func identityEncoding(fileinfo *fileInfo) *encodingInfo {
+	for i, e := range fileinfo.encodings {
+		if e.encoding == "identity" {
+			return &fileinfo.encodings[i]
+		}
+	}
+	return nil
+}
+
+// etagMatch reports whether the If-None-Match header value matches hash using
+// the weak comparison function.
+func etagMatch(ifNoneMatch, hash string) bool {
+	for _, etag := range strings.Split(ifNoneMatch, ",") {
+		etag = strings.TrimSpace(etag)
+		if etag == "*" || strings.TrimPrefix(etag, "W/") == `"`+hash+`"` {
+			return true
+		}
+	}
+	return false
+}
+
 func negiotiateEncoding(acceptHeaders []string, encodings []encodingInfo) (*encodingInfo, error) {
 	var err error
 	// shortcuts
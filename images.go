@@ -0,0 +1,318 @@
+package xtemplate
+
+// This file implements an optional http handler that serves resized variants
+// of images.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/sync/singleflight"
+)
+
+var errServerStopped = errors.New("server stopped")
+
+// ImagesConfig configures a handler that serves resized variants of image
+// files at `GET <prefix>/{w}x{h}/{path...}`. Either dimension may be 0 to scale
+// proportionally to the other. Images are only ever scaled down to fit in the
+// requested box, preserving their aspect ratio.
+type ImagesConfig struct {
+	// The path prefix to mount the handler at. Default `/img`.
+	Prefix string `json:"prefix,omitempty"`
+
+	// The name of a configured directory to load images from. If empty, images
+	// are loaded from the templates FS.
+	Directory string `json:"directory,omitempty"`
+
+	// The maximum width and height that can be requested. Default `2048`.
+	MaxWidth  int `json:"max_width,omitempty"`
+	MaxHeight int `json:"max_height,omitempty"`
+
+	// The maximum number of pixels a source image can have to be decoded.
+	// Default `40000000` (40 megapixels).
+	MaxSourcePixels int `json:"max_source_pixels,omitempty"`
+
+	// The maximum total size in bytes of generated variants kept in memory.
+	// Default `64MiB`.
+	CacheBytes int `json:"cache_bytes,omitempty"`
+}
+
+func (c *ImagesConfig) defaults() {
+	if c.Prefix == "" {
+		c.Prefix = "/img"
+	}
+	c.Prefix = path.Clean("/" + c.Prefix)
+	if c.MaxWidth == 0 {
+		c.MaxWidth = 2048
+	}
+	if c.MaxHeight == 0 {
+		c.MaxHeight = 2048
+	}
+	if c.MaxSourcePixels == 0 {
+		c.MaxSourcePixels = 40_000_000
+	}
+	if c.CacheBytes == 0 {
+		c.CacheBytes = 64 << 20
+	}
+}
+
+// WithImages creates an [xtemplate.Option] that enables the image resizing
+// handler.
+func WithImages(config ImagesConfig) Option {
+	return func(c *Config) error {
+		c.Images = &config
+		return nil
+	}
+}
+
+type resizedImage struct {
+	key         string
+	content     []byte
+	contentType string
+	hash        string
+	modtime     time.Time
+}
+
+// imageCache holds generated image variants up to a total size budget,
+// evicting the oldest entries first.
+type imageCache struct {
+	mu       sync.Mutex
+	entries  map[string]*resizedImage
+	order    []string
+	size     int
+	maxBytes int
+}
+
+func (c *imageCache) get(key string) *resizedImage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+func (c *imageCache) put(img *resizedImage) {
+	if len(img.content) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[img.key]; ok {
+		return
+	}
+	for c.size+len(img.content) > c.maxBytes && len(c.order) > 0 {
+		oldest := c.entries[c.order[0]]
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+		c.size -= len(oldest.content)
+	}
+	c.entries[img.key] = img
+	c.order = append(c.order, img.key)
+	c.size += len(img.content)
+}
+
+func (b *builder) addImageHandler(dots []DotConfig) error {
+	config := *b.config.Images
+	config.defaults()
+
+	fsys := b.config.TemplatesFS
+	if config.Directory != "" {
+		fsys = nil
+		for _, d := range dots {
+			if dir, ok := d.(*DotDirConfig); ok && dir.Name == config.Directory {
+				fsys = dir.FS
+			}
+		}
+		if fsys == nil {
+			return fmt.Errorf("images directory '%s' is not a configured directory", config.Directory)
+		}
+	}
+
+	pattern := "GET " + config.Prefix + "/{size}/{path...}"
+	handler := imageHandler(b.config.Ctx, fsys, &config)
+	if err := catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.HandleFunc(pattern, handler) }); err != nil {
+		return err
+	}
+	b.Routes += 1
+	b.routes = append(b.routes, InstanceRoute{pattern, handler})
+	b.config.Logger.Debug("added image handler", slog.String("pattern", pattern), slog.String("directory", config.Directory))
+	return nil
+}
+
+func imageHandler(ctx context.Context, fsys fs.FS, config *ImagesConfig) http.HandlerFunc {
+	cache := &imageCache{entries: make(map[string]*resizedImage), maxBytes: config.CacheBytes}
+	// limit concurrent resizes to bound memory usage
+	sem := make(chan struct{}, 4)
+	var resizing singleflight.Group
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := GetLogger(r.Context())
+
+		width, height, err := parseImageSize(r.PathValue("size"))
+		if err != nil || width > config.MaxWidth || height > config.MaxHeight {
+			http.Error(w, "invalid image size", http.StatusBadRequest)
+			return
+		}
+		name := path.Clean(r.PathValue("path"))
+		if !fs.ValidPath(name) {
+			http.NotFound(w, r)
+			return
+		}
+
+		stat, err := fs.Stat(fsys, name)
+		if err != nil || stat.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		key := fmt.Sprintf("%s %dx%d %d", name, width, height, stat.ModTime().UnixNano())
+		img := cache.get(key)
+		if img == nil {
+			// concurrent requests for the same variant share one resize, which
+			// keeps going if the request that started it is cancelled
+			result := resizing.DoChan(key, func() (any, error) {
+				if img := cache.get(key); img != nil {
+					return img, nil
+				}
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					return nil, errServerStopped
+				}
+				img, err := resizeImage(fsys, name, width, height, config.MaxSourcePixels)
+				<-sem
+				if err != nil {
+					return nil, err
+				}
+				img.key = key
+				img.modtime = stat.ModTime()
+				cache.put(img)
+				log.LogAttrs(ctx, slog.LevelDebug, "resized image", slog.String("path", name), slog.Int("width", width), slog.Int("height", height), slog.Int("size", len(img.content)))
+				return img, nil
+			})
+			var res singleflight.Result
+			select {
+			case res = <-result:
+			case <-r.Context().Done():
+				return
+			}
+			if res.Err == errServerStopped {
+				http.Error(w, "server stopped", http.StatusServiceUnavailable)
+				return
+			} else if res.Err != nil {
+				log.LogAttrs(r.Context(), slog.LevelDebug, "failed to resize image", slog.String("path", name), slog.Any("error", res.Err))
+				http.Error(w, "failed to resize image", http.StatusUnprocessableEntity)
+				return
+			}
+			img = res.Val.(*resizedImage)
+		}
+
+		w.Header().Set("Etag", `"`+img.hash+`"`)
+		w.Header().Set("Content-Type", img.contentType)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		http.ServeContent(w, r, name, img.modtime, bytes.NewReader(img.content))
+	}
+}
+
+func parseImageSize(size string) (width, height int, err error) {
+	ws, hs, ok := strings.Cut(size, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("image size must be formatted like {w}x{h}")
+	}
+	if width, err = strconv.Atoi(ws); err != nil {
+		return
+	}
+	if height, err = strconv.Atoi(hs); err != nil {
+		return
+	}
+	if width < 0 || height < 0 || (width == 0 && height == 0) {
+		err = fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	return
+}
+
+func resizeImage(fsys fs.FS, name string, width, height, maxPixels int) (*resizedImage, error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image config: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("source image is too large: %dx%d", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	var out image.Image = src
+	if size := fitImage(src.Bounds().Dx(), src.Bounds().Dy(), width, height); size != src.Bounds().Size() {
+		dst := image.NewRGBA(image.Rectangle{Max: size})
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+		out = dst
+	}
+
+	content, contentType, err := encodeImage(out, format, 85)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	return &resizedImage{content: content, contentType: contentType, hash: base64.RawURLEncoding.EncodeToString(sum[:18])}, nil
+}
+
+// encodeImage encodes img in format, which is `jpeg` with quality, `gif`,
+// `webp`, or otherwise `png`, and returns it with its content type.
+func encodeImage(img image.Image, format string, quality int) ([]byte, string, error) {
+	buf := new(bytes.Buffer)
+	var contentType string
+	var err error
+	switch format {
+	case "jpeg":
+		contentType = "image/jpeg"
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		contentType = "image/gif"
+		err = gif.Encode(buf, img, nil)
+	case "webp":
+		contentType = "image/webp"
+		err = encodeWebP(buf, img)
+	default:
+		contentType = "image/png"
+		err = png.Encode(buf, img)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+// fitImage returns the size of an image with dimensions w x h scaled down to
+// fit within maxW x maxH while preserving its aspect ratio. A max dimension of
+// 0 is unconstrained.
+func fitImage(w, h, maxW, maxH int) image.Point {
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && h > maxH {
+		scale = min(scale, float64(maxH)/float64(h))
+	}
+	return image.Pt(max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5)))
+}
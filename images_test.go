@@ -0,0 +1,72 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// slowFS counts and delays reading files so concurrent resizes overlap.
+type slowFS struct {
+	fstest.MapFS
+	reads atomic.Int32
+}
+
+func (f *slowFS) ReadFile(name string) ([]byte, error) {
+	f.reads.Add(1)
+	time.Sleep(50 * time.Millisecond)
+	return f.MapFS.ReadFile(name)
+}
+
+func TestImageResizesOnce(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 64, 32))); err != nil {
+		t.Fatal(err)
+	}
+	fsys := &slowFS{MapFS: fstest.MapFS{"a.png": {Data: buf.Bytes()}}}
+	config := &ImagesConfig{}
+	config.defaults()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /img/{size}/{path...}", imageHandler(context.Background(), fsys, config))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", "/img/16x0/a.png", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("status %d", w.Code)
+				return
+			}
+			img, err := png.Decode(w.Body)
+			if err != nil {
+				t.Errorf("failed to decode the resized image: %v", err)
+				return
+			}
+			if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 8 {
+				t.Errorf("resized to %v, want 16x8", img.Bounds())
+			}
+		}()
+	}
+	wg.Wait()
+	if reads := fsys.reads.Load(); reads != 1 {
+		t.Fatalf("read the image %d times for concurrent requests, want 1", reads)
+	}
+
+	// cached variants aren't resized again
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/img/16x0/a.png", nil))
+	if w.Code != http.StatusOK || fsys.reads.Load() != 1 {
+		t.Fatalf("status %d and %d reads after the variant was cached", w.Code, fsys.reads.Load())
+	}
+}
@@ -0,0 +1,117 @@
+package xtemplate
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// initSource records where an INIT template was defined so initializers can
+// be executed in a deterministic order.
+type initSource struct {
+	file  string
+	after []string
+}
+
+// recordInit remembers the file that defines the INIT template name and the
+// files or templates it must run after, from the `after` front matter key.
+func (b *builder) recordInit(name, file string, meta map[string]any) error {
+	var after []string
+	switch a := meta["after"].(type) {
+	case nil:
+	case string:
+		after = []string{a}
+	case []any:
+		for _, v := range a {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("front matter 'after' in template file '%s' must be a string or a list of strings", file)
+			}
+			after = append(after, s)
+		}
+	default:
+		return fmt.Errorf("front matter 'after' in template file '%s' must be a string or a list of strings", file)
+	}
+	for i, a := range after {
+		if !strings.HasPrefix(a, "INIT ") {
+			after[i] = path.Clean("/" + a)
+		}
+	}
+	b.inits[name] = initSource{file: file, after: after}
+	return nil
+}
+
+type initializer struct {
+	tmpl  templateExecutor
+	order int
+	initSource
+}
+
+// initPriority returns the optional numeric prefix of an initializer's name,
+// like the 10 in `INIT 10 seed users`.
+func initPriority(name string) int {
+	field, _, _ := strings.Cut(strings.TrimPrefix(name, "INIT "), " ")
+	n, _ := strconv.Atoi(field)
+	return n
+}
+
+// orderInitializers sorts INIT templates by their numeric prefix, then by the
+// path of the file that defines them, then by name. Files can list other files
+// or INIT template names in their `after` front matter to run after them.
+func (b *builder) orderInitializers(templates []templateExecutor) ([]templateExecutor, error) {
+	var inits []initializer
+	for _, tmpl := range templates {
+		if !strings.HasPrefix(tmpl.Name(), "INIT ") {
+			continue
+		}
+		inits = append(inits, initializer{tmpl, initPriority(tmpl.Name()), b.inits[tmpl.Name()]})
+	}
+	slices.SortFunc(inits, func(a, b initializer) int {
+		return cmp.Or(cmp.Compare(a.order, b.order), cmp.Compare(a.file, b.file), cmp.Compare(a.tmpl.Name(), b.tmpl.Name()))
+	})
+
+	// resolve dependencies, preserving the sorted order where possible
+	deps := make([][]int, len(inits))
+	for i, init := range inits {
+		for _, after := range init.after {
+			found := false
+			for j, other := range inits {
+				if other.tmpl.Name() == after || other.file == after {
+					found = true
+					if i != j {
+						deps[i] = append(deps[i], j)
+					}
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("initializer '%s' is ordered after '%s' which does not define any initializers", init.tmpl.Name(), after)
+			}
+		}
+	}
+	ordered := make([]templateExecutor, 0, len(inits))
+	done := make([]bool, len(inits))
+	for len(ordered) < len(inits) {
+		next := -1
+		for i := range inits {
+			if !done[i] && !slices.ContainsFunc(deps[i], func(j int) bool { return !done[j] }) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []string
+			for i, init := range inits {
+				if !done[i] {
+					cycle = append(cycle, init.tmpl.Name())
+				}
+			}
+			return nil, fmt.Errorf("initializers have cyclic 'after' dependencies: %s", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		ordered = append(ordered, inits[next].tmpl)
+	}
+	return ordered, nil
+}
@@ -3,24 +3,32 @@ package xtemplate
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"log/slog"
 	"maps"
+	"mime"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"reflect"
 	"regexp"
 	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
+	texttemplate "text/template"
+	"text/template/parse"
 	"time"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/felixge/httpsnoop"
 	"github.com/google/uuid"
+	"github.com/microcosm-cc/bluemonday"
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/tdewolff/minify/v2"
@@ -28,6 +36,8 @@ import (
 	"github.com/tdewolff/minify/v2/html"
 	"github.com/tdewolff/minify/v2/js"
 	"github.com/tdewolff/minify/v2/svg"
+	"github.com/yuin/goldmark"
+	"golang.org/x/net/webdav"
 )
 
 // Instance is a configured, immutable, xtemplate request handler ready to
@@ -43,53 +53,200 @@ type Instance struct {
 	config Config
 	id     int64
 
-	router    *http.ServeMux
-	files     map[string]*fileInfo
-	templates *template.Template
-	funcs     template.FuncMap
+	router        *http.ServeMux
+	files         map[string]*fileInfo
+	templates     *template.Template
+	textTemplates *texttemplate.Template
+	funcs         template.FuncMap
 
 	natsServer *server.Server
 	natsClient *jetstream.JetStream
 
+	compressedFiles sync.Map
+
+	// the results of loading files, which the next instance can reuse
+	loaded *loadCache
+
+	// the router wrapped with panic recovery
+	handler http.Handler
+	panics  atomic.Int64
+
+	// stats and route patterns reported by the admin API
+	stats    *InstanceStats
+	patterns []string
+
+	cronJobs      []*cronJob
+	cronOverrides *cronOverrides
+
+	natsServices []*natsService
+
+	// metrics registry, which the next instance keeps. nil if disabled.
+	metrics *metricsRegistry
+
+	// webdav locks, which the next instance keeps. nil if disabled.
+	webdavLocks webdav.LockSystem
+
+	// cache providers by name, which the next instance can keep
+	caches map[string]*ttlCache
+
+	// in-process nats servers by nats provider name, which the next instance
+	// keeps if their config is the same
+	natsServers map[string]*embeddedNats
+
+	// message buses by name, which the next instance keeps so subscribers
+	// still receive messages published after a reload
+	buses map[string]*busBroker
+
+	// concurrency limiters by route pattern, or "" for the global limiter,
+	// which the next instance keeps if their limits are the same
+	concurrencyLimiters map[string]*concurrencyLimiter
+
+	// rendered output of cachedblock regions
+	blocks *ttlCache
+	jobs   *jobQueue
+
+	// markdown renderers by name
+	markdown map[string]goldmark.Markdown
+
+	// html sanitization policies by name
+	sanitizePolicies map[string]*bluemonday.Policy
+
 	bufferDot  dot
 	flusherDot dot
 }
 
-// Instance creates a new *Instance from the given config
+// Instance creates a new *Instance from the given config. If an INIT template
+// fails, the returned stats list the initializers that succeeded before it.
 func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []InstanceRoute, error) {
 	start := time.Now()
 
 	build := &builder{
 		Instance: &Instance{
-			config: *config.Defaults(),
-			id:     nextInstanceIdentity.Add(1),
+			config:              *config.Defaults(),
+			id:                  nextInstanceIdentity.Add(1),
+			loaded:              newLoadCache(),
+			caches:              make(map[string]*ttlCache),
+			natsServers:         make(map[string]*embeddedNats),
+			buses:               make(map[string]*busBroker),
+			concurrencyLimiters: make(map[string]*concurrencyLimiter),
+			cronOverrides:       config.reload.prevCronOverrides(),
+			blocks:              &ttlCache{entries: make(map[string]ttlEntry), maxEntries: blockCacheEntries},
 		},
 		InstanceStats: &InstanceStats{},
+		reload:        config.reload,
+		inits:         make(map[string]initSource),
+		fileTrees:     make(map[string]map[string]*parse.Tree),
+		limitedRoutes: make(map[string]bool),
 	}
 
 	if _, err := build.config.Options(cfgs...); err != nil {
 		return nil, nil, nil, err
 	}
+	// don't keep a reference to the previous instance's files
+	build.config.reload = nil
 
 	build.config.Logger = build.config.Logger.With(slog.Int64("instance", build.id))
 	build.config.Logger.Info("initializing")
 
+	if build.config.TemplatesFS == nil && build.config.TemplatesS3 != nil {
+		s3fs, err := NewS3FS(*build.config.TemplatesS3)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create templates s3 fs: %w", err)
+		}
+		build.config.TemplatesFS = s3fs
+	}
+
+	if build.config.TemplatesFS == nil {
+		source, err := build.config.templateSource()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create templates source: %w", err)
+		}
+		if source != nil {
+			fsys, err := source.Load(build.config.Ctx)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to load templates from source: %w", err)
+			}
+			build.config.TemplatesFS = fsys
+		}
+	}
+
 	if build.config.TemplatesFS == nil {
 		build.config.TemplatesFS = os.DirFS(build.config.TemplatesDir)
 	}
 
+	if len(build.config.TemplatesBaseDirs) > 0 || len(build.config.TemplatesBaseFS) > 0 || len(build.config.patches) > 0 {
+		layers := []fs.FS{build.config.TemplatesFS}
+		if len(build.config.patches) > 0 {
+			layers = []fs.FS{build.config.patches, build.config.TemplatesFS}
+		}
+		for _, dir := range build.config.TemplatesBaseDirs {
+			layers = append(layers, os.DirFS(dir))
+		}
+		layers = append(layers, build.config.TemplatesBaseFS...)
+		build.config.TemplatesFS = OverlayFS(layers...)
+	}
+
+	{
+		var err error
+		if build.markdown, err = newMarkdownConfigs(build.config.Markdown); err != nil {
+			return nil, nil, nil, err
+		}
+		if build.sanitizePolicies, err = newSanitizePolicies(build.config.SanitizePolicies); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
 	{
 		build.funcs = template.FuncMap{}
-		maps.Copy(build.funcs, xtemplateFuncs)
 		maps.Copy(build.funcs, sprig.HtmlFuncMap())
+		// xtemplate's funcs replace sprig's funcs with the same name
+		maps.Copy(build.funcs, xtemplateFuncs)
+		build.funcs["asset"] = DotX{instance: build.Instance}.Asset
+		build.funcs["cachedblock"] = build.Instance.cachedBlock
+		build.funcs["component"] = build.Instance.component
+		build.funcs["markdown"] = build.Instance.renderMarkdown
+		build.funcs["markdownTOC"] = build.Instance.renderMarkdownTOC
+		build.funcs["sanitizeHtml"] = build.Instance.sanitizeHtml
+		build.funcs["slot"] = slot
 		for _, extra := range build.config.FuncMaps {
 			maps.Copy(build.funcs, extra)
 		}
 	}
 
+	if c := build.config.Concurrency; c != nil {
+		if err := c.defaults(); err != nil {
+			return nil, nil, nil, err
+		}
+		if c.MaxConcurrent > 0 {
+			build.concurrency = build.concurrencyLimiter("", ConcurrencyLimit{MaxConcurrent: c.MaxConcurrent, MaxQueue: c.MaxQueue})
+		}
+	}
+
+	for _, rule := range build.config.StaticHeaders {
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid static header match pattern '%s': %w", rule.Match, err)
+		}
+	}
+
+	build.contentTypes = maps.Clone(extensionContentTypes)
+	for ext, ctype := range build.config.ContentTypes {
+		if _, _, err := mime.ParseMediaType(ctype); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid content type '%s' for extension '%s': %w", ctype, ext, err)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		build.contentTypes[strings.ToLower(ext)] = ctype
+	}
+
 	build.files = make(map[string]*fileInfo)
 	build.router = http.NewServeMux()
 	build.templates = template.New(".").Delims(build.config.LDelim, build.config.RDelim).Funcs(build.funcs)
+	build.textTemplates = texttemplate.New(".").Delims(build.config.LDelim, build.config.RDelim).Funcs(texttemplate.FuncMap(build.funcs))
+	if build.config.Strict {
+		build.templates.Option("missingkey=error")
+		build.textTemplates.Option("missingkey=error")
+	}
 
 	if config.Minify {
 		m := minify.New()
@@ -102,12 +259,20 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 		build.m = m
 	}
 
+	for _, ext := range build.config.TemplateExtensions {
+		build.templateKinds = append(build.templateKinds, build.newTemplateKind(ext))
+	}
+	// match the longest extension first, e.g. `.go.html` before `.html`
+	sort.SliceStable(build.templateKinds, func(i, j int) bool { return len(build.templateKinds[i].ext) > len(build.templateKinds[j].ext) })
+
 	if err := fs.WalkDir(build.config.TemplatesFS, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil || d.IsDir() {
 			return err
 		}
-		if strings.HasSuffix(path, build.config.TemplateExtension) {
-			err = build.addTemplateHandler(path)
+		if build.isContentFile(path) {
+			err = build.addContentFile(path)
+		} else if kind, ok := build.templateKindOf(path); ok {
+			err = build.addTemplateHandler(path, kind)
 		} else {
 			err = build.addStaticFileHandler(path)
 		}
@@ -116,6 +281,61 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 		return nil, nil, nil, fmt.Errorf("error scanning files: %w", err)
 	}
 
+	if err := build.addLayoutHandlers(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if build.config.Content != nil {
+		if err := build.addContentHandlers(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if err := build.checkConcurrencyRoutes(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if build.config.Sitemap != nil {
+		if err := build.addSitemapHandlers(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if build.config.Metrics != nil {
+		if err := build.config.Metrics.defaults(); err != nil {
+			return nil, nil, nil, err
+		}
+		build.metrics = build.reload.prevMetrics()
+		if err := build.addMetricsHandler(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	switch {
+	case build.config.AccessLog == nil:
+		build.config.accessLog = nil
+	case build.config.accessLog == nil || build.config.accessLog.config != build.config.AccessLog:
+		// the access log wasn't opened by a Server, or was changed by a reload
+		var err error
+		if build.config.accessLog, err = newAccessLogger(build.config.Ctx, build.config.AccessLog); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if build.config.WebDAV != nil {
+		build.config.WebDAV.defaults()
+		build.webdavLocks = build.reload.prevWebDAVLocks()
+		if err := build.addWebDAVHandler(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if build.config.AssetManifestPath != "" {
+		if err := build.addAssetManifestHandler(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
 	dcInstance := dotXProvider{build.Instance}
 	dcReq := dotReqProvider{}
 	dcResp := dotRespProvider{}
@@ -138,6 +358,126 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 			names[d.FieldName()] += 1
 		}
 		for _, d := range build.config.Nats {
+			d.embedded = build.reload.prevNatsServer(d.Name)
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Caches {
+			if d.Persist {
+				d.cache = build.reload.prevCache(d.Name)
+			}
+			d.templates = build.templates
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Memcached {
+			d.templates = build.templates
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Redis {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Stores {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Buckets {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Mailers {
+			d.templates, d.textTemplates = build.templates, build.textTemplates
+			d.log = build.config.Logger
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.GeoIP {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.MQTT {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Kafka {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.GraphQL {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.GRPC {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Exec {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Bus {
+			d.bus = build.reload.prevBus(d.Name)
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Sqlite {
+			for _, db := range dot {
+				if db, ok := db.(*DotDBConfig); ok && db.Name == d.Database {
+					d.db = db
+				}
+			}
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Search {
+			for _, db := range dot {
+				if db, ok := db.(*DotDBConfig); ok && db.Name == d.Database {
+					d.db = db
+				}
+			}
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Scripts {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.AI {
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.Image {
+			for _, dir := range dot {
+				if dir, ok := dir.(*DotDirConfig); ok && dir.Name == d.Directory {
+					d.dir = dir
+				}
+			}
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.PDF {
+			d.templates, d.textTemplates = build.templates, build.textTemplates
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.KV {
+			for _, n := range dot {
+				if n, ok := n.(*DotNatsConfig); ok && n.Name == d.Nats {
+					d.nats = n
+				}
+			}
+			dot = append(dot, &d)
+			names[d.FieldName()] += 1
+		}
+		for _, d := range build.config.NatsCache {
+			for _, n := range dot {
+				if n, ok := n.(*DotNatsConfig); ok && n.Name == d.Nats {
+					d.nats = n
+				}
+			}
+			d.templates = build.templates
 			dot = append(dot, &d)
 			names[d.FieldName()] += 1
 		}
@@ -145,6 +485,11 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 			dot = append(dot, d)
 			names[d.FieldName()] += 1
 		}
+		if build.config.Content != nil {
+			d := dotPageProvider{build.content}
+			dot = append(dot, d)
+			names[d.FieldName()] += 1
+		}
 		for name, count := range names {
 			if count > 1 {
 				return nil, nil, nil, fmt.Errorf("dot field name '%s' is used %d times", name, count)
@@ -155,37 +500,131 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 			if err != nil {
 				return nil, nil, nil, fmt.Errorf("failed to initialize dot field '%s': %w", d.FieldName(), err)
 			}
+			if c, ok := d.(*DotCacheConfig); ok {
+				build.caches[c.Name] = c.cache
+			}
+			if n, ok := d.(*DotNatsConfig); ok && n.embedded != nil {
+				build.natsServers[n.Name] = n.embedded
+			}
+			if b, ok := d.(*DotBusConfig); ok {
+				build.buses[b.Name] = b.bus
+			}
+		}
+	}
+
+	if build.config.Jobs != nil {
+		queue, err := build.newJobQueue(dot)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to initialize jobs: %w", err)
+		}
+		for _, d := range dot {
+			if d.FieldName() == queue.config.Name {
+				return nil, nil, nil, fmt.Errorf("dot field name '%s' is used by jobs and another provider", d.FieldName())
+			}
+		}
+		build.jobs = queue
+		dot = append(dot, dotJobsProvider{queue})
+	}
+
+	if build.config.Cron != nil {
+		build.config.Cron.defaults()
+		for _, d := range dot {
+			if d.FieldName() == build.config.Cron.Name {
+				return nil, nil, nil, fmt.Errorf("dot field name '%s' is used by cron and another provider", d.FieldName())
+			}
+		}
+		dot = append(dot, dotCronProvider{build.Instance})
+	}
+
+	if build.config.Metrics != nil {
+		for _, d := range dot {
+			if d.FieldName() == build.config.Metrics.Name {
+				return nil, nil, nil, fmt.Errorf("dot field name '%s' is used by metrics and another provider", d.FieldName())
+			}
+		}
+		dot = append(dot, dotMetricsProvider{build.config.Metrics, build.metrics})
+	}
+
+	if build.config.Images != nil {
+		if err := build.addImageHandler(dot); err != nil {
+			return nil, nil, nil, err
 		}
 	}
 
+	if name := build.config.ErrorTemplate; name != "" && build.templates.Lookup(name) == nil {
+		return nil, nil, nil, fmt.Errorf("error template '%s' is not defined", name)
+	}
+	build.handler = build.recoverPanics(build.router)
+
 	build.bufferDot = makeDot(slices.Concat([]DotConfig{dcInstance, dcReq}, dot, []DotConfig{dcResp}))
 	build.flusherDot = makeDot(slices.Concat([]DotConfig{dcInstance, dcReq}, dot, []DotConfig{dcFlush}))
 
 	{
 		// Invoke all initilization templates, aka any template whose name starts
-		// with "INIT ".
+		// with "INIT ", in a deterministic order.
 		makeDot := func() (*reflect.Value, error) {
 			w, r := httptest.NewRecorder(), httptest.NewRequest("", "/", nil)
 			return build.bufferDot.value(build.config.Ctx, w, r)
 		}
 		cleanup := build.bufferDot.cleanup
-		buf := new(bytes.Buffer)
+		var templates []templateExecutor
 		for _, tmpl := range build.templates.Templates() {
-			buf.Reset()
-			if strings.HasPrefix(tmpl.Name(), "INIT ") {
-				val, err := makeDot()
-				if err != nil {
-					return nil, nil, nil, fmt.Errorf("failed to initialize dot value: %w", err)
+			templates = append(templates, tmpl)
+		}
+		for _, tmpl := range build.textTemplates.Templates() {
+			templates = append(templates, tmpl)
+		}
+		// register jobs first so initializers can enqueue them
+		for _, tmpl := range templates {
+			if strings.HasPrefix(tmpl.Name(), "JOB ") && build.jobs != nil {
+				build.addJobTemplate(tmpl)
+			}
+			if strings.HasPrefix(tmpl.Name(), "CRON ") {
+				if err := build.addCronJob(tmpl); err != nil {
+					return nil, nil, nil, err
 				}
-				err = tmpl.Execute(buf, *val)
-				if err = cleanup(val, err); err != nil {
-					return nil, nil, nil, fmt.Errorf("template initializer '%s' failed: %w", tmpl.Name(), err)
+			}
+			if strings.HasPrefix(tmpl.Name(), "NATS ") {
+				if err := build.addNatsService(tmpl, dot); err != nil {
+					return nil, nil, nil, err
 				}
-				// TODO: output buffer somewhere?
-				build.config.Logger.Debug("executed initializer", slog.String("template_name", tmpl.Name()), slog.Int("rendered_len", buf.Len()))
-				build.TemplateInitializers += 1
 			}
 		}
+		inits, err := build.orderInitializers(templates)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		buf := new(bytes.Buffer)
+		for _, tmpl := range inits {
+			buf.Reset()
+			val, err := makeDot()
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to initialize dot value: %w", err)
+			}
+			err = tmpl.Execute(buf, *val)
+			if err == nil && build.config.InitDryRun {
+				// roll back transactions by cleaning up with an error
+				err = errInitDryRun
+			}
+			if err = cleanup(val, err); err != nil && !errors.Is(err, errInitDryRun) {
+				// return stats so callers can see which initializers succeeded
+				return nil, build.InstanceStats, nil, fmt.Errorf("template initializer '%s' failed: %w", tmpl.Name(), err)
+			}
+			// TODO: output buffer somewhere?
+			build.config.Logger.Debug("executed initializer", slog.String("template_name", tmpl.Name()), slog.Int("rendered_len", buf.Len()))
+			build.TemplateInitializers += 1
+			build.Initializers = append(build.Initializers, tmpl.Name())
+		}
+	}
+
+	// cron jobs, job workers, and nats services run until the instance's
+	// context is cancelled
+	if !build.config.InitDryRun {
+		if err := build.startNatsServices(); err != nil {
+			return nil, nil, nil, err
+		}
+		build.startCron()
+		build.startJobWorkers()
 	}
 
 	build.config.Logger.Info("instance loaded",
@@ -195,13 +634,26 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 			slog.Int("templateFiles", build.TemplateFiles),
 			slog.Int("templateDefinitions", build.TemplateDefinitions),
 			slog.Int("templateInitializers", build.TemplateInitializers),
+			slog.Int("cronJobs", build.CronJobs),
+			slog.Int("jobTemplates", build.JobTemplates),
+			slog.Int("natsServices", build.NatsServices),
 			slog.Int("staticFiles", build.StaticFiles),
 			slog.Int("staticFilesAlternateEncodings", build.StaticFilesAlternateEncodings),
+			slog.Int("staticFilesCached", build.StaticFilesCached),
+			slog.Int64("staticCacheBytes", build.StaticCacheBytes),
+			slog.Int("reusedFiles", build.ReusedFiles),
 		))
 
+	build.stats = build.InstanceStats
+	for _, route := range build.routes {
+		build.patterns = append(build.patterns, route.Pattern)
+	}
+
 	return build.Instance, build.InstanceStats, build.routes, nil
 }
 
+var errInitDryRun = errors.New("init dry run")
+
 // Counter to assign a unique id to each instance of xtemplate created when
 // calling Config.Instance(). This is intended to help distinguish logs from
 // multiple instances in a single process.
@@ -219,6 +671,16 @@ var (
 	levelDebug2 slog.Level = slog.LevelDebug + 2
 )
 
+// Stats returns the stats of loading this instance.
+func (x *Instance) Stats() InstanceStats {
+	return *x.stats
+}
+
+// RoutePatterns returns the patterns of the routes served by this instance.
+func (x *Instance) RoutePatterns() []string {
+	return x.patterns
+}
+
 func (instance *Instance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	select {
 	case <-instance.config.Ctx.Done():
@@ -245,8 +707,34 @@ func (instance *Instance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	)
 	ctx = context.WithValue(ctx, loggerKey, log)
 
+	var trace *requestTrace
+	if instance.config.Trace {
+		trace = newRequestTrace(r.Method + " " + r.URL.Path)
+		ctx = context.WithValue(ctx, traceContextKey{}, trace)
+	}
+
 	r = r.WithContext(ctx)
-	metrics := httpsnoop.CaptureMetrics(instance.router, w, r)
+	start := time.Now()
+	var pattern string
+	if instance.metrics != nil || instance.config.accessLog != nil {
+		_, pattern = instance.router.Handler(r)
+	}
+	if instance.metrics != nil {
+		instance.metrics.inFlight.Inc()
+	}
+	metrics := httpsnoop.CaptureMetrics(instance.handler, w, r)
+	if instance.metrics != nil {
+		instance.metrics.inFlight.Dec()
+		instance.metrics.observeRequest(r.Method, pattern, metrics)
+	}
+	if instance.config.accessLog != nil {
+		instance.config.accessLog.log(r, pattern, start, metrics)
+	}
+
+	if trace != nil {
+		trace.finish()
+		log.LogAttrs(r.Context(), slog.LevelInfo, "request trace", slog.String("trace", trace.String()))
+	}
 
 	log.LogAttrs(r.Context(), levelDebug2, "request served",
 		slog.Group("response",
@@ -0,0 +1,64 @@
+package xtemplate
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestStrictMissingKey(t *testing.T) {
+	files := map[string]string{
+		"key.html":     `{{(dict "title" "hello").title}}`,
+		"missing.html": `{{(dict "title" "hello").titel}}`,
+	}
+	for _, test := range []struct {
+		strict bool
+		path   string
+		status int
+		body   string
+	}{
+		{false, "/key", http.StatusOK, "hello"},
+		{false, "/missing", http.StatusOK, ""},
+		{true, "/key", http.StatusOK, "hello"},
+		{true, "/missing", http.StatusInternalServerError, ""},
+	} {
+		strict := func(c *Config) error {
+			c.Strict = test.strict
+			return nil
+		}
+		_, ts := newTestServer(t, files, nil, nil, strict)
+		resp, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status || test.body != "" && string(body) != test.body {
+			t.Errorf("strict %v %s: status %d body %q, want %d %q", test.strict, test.path, resp.StatusCode, body, test.status, test.body)
+		}
+	}
+}
+
+func TestStaticCacheDisabled(t *testing.T) {
+	files := map[string]string{
+		"index.html": `hello`,
+		"empty.txt":  ``,
+		"small.txt":  `small`,
+	}
+	for _, test := range []struct {
+		cacheBytes int64
+		cached     int
+	}{
+		{0, 0},
+		{1 << 20, 2},
+	} {
+		cache := func(c *Config) error {
+			c.StaticCacheBytes = test.cacheBytes
+			return nil
+		}
+		server, _ := newTestServer(t, files, nil, nil, cache)
+		if cached := server.Instance().Stats().StaticFilesCached; cached != test.cached {
+			t.Errorf("cached %d static files with a %d byte budget, want %d", cached, test.cacheBytes, test.cached)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package xtemplate
+
+// This file implements a background job queue executed by templates.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// JobsConfig configures a background job queue. Templates enqueue jobs with
+// `.Jobs.Enqueue "name" payload`, and jobs are executed asynchronously by the
+// template named `JOB name`. If a job's template fails it is retried with
+// exponential backoff.
+//
+// Jobs are persisted in a SQL database if Database is set, or in a NATS
+// JetStream stream if Nats is set. Otherwise jobs are only kept in memory and
+// are lost when the instance is reloaded.
+type JobsConfig struct {
+	// The name of the dot field. Default `Jobs`.
+	Name string `json:"name,omitempty"`
+
+	// The name of a configured database to persist jobs in. The database must
+	// use `?` query placeholders, like SQLite or MySQL.
+	Database string `json:"database,omitempty"`
+
+	// The table to persist jobs in. Default `xtemplate_jobs`.
+	Table string `json:"table,omitempty"`
+
+	// The name of a configured nats client to persist jobs in JetStream.
+	Nats string `json:"nats,omitempty"`
+
+	// The JetStream stream to persist jobs in. Default `XTEMPLATE_JOBS`.
+	Stream string `json:"stream,omitempty"`
+
+	// The number of jobs executed concurrently. Default `2`.
+	Workers int `json:"workers,omitempty"`
+
+	// The number of times a job is attempted before it's abandoned. Default
+	// `5`.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// The delay before the first retry, which doubles after each attempt.
+	// Default `1s`.
+	Backoff Duration `json:"backoff,omitempty"`
+
+	// How long a job can run before it's considered lost and is retried.
+	// Default `5m`.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// How often to check for new jobs that were enqueued by other processes
+	// or are due to be retried. Default `1s`.
+	PollInterval Duration `json:"poll_interval,omitempty"`
+}
+
+func (c *JobsConfig) defaults() {
+	if c.Name == "" {
+		c.Name = "Jobs"
+	}
+	if c.Table == "" {
+		c.Table = "xtemplate_jobs"
+	}
+	if c.Stream == "" {
+		c.Stream = "XTEMPLATE_JOBS"
+	}
+	if c.Workers == 0 {
+		c.Workers = 2
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 5
+	}
+	if c.Backoff == 0 {
+		c.Backoff = Duration(time.Second)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = Duration(5 * time.Minute)
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = Duration(time.Second)
+	}
+}
+
+// WithJobs creates an [xtemplate.Option] that enables the background job
+// queue.
+func WithJobs(config JobsConfig) Option {
+	return func(c *Config) error {
+		c.Jobs = &config
+		return nil
+	}
+}
+
+// Job is a unit of work in the job queue. It's available at `.Jobs.Job` when
+// executing a `JOB` template.
+type Job struct {
+	ID      string
+	Name    string
+	Payload any
+	// The current attempt, starting at 1.
+	Attempt int
+
+	payload []byte
+	handle  any // store-specific
+}
+
+type jobStore interface {
+	enqueue(ctx context.Context, job *Job, at time.Time) error
+	// next claims the next job that is due, or returns nil if there is none.
+	next(ctx context.Context) (*Job, error)
+	complete(ctx context.Context, job *Job) error
+	retry(ctx context.Context, job *Job, at time.Time, cause error) error
+	fail(ctx context.Context, job *Job, cause error) error
+}
+
+type jobQueue struct {
+	config    JobsConfig
+	store     jobStore
+	templates map[string]templateExecutor
+	wake      chan struct{}
+}
+
+func (b *builder) newJobQueue(dots []DotConfig) (*jobQueue, error) {
+	config := *b.config.Jobs
+	config.defaults()
+
+	q := &jobQueue{config: config, templates: make(map[string]templateExecutor), wake: make(chan struct{}, 1)}
+	switch {
+	case config.Database != "" && config.Nats != "":
+		return nil, fmt.Errorf("jobs can be persisted in a database or nats, not both")
+	case config.Database != "":
+		for _, d := range dots {
+			if db, ok := d.(*DotDBConfig); ok && db.Name == config.Database {
+				store := &sqlJobStore{db: db.DB, table: config.Table, timeout: time.Duration(config.Timeout)}
+				if err := store.init(b.config.Ctx); err != nil {
+					return nil, err
+				}
+				q.store = store
+			}
+		}
+		if q.store == nil {
+			return nil, fmt.Errorf("jobs database '%s' is not a configured database", config.Database)
+		}
+	case config.Nats != "":
+		for _, d := range dots {
+			if n, ok := d.(*DotNatsConfig); ok && n.Name == config.Nats {
+				store := &natsJobStore{js: n.js, stream: config.Stream, maxWait: time.Duration(config.PollInterval)}
+				if err := store.init(b.config.Ctx, config); err != nil {
+					return nil, err
+				}
+				q.store = store
+			}
+		}
+		if q.store == nil {
+			return nil, fmt.Errorf("jobs nats '%s' is not a configured nats client", config.Nats)
+		}
+	default:
+		q.store = &memoryJobStore{timeout: time.Duration(config.Timeout)}
+	}
+	return q, nil
+}
+
+func (b *builder) addJobTemplate(tmpl templateExecutor) {
+	name := strings.TrimSpace(strings.TrimPrefix(tmpl.Name(), "JOB "))
+	b.jobs.templates[name] = tmpl
+	b.JobTemplates += 1
+	b.config.Logger.Debug("added job template", slog.String("name", name))
+}
+
+func (q *jobQueue) enqueue(ctx context.Context, name string, payload any, delay time.Duration) error {
+	if _, ok := q.templates[name]; !ok {
+		return fmt.Errorf("no job template named 'JOB %s'", name)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode job payload: %w", err)
+	}
+	job := &Job{ID: uuid.NewString(), Name: name, payload: data}
+	if err := q.store.enqueue(ctx, job, time.Now().Add(delay)); err != nil {
+		return fmt.Errorf("failed to enqueue job '%s': %w", name, err)
+	}
+	if delay == 0 {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// startJobWorkers starts the job workers which run until the instance's
+// context is cancelled.
+func (x *Instance) startJobWorkers() {
+	if x.jobs == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < x.jobs.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			x.jobWorker()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		x.config.Logger.Debug("stopped job workers")
+	}()
+}
+
+func (x *Instance) jobWorker() {
+	ctx := x.config.Ctx
+	poll := time.NewTicker(time.Duration(x.jobs.config.PollInterval))
+	defer poll.Stop()
+	for {
+		job, err := x.jobs.store.next(ctx)
+		if err != nil && ctx.Err() == nil {
+			x.config.Logger.Warn("failed to get next job", slog.Any("error", err))
+		}
+		if job != nil {
+			x.runJob(job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-x.jobs.wake:
+		case <-poll.C:
+		}
+	}
+}
+
+func (x *Instance) runJob(job *Job) {
+	ctx := x.config.Ctx
+	log := x.config.Logger.With(slog.String("job", job.Name), slog.String("job_id", job.ID), slog.Int("attempt", job.Attempt))
+	start := time.Now()
+
+	err := func() error {
+		tmpl, ok := x.jobs.templates[job.Name]
+		if !ok {
+			return fmt.Errorf("no job template named 'JOB %s'", job.Name)
+		}
+		if err := json.Unmarshal(job.payload, &job.Payload); err != nil {
+			return fmt.Errorf("failed to decode job payload: %w", err)
+		}
+		w, r := httptest.NewRecorder(), httptest.NewRequest("JOB", "/", nil)
+		r = r.WithContext(context.WithValue(ctx, jobContextKey{}, job))
+		val, err := x.bufferDot.value(ctx, w, r)
+		if err != nil {
+			return fmt.Errorf("failed to initialize dot value: %w", err)
+		}
+		buf := bufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer bufPool.Put(buf)
+		return x.bufferDot.cleanup(val, tmpl.Execute(buf, *val))
+	}()
+
+	switch {
+	case err == nil:
+		log.Info("job succeeded", slog.Duration("duration", time.Since(start)))
+		err = x.jobs.store.complete(ctx, job)
+	case job.Attempt >= x.jobs.config.MaxAttempts:
+		log.Error("job failed permanently", slog.Any("error", err), slog.Duration("duration", time.Since(start)))
+		err = x.jobs.store.fail(ctx, job, err)
+	default:
+		delay := time.Duration(x.jobs.config.Backoff) << (job.Attempt - 1)
+		if delay <= 0 || delay > time.Hour {
+			delay = time.Hour
+		}
+		log.Warn("job failed, will retry", slog.Any("error", err), slog.Duration("duration", time.Since(start)), slog.Duration("retry_in", delay))
+		err = x.jobs.store.retry(ctx, job, time.Now().Add(delay), err)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Warn("failed to update job", slog.Any("error", err))
+	}
+}
+
+type jobContextKey struct{}
+
+// memoryJobStore keeps jobs in memory. They are lost when the instance is
+// reloaded.
+type memoryJobStore struct {
+	mu      sync.Mutex
+	jobs    []*memoryJob
+	timeout time.Duration
+}
+
+type memoryJob struct {
+	*Job
+	runAt, lockedUntil time.Time
+}
+
+func (s *memoryJobStore) enqueue(_ context.Context, job *Job, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, &memoryJob{Job: job, runAt: at})
+	return nil
+}
+
+func (s *memoryJobStore) next(context.Context) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, j := range s.jobs {
+		if !j.runAt.After(now) && !j.lockedUntil.After(now) {
+			j.lockedUntil = now.Add(s.timeout)
+			j.Attempt += 1
+			return j.Job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryJobStore) remove(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.jobs {
+		if j.Job == job {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *memoryJobStore) complete(_ context.Context, job *Job) error {
+	s.remove(job)
+	return nil
+}
+
+func (s *memoryJobStore) retry(_ context.Context, job *Job, at time.Time, _ error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.Job == job {
+			j.runAt, j.lockedUntil = at, time.Time{}
+		}
+	}
+	return nil
+}
+
+func (s *memoryJobStore) fail(_ context.Context, job *Job, _ error) error {
+	s.remove(job)
+	return nil
+}
+
+// sqlJobStore persists jobs in a database table. Jobs are claimed by setting
+// locked_until, so multiple processes can share the same table.
+type sqlJobStore struct {
+	db      *sql.DB
+	table   string
+	timeout time.Duration
+}
+
+func (s *sqlJobStore) init(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+	id VARCHAR(36) PRIMARY KEY,
+	name TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	run_at BIGINT NOT NULL,
+	locked_until BIGINT NOT NULL DEFAULT 0,
+	failed INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT
+)`)
+	if err != nil {
+		return fmt.Errorf("failed to create jobs table '%s': %w", s.table, err)
+	}
+	return nil
+}
+
+func (s *sqlJobStore) enqueue(ctx context.Context, job *Job, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO `+s.table+` (id, name, payload, run_at) VALUES (?, ?, ?, ?)`, job.ID, job.Name, string(job.payload), at.UnixMilli())
+	return err
+}
+
+func (s *sqlJobStore) next(ctx context.Context) (*Job, error) {
+	for {
+		now := time.Now().UnixMilli()
+		job := &Job{}
+		var payload string
+		err := s.db.QueryRowContext(ctx, `SELECT id, name, payload, attempts FROM `+s.table+` WHERE failed = 0 AND run_at <= ? AND locked_until <= ? ORDER BY run_at LIMIT 1`, now, now).Scan(&job.ID, &job.Name, &payload, &job.Attempt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// claim the job, unless another worker claimed it first
+		result, err := s.db.ExecContext(ctx, `UPDATE `+s.table+` SET locked_until = ?, attempts = attempts + 1 WHERE id = ? AND locked_until <= ?`, time.Now().Add(s.timeout).UnixMilli(), job.ID, now)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			job.payload = []byte(payload)
+			job.Attempt += 1
+			return job, nil
+		}
+	}
+}
+
+func (s *sqlJobStore) complete(ctx context.Context, job *Job) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table+` WHERE id = ?`, job.ID)
+	return err
+}
+
+func (s *sqlJobStore) retry(ctx context.Context, job *Job, at time.Time, cause error) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE `+s.table+` SET run_at = ?, locked_until = 0, last_error = ? WHERE id = ?`, at.UnixMilli(), cause.Error(), job.ID)
+	return err
+}
+
+func (s *sqlJobStore) fail(ctx context.Context, job *Job, cause error) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE `+s.table+` SET failed = 1, locked_until = 0, last_error = ? WHERE id = ?`, cause.Error(), job.ID)
+	return err
+}
+
+// natsJobStore persists jobs in a JetStream work queue stream. Retries and
+// timeouts are handled by redelivering messages.
+type natsJobStore struct {
+	js       jetstream.JetStream
+	stream   string
+	consumer jetstream.Consumer
+	maxWait  time.Duration
+}
+
+func (s *natsJobStore) subject(name string) string {
+	return strings.ToLower(s.stream) + "." + name
+}
+
+func (s *natsJobStore) init(ctx context.Context, config JobsConfig) error {
+	_, err := s.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      s.stream,
+		Subjects:  []string{s.subject(">")},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create jobs stream '%s': %w", s.stream, err)
+	}
+	s.consumer, err = s.js.CreateOrUpdateConsumer(ctx, s.stream, jetstream.ConsumerConfig{
+		Durable:    strings.ToLower(s.stream) + "_workers",
+		AckPolicy:  jetstream.AckExplicitPolicy,
+		AckWait:    time.Duration(config.Timeout),
+		MaxDeliver: config.MaxAttempts,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create jobs consumer for stream '%s': %w", s.stream, err)
+	}
+	return nil
+}
+
+func (s *natsJobStore) enqueue(ctx context.Context, job *Job, at time.Time) error {
+	if time.Until(at) > 0 {
+		return fmt.Errorf("delayed jobs are not supported with nats")
+	}
+	_, err := s.js.Publish(ctx, s.subject(job.Name), job.payload, jetstream.WithMsgID(job.ID))
+	return err
+}
+
+func (s *natsJobStore) next(ctx context.Context) (*Job, error) {
+	msg, err := s.consumer.Next(jetstream.FetchMaxWait(s.maxWait))
+	if errors.Is(err, jetstream.ErrNoMessages) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	md, err := msg.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	return &Job{
+		ID:      msg.Headers().Get(jetstream.MsgIDHeader),
+		Name:    strings.TrimPrefix(msg.Subject(), s.subject("")),
+		Attempt: int(md.NumDelivered),
+		payload: msg.Data(),
+		handle:  msg,
+	}, nil
+}
+
+func (s *natsJobStore) complete(_ context.Context, job *Job) error {
+	return job.handle.(jetstream.Msg).Ack()
+}
+
+func (s *natsJobStore) retry(_ context.Context, job *Job, at time.Time, _ error) error {
+	return job.handle.(jetstream.Msg).NakWithDelay(time.Until(at))
+}
+
+func (s *natsJobStore) fail(_ context.Context, job *Job, _ error) error {
+	return job.handle.(jetstream.Msg).Term()
+}
@@ -0,0 +1,76 @@
+package xtemplate
+
+// This file implements rendering route templates into a layout template
+// selected by their front matter.
+
+import (
+	"fmt"
+	"html/template"
+	"path"
+	"text/template/parse"
+)
+
+// layoutPage is a route template with `layout` in its front matter. Its route
+// is added after all templates are loaded so it can use any layout.
+type layoutPage struct {
+	pattern string
+	file    string
+	layout  string
+	kind    templateKind
+	// the templates defined in the file, by name
+	trees map[string]*parse.Tree
+}
+
+// lookupLayout finds the html template named layout. If there's no template
+// with that exact name, a relative name like `base.html` is resolved against
+// the directory of file, then against the templates root.
+func (b *builder) lookupLayout(layout, file string) *template.Template {
+	if t := b.templates.Lookup(layout); t != nil {
+		return t
+	}
+	if path.IsAbs(layout) {
+		return nil
+	}
+	if t := b.templates.Lookup(path.Join(path.Dir(file), layout)); t != nil {
+		return t
+	}
+	return b.templates.Lookup(path.Join("/", layout))
+}
+
+// addLayoutHandlers registers a route for each template with a layout. Each
+// route executes its layout in a copy of the template namespace where the
+// page's template is named `content`, along with any other templates defined
+// in the page's file, so the layout can render it with `{{block "content"
+// .}}{{end}}` and the page can override the layout's other blocks.
+func (b *builder) addLayoutHandlers() error {
+	for _, page := range b.layoutPages {
+		layout := b.lookupLayout(page.layout, page.file)
+		if layout == nil {
+			return fmt.Errorf("layout template '%s' of template file '%s' is not defined", page.layout, page.file)
+		}
+		ns, err := b.templates.Clone()
+		if err != nil {
+			return fmt.Errorf("failed to copy templates for layout of template file '%s': %w", page.file, err)
+		}
+		// restore the layout's own blocks in case another page overrode them,
+		// then add the page's
+		for _, trees := range []map[string]*parse.Tree{b.fileTrees[layout.Tree.ParseName], page.trees} {
+			for name, tree := range trees {
+				if name == page.file {
+					name = "content"
+				}
+				if _, err := ns.AddParseTree(name, tree.Copy()); err != nil {
+					return fmt.Errorf("could not add template '%s' from '%s' to layout '%s': %v", name, page.file, layout.Name(), err)
+				}
+			}
+		}
+		handler := b.limitConcurrency(page.pattern, bufferingTemplateHandler(b.Instance, ns.Lookup(layout.Name()), page.kind.contentType), false)
+		if err := catch(fmt.Sprintf("add handler to servemux '%s'", page.pattern), func() { b.router.HandleFunc(page.pattern, handler) }); err != nil {
+			return err
+		}
+		b.routes = append(b.routes, InstanceRoute{page.pattern, handler})
+		b.Routes += 1
+		b.config.Logger.Debug("added template handler with layout", "pattern", page.pattern, "template_path", page.file, "layout", layout.Name())
+	}
+	return nil
+}
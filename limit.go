@@ -0,0 +1,182 @@
+package xtemplate
+
+// This file implements limiting how many templates execute at once.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ConcurrencyConfig limits how many requests execute templates at once, so a
+// burst of requests to pages that query a database waits in a queue instead
+// of overwhelming it. Requests that don't fit in the queue, or wait in it too
+// long, get a `503 Service Unavailable` response with a `Retry-After` header.
+// Static files aren't limited.
+type ConcurrencyConfig struct {
+	// The number of templates that can execute at once across all routes.
+	// SSE templates stay open, so they're only limited by their route's
+	// limit. Default `0`, unlimited.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+
+	// The number of requests that can wait for one of MaxConcurrent to finish.
+	// Default `0`, requests over the limit are rejected immediately.
+	MaxQueue int `json:"max_queue,omitempty"`
+
+	// Limits of routes by pattern, like `GET /search`, which apply in addition
+	// to MaxConcurrent.
+	//
+	// Requests being handled by the previous instance after a reload count
+	// toward the limits of the new instance, unless the limits changed.
+	Routes map[string]ConcurrencyLimit `json:"routes,omitempty"`
+
+	// How long requests wait in a queue before they're rejected. Default `10s`.
+	QueueTimeout Duration `json:"queue_timeout,omitempty"`
+
+	// How long rejected clients are asked to wait before retrying. Default
+	// `5s`.
+	RetryAfter Duration `json:"retry_after,omitempty"`
+}
+
+// ConcurrencyLimit is the limit of a single route.
+type ConcurrencyLimit struct {
+	MaxConcurrent int `json:"max_concurrent"`
+	MaxQueue      int `json:"max_queue,omitempty"`
+}
+
+// WithConcurrency creates an [xtemplate.Option] that limits how many templates
+// execute at once.
+func WithConcurrency(config ConcurrencyConfig) Option {
+	return func(c *Config) error {
+		c.Concurrency = &config
+		return nil
+	}
+}
+
+func (c *ConcurrencyConfig) defaults() error {
+	if c.QueueTimeout == 0 {
+		c.QueueTimeout = Duration(10 * time.Second)
+	}
+	if c.RetryAfter == 0 {
+		c.RetryAfter = Duration(5 * time.Second)
+	}
+	if c.MaxConcurrent < 0 || c.MaxQueue < 0 {
+		return fmt.Errorf("concurrency max_concurrent and max_queue can't be negative")
+	}
+	for pattern, limit := range c.Routes {
+		if limit.MaxConcurrent < 1 {
+			return fmt.Errorf("concurrency limit of route '%s' must be at least 1", pattern)
+		}
+		if limit.MaxQueue < 0 {
+			return fmt.Errorf("concurrency queue of route '%s' can't be negative", pattern)
+		}
+	}
+	return nil
+}
+
+// concurrencyLimiter allows up to the capacity of slots to run at once, and
+// up to the capacity of queue to wait for a slot.
+type concurrencyLimiter struct {
+	slots chan struct{}
+	queue chan struct{}
+}
+
+func newConcurrencyLimiter(limit ConcurrencyLimit) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, limit.MaxConcurrent), queue: make(chan struct{}, limit.MaxQueue)}
+}
+
+// concurrencyLimiter returns the limiter of the route pattern, or the global
+// limiter if pattern is "". It's the previous instance's limiter if it had the
+// same limit, so requests it's still handling count toward the limit.
+func (b *builder) concurrencyLimiter(pattern string, limit ConcurrencyLimit) *concurrencyLimiter {
+	l := b.reload.prevConcurrencyLimiter(pattern)
+	if l == nil || cap(l.slots) != limit.MaxConcurrent || cap(l.queue) != limit.MaxQueue {
+		l = newConcurrencyLimiter(limit)
+	}
+	b.concurrencyLimiters[pattern] = l
+	return l
+}
+
+// acquire takes a slot, waiting up to timeout in the queue if there's room in
+// it, and reports whether it succeeded. A successful acquire must be followed
+// by release.
+func (l *concurrencyLimiter) acquire(ctx context.Context, timeout time.Duration) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case l.queue <- struct{}{}:
+		defer func() { <-l.queue }()
+	default:
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
+
+// limitConcurrency wraps the template handler of the route pattern with the
+// global limit and the route's limit, if they're configured. stream is true
+// for SSE templates.
+func (b *builder) limitConcurrency(pattern string, handler http.HandlerFunc, stream bool) http.HandlerFunc {
+	config := b.config.Concurrency
+	if config == nil {
+		return handler
+	}
+	var limiters []*concurrencyLimiter
+	if limit, ok := config.Routes[pattern]; ok {
+		limiters = append(limiters, b.concurrencyLimiter(pattern, limit))
+		b.limitedRoutes[pattern] = true
+	}
+	if b.concurrency != nil && !stream {
+		limiters = append(limiters, b.concurrency)
+	}
+	if len(limiters) == 0 {
+		return handler
+	}
+	timeout := time.Duration(config.QueueTimeout)
+	retryAfter := strconv.Itoa(int(math.Ceil(time.Duration(config.RetryAfter).Seconds())))
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, l := range limiters {
+			if !l.acquire(r.Context(), timeout) {
+				GetLogger(r.Context()).Warn("rejected request over concurrency limit", slog.String("pattern", pattern))
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, "server busy", http.StatusServiceUnavailable)
+				return
+			}
+			defer l.release()
+		}
+		handler(w, r)
+	}
+}
+
+// checkConcurrencyRoutes returns an error if a route limit doesn't match the
+// pattern of any template route, which is probably a typo.
+func (b *builder) checkConcurrencyRoutes() error {
+	if b.config.Concurrency == nil {
+		return nil
+	}
+	for pattern := range b.config.Concurrency.Routes {
+		if !b.limitedRoutes[pattern] {
+			return fmt.Errorf("concurrency limit route '%s' is not the pattern of a template route", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package xtemplate
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestConcurrencyLimitRejects(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server, ts := newTestServer(t, map[string]string{"slow.html": "{{wait}}"}, started, release, WithConcurrency(ConcurrencyConfig{
+		Routes:     map[string]ConcurrencyLimit{"GET /slow": {MaxConcurrent: 1}},
+		RetryAfter: Duration(3 * time.Second),
+	}))
+
+	slow := make(chan int)
+	getSlow := func() {
+		resp, err := http.Get(ts.URL + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}
+	go getSlow()
+	<-started
+
+	resp, err := http.Get(ts.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "3" {
+		t.Fatalf("status %d and Retry-After %q over the limit, want 503 and 3", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// the previous instance's request still counts after a reload
+	if err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if status := getStatus(t, ts.URL+"/slow"); status != http.StatusServiceUnavailable {
+		t.Fatalf("status %d over the limit after a reload, want 503", status)
+	}
+
+	release <- struct{}{}
+	if status := <-slow; status != http.StatusOK {
+		t.Fatalf("status %d under the limit, want 200", status)
+	}
+	go getSlow()
+	<-started
+	release <- struct{}{}
+	if status := <-slow; status != http.StatusOK {
+		t.Fatalf("status %d after the limit was released, want 200", status)
+	}
+}
+
+func TestConcurrencyLimitQueues(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	_, ts := newTestServer(t, map[string]string{"slow.html": "{{wait}}"}, started, release, WithConcurrency(ConcurrencyConfig{
+		MaxConcurrent: 1,
+		MaxQueue:      1,
+		QueueTimeout:  Duration(time.Minute),
+	}))
+
+	slow := make(chan int)
+	getSlow := func() {
+		resp, err := http.Get(ts.URL + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}
+	go getSlow()
+	<-started
+	// the second request waits in the queue
+	go getSlow()
+	time.Sleep(50 * time.Millisecond)
+	// and the third doesn't fit in it
+	if status := getStatus(t, ts.URL+"/slow"); status != http.StatusServiceUnavailable {
+		t.Fatalf("status %d with a full queue, want 503", status)
+	}
+
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if status := <-slow; status != http.StatusOK {
+			t.Fatalf("status %d of a queued request, want 200", status)
+		}
+	}
+}
+
+func TestConcurrencyConfigValidation(t *testing.T) {
+	for _, config := range []ConcurrencyConfig{
+		{MaxConcurrent: 1, MaxQueue: -1},
+		{Routes: map[string]ConcurrencyLimit{"GET /": {MaxConcurrent: -1}}},
+		{Routes: map[string]ConcurrencyLimit{"GET /": {MaxConcurrent: 1, MaxQueue: -1}}},
+		{Routes: map[string]ConcurrencyLimit{"GET /missing": {MaxConcurrent: 1}}},
+	} {
+		c := New()
+		c.Ctx = context.Background()
+		_, err := c.Server(WithTemplateFS(fstest.MapFS{"index.html": {Data: []byte("hello")}}), WithConcurrency(config))
+		if err == nil || !strings.Contains(err.Error(), "concurrency") {
+			t.Errorf("config %+v loaded with error %v, want a concurrency error", config, err)
+		}
+	}
+}
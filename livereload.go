@@ -0,0 +1,106 @@
+package xtemplate
+
+// This file implements refreshing browsers after the server reloads in
+// development mode.
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LiveReloadPath is the url path of the event stream that notifies browsers
+// when a [Server] in development mode reloads.
+const LiveReloadPath = "/_xtemplate/livereload"
+
+// liveReloadScript is injected into html responses in development mode. The
+// stream sends the current instance's version when connecting and after each
+// reload, so the page refreshes when it sees a different version, including
+// after reconnecting to a restarted server. The guard avoids opening more
+// streams when html fragments are swapped into the page.
+const liveReloadScript = `<script>(()=>{if(window.xtemplateLiveReload)return;window.xtemplateLiveReload=1;let v;new EventSource("` + LiveReloadPath + `").addEventListener("reload",e=>{if(v&&v!==e.data)location.reload();v=e.data})})()</script>`
+
+// injectLiveReload adds the live reload script to the end of the html body.
+func injectLiveReload(content []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(content), []byte("</body>"))
+	if i < 0 {
+		return append(content, liveReloadScript...)
+	}
+	return append(content[:i:i], append([]byte(liveReloadScript), content[i:]...)...)
+}
+
+// shouldInjectLiveReload reports whether the response to r is a full html page
+// that should have the live reload script added.
+func shouldInjectLiveReload(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("HX-Request") != "" {
+		return false
+	}
+	if _, ok := r.Context().Value(callDepthKey{}).(int); ok {
+		// internal requests are embedded in another response
+		return false
+	}
+	ctype := w.Header().Get("Content-Type")
+	return ctype == "" || strings.HasPrefix(ctype, "text/html")
+}
+
+// ReloadEvents returns a handler that streams an SSE event named `reload`
+// with the current instance's version when a client connects and after every
+// successful reload. In development mode it's served at [LiveReloadPath] by
+// [Server.Handler].
+func (x *Server) ReloadEvents() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && x.config.WriteTimeout != 0 {
+			x.config.Logger.Warn("failed to clear write deadline, the live reload stream will be closed after the write timeout", slog.Any("error", err))
+		}
+
+		for {
+			x.mutex.Lock()
+			reloaded := x.reloaded
+			version := x.version()
+			x.mutex.Unlock()
+
+			if _, err := fmt.Fprintf(w, "event: reload\ndata: %s\n\n", version); err != nil {
+				return
+			}
+			flusher.Flush()
+
+			select {
+			case <-reloaded:
+			case <-r.Context().Done():
+				return
+			case <-x.config.Ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// version identifies the current instance across restarts of the process.
+// x.mutex must be held.
+func (x *Server) version() string {
+	var id int64
+	if instance := x.instance.Load(); instance != nil {
+		id = instance.id
+	}
+	return fmt.Sprintf("%d-%d", x.started.UnixNano(), id)
+}
+
+// notifyReloaded wakes all live reload streams. x.mutex must be held.
+func (x *Server) notifyReloaded() {
+	if x.reloaded == nil {
+		return
+	}
+	close(x.reloaded)
+	x.reloaded = make(chan struct{})
+}
@@ -71,10 +71,17 @@ task: run: {
 
 	mktemp: file.MkdirTemp & {dir: vars.testdir, pattern: "temp-"}
 
+	check: exec.Run & {
+		cmd: ["bash", "-c", "../xtemplate --loglevel -4 --config-file ../config.json check &>check.log"]
+		dir:         mktemp.path
+		mustSucceed: true
+		$after:      mktemp.$done
+	}
+
 	start: exec.Run & {
 		cmd: ["bash", "-c", "../xtemplate --loglevel -4 --config-file ../config.json &>xtemplate.log &"]
 		dir:    mktemp.path
-		$after: mktemp.$done
+		$after: check.$done
 	}
 }
 
@@ -106,7 +113,7 @@ task: build_test: {
 	vars: #vars
 
 	build: task.build & {"vars": vars, outfile: "\(vars.testdir)/xtemplate"}
-	run: task.run & {"vars": vars, start: $after: build.gobuild.$done}
+	run: task.run & {"vars": vars, check: $after: build.gobuild.$done}
 	test: task.test & {"vars": vars, reportpath: "\(run.mktemp.path)/report", ready: $after: run.start.$done}
 	kill: exec.Run & {cmd: "pkill xtemplate", $after: test.hurl.$done}
 }
@@ -0,0 +1,138 @@
+package xtemplate
+
+// This file implements configuring the markdown renderers used by the
+// `markdown` and `markdownTOC` funcs and content pages.
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// MarkdownConfig configures a named markdown renderer. Templates select it
+// with `{{markdown .Text "name"}}`. A config named `default` replaces the
+// renderer used when no name is given.
+type MarkdownConfig struct {
+	Name string `json:"name"`
+
+	// The goldmark extensions to enable by name: `gfm`, `table`,
+	// `strikethrough`, `linkify`, `tasklist`, `footnote`, `definition_list`,
+	// `typographer`, `cjk`, `highlighting`, or a name added with
+	// [AddMarkdownExtension]. Default `["gfm", "footnote", "highlighting"]`.
+	Extensions []string `json:"extensions,omitempty"`
+
+	// Render raw html and potentially dangerous links in the markdown as is.
+	// Only enable this for trusted markdown. Default `false`.
+	Unsafe bool `json:"unsafe,omitempty"`
+
+	// Render newlines within paragraphs as `<br>`. Default `false`.
+	HardWraps bool `json:"hard_wraps,omitempty"`
+
+	// The chroma style used to highlight code blocks with inline styles, like
+	// `monokai`. Default ``, code is marked up with css classes instead.
+	HighlightStyle string `json:"highlight_style,omitempty"`
+}
+
+// WithMarkdown creates an [xtemplate.Option] that adds a named markdown
+// renderer.
+func WithMarkdown(config MarkdownConfig) Option {
+	return func(c *Config) error {
+		c.Markdown = append(c.Markdown, config)
+		return nil
+	}
+}
+
+var markdownExtensionsMutex sync.Mutex
+
+// markdownExtensions is the map of names of goldmark extensions that can be
+// enabled by a [MarkdownConfig].
+var markdownExtensions = map[string]goldmark.Extender{
+	"gfm":             extension.GFM,
+	"table":           extension.Table,
+	"strikethrough":   extension.Strikethrough,
+	"linkify":         extension.Linkify,
+	"tasklist":        extension.TaskList,
+	"footnote":        extension.Footnote,
+	"definition_list": extension.DefinitionList,
+	"typographer":     extension.Typographer,
+	"cjk":             extension.CJK,
+}
+
+// AddMarkdownExtension adds a goldmark extension, like a custom renderer, that
+// a [MarkdownConfig] can enable by name.
+func AddMarkdownExtension(name string, ext goldmark.Extender) {
+	markdownExtensionsMutex.Lock()
+	defer markdownExtensionsMutex.Unlock()
+	if _, ok := markdownExtensions[name]; ok || name == "highlighting" {
+		panic(fmt.Sprintf("markdown extension with name %s already exists", name))
+	}
+	markdownExtensions[name] = ext
+}
+
+// New creates a goldmark renderer from the config.
+func (c MarkdownConfig) New() (goldmark.Markdown, error) {
+	names := c.Extensions
+	if names == nil {
+		names = []string{"gfm", "footnote", "highlighting"}
+	}
+	var exts []goldmark.Extender
+	markdownExtensionsMutex.Lock()
+	defer markdownExtensionsMutex.Unlock()
+	for _, name := range names {
+		if name == "highlighting" {
+			if c.HighlightStyle != "" {
+				exts = append(exts, highlighting.NewHighlighting(highlighting.WithStyle(c.HighlightStyle)))
+			} else {
+				exts = append(exts, highlighting.NewHighlighting(highlighting.WithFormatOptions(chromahtml.WithClasses(true))))
+			}
+			continue
+		}
+		ext, ok := markdownExtensions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown markdown extension '%s' in markdown config '%s'", name, c.Name)
+		}
+		exts = append(exts, ext)
+	}
+	var rendererOpts []renderer.Option
+	if c.Unsafe {
+		rendererOpts = append(rendererOpts, gmhtml.WithUnsafe())
+	}
+	if c.HardWraps {
+		rendererOpts = append(rendererOpts, gmhtml.WithHardWraps())
+	}
+	return goldmark.New(
+		goldmark.WithExtensions(exts...),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		goldmark.WithRendererOptions(rendererOpts...),
+	), nil
+}
+
+// newMarkdownConfigs returns the built-in markdown renderers overridden by
+// configs.
+func newMarkdownConfigs(configs []MarkdownConfig) (map[string]goldmark.Markdown, error) {
+	mds := maps.Clone(markdownConfigs)
+	seen := map[string]bool{}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("markdown config name is required")
+		}
+		if seen[config.Name] {
+			return nil, fmt.Errorf("markdown config name '%s' is used more than once", config.Name)
+		}
+		seen[config.Name] = true
+		md, err := config.New()
+		if err != nil {
+			return nil, err
+		}
+		mds[config.Name] = md
+	}
+	return mds, nil
+}
@@ -0,0 +1,261 @@
+package xtemplate
+
+// This file implements a registry of prometheus metrics served at a metrics
+// endpoint.
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsConfig configures a metrics endpoint in the prometheus text format,
+// and a dot provider that templates use to record their own metrics. The
+// count, duration, and response size of requests are recorded for each route.
+// Metrics are kept when the server is reloaded.
+type MetricsConfig struct {
+	// The name of the dot field. Default `Metrics`.
+	Name string `json:"name,omitempty"`
+
+	// The path of the metrics endpoint. Default `/metrics`.
+	Path string `json:"path,omitempty"`
+
+	// The address to serve the metrics endpoint at, like `localhost:9090`, so
+	// it isn't public. Only used by a [Server]. Either this or Public must be
+	// set.
+	Listen string `json:"listen,omitempty"`
+
+	// Serve the metrics endpoint with the other routes, where anyone can read
+	// it. Default `false`.
+	Public bool `json:"public,omitempty"`
+
+	// The most label combinations each metric recorded by templates can
+	// have. Recording a metric with a new combination after that fails, so
+	// labels from user input can't create any number of series. Default
+	// `100`.
+	MaxSeries int `json:"max_series,omitempty"`
+
+	// The upper bounds of the buckets of histograms recorded by templates.
+	// Default prometheus' default buckets, from `0.005` to `10`.
+	Buckets []float64 `json:"buckets,omitempty"`
+}
+
+// WithMetrics creates an [xtemplate.Option] that enables the metrics endpoint
+// and dot provider.
+func WithMetrics(config MetricsConfig) Option {
+	return func(c *Config) error {
+		c.Metrics = &config
+		return nil
+	}
+}
+
+func (c *MetricsConfig) defaults() error {
+	if c.Name == "" {
+		c.Name = "Metrics"
+	}
+	if c.Path == "" {
+		c.Path = "/metrics"
+	}
+	if len(c.Buckets) == 0 {
+		c.Buckets = prometheus.DefBuckets
+	}
+	if c.MaxSeries == 0 {
+		c.MaxSeries = 100
+	}
+	if c.MaxSeries < 0 {
+		return fmt.Errorf("metrics max_series must not be negative, got %d", c.MaxSeries)
+	}
+	if c.Listen != "" && c.Public {
+		return fmt.Errorf("metrics can't be served both at listen address '%s' and publicly", c.Listen)
+	}
+	if c.Listen == "" && !c.Public {
+		return fmt.Errorf("metrics must be served at a listen address, or set public to serve them with the other routes")
+	}
+	return nil
+}
+
+// metricsRegistry holds the metrics of a server, which the next instance
+// keeps so counters aren't reset by reloading.
+type metricsRegistry struct {
+	reg *prometheus.Registry
+
+	// metrics of requests labeled by method, route pattern, and status
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	size     *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+
+	mu sync.Mutex
+	// metrics defined by templates by name
+	vecs map[string]*metricsVec
+}
+
+type metricsVec struct {
+	kind   string
+	labels []string
+	vec    prometheus.Collector
+	// label values of each series recorded, joined by a separator
+	series map[string]struct{}
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	labels := []string{"method", "route", "status"}
+	m := &metricsRegistry{
+		reg: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "xtemplate_http_requests_total",
+			Help: "Requests served, by route.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "xtemplate_http_request_duration_seconds",
+			Help:    "Time taken to serve requests, by route.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "xtemplate_http_response_size_bytes",
+			Help:    "Size of response bodies, by route.",
+			Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+		}, labels),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "xtemplate_http_requests_in_flight",
+			Help: "Requests being served.",
+		}),
+		vecs: make(map[string]*metricsVec),
+	}
+	m.reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.size, m.inFlight,
+	)
+	return m
+}
+
+// metricsMethods are the methods that are recorded as is. Others are recorded
+// as `OTHER` so clients can't create any number of metrics.
+var metricsMethods = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true}
+
+// observeRequest records a request served by the route pattern, which is
+// empty if no route matched.
+func (m *metricsRegistry) observeRequest(method, pattern string, metrics httpsnoop.Metrics) {
+	if !metricsMethods[method] {
+		method = "OTHER"
+	}
+	if pattern == "" {
+		pattern = "unmatched"
+	}
+	labels := prometheus.Labels{"method": method, "route": pattern, "status": strconv.Itoa(metrics.Code)}
+	m.requests.With(labels).Inc()
+	m.duration.With(labels).Observe(metrics.Duration.Seconds())
+	m.size.With(labels).Observe(float64(metrics.Written))
+}
+
+// vec returns the metric vector of kind `counter`, `gauge`, or `histogram`
+// named name, which is registered the first time it's used, and the labels of
+// the optional labels argument. A metric must be used with the same kind and
+// label names each time, and with at most maxSeries combinations of label
+// values.
+func (m *metricsRegistry) vec(kind, name string, buckets []float64, maxSeries int, labelArgs []map[string]any) (prometheus.Collector, prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	switch len(labelArgs) {
+	case 0:
+	case 1:
+		for k, v := range labelArgs[0] {
+			labels[k] = fmt.Sprint(v)
+		}
+	default:
+		return nil, nil, fmt.Errorf("too many labels arguments provided: %v", labelArgs)
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	values := make([]string, len(names))
+	for i, k := range names {
+		values[i] = labels[k]
+	}
+	series := strings.Join(values, "\xff")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.vecs[name]; ok {
+		if v.kind != kind {
+			return nil, nil, fmt.Errorf("metric '%s' is a %s, not a %s", name, v.kind, kind)
+		}
+		if !slices.Equal(v.labels, names) {
+			return nil, nil, fmt.Errorf("metric '%s' has labels %v, got %v", name, v.labels, names)
+		}
+		if _, ok := v.series[series]; !ok {
+			if len(v.series) >= maxSeries {
+				return nil, nil, fmt.Errorf("metric '%s' already has %d label combinations, the most allowed by max_series", name, len(v.series))
+			}
+			v.series[series] = struct{}{}
+		}
+		return v.vec, labels, nil
+	}
+	help := "Recorded by templates."
+	var vec prometheus.Collector
+	switch kind {
+	case "counter":
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, names)
+	case "gauge":
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, names)
+	case "histogram":
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, names)
+	}
+	if err := m.reg.Register(vec); err != nil {
+		return nil, nil, fmt.Errorf("failed to register metric '%s': %w", name, err)
+	}
+	m.vecs[name] = &metricsVec{kind, names, vec, map[string]struct{}{series: {}}}
+	return vec, labels, nil
+}
+
+func (m *metricsRegistry) handler(log *slog.Logger) http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{ErrorLog: slog.NewLogLogger(log.Handler(), slog.LevelWarn)})
+}
+
+func (b *builder) addMetricsHandler() error {
+	config := b.config.Metrics
+	if !config.Public {
+		return nil
+	}
+	pattern := "GET " + config.Path
+	handler := b.metrics.handler(b.config.Logger)
+	if err := catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.Handle(pattern, handler) }); err != nil {
+		return err
+	}
+	b.Routes += 1
+	b.routes = append(b.routes, InstanceRoute{pattern, handler})
+	b.config.Logger.Debug("added metrics handler", slog.String("path", config.Path))
+	return nil
+}
+
+// serveMetrics serves the metrics endpoint at the configured listen address
+// until the server's context is cancelled.
+func (x *Server) serveMetrics() {
+	config := x.config.Metrics
+	log := x.config.Logger.WithGroup("metrics")
+	mux := http.NewServeMux()
+	mux.Handle("GET "+config.Path, x.Instance().metrics.handler(log))
+	srv := &http.Server{Addr: config.Listen, Handler: mux, ReadHeaderTimeout: time.Duration(x.config.ReadHeaderTimeout)}
+	go func() {
+		<-x.config.Ctx.Done()
+		srv.Close()
+	}()
+	log.Info("starting metrics server", slog.String("listen", config.Listen))
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Error("metrics server stopped", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,138 @@
+package xtemplate
+
+// This file implements responding to NATS requests with templates.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsService is a template named like `NATS <subject>` that is executed for
+// each message published to subject, replying with its output.
+type natsService struct {
+	subject string
+	nats    *DotNatsConfig
+	tmpl    templateExecutor
+}
+
+func (b *builder) addNatsService(tmpl templateExecutor, dots []DotConfig) error {
+	subject := strings.TrimSpace(strings.TrimPrefix(tmpl.Name(), "NATS "))
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("nats template name must be formatted like 'NATS <subject>': '%s'", tmpl.Name())
+	}
+	var client *DotNatsConfig
+	for _, d := range dots {
+		if n, ok := d.(*DotNatsConfig); ok && n.Services {
+			if client != nil {
+				return fmt.Errorf("nats providers '%s' and '%s' both enable services, only one can", client.Name, n.Name)
+			}
+			client = n
+		}
+	}
+	if client == nil {
+		return fmt.Errorf("nats template '%s' requires a nats provider with services enabled", tmpl.Name())
+	}
+	b.natsServices = append(b.natsServices, &natsService{subject: subject, nats: client, tmpl: tmpl})
+	b.NatsServices += 1
+	b.config.Logger.Debug("added nats service", slog.String("subject", subject), slog.String("nats", client.Name))
+	return nil
+}
+
+// startNatsServices subscribes each nats service to its subject until the
+// instance's context is cancelled. Messages are handled by at most the nats
+// provider's ServiceWorkers templates at once.
+func (x *Instance) startNatsServices() error {
+	if len(x.natsServices) == 0 {
+		return nil
+	}
+	// all services use the same nats provider
+	workers := make(chan struct{}, x.natsServices[0].nats.ServiceWorkers)
+	var subs []*nats.Subscription
+	for _, svc := range x.natsServices {
+		sub, err := svc.nats.Conn.QueueSubscribe(svc.subject, svc.nats.Queue, func(msg *nats.Msg) {
+			// block the subscription until a worker is free, so messages
+			// wait in its pending buffer
+			select {
+			case workers <- struct{}{}:
+			case <-x.config.Ctx.Done():
+				return
+			}
+			go func() {
+				defer func() { <-workers }()
+				x.runNatsService(svc, msg)
+			}()
+		})
+		if err != nil {
+			for _, sub := range subs {
+				sub.Unsubscribe()
+			}
+			return fmt.Errorf("failed to subscribe nats template to '%s': %w", svc.subject, err)
+		}
+		subs = append(subs, sub)
+	}
+	go func() {
+		<-x.config.Ctx.Done()
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+		x.config.Logger.Debug("stopped nats services")
+	}()
+	return nil
+}
+
+// runNatsService executes the service's template with the same dot value as a
+// buffered http request, with the message at `.Nats.Msg`, and replies with
+// its output if the message is a request. If the template fails, the reply is
+// empty with the error in the `Nats-Service-Error` header, like NATS micro
+// services. The template's context is cancelled after the ServiceTimeout.
+func (x *Instance) runNatsService(svc *natsService, msg *nats.Msg) {
+	log := x.config.Logger.With(slog.String("nats_subject", msg.Subject))
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(x.config.Ctx, time.Duration(svc.nats.ServiceTimeout))
+	defer cancel()
+
+	w, r := httptest.NewRecorder(), httptest.NewRequest("NATS", "/", bytes.NewReader(msg.Data))
+	for k, v := range msg.Header {
+		r.Header[k] = v
+	}
+	r = r.WithContext(context.WithValue(ctx, natsMsgContextKey{}, msg))
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+	err := func() error {
+		val, err := x.bufferDot.value(ctx, w, r)
+		if err != nil {
+			return fmt.Errorf("failed to initialize dot value: %w", err)
+		}
+		return x.bufferDot.cleanup(val, svc.tmpl.Execute(buf, *val))
+	}()
+
+	if err != nil {
+		log.Warn("nats template failed", slog.Any("error", err), slog.Duration("duration", time.Since(start)))
+	} else {
+		log.Debug("nats template succeeded", slog.Duration("duration", time.Since(start)))
+	}
+	if msg.Reply == "" {
+		return
+	}
+	reply := nats.NewMsg(msg.Reply)
+	if err != nil {
+		reply.Header.Set("Nats-Service-Error", err.Error())
+		reply.Header.Set("Nats-Service-Error-Code", "500")
+	} else {
+		reply.Data = buf.Bytes()
+	}
+	if err := msg.RespondMsg(reply); err != nil {
+		log.Warn("failed to reply to nats request", slog.Any("error", err))
+	}
+}
+
+type natsMsgContextKey struct{}
@@ -0,0 +1,474 @@
+package xtemplate
+
+// This file implements the backends that convert html documents to PDF for
+// DotPDF: a basic pure-Go layout of the document's text, and printing with a
+// chromium browser over the DevTools protocol.
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// pdfBasic lays out the text of an html document with gofpdf's core fonts.
+type pdfBasic struct {
+	pageSize  string
+	landscape bool
+}
+
+func (b *pdfBasic) convert(_ context.Context, document string) ([]byte, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse html: %w", err)
+	}
+	orientation := "P"
+	if b.landscape {
+		orientation = "L"
+	}
+	pdf := gofpdf.New(orientation, "mm", b.pageSize, "")
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AddPage()
+	w := &pdfBasicWriter{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor(""), size: 11}
+	w.setFont()
+	w.node(root)
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// pdfBasicWriter writes html nodes to a pdf, tracking the inline style.
+type pdfBasicWriter struct {
+	pdf                     *gofpdf.Fpdf
+	tr                      func(string) string
+	bold, italic, underline int
+	size                    float64
+	link                    string
+	lists                   []int // the next item number of each ol, or -1 for ul
+}
+
+var pdfHeadingSizes = map[atom.Atom]float64{atom.H1: 22, atom.H2: 18, atom.H3: 15, atom.H4: 13, atom.H5: 12, atom.H6: 11}
+
+func (w *pdfBasicWriter) setFont() {
+	style := ""
+	if w.bold > 0 {
+		style += "B"
+	}
+	if w.italic > 0 {
+		style += "I"
+	}
+	if w.underline > 0 || w.link != "" {
+		style += "U"
+	}
+	w.pdf.SetFont("Helvetica", style, w.size)
+}
+
+// lineHeight is 1.4 times the font size, in mm.
+func (w *pdfBasicWriter) lineHeight() float64 {
+	return w.size * 1.4 * 25.4 / 72
+}
+
+// block starts a new line unless the current line is empty, and adds space
+// after the previous block.
+func (w *pdfBasicWriter) block(space float64) {
+	left, _, _, _ := w.pdf.GetMargins()
+	if w.pdf.GetX() > left+0.01 {
+		w.pdf.Ln(w.lineHeight())
+	}
+	w.pdf.Ln(space)
+}
+
+func (w *pdfBasicWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+func (w *pdfBasicWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Template, atom.Img:
+	case atom.Br:
+		w.pdf.Ln(w.lineHeight())
+	case atom.B, atom.Strong, atom.Th:
+		w.bold++
+		w.setFont()
+		w.children(n)
+		w.bold--
+		w.setFont()
+	case atom.I, atom.Em:
+		w.italic++
+		w.setFont()
+		w.children(n)
+		w.italic--
+		w.setFont()
+	case atom.U:
+		w.underline++
+		w.setFont()
+		w.children(n)
+		w.underline--
+		w.setFont()
+	case atom.A:
+		prev := w.link
+		w.link = htmlAttr(n, "href")
+		w.pdf.SetTextColor(0, 0, 238)
+		w.setFont()
+		w.children(n)
+		w.link = prev
+		if prev == "" {
+			w.pdf.SetTextColor(0, 0, 0)
+		}
+		w.setFont()
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		prev := w.size
+		w.size = pdfHeadingSizes[n.DataAtom]
+		w.bold++
+		w.setFont()
+		w.block(w.lineHeight() / 2)
+		w.children(n)
+		w.block(0)
+		w.bold--
+		w.size = prev
+		w.setFont()
+	case atom.P, atom.Blockquote, atom.Pre:
+		w.block(w.lineHeight() / 2)
+		w.children(n)
+		w.block(0)
+	case atom.Hr:
+		w.block(w.lineHeight() / 2)
+		left, _, right, _ := w.pdf.GetMargins()
+		width, _ := w.pdf.GetPageSize()
+		w.pdf.Line(left, w.pdf.GetY(), width-right, w.pdf.GetY())
+	case atom.Ul, atom.Ol:
+		start := -1
+		if n.DataAtom == atom.Ol {
+			start = 1
+		}
+		w.block(0)
+		w.lists = append(w.lists, start)
+		left, _, _, _ := w.pdf.GetMargins()
+		w.pdf.SetLeftMargin(left + 6)
+		w.children(n)
+		w.block(0)
+		w.pdf.SetLeftMargin(left)
+		w.pdf.SetX(left)
+		w.lists = w.lists[:len(w.lists)-1]
+	case atom.Li:
+		w.block(0)
+		marker := "•"
+		if len(w.lists) > 0 && w.lists[len(w.lists)-1] > 0 {
+			marker = fmt.Sprintf("%d.", w.lists[len(w.lists)-1])
+			w.lists[len(w.lists)-1]++
+		}
+		left, _, _, _ := w.pdf.GetMargins()
+		w.pdf.SetX(left - 5)
+		w.pdf.CellFormat(5, w.lineHeight(), w.tr(marker), "", 0, "L", false, 0, "")
+		w.children(n)
+	case atom.Table:
+		w.block(w.lineHeight() / 2)
+		w.table(n)
+		w.block(0)
+	case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main, atom.Nav, atom.Aside, atom.Address, atom.Figure:
+		w.block(0)
+		w.children(n)
+		w.block(0)
+	default:
+		w.children(n)
+	}
+}
+
+// text writes text with its whitespace collapsed like a browser would.
+func (w *pdfBasicWriter) text(s string) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			w.space()
+		}
+		return
+	}
+	if strings.IndexAny(s[:1], " \t\r\n") == 0 {
+		w.space()
+	}
+	text := strings.Join(fields, " ")
+	if strings.IndexAny(s[len(s)-1:], " \t\r\n") == 0 {
+		text += " "
+	}
+	if w.link != "" {
+		w.pdf.WriteLinkString(w.lineHeight(), w.tr(text), w.link)
+	} else {
+		w.pdf.Write(w.lineHeight(), w.tr(text))
+	}
+}
+
+// space writes a space between inline content, but not at the start of a line.
+func (w *pdfBasicWriter) space() {
+	left, _, _, _ := w.pdf.GetMargins()
+	if w.pdf.GetX() > left+0.01 {
+		w.pdf.Write(w.lineHeight(), " ")
+	}
+}
+
+// table lays out the rows of a table with equal width columns, wrapping the
+// text of each cell.
+func (w *pdfBasicWriter) table(n *html.Node) {
+	type cell struct {
+		text, align string
+		header      bool
+	}
+	var rows [][]cell
+	columns := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.DataAtom {
+			case atom.Tr:
+				var row []cell
+				for td := c.FirstChild; td != nil; td = td.NextSibling {
+					if td.DataAtom != atom.Td && td.DataAtom != atom.Th {
+						continue
+					}
+					align := "L"
+					switch strings.ToLower(htmlAttr(td, "align")) {
+					case "right":
+						align = "R"
+					case "center":
+						align = "C"
+					}
+					row = append(row, cell{strings.Join(strings.Fields(htmlNodeText(td)), " "), align, td.DataAtom == atom.Th})
+				}
+				rows = append(rows, row)
+				columns = max(columns, len(row))
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	if columns == 0 {
+		return
+	}
+
+	left, _, right, bottom := w.pdf.GetMargins()
+	pageWidth, pageHeight := w.pdf.GetPageSize()
+	width := (pageWidth - left - right) / float64(columns)
+	lh := w.lineHeight()
+	for _, row := range rows {
+		lines := 1
+		for _, c := range row {
+			w.bold += boolInt(c.header)
+			w.setFont()
+			lines = max(lines, len(w.pdf.SplitLines([]byte(w.tr(c.text)), width-2)))
+			w.bold -= boolInt(c.header)
+		}
+		height := float64(lines)*lh + 2
+		if w.pdf.GetY()+height > pageHeight-bottom {
+			w.pdf.AddPage()
+		}
+		y := w.pdf.GetY()
+		for i, c := range row {
+			x := left + float64(i)*width
+			w.bold += boolInt(c.header)
+			w.setFont()
+			w.pdf.Rect(x, y, width, height, "D")
+			w.pdf.SetXY(x, y+1)
+			w.pdf.MultiCell(width, lh, w.tr(c.text), "", c.align, false)
+			w.bold -= boolInt(c.header)
+		}
+		w.setFont()
+		w.pdf.SetXY(left, y+height)
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// htmlNodeText returns the text content of n.
+func htmlNodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+// pdfChromium prints documents with a chromium browser over the DevTools
+// protocol. Each document is printed in a new tab that's closed after.
+type pdfChromium struct {
+	endpoint      string
+	width, height float64 // inches
+}
+
+func (b *pdfChromium) convert(ctx context.Context, document string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", b.endpoint+"/json/new?about:blank", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chromium tab: %w", err)
+	}
+	var target struct {
+		ID                   string `json:"id"`
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&target)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode chromium tab: %w", err)
+	}
+	defer func() {
+		req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), "GET", b.endpoint+"/json/close/"+target.ID, nil)
+		if err != nil {
+			return
+		}
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, target.WebSocketDebuggerURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to chromium tab: %w", err)
+	}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+	conn := &cdpConn{ws: ws}
+
+	if err := conn.call("Page.enable", nil, nil); err != nil {
+		return nil, err
+	}
+	url := "data:text/html;charset=utf-8;base64," + base64.StdEncoding.EncodeToString([]byte(document))
+	if err := conn.call("Page.navigate", map[string]any{"url": url}, nil); err != nil {
+		return nil, err
+	}
+	if err := conn.wait("Page.loadEventFired"); err != nil {
+		return nil, err
+	}
+	var result struct {
+		Data []byte `json:"data"`
+	}
+	err = conn.call("Page.printToPDF", map[string]any{
+		"printBackground":   true,
+		"paperWidth":        b.width,
+		"paperHeight":       b.height,
+		"preferCSSPageSize": true,
+	}, &result)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// cdpConn sends DevTools protocol commands to a tab one at a time.
+type cdpConn struct {
+	ws     *websocket.Conn
+	id     int
+	events map[string]bool
+}
+
+type cdpMessage struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// call sends the command method and decodes its result into result.
+func (c *cdpConn) call(method string, params, result any) error {
+	c.id++
+	if params == nil {
+		params = map[string]any{}
+	}
+	if err := c.ws.WriteJSON(map[string]any{"id": c.id, "method": method, "params": params}); err != nil {
+		return fmt.Errorf("failed to send chromium command %s: %w", method, err)
+	}
+	for {
+		msg, err := c.read(method)
+		if err != nil {
+			return err
+		}
+		if msg.ID != c.id {
+			continue
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("chromium command %s failed: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("failed to decode chromium %s result: %w", method, err)
+			}
+		}
+		return nil
+	}
+}
+
+// wait waits until the event named method is received.
+func (c *cdpConn) wait(method string) error {
+	for !c.events[method] {
+		if _, err := c.read(method); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cdpConn) read(method string) (cdpMessage, error) {
+	var msg cdpMessage
+	if err := c.ws.ReadJSON(&msg); err != nil {
+		return msg, fmt.Errorf("failed to read chromium %s: %w", method, err)
+	}
+	if msg.Method != "" {
+		if c.events == nil {
+			c.events = make(map[string]bool)
+		}
+		c.events[msg.Method] = true
+	}
+	return msg, nil
+}
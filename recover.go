@@ -0,0 +1,86 @@
+package xtemplate
+
+// This file implements recovering from panics while serving a request.
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicInfo is the dot value used to execute the [Config.ErrorTemplate] when
+// a request panics.
+type PanicInfo struct {
+	// The value passed to panic, formatted as a string.
+	Panic string
+	// The stack trace of the panic. Only set in development mode.
+	Stack string
+
+	Method    string
+	Path      string
+	RequestId string
+}
+
+// Panics returns the number of requests served by this instance that
+// panicked.
+func (x *Instance) Panics() int64 {
+	return x.panics.Load()
+}
+
+// recoverPanics wraps h so that if it panics, the panic and stack are logged
+// and an error page is rendered instead of dropping the connection.
+func (x *Instance) recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// intentionally aborted by the handler
+				panic(p)
+			}
+			stack := debug.Stack()
+			count := x.panics.Add(1)
+			log := GetLogger(r.Context())
+			log.LogAttrs(r.Context(), slog.LevelError, "panic while serving request", slog.Any("panic", p), slog.String("stack", string(stack)), slog.Int64("panics", count))
+			x.renderPanic(w, r, p, stack)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// renderPanic writes a 500 response using the configured error template, or a
+// plain text message that includes the stack in development mode. If the
+// response was already started this adds to it, which is the best we can do.
+func (x *Instance) renderPanic(w http.ResponseWriter, r *http.Request, p any, stack []byte) {
+	info := PanicInfo{
+		Panic:     fmt.Sprint(p),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		RequestId: GetRequestId(r.Context()),
+	}
+	if x.config.Development {
+		info.Stack = string(stack)
+	}
+
+	if x.config.ErrorTemplate != "" {
+		buf := new(bytes.Buffer)
+		err := x.templates.ExecuteTemplate(buf, x.config.ErrorTemplate, info)
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(buf.Bytes())
+			return
+		}
+		GetLogger(r.Context()).Error("failed to execute error template", slog.String("template_name", x.config.ErrorTemplate), slog.Any("error", err))
+	}
+
+	if x.config.Development {
+		http.Error(w, fmt.Sprintf("internal server error: panic: %s\n\n%s", info.Panic, info.Stack), http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,84 @@
+package xtemplate
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// panicProvider panics while creating its dot value for some paths. Panics in
+// template funcs are returned as errors by text/template, so they can't be
+// used to test recovery.
+type panicProvider struct{}
+
+func (panicProvider) FieldName() string              { return "Panic" }
+func (panicProvider) Init(ctx context.Context) error { return nil }
+func (panicProvider) Value(r Request) (any, error) {
+	switch r.R.URL.Path {
+	case "/panic":
+		panic("boom")
+	case "/abort":
+		panic(http.ErrAbortHandler)
+	}
+	return "", nil
+}
+
+func TestRecoverPanics(t *testing.T) {
+	files := map[string]string{
+		"index.html": `hello{{define "error-page"}}<p>{{.Method}} {{.Path}}: {{.Panic}}</p>{{with .Stack}}<pre>stack</pre>{{end}}{{end}}`,
+		"panic.html": `unreachable`,
+		"abort.html": `unreachable`,
+	}
+	for _, test := range []struct {
+		name        string
+		template    string
+		development bool
+		want        string
+	}{
+		{"plain", "", false, "internal server error\n"},
+		{"plain dev", "", true, "internal server error: panic: boom\n\ngoroutine "},
+		{"template", "error-page", false, "<p>GET /panic: boom</p>"},
+		{"template dev", "error-page", true, "<p>GET /panic: boom</p><pre>stack</pre>"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := func(c *Config) error {
+				c.ErrorTemplate = test.template
+				c.Development = test.development
+				return nil
+			}
+			server, ts := newTestServer(t, files, nil, nil, WithProvider(panicProvider{}), config)
+			req, _ := http.NewRequest("GET", ts.URL+"/panic", nil)
+			resp, body := doRequest(t, req)
+			if resp.StatusCode != http.StatusInternalServerError || !strings.HasPrefix(body, test.want) {
+				t.Fatalf("status %d body %q after a panic, want 500 %q", resp.StatusCode, body, test.want)
+			}
+			if panics := server.Instance().Panics(); panics != 1 {
+				t.Fatalf("counted %d panics, want 1", panics)
+			}
+			if status := getStatus(t, ts.URL+"/"); status != http.StatusOK {
+				t.Fatalf("status %d after a panic, want 200", status)
+			}
+
+			// aborted handlers still drop the connection and aren't counted
+			if resp, err := http.Get(ts.URL + "/abort"); err == nil {
+				resp.Body.Close()
+				t.Fatalf("aborted request got status %d, want a dropped connection", resp.StatusCode)
+			}
+			if panics := server.Instance().Panics(); panics != 1 {
+				t.Fatalf("counted %d panics after an abort, want 1", panics)
+			}
+		})
+	}
+}
+
+func TestRecoverPanicsUndefinedTemplate(t *testing.T) {
+	c := New()
+	c.Ctx = context.Background()
+	c.ErrorTemplate = "missing"
+	_, err := c.Server(WithTemplateFS(fstest.MapFS{"index.html": {Data: []byte("hello")}}))
+	if err == nil || !strings.Contains(err.Error(), "error template") {
+		t.Fatalf("loaded with an undefined error template: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package xtemplate
+
+// This file implements reusing the results of loading unchanged files from the
+// previous instance when reloading.
+
+import (
+	"hash/maphash"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"text/template/parse"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// loadCache records the expensive results of loading each file into an
+// instance, keyed by its path in the templates FS.
+type loadCache struct {
+	static    map[string]staticLoad
+	templates map[string]templateLoad
+}
+
+func newLoadCache() *loadCache {
+	return &loadCache{static: make(map[string]staticLoad), templates: make(map[string]templateLoad)}
+}
+
+type fileStamp struct {
+	size    int64
+	modtime time.Time
+	sum     uint64
+}
+
+// stampSeed seeds the hashes of file contents. Stamps are only compared within
+// a process, so a random seed is fine.
+var stampSeed = maphash.MakeSeed()
+
+// sumReader hashes the content read from r. maphash is much faster than the
+// sha384 used for static file hashes, so comparing sums is cheaper than
+// rehashing or reparsing a file.
+func sumReader(r io.Reader) (uint64, error) {
+	var h maphash.Hash
+	h.SetSeed(stampSeed)
+	if _, err := io.Copy(&h, r); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+type staticLoad struct {
+	fileStamp
+	sri string
+}
+
+type templateLoad struct {
+	fileStamp
+	trees map[string]*parse.Tree
+	meta  map[string]any
+}
+
+// reloadHint is passed from [Server.ReloadChanged] to [Config.Instance] to
+// reuse files loaded into the previous instance.
+type reloadHint struct {
+	prev    *loadCache
+	changed map[string]struct{}
+
+	// changed lists every file that changed since the previous instance, so
+	// files that aren't in it don't have to be read to check their content
+	complete bool
+
+	// caches of the previous instance to keep if configured to persist
+	caches map[string]*ttlCache
+
+	// cron jobs paused in the previous instance
+	cron *cronOverrides
+
+	// metrics of the previous instance
+	metrics *metricsRegistry
+
+	// webdav locks held in the previous instance
+	webdavLocks webdav.LockSystem
+
+	// nats servers started by the previous instance
+	natsServers map[string]*embeddedNats
+
+	// message buses of the previous instance
+	buses map[string]*busBroker
+
+	// concurrency limiters of the previous instance
+	concurrencyLimiters map[string]*concurrencyLimiter
+}
+
+func (h *reloadHint) prevCache(name string) *ttlCache {
+	if h == nil {
+		return nil
+	}
+	return h.caches[name]
+}
+
+func (h *reloadHint) prevNatsServer(name string) *embeddedNats {
+	if h == nil {
+		return nil
+	}
+	return h.natsServers[name]
+}
+
+func (h *reloadHint) prevBus(name string) *busBroker {
+	if h == nil {
+		return nil
+	}
+	return h.buses[name]
+}
+
+func (h *reloadHint) prevConcurrencyLimiter(pattern string) *concurrencyLimiter {
+	if h == nil {
+		return nil
+	}
+	return h.concurrencyLimiters[pattern]
+}
+
+func (h *reloadHint) prevCronOverrides() *cronOverrides {
+	if h == nil || h.cron == nil {
+		return &cronOverrides{paused: make(map[string]bool), running: make(map[string]bool)}
+	}
+	return h.cron
+}
+
+func (h *reloadHint) prevMetrics() *metricsRegistry {
+	if h == nil || h.metrics == nil {
+		return newMetricsRegistry()
+	}
+	return h.metrics
+}
+
+func (h *reloadHint) prevWebDAVLocks() webdav.LockSystem {
+	if h == nil || h.webdavLocks == nil {
+		return webdav.NewMemLS()
+	}
+	return h.webdavLocks
+}
+
+func (h *reloadHint) prevStatic(path_ string) (staticLoad, bool) {
+	if h == nil || h.prev == nil {
+		return staticLoad{}, false
+	}
+	l, ok := h.prev.static[path_]
+	return l, ok
+}
+
+func (h *reloadHint) prevTemplate(path_ string) (templateLoad, bool) {
+	if h == nil || h.prev == nil {
+		return templateLoad{}, false
+	}
+	l, ok := h.prev.templates[path_]
+	return l, ok
+}
+
+// isChanged reports whether path_ or one of its parent directories is hinted
+// as changed.
+func (h *reloadHint) isChanged(path_ string) bool {
+	for p := path_; ; p = path.Dir(p) {
+		if _, changed := h.changed[p]; changed {
+			return true
+		}
+		if p == "." || p == "/" {
+			return false
+		}
+	}
+}
+
+// unchanged reports whether the previous result of loading the file at path_
+// can be reused without reading the file: the hint must list every changed
+// file, the file must not be in it, and its size and modtime must be the
+// same.
+func (h *reloadHint) unchanged(path_ string, prev, current fileStamp) bool {
+	if h == nil || !h.complete || h.isChanged(path_) {
+		return false
+	}
+	return prev.size == current.size && prev.modtime.Equal(current.modtime)
+}
+
+// reusable reports whether the previous result of loading the file at path_
+// can be reused: it must not be hinted as changed, and its size and content
+// hash must be the same. Modtimes aren't compared since editors can save
+// changes within the filesystem's modtime granularity.
+func (h *reloadHint) reusable(path_ string, prev, current fileStamp, stamped bool) bool {
+	if h == nil || !stamped || h.isChanged(path_) {
+		return false
+	}
+	return prev.size == current.size && prev.sum == current.sum
+}
+
+// stamp returns the size and modtime of the file at path_ in the templates FS,
+// without its content hash.
+func (b *builder) stamp(path_ string) (fileStamp, bool) {
+	stat, err := fs.Stat(b.config.TemplatesFS, path_)
+	if err != nil {
+		return fileStamp{modtime: buildTime()}, false
+	}
+	modtime := stat.ModTime()
+	if modtime.IsZero() {
+		modtime = buildTime()
+	}
+	return fileStamp{size: stat.Size(), modtime: modtime}, true
+}
+
+func copyTrees(trees map[string]*parse.Tree) map[string]*parse.Tree {
+	copied := make(map[string]*parse.Tree, len(trees))
+	for name, tree := range trees {
+		copied[name] = tree.Copy()
+	}
+	return copied
+}
+
+// ReloadChanged is like [Server.Reload], but reuses the content hashes of
+// static files and the parsed templates of template files from the current
+// instance for files that haven't changed, which makes reloading large sites
+// much faster. Paths in changed can be relative to the templates FS or OS
+// paths under TemplatesDir or TemplatesBaseDirs, like the paths reported by a
+// file watcher; a changed directory marks every file under it as changed.
+//
+// If changed is nil, every file is read and reused only if its content is the
+// same. Otherwise changed must list every file that changed since the current
+// instance was loaded, and other files are reused without being read if their
+// size and modtime are the same. If cfgs are given, nothing is reused.
+func (x *Server) ReloadChanged(changed []string, cfgs ...Option) error {
+	hint := &reloadHint{changed: make(map[string]struct{}, len(changed)), complete: changed != nil}
+	dirs := append([]string{x.config.TemplatesDir}, x.config.TemplatesBaseDirs...)
+	for _, p := range changed {
+		for _, dir := range dirs {
+			if rel, err := filepath.Rel(dir, p); err == nil && fs.ValidPath(filepath.ToSlash(rel)) {
+				p = rel
+				break
+			}
+		}
+		hint.changed[filepath.ToSlash(p)] = struct{}{}
+	}
+	if old := x.instance.Load(); old != nil && len(cfgs) == 0 {
+		hint.prev = old.loaded
+	}
+	return x.reload(hint, cfgs...)
+}
@@ -0,0 +1,174 @@
+package xtemplate
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestReloadChangedReusesUnchangedFiles(t *testing.T) {
+	modtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte("hello"), ModTime: modtime},
+		"app.js":     {Data: []byte("console.log(1)"), ModTime: modtime},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	config := New()
+	config.Ctx = ctx
+	server, err := config.Server(WithTemplateFS(fsys), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+
+	reload := func(changed ...string) int {
+		t.Helper()
+		if err := server.ReloadChanged(changed); err != nil {
+			t.Fatal(err)
+		}
+		return server.Instance().Stats().ReusedFiles
+	}
+	if reused := reload(); reused != 2 {
+		t.Fatalf("reused %d unchanged files, want 2", reused)
+	}
+
+	// an edit that keeps the size and modtime is still noticed
+	fsys["index.html"] = &fstest.MapFile{Data: []byte("howdy"), ModTime: modtime}
+	if reused := reload(); reused != 1 {
+		t.Fatalf("reused %d files after editing one, want 1", reused)
+	}
+	req, err := http.NewRequest("GET", ts.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, body := doRequest(t, req); body != "howdy" {
+		t.Fatalf("served %q after the edit, want %q", body, "howdy")
+	}
+
+	// files hinted as changed are reloaded even if their content is the same
+	if reused := reload("app.js"); reused != 1 {
+		t.Fatalf("reused %d files with one hinted as changed, want 1", reused)
+	}
+}
+
+// readCountingFS counts the reads of each file's content.
+type readCountingFS struct {
+	fstest.MapFS
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (fsys *readCountingFS) count(name string) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.reads[name] += 1
+}
+
+func (fsys *readCountingFS) take() map[string]int {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	reads := fsys.reads
+	fsys.reads = make(map[string]int)
+	return reads
+}
+
+func (fsys *readCountingFS) Open(name string) (fs.File, error) {
+	f, err := fsys.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readCountingFile{f, fsys, name}, nil
+}
+
+func (fsys *readCountingFS) ReadFile(name string) ([]byte, error) {
+	fsys.count(name)
+	return fsys.MapFS.ReadFile(name)
+}
+
+type readCountingFile struct {
+	fs.File
+	fsys *readCountingFS
+	name string
+}
+
+func (f *readCountingFile) Read(p []byte) (int, error) {
+	f.fsys.count(f.name)
+	return f.File.Read(p)
+}
+
+func (f *readCountingFile) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+func (f *readCountingFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return f.File.(fs.ReadDirFile).ReadDir(n)
+}
+
+func TestReloadChangedSkipsReadingUnchangedFiles(t *testing.T) {
+	modtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := &readCountingFS{reads: make(map[string]int), MapFS: fstest.MapFS{
+		"index.html":    {Data: []byte("hello"), ModTime: modtime},
+		"app.js":        {Data: []byte("console.log(1)"), ModTime: modtime},
+		"sub/page.html": {Data: []byte("page"), ModTime: modtime},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	config := New()
+	config.Ctx = ctx
+	server, err := config.Server(WithTemplateFS(fsys), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.take()
+
+	for _, test := range []struct {
+		changed []string
+		reads   []string
+		reused  int
+	}{
+		// only the listed files are read
+		{[]string{"index.html"}, []string{"index.html"}, 2},
+		// os paths under the templates dir are accepted
+		{[]string{filepath.Join(config.TemplatesDir, "app.js")}, []string{"app.js"}, 2},
+		// a changed directory marks the files under it as changed
+		{[]string{"sub"}, []string{"sub/page.html"}, 2},
+		// without a list every file is read and compared
+		{nil, []string{"app.js", "index.html", "sub/page.html"}, 3},
+	} {
+		if err := server.ReloadChanged(test.changed); err != nil {
+			t.Fatal(err)
+		}
+		reads := fsys.take()
+		for _, name := range test.reads {
+			if reads[name] == 0 {
+				t.Errorf("didn't read %s with changed %q", name, test.changed)
+			}
+			delete(reads, name)
+		}
+		if len(reads) != 0 {
+			t.Errorf("read %v with changed %q", reads, test.changed)
+		}
+		if reused := server.Instance().Stats().ReusedFiles; reused != test.reused {
+			t.Errorf("reused %d files with changed %q, want %d", reused, test.changed, test.reused)
+		}
+	}
+
+	// files that aren't listed are still reloaded if their modtime changed
+	fsys.MapFS["app.js"] = &fstest.MapFile{Data: []byte("console.log(2)"), ModTime: modtime.Add(time.Second)}
+	if err := server.ReloadChanged([]string{"index.html"}); err != nil {
+		t.Fatal(err)
+	}
+	if reads := fsys.take(); reads["app.js"] == 0 {
+		t.Errorf("didn't read app.js after its modtime changed")
+	}
+}
@@ -0,0 +1,98 @@
+package xtemplate
+
+// This file implements rewriting parsed templates to support block helpers
+// like `{{with cachedblock ...}}` that the template language can't express.
+
+import (
+	"fmt"
+	"sort"
+	"text/template/parse"
+)
+
+// rewriteWithBlocks finds each `{{with ident args...}}...{{end}}` block in
+// trees, innermost first, and replaces it with the node returned by replace.
+// Templates returned by replace are added to trees. n counts the blocks found
+// in trees so replace can give new templates unique names.
+func rewriteWithBlocks(trees map[string]*parse.Tree, ident string, replace func(tree *parse.Tree, with *parse.BranchNode, n int) (parse.Node, []*parse.Tree, error)) error {
+	count := 0
+	var added []*parse.Tree
+	var rewrite func(tree *parse.Tree, list *parse.ListNode) error
+	rewrite = func(tree *parse.Tree, list *parse.ListNode) error {
+		if list == nil {
+			return nil
+		}
+		for i, node := range list.Nodes {
+			var branch *parse.BranchNode
+			switch n := node.(type) {
+			case *parse.IfNode:
+				branch = &n.BranchNode
+			case *parse.RangeNode:
+				branch = &n.BranchNode
+			case *parse.WithNode:
+				branch = &n.BranchNode
+			default:
+				continue
+			}
+			if err := rewrite(tree, branch.List); err != nil {
+				return err
+			}
+			if err := rewrite(tree, branch.ElseList); err != nil {
+				return err
+			}
+			if !isWithBlock(branch, ident) {
+				continue
+			}
+			if len(branch.Pipe.Decl) > 0 || branch.ElseList != nil {
+				return fmt.Errorf("%s in template '%s' can't declare variables or have an else block", ident, tree.Name)
+			}
+			count += 1
+			replacement, trees, err := replace(tree, branch, count)
+			if err != nil {
+				return err
+			}
+			list.Nodes[i] = replacement
+			added = append(added, trees...)
+		}
+		return nil
+	}
+	// visit trees in a consistent order so generated names are stable
+	names := make([]string, 0, len(trees))
+	for name := range trees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tree := trees[name]
+		if err := rewrite(tree, tree.Root); err != nil {
+			return err
+		}
+	}
+	for _, tree := range added {
+		trees[tree.Name] = tree
+	}
+	return nil
+}
+
+// isWithBlock reports whether node is a `{{with ident ...}}` block.
+func isWithBlock(node parse.Node, ident string) bool {
+	branch, ok := node.(*parse.BranchNode)
+	if w, isWith := node.(*parse.WithNode); isWith {
+		branch, ok = &w.BranchNode, true
+	}
+	if !ok || branch.NodeType != parse.NodeWith || len(branch.Pipe.Cmds) != 1 {
+		return false
+	}
+	id, ok := branch.Pipe.Cmds[0].Args[0].(*parse.IdentifierNode)
+	return ok && id.Ident == ident
+}
+
+// parseAction parses text containing a single action, like `{{f .}}`, so the
+// nodes of a replacement are fully initialized. funcs must include the funcs
+// that text calls.
+func parseAction(name, text string, funcs map[string]any) (*parse.ActionNode, error) {
+	tree, err := parse.New(name).Parse(text, "{{", "}}", map[string]*parse.Tree{}, funcs)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Root.Nodes[0].(*parse.ActionNode), nil
+}
@@ -0,0 +1,687 @@
+package xtemplate
+
+// This file implements a minimal client for S3-compatible object storage (AWS
+// S3, MinIO, GCS with HMAC keys, etc) and an fs.FS backed by a bucket.
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Config configures access to a bucket in S3-compatible object storage.
+type S3Config struct {
+	// The storage endpoint url, like `https://s3.us-east-1.amazonaws.com` or
+	// `http://localhost:9000`. Default `https://s3.<region>.amazonaws.com`.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// The bucket region. Default `us-east-1`.
+	Region string `json:"region,omitempty"`
+
+	// The bucket name.
+	Bucket string `json:"bucket"`
+
+	// An optional key prefix that all paths are relative to, like a directory.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Access credentials. Default to the `AWS_ACCESS_KEY_ID` and
+	// `AWS_SECRET_ACCESS_KEY` environment variables.
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+
+	// Address the bucket as a path on the endpoint instead of a subdomain.
+	// Required by MinIO and most self-hosted services.
+	PathStyle bool `json:"path_style,omitempty"`
+
+	// How long fetched objects can be reused before they are revalidated with
+	// a conditional request. Default `0`, always revalidate.
+	CacheTTL Duration `json:"cache_ttl,omitempty"`
+
+	// The maximum total size in bytes of objects cached in memory. Default
+	// `32MiB`.
+	CacheBytes int `json:"cache_bytes,omitempty"`
+}
+
+type s3Client struct {
+	S3Config
+	client *http.Client
+}
+
+func newS3Client(config S3Config) (*s3Client, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket name is required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.AccessKeyID == "" {
+		config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if config.SecretAccessKey == "" {
+		config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+	if config.CacheBytes == 0 {
+		config.CacheBytes = 32 << 20
+	}
+	return &s3Client{config, &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// key converts an fs path into an object key
+func (c *s3Client) key(name string) string {
+	if name == "." {
+		name = ""
+	}
+	if c.Prefix == "" {
+		return name
+	}
+	if name == "" {
+		return c.Prefix
+	}
+	return c.Prefix + "/" + name
+}
+
+// objectURL returns the url of the object key, or the bucket if key is empty.
+func (c *s3Client) objectURL(key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint '%s': %w", c.Endpoint, err)
+	}
+	p := "/" + s3Escape(key)
+	if c.PathStyle {
+		p = "/" + c.Bucket + p
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+	}
+	u.RawPath = p
+	u.Path, _ = url.PathUnescape(p)
+	u.RawQuery = s3Query(query)
+	return u, nil
+}
+
+// do sends a signed request for key with an optional body.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := c.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	sum := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	return c.client.Do(req)
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzdate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host"}
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") || lk == "content-type" || lk == "content-md5" {
+			signed = append(signed, lk)
+		}
+	}
+	sort.Strings(signed)
+	var headers strings.Builder
+	for _, k := range signed {
+		v := req.Host
+		if v == "" {
+			v = req.URL.Host
+		}
+		if k != "host" {
+			v = strings.Join(req.Header.Values(k), ",")
+		}
+		headers.WriteString(k + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signedHeaders, payloadHash}, "\n")
+	scope, signature := c.signature(amzdate, canonical)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func (c *s3Client) signature(amzdate, canonicalRequest string) (scope, signature string) {
+	date := amzdate[:8]
+	scope = date + "/" + c.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + c.SecretAccessKey)
+	for _, part := range []string{date, c.Region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	return scope, hex.EncodeToString(key)
+}
+
+// presign returns a url for key that can be requested with method without
+// credentials until expires has passed, using AWS Signature Version 4 query
+// parameters.
+func (c *s3Client) presign(method, key string, expires time.Duration, now time.Time) (string, error) {
+	amzdate := now.UTC().Format("20060102T150405Z")
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {c.AccessKeyID + "/" + amzdate[:8] + "/" + c.Region + "/s3/aws4_request"},
+		"X-Amz-Date":          {amzdate},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u, err := c.objectURL(key, query)
+	if err != nil {
+		return "", err
+	}
+	canonical := strings.Join([]string{method, u.EscapedPath(), u.RawQuery, "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	_, signature := c.signature(amzdate, canonical)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// s3PartSize is the size of the parts of multipart uploads, the minimum
+// allowed by S3.
+const s3PartSize = 5 << 20
+
+// upload streams r to key, in a single request if it's smaller than
+// s3PartSize and otherwise as a multipart upload, and returns the size and
+// ETag of the object.
+func (c *s3Client) upload(ctx context.Context, key string, r io.Reader, contentType string) (int64, string, error) {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := c.do(ctx, "PUT", key, nil, header, buf[:n])
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, "", s3Error(resp)
+		}
+		return int64(n), resp.Header.Get("ETag"), nil
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	resp, err := c.do(ctx, "POST", key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	var initiated struct{ UploadId string }
+	if resp.StatusCode != http.StatusOK {
+		err = s3Error(resp)
+	} else {
+		err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	}
+	resp.Body.Close()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to start multipart upload of '%s': %w", key, err)
+	}
+	size, etag, err := c.uploadParts(ctx, key, initiated.UploadId, r, buf, n)
+	if err != nil {
+		// abort the upload so its parts aren't stored
+		if resp, err := c.do(context.WithoutCancel(ctx), "DELETE", key, url.Values{"uploadId": {initiated.UploadId}}, nil, nil); err == nil {
+			resp.Body.Close()
+		}
+		return 0, "", fmt.Errorf("failed to upload '%s': %w", key, err)
+	}
+	return size, etag, nil
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+// uploadParts uploads the first n bytes of buf and then the rest of r as the
+// parts of the multipart upload uploadId, and completes it.
+func (c *s3Client) uploadParts(ctx context.Context, key, uploadId string, r io.Reader, buf []byte, n int) (int64, string, error) {
+	var parts []s3Part
+	var size int64
+	for n > 0 {
+		query := url.Values{"uploadId": {uploadId}, "partNumber": {strconv.Itoa(len(parts) + 1)}}
+		resp, err := c.do(ctx, "PUT", key, query, nil, buf[:n])
+		if err != nil {
+			return 0, "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return 0, "", err
+		}
+		resp.Body.Close()
+		parts = append(parts, s3Part{len(parts) + 1, resp.Header.Get("ETag")})
+		size += int64(n)
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, "", err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := c.do(ctx, "POST", key, url.Values{"uploadId": {uploadId}}, nil, body)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", s3Error(resp)
+	}
+	// completing can fail after the status is sent, with an error document
+	var result struct {
+		XMLName xml.Name
+		ETag    string
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, "", err
+	}
+	if result.XMLName.Local == "Error" {
+		return 0, "", fmt.Errorf("s3 request failed: %s: %s", result.Code, result.Message)
+	}
+	return size, result.ETag, nil
+}
+
+// s3Escape encodes s as required by S3 signatures: every byte except
+// unreserved characters and '/' is percent encoded.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, strings.ReplaceAll(s3Escape(k), "/", "%2F")+"="+strings.ReplaceAll(s3Escape(v), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e struct {
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 request failed with status %d: %s: %s", resp.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3 request failed with status %d", resp.StatusCode)
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+		ETag         string
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list lists objects in the bucket with the given key prefix. If delimiter is
+// set, keys with the delimiter after the prefix are grouped into CommonPrefixes.
+func (c *s3Client) list(ctx context.Context, prefix, delimiter string, limit int) (*s3ListResult, error) {
+	all := &s3ListResult{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if limit > 0 {
+		query.Set("max-keys", strconv.Itoa(limit))
+	}
+	for {
+		resp, err := c.do(ctx, "GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page s3ListResult
+		if resp.StatusCode != http.StatusOK {
+			err = s3Error(resp)
+		} else {
+			err = xml.NewDecoder(resp.Body).Decode(&page)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects with prefix '%s': %w", prefix, err)
+		}
+		all.Contents = append(all.Contents, page.Contents...)
+		all.CommonPrefixes = append(all.CommonPrefixes, page.CommonPrefixes...)
+		if !page.IsTruncated || page.NextContinuationToken == "" || (limit > 0 && len(all.Contents) >= limit) {
+			return all, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// NewS3FS creates an [fs.FS] backed by a bucket in S3-compatible object
+// storage. It can be used as the templates FS with [WithTemplateFS] or as a
+// directory with [WithDir], or configured in JSON with the `s3` field of a
+// directory.
+//
+// Objects are read fully into memory when opened and cached up to a size
+// budget. Cached objects are revalidated with a conditional request using
+// their ETag, so unchanged objects are not downloaded again.
+func NewS3FS(config S3Config) (fs.FS, error) {
+	client, err := newS3Client(config)
+	if err != nil {
+		return nil, err
+	}
+	return &s3FS{client: client, cache: make(map[string]*s3Object)}, nil
+}
+
+type s3FS struct {
+	client *s3Client
+
+	mu    sync.Mutex
+	cache map[string]*s3Object
+	order []string
+	size  int
+}
+
+var _ fs.ReadDirFS = &s3FS{}
+var _ fs.StatFS = &s3FS{}
+
+type s3Object struct {
+	key     string
+	etag    string
+	modtime time.Time
+	content []byte
+	fetched time.Time
+}
+
+func (f *s3FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return f.openDir(name)
+	}
+	obj, err := f.get(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if obj == nil {
+		return f.openDir(name)
+	}
+	return &s3File{Reader: bytes.NewReader(obj.content), info: s3FileInfo{name: path.Base(name), size: int64(len(obj.content)), modtime: obj.modtime}}, nil
+}
+
+// Stat returns the info of a cached object, otherwise it sends a HEAD request
+// so the object isn't downloaded.
+func (f *s3FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return s3FileInfo{name: ".", dir: true}, nil
+	}
+	key := f.client.key(name)
+	if obj := f.fresh(key); obj != nil {
+		return s3FileInfo{name: path.Base(name), size: int64(len(obj.content)), modtime: obj.modtime}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3FSTimeout)
+	defer cancel()
+	resp, err := f.client.do(ctx, "HEAD", key, nil, nil, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		modtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return s3FileInfo{name: path.Base(name), size: resp.ContentLength, modtime: modtime}, nil
+	case http.StatusNotFound:
+		dir, err := f.openDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return dir.Stat()
+	default:
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: s3Error(resp)}
+	}
+}
+
+func (f *s3FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (f *s3FS) openDir(name string) (fs.File, error) {
+	entries, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &s3Dir{info: s3FileInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+}
+
+func (f *s3FS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := f.client.key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3FSTimeout)
+	defer cancel()
+	result, err := f.client.list(ctx, prefix, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+	var entries []fs.DirEntry
+	for _, p := range result.CommonPrefixes {
+		entries = append(entries, s3FileInfo{name: strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"), dir: true})
+	}
+	for _, o := range result.Contents {
+		if n := strings.TrimPrefix(o.Key, prefix); n != "" {
+			entries = append(entries, s3FileInfo{name: n, size: o.Size, modtime: o.LastModified})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// s3FSTimeout is how long a request of an s3FS can take, since fs.FS methods
+// don't take a context.
+const s3FSTimeout = 30 * time.Second
+
+// fresh returns the cached object at key if it was fetched within the
+// CacheTTL, otherwise nil.
+func (f *s3FS) fresh(key string) *s3Object {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cached := f.cache[key]; cached != nil && time.Since(cached.fetched) < time.Duration(f.client.CacheTTL) {
+		return cached
+	}
+	return nil
+}
+
+// get returns the object at name using the cache, or nil if it doesn't exist.
+func (f *s3FS) get(name string) (*s3Object, error) {
+	key := f.client.key(name)
+	if obj := f.fresh(key); obj != nil {
+		return obj, nil
+	}
+
+	f.mu.Lock()
+	cached := f.cache[key]
+	f.mu.Unlock()
+
+	header := http.Header{}
+	if cached != nil {
+		header.Set("If-None-Match", cached.etag)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3FSTimeout)
+	defer cancel()
+	resp, err := f.client.do(ctx, "GET", key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		f.mu.Lock()
+		cached.fetched = time.Now()
+		f.mu.Unlock()
+		return cached, nil
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, s3Error(resp)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	modtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	obj := &s3Object{key: key, etag: resp.Header.Get("ETag"), modtime: modtime, content: content, fetched: time.Now()}
+	f.put(obj)
+	return obj, nil
+}
+
+// put adds obj to the cache, evicting the oldest entries to stay in budget.
+func (f *s3FS) put(obj *s3Object) {
+	if len(obj.content) > f.client.CacheBytes || obj.etag == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if old, ok := f.cache[obj.key]; ok {
+		f.size -= len(old.content)
+		f.order = slices.DeleteFunc(f.order, func(k string) bool { return k == obj.key })
+	}
+	for f.size+len(obj.content) > f.client.CacheBytes && len(f.order) > 0 {
+		f.size -= len(f.cache[f.order[0]].content)
+		delete(f.cache, f.order[0])
+		f.order = f.order[1:]
+	}
+	f.cache[obj.key] = obj
+	f.order = append(f.order, obj.key)
+	f.size += len(obj.content)
+}
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modtime time.Time
+	dir     bool
+}
+
+var _ fs.FileInfo = s3FileInfo{}
+var _ fs.DirEntry = s3FileInfo{}
+
+func (i s3FileInfo) Name() string               { return i.name }
+func (i s3FileInfo) Size() int64                { return i.size }
+func (i s3FileInfo) ModTime() time.Time         { return i.modtime }
+func (i s3FileInfo) IsDir() bool                { return i.dir }
+func (i s3FileInfo) Sys() any                   { return nil }
+func (i s3FileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i s3FileInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i s3FileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type s3File struct {
+	*bytes.Reader
+	info s3FileInfo
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *s3File) Close() error               { return nil }
+
+type s3Dir struct {
+	info    s3FileInfo
+	entries []fs.DirEntry
+}
+
+var _ fs.ReadDirFile = &s3Dir{}
+
+func (d *s3Dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *s3Dir) Close() error               { return nil }
+func (d *s3Dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+func (d *s3Dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
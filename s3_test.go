@@ -0,0 +1,310 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory bucket named `bucket` that serves the subset of the
+// S3 api used by s3Client with path style addressing. It doesn't check
+// signatures.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	parts    map[string]map[int][]byte
+	requests []string
+	// fail uploads of parts with an error document
+	failParts bool
+}
+
+func newFakeS3(t *testing.T, objects map[string]string) (*fakeS3, S3Config) {
+	f := &fakeS3{objects: make(map[string][]byte), parts: make(map[string]map[int][]byte)}
+	for k, v := range objects {
+		f.objects[k] = []byte(v)
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, S3Config{Endpoint: server.URL, Bucket: "bucket", PathStyle: true, AccessKeyID: "key", SecretAccessKey: "secret"}
+}
+
+func (f *fakeS3) set(key, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = []byte(content)
+}
+
+// methods returns the methods of the requests received since the last call.
+func (f *fakeS3) methods() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requests := f.requests
+	f.requests = nil
+	return requests
+}
+
+func fakeETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r.Method)
+
+	switch {
+	case r.Method == "GET" && query.Get("list-type") == "2":
+		f.list(w, query.Get("prefix"), query.Get("delimiter"))
+	case r.Method == "GET" || r.Method == "HEAD":
+		content, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		etag := fakeETag(content)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == "GET" {
+			w.Write(content)
+		}
+	case r.Method == "PUT" && query.Has("uploadId") && f.failParts:
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "<Error><Code>InternalError</Code><Message>part failed</Message></Error>")
+	case r.Method == "PUT" && query.Has("uploadId"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.parts[query.Get("uploadId")][number] = body
+		w.Header().Set("ETag", fakeETag(body))
+	case r.Method == "PUT":
+		f.objects[key] = body
+		w.Header().Set("ETag", fakeETag(body))
+	case r.Method == "POST" && query.Has("uploads"):
+		id := strconv.Itoa(len(f.parts) + 1)
+		f.parts[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == "POST" && query.Has("uploadId"):
+		var complete struct {
+			Part []s3Part
+		}
+		xml.Unmarshal(body, &complete)
+		parts := f.parts[query.Get("uploadId")]
+		var content []byte
+		for i, part := range complete.Part {
+			if part.PartNumber != i+1 || part.ETag != fakeETag(parts[part.PartNumber]) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code><Message>bad part</Message></Error>")
+				return
+			}
+			content = append(content, parts[part.PartNumber]...)
+		}
+		delete(f.parts, query.Get("uploadId"))
+		f.objects[key] = content
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><ETag>%s</ETag></CompleteMultipartUploadResult>", fakeETag(content))
+	case r.Method == "DELETE" && query.Has("uploadId"):
+		delete(f.parts, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, delimiter string) {
+	type content struct{ Key string }
+	type commonPrefix struct{ Prefix string }
+	var result struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}
+	seen := map[string]bool{}
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rest, ok := strings.CutPrefix(k, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			if p := prefix + rest[:i+1]; !seen[p] {
+				seen[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{p})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, content{k})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3FSRevalidatesCachedObjects(t *testing.T) {
+	fake, config := newFakeS3(t, map[string]string{"a.txt": "hello"})
+	fsys, err := NewS3FS(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(want string) error {
+		content, err := fs.ReadFile(fsys, "a.txt")
+		if err != nil {
+			return err
+		}
+		if string(content) != want {
+			return fmt.Errorf("read %q, want %q", content, want)
+		}
+		return nil
+	}
+	if err := read("hello"); err != nil {
+		t.Fatal(err)
+	}
+	// concurrent revalidations of the cached object, run with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := read("hello"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	fake.set("a.txt", "changed")
+	if err := read("changed"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.methods(); len(got) != 10 {
+		t.Fatalf("requests %v, want a GET for every read", got)
+	}
+}
+
+func TestS3FSCacheTTL(t *testing.T) {
+	fake, config := newFakeS3(t, map[string]string{"a.txt": "hello"})
+	config.CacheTTL = Duration(time.Hour)
+	fsys, err := NewS3FS(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.ReadFile(fsys, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	fake.methods()
+	fake.set("a.txt", "changed")
+	content, err := fs.ReadFile(fsys, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello" {
+		t.Fatalf("read %q within the cache ttl, want the cached %q", content, "hello")
+	}
+	if got := fake.methods(); len(got) != 0 {
+		t.Fatalf("requests %v within the cache ttl, want none", got)
+	}
+}
+
+func TestS3FSStat(t *testing.T) {
+	fake, config := newFakeS3(t, map[string]string{"a.txt": "hello", "dir/b.txt": "world"})
+	fsys, err := NewS3FS(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(fsys, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "a.txt" || info.Size() != 5 || info.IsDir() || info.ModTime().IsZero() {
+		t.Fatalf("stat a.txt = %s %d %v %v", info.Name(), info.Size(), info.IsDir(), info.ModTime())
+	}
+	if got := fake.methods(); strings.Join(got, " ") != "HEAD" {
+		t.Fatalf("requests %v, want a HEAD request instead of downloading the object", got)
+	}
+
+	info, err = fs.Stat(fsys, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Fatalf("stat dir is not a directory")
+	}
+
+	if _, err := fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("stat missing = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	fake, config := newFakeS3(t, nil)
+	client, err := newS3Client(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*s3PartSize+100)
+	for i := range data {
+		data[i] = byte(rand.IntN(256))
+	}
+
+	size, etag, err := client.upload(context.Background(), "big.bin", bytes.NewReader(data), "application/octet-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) || etag != fakeETag(data) {
+		t.Fatalf("uploaded size %d etag %s, want %d %s", size, etag, len(data), fakeETag(data))
+	}
+	if !bytes.Equal(fake.objects["big.bin"], data) {
+		t.Fatalf("uploaded object differs from the data")
+	}
+	if got := strings.Join(fake.methods(), " "); got != "POST PUT PUT PUT POST" {
+		t.Fatalf("requests %s, want a multipart upload of 3 parts", got)
+	}
+}
+
+func TestS3MultipartUploadAborts(t *testing.T) {
+	fake, config := newFakeS3(t, nil)
+	fake.failParts = true
+	client, err := newS3Client(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = client.upload(context.Background(), "big.bin", bytes.NewReader(make([]byte, s3PartSize+1)), "")
+	if err == nil || !strings.Contains(err.Error(), "InternalError: part failed") {
+		t.Fatalf("upload error %v, want the error document of the failed part", err)
+	}
+	if got := strings.Join(fake.methods(), " "); got != "POST PUT DELETE" {
+		t.Fatalf("requests %s, want the upload to be aborted", got)
+	}
+	if len(fake.parts) != 0 || fake.objects["big.bin"] != nil {
+		t.Fatalf("aborted upload left parts or an object behind")
+	}
+}
@@ -0,0 +1,141 @@
+package xtemplate
+
+// This file implements configuring the bluemonday policies used by the
+// `sanitizeHtml` func.
+
+import (
+	"fmt"
+	"html/template"
+	"maps"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// SanitizePolicyConfig configures a named html sanitization policy. Templates
+// select it with `{{sanitizeHtml "name" .Html}}`. A config with the name of a
+// built-in policy, `strict`, `ugc`, or `externalugc`, replaces it.
+type SanitizePolicyConfig struct {
+	Name string `json:"name"`
+
+	// The built-in policy to extend: `strict`, which removes all html, `ugc`,
+	// which allows common formatting, links, and images, or `externalugc`,
+	// which is `ugc` that opens absolute links in a new tab and doesn't allow
+	// relative links. Default `strict`.
+	Base string `json:"base,omitempty"`
+
+	// Additional elements to allow without attributes, like `["mark", "kbd"]`.
+	Elements []string `json:"elements,omitempty"`
+
+	// Additional attributes to allow, and the elements to allow each one on,
+	// like `{"class": ["span", "div"]}`. An attribute with no elements is
+	// allowed on every element.
+	Attributes map[string][]string `json:"attributes,omitempty"`
+
+	// Additional url schemes to allow in links, like `["mailto", "tel"]`.
+	URLSchemes []string `json:"url_schemes,omitempty"`
+
+	// Whether links to relative urls are allowed. Default `false`, unless the
+	// base policy allows them.
+	AllowRelativeURLs bool `json:"allow_relative_urls,omitempty"`
+
+	// Whether `rel="nofollow"` is added to links. Default `false`.
+	NoFollow bool `json:"nofollow,omitempty"`
+
+	// Whether `target="_blank"` is added to links to absolute urls. Default
+	// `false`, unless the base policy adds it.
+	TargetBlank bool `json:"target_blank,omitempty"`
+}
+
+// WithSanitizePolicy creates an [xtemplate.Option] that adds a named html
+// sanitization policy.
+func WithSanitizePolicy(config SanitizePolicyConfig) Option {
+	return func(c *Config) error {
+		c.SanitizePolicies = append(c.SanitizePolicies, config)
+		return nil
+	}
+}
+
+// sanitizeBasePolicies create fresh copies of the built-in policies, since
+// bluemonday policies are modified in place.
+var sanitizeBasePolicies = map[string]func() *bluemonday.Policy{
+	"strict": bluemonday.StrictPolicy,
+	"ugc":    bluemonday.UGCPolicy,
+	"externalugc": func() *bluemonday.Policy {
+		return bluemonday.UGCPolicy().
+			AddTargetBlankToFullyQualifiedLinks(true).
+			AllowRelativeURLs(false)
+	},
+}
+
+// New creates a bluemonday policy from the config.
+func (c SanitizePolicyConfig) New() (*bluemonday.Policy, error) {
+	base := c.Base
+	if base == "" {
+		base = "strict"
+	}
+	newBase, ok := sanitizeBasePolicies[base]
+	if !ok {
+		return nil, fmt.Errorf("unknown base policy '%s' in sanitize policy '%s'", base, c.Name)
+	}
+	p := newBase()
+	if len(c.Elements) > 0 {
+		p.AllowElements(c.Elements...)
+	}
+	for attr, elements := range c.Attributes {
+		if len(elements) == 0 {
+			p.AllowAttrs(attr).Globally()
+		} else {
+			p.AllowAttrs(attr).OnElements(elements...)
+		}
+	}
+	if len(c.URLSchemes) > 0 {
+		p.AllowURLSchemes(c.URLSchemes...)
+	}
+	if c.AllowRelativeURLs {
+		p.AllowRelativeURLs(true)
+	}
+	if c.NoFollow {
+		p.RequireNoFollowOnLinks(true)
+	}
+	if c.TargetBlank {
+		p.AddTargetBlankToFullyQualifiedLinks(true)
+	}
+	return p, nil
+}
+
+// newSanitizePolicies returns the global policies overridden by configs.
+func newSanitizePolicies(configs []SanitizePolicyConfig) (map[string]*bluemonday.Policy, error) {
+	blueMondayPoliciesMutex.RLock()
+	policies := maps.Clone(blueMondayPolicies)
+	blueMondayPoliciesMutex.RUnlock()
+	seen := map[string]bool{}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("sanitize policy name is required")
+		}
+		if seen[config.Name] {
+			return nil, fmt.Errorf("sanitize policy name '%s' is used more than once", config.Name)
+		}
+		seen[config.Name] = true
+		p, err := config.New()
+		if err != nil {
+			return nil, err
+		}
+		policies[config.Name] = p
+	}
+	return policies, nil
+}
+
+// sanitizeHtml is the `sanitizeHtml` func of an instance, which uses the
+// instance's policies.
+func (x *Instance) sanitizeHtml(policyName string, html string) (template.HTML, error) {
+	return sanitizeHtml(x.sanitizePolicies, policyName, html)
+}
+
+func sanitizeHtml(policies map[string]*bluemonday.Policy, policyName string, html string) (template.HTML, error) {
+	policy, ok := policies[policyName]
+	if !ok {
+		return "", fmt.Errorf("failed to find policy name '%s'", policyName)
+	}
+	return template.HTML(policy.Sanitize(html)), nil
+}
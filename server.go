@@ -2,8 +2,10 @@ package xtemplate
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,6 +27,21 @@ type Server struct {
 
 	mutex  sync.Mutex
 	config Config
+
+	// serializes changes to config.patches
+	patchMutex sync.Mutex
+
+	// closed and replaced after each reload to notify live reload streams
+	started  time.Time
+	reloaded chan struct{}
+
+	// new requests are rejected while draining, see [Server.Drain]
+	draining atomic.Bool
+	inFlight atomic.Int64
+
+	// closed by Stop
+	stopped  chan struct{}
+	stopOnce sync.Once
 }
 
 // Build creates a new Server from an xtemplate.Config.
@@ -34,15 +51,47 @@ func (config Config) Server(cfgs ...Option) (*Server, error) {
 	}
 
 	config.Logger = config.Logger.WithGroup("xtemplate")
+	config.liveReload = config.Development
+
+	// create the source once so each reload uses the same one
+	source, err := config.templateSource()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create templates source: %w", err)
+	}
+	config.TemplatesSource = source
+
+	if config.AccessLog != nil {
+		if config.accessLog, err = newAccessLogger(config.Ctx, config.AccessLog); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.AdminListen != "" && config.AdminToken == "" {
+		return nil, fmt.Errorf("admin listen requires an admin token")
+	}
 
 	server := &Server{
-		config: config,
+		config:  config,
+		started: time.Now(),
+		stopped: make(chan struct{}),
+	}
+	if config.liveReload {
+		server.reloaded = make(chan struct{})
 	}
-	err := server.Reload()
+	err = server.Reload()
 
 	if err != nil {
 		return nil, err
 	}
+	if source != nil && config.TemplatesFS == nil && config.TemplatesS3 == nil {
+		go server.watchSource(source)
+	}
+	if config.Metrics != nil && config.Metrics.Listen != "" {
+		go server.serveMetrics()
+	}
+	if config.AdminListen != "" {
+		go server.serveAdmin()
+	}
 	return server, nil
 }
 
@@ -55,20 +104,93 @@ func (x *Server) Instance() *Instance {
 // Serve opens a net listener on `listen_addr` and serves requests from it.
 func (x *Server) Serve(listen_addr string) error {
 	x.config.Logger.Info("starting server")
-	return http.ListenAndServe(listen_addr, x.Handler())
+	srv := x.HTTPServer()
+	srv.Addr = listen_addr
+	return srv.ListenAndServe()
+}
+
+// HTTPServer returns an [http.Server] that serves [Server.Handler] with the
+// configured timeouts and header size limit.
+func (x *Server) HTTPServer() *http.Server {
+	return &http.Server{
+		Handler:           x.Handler(),
+		ReadTimeout:       time.Duration(x.config.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(x.config.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(x.config.WriteTimeout),
+		IdleTimeout:       time.Duration(x.config.IdleTimeout),
+		MaxHeaderBytes:    x.config.MaxHeaderBytes,
+	}
 }
 
 // Handler returns a `http.Handler` that always routes new requests to the
 // current Instance.
 func (x *Server) Handler() http.Handler {
+	var events, admin http.Handler
+	if x.config.liveReload {
+		events = x.ReloadEvents()
+	}
+	if x.config.AdminToken != "" && x.config.AdminListen == "" {
+		admin = x.adminHandler()
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		x.Instance().ServeHTTP(w, r)
+		if events != nil && r.URL.Path == LiveReloadPath {
+			events.ServeHTTP(w, r)
+			return
+		}
+		if admin != nil && strings.HasPrefix(r.URL.Path, AdminPath+"/") {
+			admin.ServeHTTP(w, r)
+			return
+		}
+		// count the request before checking draining, so Drain either waits
+		// for it or it sees that the server is draining
+		x.inFlight.Add(1)
+		defer x.inFlight.Add(-1)
+		instance := x.Instance()
+		if instance == nil || x.draining.Load() {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "server unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		instance.ServeHTTP(w, r)
 	})
 }
 
+// Drain stops handling new requests, which get a 503 response so load
+// balancers send them to another server, and waits until the requests being
+// handled finish or ctx is cancelled. Call Resume to handle requests again.
+func (x *Server) Drain(ctx context.Context) error {
+	x.draining.Store(true)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for x.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d requests still in flight: %w", x.inFlight.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Resume handles new requests again after Drain.
+func (x *Server) Resume() {
+	x.draining.Store(false)
+}
+
+// Stopped returns a channel that's closed when the server is stopped, so the
+// program serving it can exit.
+func (x *Server) Stopped() <-chan struct{} {
+	return x.stopped
+}
+
 // Reload creates a new Instance from the config and swaps it with the
 // current instance if successful, otherwise returns the error.
 func (x *Server) Reload(cfgs ...Option) error {
+	return x.reload(nil, cfgs...)
+}
+
+func (x *Server) reload(hint *reloadHint, cfgs ...Option) error {
 	start := time.Now()
 
 	x.mutex.Lock()
@@ -78,15 +200,27 @@ func (x *Server) Reload(cfgs ...Option) error {
 	old := x.instance.Load()
 	if old != nil {
 		log = log.With(slog.Int64("old_id", old.id))
+		if hint == nil {
+			hint = &reloadHint{}
+		}
+		hint.caches = old.caches
+		hint.cron = old.cronOverrides
+		hint.metrics = old.metrics
+		hint.webdavLocks = old.webdavLocks
+		hint.natsServers = old.natsServers
+		hint.buses = old.buses
+		hint.concurrencyLimiters = old.concurrencyLimiters
 	}
 
 	var newcancel func()
 	var new_ *Instance
+	var stats *InstanceStats
 	{
 		var err error
 		config := x.config
 		config.Ctx, newcancel = context.WithCancel(x.config.Ctx)
-		new_, _, _, err = config.Instance(cfgs...)
+		config.reload = hint
+		new_, stats, _, err = config.Instance(cfgs...)
 		if err != nil {
 			newcancel()
 			log.Info("failed to load", slog.Any("error", err), slog.Duration("rebuild_time", time.Since(start)))
@@ -99,11 +233,34 @@ func (x *Server) Reload(cfgs ...Option) error {
 		x.cancel()
 	}
 	x.cancel = newcancel
+	x.notifyReloaded()
 
-	log.Info("rebuild succeeded", slog.Int64("new_id", new_.id), slog.Duration("rebuild_time", time.Since(start)))
+	log.Info("rebuild succeeded", slog.Int64("new_id", new_.id), slog.Duration("rebuild_time", time.Since(start)), slog.Int("reused_files", stats.ReusedFiles))
 	return nil
 }
 
+// watchSource reloads the server after the templates source changes, waiting
+// for changes to settle first.
+func (x *Server) watchSource(source TemplateSource) {
+	log := x.config.Logger.WithGroup("source")
+	ctx := context.WithValue(x.config.Ctx, loggerKey, log)
+	debounce := time.Duration(x.config.TemplatesSourceDebounce)
+	var mu sync.Mutex
+	var timer *time.Timer
+	err := source.Watch(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer == nil {
+			timer = time.AfterFunc(debounce, func() { x.Reload() })
+		} else {
+			timer.Reset(debounce)
+		}
+	})
+	if err != nil {
+		log.Error("failed to watch templates source", slog.Any("error", err))
+	}
+}
+
 func (x *Server) Stop() {
 	x.mutex.Lock()
 	defer x.mutex.Unlock()
@@ -113,4 +270,5 @@ func (x *Server) Stop() {
 	}
 	x.cancel = nil
 	x.instance.Store(nil)
+	x.stopOnce.Do(func() { close(x.stopped) })
 }
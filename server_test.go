@@ -0,0 +1,67 @@
+package xtemplate
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEOutlivesWriteTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	writeTimeout := func(c *Config) error {
+		c.WriteTimeout = Duration(100 * time.Millisecond)
+		return nil
+	}
+	files := map[string]string{
+		"index.html": `hello{{wait}}`,
+		"sse.html":   `{{define "SSE /stream"}}{{.Flush.SendSSE "first"}}{{wait}}{{.Flush.SendSSE "late"}}{{end}}`,
+	}
+	server, _ := newTestServer(t, files, started, release, writeTimeout)
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = server.HTTPServer()
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	// a fresh connection per request, since the client retries requests on a
+	// reused connection that closes before responding
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(path string, accept string) chan string {
+		body := make(chan string, 1)
+		go func() {
+			req, _ := http.NewRequest("GET", ts.URL+path, nil)
+			req.Header.Set("Accept", accept)
+			resp, err := client.Do(req)
+			if err != nil {
+				body <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			data, err := io.ReadAll(resp.Body)
+			if err != nil {
+				data = append(data, err.Error()...)
+			}
+			body <- string(data)
+		}()
+		return body
+	}
+
+	stream := get("/stream", "text/event-stream")
+	<-started
+	time.Sleep(300 * time.Millisecond)
+	release <- struct{}{}
+	if body := <-stream; !strings.Contains(body, "event: first") || !strings.Contains(body, "event: late") {
+		t.Fatalf("stream body %q, want both events after the write timeout", body)
+	}
+
+	// other responses are still limited by the write timeout
+	page := get("/", "text/html")
+	<-started
+	time.Sleep(300 * time.Millisecond)
+	release <- struct{}{}
+	if body := <-page; strings.Contains(body, "hello") {
+		t.Fatalf("page body %q was written after the write timeout", body)
+	}
+}
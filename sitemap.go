@@ -0,0 +1,152 @@
+package xtemplate
+
+// This file implements optional generated /sitemap.xml and /robots.txt routes.
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SitemapConfig configures generated `/sitemap.xml` and `/robots.txt` routes.
+//
+// The sitemap lists every GET route defined by a template file, excluding
+// routes with wildcards. Template files can customize their entry with a
+// `sitemap` key in their front matter, which may be `false` to exclude the
+// route, or an object with any of `exclude`, `priority`, `changefreq`, and
+// `lastmod`. The default `lastmod` is the template file's modtime.
+type SitemapConfig struct {
+	// The absolute base url of the site, like `https://example.com`. Required.
+	BaseURL string `json:"base_url"`
+
+	// The contents of `/robots.txt`. Default allows all user agents and links
+	// to the sitemap.
+	Robots string `json:"robots,omitempty"`
+
+	// Don't serve `/robots.txt`.
+	DisableRobots bool `json:"disable_robots,omitempty"`
+}
+
+// WithSitemap creates an [xtemplate.Option] that enables generated
+// `/sitemap.xml` and `/robots.txt` routes.
+func WithSitemap(config SitemapConfig) Option {
+	return func(c *Config) error {
+		c.Sitemap = &config
+		return nil
+	}
+}
+
+type sitemapPage struct {
+	routePath string
+	modtime   time.Time
+	meta      map[string]any
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+func (b *builder) addSitemapHandlers() error {
+	config := b.config.Sitemap
+	if config.BaseURL == "" {
+		return fmt.Errorf("sitemap base_url is required")
+	}
+	base := strings.TrimSuffix(config.BaseURL, "/")
+
+	set := sitemapURLSet{}
+	// the generated files are as new as the newest template file
+	var modtime time.Time
+	for _, page := range b.pages {
+		if page.modtime.After(modtime) {
+			modtime = page.modtime
+		}
+		routePath := strings.TrimSuffix(page.routePath, "{$}")
+		if strings.ContainsAny(routePath, "{}") {
+			continue
+		}
+		url := sitemapURL{Loc: base + routePath, LastMod: page.modtime.UTC().Format(time.DateOnly)}
+		switch s := page.meta["sitemap"].(type) {
+		case bool:
+			if !s {
+				continue
+			}
+		case map[string]any:
+			if exclude, _ := s["exclude"].(bool); exclude {
+				continue
+			}
+			if v, ok := s["priority"]; ok {
+				url.Priority = fmt.Sprint(v)
+			}
+			if v, ok := s["changefreq"]; ok {
+				url.ChangeFreq = fmt.Sprint(v)
+			}
+			switch v := s["lastmod"].(type) {
+			case time.Time:
+				url.LastMod = v.UTC().Format(time.DateOnly)
+			case string:
+				url.LastMod = v
+			}
+		}
+		set.URLs = append(set.URLs, url)
+	}
+	sort.Slice(set.URLs, func(i, j int) bool { return set.URLs[i].Loc < set.URLs[j].Loc })
+
+	sitemap, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to render sitemap: %w", err)
+	}
+	sitemap = append([]byte(xml.Header), sitemap...)
+	if modtime.IsZero() {
+		modtime = buildTime()
+	}
+	if err := b.addGeneratedFileHandler("/sitemap.xml", "application/xml; charset=utf-8", modtime, sitemap); err != nil {
+		return err
+	}
+
+	if !config.DisableRobots {
+		robots := config.Robots
+		if robots == "" {
+			robots = "User-agent: *\nAllow: /\n\nSitemap: " + base + "/sitemap.xml\n"
+		}
+		if err := b.addGeneratedFileHandler("/robots.txt", "text/plain; charset=utf-8", modtime, []byte(robots)); err != nil {
+			return err
+		}
+	}
+
+	b.config.Logger.Debug("added sitemap handlers", slog.Int("urls", len(set.URLs)), slog.Bool("robots", !config.DisableRobots))
+	return nil
+}
+
+// addGeneratedFileHandler serves content rendered at build time from files
+// last modified at modtime at urlpath, unless a static file at the same path
+// takes precedence.
+func (b *builder) addGeneratedFileHandler(urlpath, contentType string, modtime time.Time, content []byte) error {
+	if _, ok := b.files[urlpath]; ok {
+		b.config.Logger.Debug("static file overrides generated file", slog.String("path", urlpath))
+		return nil
+	}
+	pattern := "GET " + urlpath
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		http.ServeContent(w, r, urlpath, modtime, bytes.NewReader(content))
+	}
+	if err := catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.HandleFunc(pattern, handler) }); err != nil {
+		return err
+	}
+	b.Routes += 1
+	b.routes = append(b.routes, InstanceRoute{pattern, http.HandlerFunc(handler)})
+	return nil
+}
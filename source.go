@@ -0,0 +1,277 @@
+package xtemplate
+
+// This file implements loading template files from a SQL table or a NATS KV
+// bucket, and reloading the server when they change.
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"testing/fstest"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// TemplateSource loads template files from somewhere other than a filesystem,
+// like a database, so they can be edited at runtime.
+type TemplateSource interface {
+	// Load returns a snapshot of all files in the source.
+	Load(ctx context.Context) (fs.FS, error)
+
+	// Watch blocks until ctx is cancelled, calling changed after files in the
+	// source are modified.
+	Watch(ctx context.Context, changed func()) error
+}
+
+// templateSource returns the configured source of template files, if any.
+func (config *Config) templateSource() (TemplateSource, error) {
+	switch {
+	case config.TemplatesSource != nil:
+		return config.TemplatesSource, nil
+	case config.TemplatesDB != nil:
+		return newSQLSource(*config.TemplatesDB)
+	case config.TemplatesKV != nil:
+		return newKVSource(*config.TemplatesKV)
+	}
+	return nil, nil
+}
+
+// SQLSourceConfig configures loading template files from a SQL table. The
+// table has a `path` column with the file's path like `blog/index.html`, a
+// `content` column, and a `modified` column that must be updated when the
+// content changes. It's created if it doesn't exist.
+type SQLSourceConfig struct {
+	Driver  string `json:"driver"`
+	Connstr string `json:"connstr"`
+
+	// The table name, optionally qualified by a schema like
+	// `cms.templates`. Default `xtemplate_templates`.
+	Table string `json:"table,omitempty"`
+
+	// How often to check the table for changes. Default `2s`.
+	PollInterval Duration `json:"poll_interval,omitempty"`
+}
+
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+type sqlSource struct {
+	SQLSourceConfig
+}
+
+func newSQLSource(config SQLSourceConfig) (*sqlSource, error) {
+	if config.Driver == "" {
+		return nil, fmt.Errorf("templates database driver is required")
+	}
+	if config.Table == "" {
+		config.Table = "xtemplate_templates"
+	}
+	// the table name is formatted into queries, so it must be an identifier
+	if !sqlTableName.MatchString(config.Table) {
+		return nil, fmt.Errorf("invalid templates table name '%s'", config.Table)
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = Duration(2 * time.Second)
+	}
+	return &sqlSource{config}, nil
+}
+
+func (s *sqlSource) open(ctx context.Context) (*sql.DB, error) {
+	db, err := sql.Open(s.Driver, s.Connstr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open templates database with driver name '%s': %w", s.Driver, err)
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (path VARCHAR(1024) PRIMARY KEY, content TEXT NOT NULL, modified TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`, s.Table))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create templates table '%s': %w", s.Table, err)
+	}
+	return db, nil
+}
+
+func (s *sqlSource) Load(ctx context.Context) (fs.FS, error) {
+	db, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT path, content, modified FROM %s`, s.Table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates table '%s': %w", s.Table, err)
+	}
+	defer rows.Close()
+	files := fstest.MapFS{}
+	loaded := time.Now()
+	for rows.Next() {
+		var name string
+		var content []byte
+		var modified any
+		if err := rows.Scan(&name, &content, &modified); err != nil {
+			return nil, fmt.Errorf("failed to scan templates table '%s': %w", s.Table, err)
+		}
+		name = path.Clean(name)
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid template path '%s' in templates table '%s'", name, s.Table)
+		}
+		modtime, ok := modified.(time.Time)
+		if !ok {
+			modtime = loaded
+		}
+		files[name] = &fstest.MapFile{Data: content, ModTime: modtime, Mode: 0444}
+	}
+	return files, rows.Err()
+}
+
+// Watch polls the table's paths and modified times for changes.
+func (s *sqlSource) Watch(ctx context.Context, changed func()) error {
+	db, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version := func() ([]byte, error) {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT path, modified FROM %s ORDER BY path`, s.Table))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		h := sha256.New()
+		for rows.Next() {
+			var name string
+			var modified any
+			if err := rows.Scan(&name, &modified); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(h, "%s\x00%v\x00", name, modified)
+		}
+		return h.Sum(nil), rows.Err()
+	}
+
+	last, err := version()
+	if err != nil {
+		return fmt.Errorf("failed to query templates table '%s': %w", s.Table, err)
+	}
+	ticker := time.NewTicker(time.Duration(s.PollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		v, err := version()
+		if err != nil {
+			GetLogger(ctx).Warn("failed to check templates table for changes", slog.String("table", s.Table), slog.Any("error", err))
+			continue
+		}
+		if string(v) != string(last) {
+			last = v
+			changed()
+		}
+	}
+}
+
+// KVSourceConfig configures loading template files from a NATS JetStream KV
+// bucket, where each key is a file path like `blog/index.html`.
+type KVSourceConfig struct {
+	// The NATS server url. Default `nats://127.0.0.1:4222`.
+	URL string `json:"url,omitempty"`
+
+	// The KV bucket name.
+	Bucket string `json:"bucket"`
+}
+
+type kvSource struct {
+	KVSourceConfig
+}
+
+func newKVSource(config KVSourceConfig) (*kvSource, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("templates kv bucket name is required")
+	}
+	if config.URL == "" {
+		config.URL = nats.DefaultURL
+	}
+	return &kvSource{config}, nil
+}
+
+func (s *kvSource) open(ctx context.Context) (*nats.Conn, jetstream.KeyValue, error) {
+	nc, err := nats.Connect(s.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to nats server '%s': %w", s.URL, err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("failed to create jetstream client: %w", err)
+	}
+	kv, err := js.KeyValue(ctx, s.Bucket)
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("failed to open templates kv bucket '%s': %w", s.Bucket, err)
+	}
+	return nc, kv, nil
+}
+
+func (s *kvSource) Load(ctx context.Context) (fs.FS, error) {
+	nc, kv, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer nc.Close()
+
+	watcher, err := kv.WatchAll(ctx, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates kv bucket '%s': %w", s.Bucket, err)
+	}
+	defer watcher.Stop()
+	files := fstest.MapFS{}
+	// the watcher sends the current value of each key followed by nil
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		name := path.Clean(entry.Key())
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid template path '%s' in templates kv bucket '%s'", name, s.Bucket)
+		}
+		files[name] = &fstest.MapFile{Data: entry.Value(), ModTime: entry.Created(), Mode: 0444}
+	}
+	return files, nil
+}
+
+// Watch subscribes to updates and deletes in the bucket.
+func (s *kvSource) Watch(ctx context.Context, changed func()) error {
+	nc, kv, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	watcher, err := kv.WatchAll(ctx, jetstream.UpdatesOnly())
+	if err != nil {
+		return fmt.Errorf("failed to watch templates kv bucket '%s': %w", s.Bucket, err)
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry, ok := <-watcher.Updates():
+			if !ok {
+				return nil
+			}
+			if entry != nil {
+				changed()
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package xtemplate
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLSourceTableName(t *testing.T) {
+	for _, table := range []string{"pages", "cms.pages", "_pages2"} {
+		if _, err := newSQLSource(SQLSourceConfig{Driver: "sqlite3", Table: table}); err != nil {
+			t.Errorf("table name '%s' is invalid: %v", table, err)
+		}
+	}
+	for _, table := range []string{"pages; DROP TABLE users", "2pages", "pages(path)", "a.b.c", `"pages"`} {
+		if _, err := newSQLSource(SQLSourceConfig{Driver: "sqlite3", Table: table}); err == nil {
+			t.Errorf("table name '%s' is valid, want an error", table)
+		}
+	}
+}
+
+func TestSQLSource(t *testing.T) {
+	connstr := "file:" + filepath.Join(t.TempDir(), "templates.sqlite")
+	source, err := newSQLSource(SQLSourceConfig{Driver: "sqlite3", Connstr: connstr, Table: "pages", PollInterval: Duration(10 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the table is created by the first load
+	if _, err := source.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", connstr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`INSERT INTO pages (path, content) VALUES ('blog/index.html', 'hello')`); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := source.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := fs.ReadFile(fsys, "blog/index.html"); err != nil || string(content) != "hello" {
+		t.Fatalf("loaded %q, %v, want %q", content, err, "hello")
+	}
+
+	changed := make(chan struct{}, 1)
+	go source.Watch(ctx, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	time.Sleep(50 * time.Millisecond)
+	if _, err := db.Exec(`UPDATE pages SET content = 'changed', modified = '2030-01-01 00:00:00' WHERE path = 'blog/index.html'`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("watch didn't notice the modified row")
+	}
+}
+
+// burstSource is a [TemplateSource] that reports a burst of changes each time
+// it receives from its changes channel.
+type burstSource struct {
+	changes chan int
+}
+
+func (s burstSource) Load(ctx context.Context) (fs.FS, error) {
+	return fstest.MapFS{"index.html": {Data: []byte("hello")}}, nil
+}
+
+func (s burstSource) Watch(ctx context.Context, changed func()) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-s.changes:
+			for i := 0; i < n; i++ {
+				changed()
+			}
+		}
+	}
+}
+
+func TestWatchSourceDebounce(t *testing.T) {
+	source := burstSource{make(chan int)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := New()
+	config.Ctx = ctx
+	config.TemplatesSource = source
+	config.TemplatesSourceDebounce = Duration(50 * time.Millisecond)
+	server, err := config.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := server.Instance().Id()
+
+	source.changes <- 5
+	time.Sleep(200 * time.Millisecond)
+	// each reload creates an instance with the next id
+	if n := server.Instance().Id() - id; n != 1 {
+		t.Fatalf("server reloaded %d times after a burst of changes, want 1", n)
+	}
+}
@@ -0,0 +1,3 @@
+const [items, coupon] = args;
+const total = items.reduce((sum, item) => sum + item.price * item.qty, 0);
+({total: coupon === "HALF" ? total / 2 : total, count: items.length});
@@ -0,0 +1,6 @@
+// Say hello.
+function greet() {
+  console.log("hello");
+}
+
+greet();
@@ -0,0 +1,2 @@
+function greet(){console.log("hello")}greet();
+//# sourceMappingURL=app.js.map
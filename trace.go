@@ -0,0 +1,127 @@
+package xtemplate
+
+// This file implements recording how long each part of rendering a request
+// takes when tracing is enabled.
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type traceContextKey struct{}
+
+// traceSpan is a timed section of a request, like executing a template or a
+// database query, with the spans that happened during it.
+type traceSpan struct {
+	name     string
+	start    time.Time
+	duration time.Duration
+	children []*traceSpan
+}
+
+// requestTrace records the spans of a single request.
+type requestTrace struct {
+	mu    sync.Mutex
+	root  traceSpan
+	stack []*traceSpan
+}
+
+func newRequestTrace(name string) *requestTrace {
+	t := &requestTrace{root: traceSpan{name: name, start: time.Now()}}
+	t.stack = []*traceSpan{&t.root}
+	return t
+}
+
+func getTrace(ctx context.Context) *requestTrace {
+	t, _ := ctx.Value(traceContextKey{}).(*requestTrace)
+	return t
+}
+
+// startSpan starts a span named name in the request's trace, and returns a
+// func that ends it. If the request isn't being traced it does nothing.
+func startSpan(ctx context.Context, name string) func() {
+	t := getTrace(ctx)
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &traceSpan{name: name, start: time.Now()}
+	parent := t.stack[len(t.stack)-1]
+	parent.children = append(parent.children, span)
+	t.stack = append(t.stack, span)
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		span.duration = time.Since(span.start)
+		// spans normally end in order, but be robust to ones that don't
+		for i := len(t.stack) - 1; i > 0; i-- {
+			if t.stack[i] == span {
+				t.stack = t.stack[:i]
+				break
+			}
+		}
+	}
+}
+
+// spanName formats a span name from a kind and a detail like a query, with
+// whitespace collapsed and truncated so it fits on one line.
+func spanName(kind, detail string) string {
+	detail = strings.Join(strings.Fields(detail), " ")
+	if len(detail) > 80 {
+		detail = detail[:77] + "..."
+	}
+	return kind + " " + detail
+}
+
+// finish ends the root span.
+func (t *requestTrace) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root.duration = time.Since(t.root.start)
+}
+
+// String formats the trace as an indented tree with each span's duration and
+// share of the total, like a flame graph turned on its side.
+func (t *requestTrace) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sb strings.Builder
+	total := t.root.duration
+	var write func(span *traceSpan, depth int)
+	write = func(span *traceSpan, depth int) {
+		var pct float64
+		if total > 0 {
+			pct = 100 * float64(span.duration) / float64(total)
+		}
+		fmt.Fprintf(&sb, "%s%10s %5.1f%% %s\n", strings.Repeat("  ", depth), span.duration.Round(time.Microsecond), pct, span.name)
+		for _, child := range span.children {
+			write(child, depth+1)
+		}
+	}
+	write(&t.root, 0)
+	return sb.String()
+}
+
+// serverTiming formats all spans in the order they started as a
+// `Server-Timing` header value, which browser developer tools display.
+func (t *requestTrace) serverTiming() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var parts []string
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	var write func(span *traceSpan)
+	write = func(span *traceSpan) {
+		parts = append(parts, fmt.Sprintf(`s%d;desc="%s";dur=%.3f`, len(parts), escape.Replace(span.name), float64(span.duration)/float64(time.Millisecond)))
+		for _, child := range span.children {
+			write(child)
+		}
+	}
+	for _, span := range t.root.children {
+		write(span)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,119 @@
+package xtemplate
+
+// This file implements serving a configured directory over WebDAV so its files
+// can be edited remotely.
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVConfig configures a WebDAV handler that serves a configured directory,
+// so content editors can mount it in their file manager and edit files like
+// markdown content remotely. All requests must authenticate with basic auth
+// as one of Users.
+type WebDAVConfig struct {
+	// The url path prefix to serve the directory at. Default `/dav`.
+	Path string `json:"path,omitempty"`
+
+	// The name of a configured directory with a path. Required.
+	Directory string `json:"directory"`
+
+	// Passwords by username. Environment variables in passwords like
+	// `${DAV_PASSWORD}` are expanded when the instance loads, and an empty
+	// password is an error. Required.
+	Users map[string]string `json:"users"`
+
+	// Only allow reading files.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// WithWebDAV creates an [xtemplate.Option] that enables the WebDAV handler.
+func WithWebDAV(config WebDAVConfig) Option {
+	return func(c *Config) error {
+		c.WebDAV = &config
+		return nil
+	}
+}
+
+func (c *WebDAVConfig) defaults() {
+	if c.Path == "" {
+		c.Path = "/dav"
+	}
+	c.Path = "/" + strings.Trim(c.Path, "/")
+}
+
+func (b *builder) addWebDAVHandler() error {
+	config := b.config.WebDAV
+	var dir string
+	for _, d := range b.config.Directories {
+		if d.Name == config.Directory {
+			if d.FS != nil || d.S3 != nil || d.Path == "" {
+				return fmt.Errorf("webdav directory '%s' must be configured with a path", d.Name)
+			}
+			dir = d.Path
+		}
+	}
+	if dir == "" {
+		return fmt.Errorf("webdav directory '%s' is not a configured directory", config.Directory)
+	}
+	if len(config.Users) == 0 {
+		return fmt.Errorf("webdav requires at least one user")
+	}
+	users := make(map[string][]byte, len(config.Users))
+	for name, password := range config.Users {
+		// an unset environment variable would otherwise let anyone in with an
+		// empty password
+		expanded := os.ExpandEnv(password)
+		if expanded == "" {
+			return fmt.Errorf("webdav user '%s' has an empty password", name)
+		}
+		users[name] = []byte(expanded)
+	}
+
+	log := b.config.Logger.With(slog.String("handler", "webdav"))
+	dav := &webdav.Handler{
+		Prefix:     config.Path,
+		FileSystem: webdav.Dir(dir),
+		LockSystem: b.webdavLocks,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Debug("webdav request failed", slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.Any("error", err))
+			}
+		},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, password, ok := r.BasicAuth()
+		if want, exists := users[name]; !ok || !exists || subtle.ConstantTimeCompare([]byte(password), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="webdav"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if config.ReadOnly {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+			default:
+				http.Error(w, "webdav is read only", http.StatusMethodNotAllowed)
+				return
+			}
+		}
+		dav.ServeHTTP(w, r)
+	})
+
+	// no method, so WebDAV methods like PROPFIND are routed too
+	for _, pattern := range []string{config.Path, config.Path + "/"} {
+		if err := catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.Handle(pattern, handler) }); err != nil {
+			return err
+		}
+		b.Routes += 1
+		b.routes = append(b.routes, InstanceRoute{pattern, handler})
+	}
+	b.config.Logger.Debug("added webdav handler", slog.String("path", config.Path), slog.String("directory", dir))
+	return nil
+}
@@ -0,0 +1,283 @@
+package xtemplate
+
+// This file implements a small lossless WebP encoder, since the standard
+// library and golang.org/x/image can only decode WebP. It writes the simplest
+// valid VP8L bitstream: no transforms, no color cache, and no backward
+// references, just each pixel's channels entropy coded with prefix codes.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"slices"
+)
+
+// encodeWebP writes img to w as a lossless WebP image.
+func encodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return fmt.Errorf("webp images must be between 1x1 and 16384x16384, got %dx%d", width, height)
+	}
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	}
+
+	// count each channel's values to build their prefix codes
+	var freq [4][]int // green, red, blue, alpha
+	freq[0] = make([]int, 256+24)
+	for i := 1; i < 4; i++ {
+		freq[i] = make([]int, 256)
+	}
+	alpha := false
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+width*4]
+		for x := 0; x < len(row); x += 4 {
+			freq[0][row[x+1]]++
+			freq[1][row[x]]++
+			freq[2][row[x+2]]++
+			freq[3][row[x+3]]++
+			alpha = alpha || row[x+3] != 0xff
+		}
+	}
+
+	bw := &webpBitWriter{}
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(boolBit(alpha), 1)
+	bw.write(0, 3) // version
+	bw.write(0, 1) // no transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta prefix codes
+
+	var codes [4]webpPrefixCode
+	for i := range codes {
+		codes[i] = newWebpPrefixCode(freq[i], 15)
+		codes[i].writeTo(bw)
+	}
+	// distance codes aren't used without backward references
+	webpPrefixCode{lengths: []int{1}, single: true}.writeTo(bw)
+
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+width*4]
+		for x := 0; x < len(row); x += 4 {
+			codes[0].writeSymbol(bw, int(row[x+1]))
+			codes[1].writeSymbol(bw, int(row[x]))
+			codes[2].writeSymbol(bw, int(row[x+2]))
+			codes[3].writeSymbol(bw, int(row[x+3]))
+		}
+	}
+	data := bw.bytes()
+
+	chunk := len(data) + 1 // signature byte
+	padded := chunk + chunk&1
+	header := make([]byte, 21)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+padded))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunk))
+	header[20] = 0x2f // VP8L signature
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if chunk&1 == 1 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// webpBitWriter writes bits least significant bit first, as VP8L is read.
+type webpBitWriter struct {
+	buf  []byte
+	bits uint64
+	n    uint
+}
+
+func (w *webpBitWriter) write(v uint32, n uint) {
+	w.bits |= uint64(v) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.n -= 8
+	}
+}
+
+func (w *webpBitWriter) bytes() []byte {
+	if w.n > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.n = 0, 0
+	}
+	return w.buf
+}
+
+// webpPrefixCode is a canonical prefix code for an alphabet.
+type webpPrefixCode struct {
+	lengths []int
+	codes   []uint32 // bit reversed, ready to write
+	// only one symbol is used, which is written with zero bits
+	single bool
+}
+
+// newWebpPrefixCode builds a prefix code for symbols with freq, with codes no
+// longer than maxLength bits.
+func newWebpPrefixCode(freq []int, maxLength int) webpPrefixCode {
+	c := webpPrefixCode{lengths: webpCodeLengths(freq, maxLength)}
+	used := 0
+	for _, l := range c.lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	if used == 1 {
+		c.single = true
+		return c
+	}
+
+	// assign canonical codes, as in deflate
+	var count [16]int
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < 16; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+	c.codes = make([]uint32, len(c.lengths))
+	for s, l := range c.lengths {
+		if l == 0 {
+			continue
+		}
+		v := next[l]
+		next[l]++
+		var r uint32
+		for i := 0; i < l; i++ {
+			r = r<<1 | (v>>i)&1
+		}
+		c.codes[s] = r
+	}
+	return c
+}
+
+func (c webpPrefixCode) writeSymbol(w *webpBitWriter, s int) {
+	if !c.single {
+		w.write(c.codes[s], uint(c.lengths[s]))
+	}
+}
+
+// webpCodeLengthOrder is the order code lengths of the code length code are
+// written in.
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// writeTo writes the code's lengths, using a simple code for one or two
+// symbols that fit in 8 bits.
+func (c webpPrefixCode) writeTo(w *webpBitWriter) {
+	var symbols []int
+	for s, l := range c.lengths {
+		if l > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) <= 2 && symbols[len(symbols)-1] < 256 {
+		w.write(1, 1) // simple code
+		w.write(uint32(len(symbols)-1), 1)
+		w.write(1, 1) // 8 bit symbols
+		for _, s := range symbols {
+			w.write(uint32(s), 8)
+		}
+		return
+	}
+
+	w.write(0, 1) // normal code
+	lengthFreq := make([]int, 19)
+	for _, l := range c.lengths {
+		lengthFreq[l]++
+	}
+	lengthCode := newWebpPrefixCode(lengthFreq, 7)
+	n := 19
+	for n > 4 && lengthCode.lengths[webpCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	w.write(uint32(n-4), 4)
+	for _, s := range webpCodeLengthOrder[:n] {
+		w.write(uint32(lengthCode.lengths[s]), 3)
+	}
+	w.write(0, 1) // code lengths for the whole alphabet follow
+	for _, l := range c.lengths {
+		lengthCode.writeSymbol(w, l)
+	}
+}
+
+// webpCodeLengths returns the lengths of huffman codes for symbols with freq,
+// halving the frequencies until no code is longer than maxLength.
+func webpCodeLengths(freq []int, maxLength int) []int {
+	freq = slices.Clone(freq)
+	for {
+		lengths := huffmanLengths(freq)
+		if slices.Max(lengths) <= maxLength {
+			return lengths
+		}
+		for i, f := range freq {
+			if f > 0 {
+				freq[i] = (f + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanLengths returns the depth of each symbol with a non-zero frequency
+// in a huffman tree. A lone symbol gets length 1.
+func huffmanLengths(freq []int) []int {
+	type node struct {
+		weight      int
+		symbol      int
+		left, right *node
+	}
+	var nodes []*node
+	for s, f := range freq {
+		if f > 0 {
+			nodes = append(nodes, &node{weight: f, symbol: s})
+		}
+	}
+	lengths := make([]int, len(freq))
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbol] = 1
+		return lengths
+	}
+	for len(nodes) > 1 {
+		slices.SortStableFunc(nodes, func(a, b *node) int { return a.weight - b.weight })
+		parent := &node{weight: nodes[0].weight + nodes[1].weight, symbol: -1, left: nodes[0], right: nodes[1]}
+		nodes = append(nodes[2:], parent)
+	}
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	if len(nodes) == 1 {
+		walk(nodes[0], 0)
+	}
+	return lengths
+}